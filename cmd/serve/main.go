@@ -37,7 +37,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dhis2-sre/im-manager/pkg/backup"
 	"github.com/dhis2-sre/im-manager/pkg/cluster"
+	"github.com/dhis2-sre/im-manager/pkg/comparison"
 
 	"github.com/dhis2-sre/im-manager/pkg/inspector"
 
@@ -175,19 +177,22 @@ func run() (err error) {
 	authentication := middleware.NewAuthentication(publicKey, userService)
 	groupRepository := group.NewRepository(db)
 	groupService := group.NewService(groupRepository, userService, clusterService)
-	groupHandler := group.NewHandler(groupService)
 
-	stackService, err := newStackService()
+	stacksFolder := os.Getenv("STACKS_FOLDER")
+	if stacksFolder == "" {
+		stacksFolder = "./stacks"
+	}
+	stackService, err := stack.NewServiceFromFolder(stacksFolder)
 	if err != nil {
-		return err
+		return fmt.Errorf("error in stack config: %v", err)
 	}
 
-	s3Client, err := newS3Client(ctx, logger)
+	objectStore, err := newObjectStore(ctx, logger)
 	if err != nil {
 		return err
 	}
 
-	instanceService, err := newInstanceService(logger, db, stackService, groupService, s3Client)
+	instanceService, err := newInstanceService(logger, db, stackService, stacksFolder, groupService, objectStore)
 	if err != nil {
 		return err
 	}
@@ -211,15 +216,27 @@ func run() (err error) {
 		return err
 	}
 
+	groupHandler := group.NewHandler(groupService, databaseService)
+
 	deploymentService := deployment.NewService(logger, instanceService, databaseService, tokenService, publisher)
 
 	databaseHandler := database.NewHandler(logger, databaseService, groupService, instanceService, stackService, deploymentService)
 
-	instanceHandler, err := newInstanceHandler(stackService, groupService, instanceService, deploymentService)
+	instanceHandler, err := newInstanceHandler(stackService, groupService, instanceService, deploymentService, databaseService)
+	if err != nil {
+		return err
+	}
+
+	comparisonService := comparison.NewService(logger, comparison.NewRepository(db), groupService, databaseService, instanceService, deploymentService)
+
+	comparisonHandler, err := newComparisonHandler(comparisonService, groupService, databaseService)
 	if err != nil {
 		return err
 	}
 
+	backupService := backup.NewService(logger, backup.NewRepository(db), instanceService, stackService, databaseService, publisher)
+	backupHandler := backup.NewHandler(backupService, instanceService)
+
 	err = handler.RegisterValidation()
 	if err != nil {
 		return err
@@ -252,10 +269,33 @@ func run() (err error) {
 		return err
 	}
 
-	ins := inspector.NewInspector(logger, instanceService, inspector.NewTTLDestroyHandler(logger, instanceService))
+	ins := inspector.NewInspector(logger, instanceService, inspector.NewTTLDestroyHandler(logger, instanceService), inspector.NewComparisonTTLHandler(logger, comparisonService), inspector.NewLockHandler(logger, databaseService, instanceService))
 	// TODO: Graceful shutdown... ?
 	go ins.Inspect(ctx)
 
+	retentionEnforcer := database.NewRetentionEnforcer(logger, databaseService, time.Hour)
+	go retentionEnforcer.Enforce(ctx)
+
+	uploadReaper := database.NewUploadReaper(logger, databaseService, time.Hour)
+	go uploadReaper.Reap(ctx)
+
+	lockReleaser := database.NewLockReleaser(logger, databaseService, 2*time.Minute)
+	go lockReleaser.Release(ctx)
+
+	trashRetentionDays, err := requireEnvAsUint("TRASH_RETENTION_DAYS")
+	if err != nil {
+		return err
+	}
+
+	databaseTrashPurger := database.NewTrashPurger(logger, databaseService, trashRetentionDays, time.Hour)
+	go databaseTrashPurger.Purge(ctx)
+
+	deploymentTrashPurger := instance.NewTrashPurger(logger, instanceService, trashRetentionDays, time.Hour)
+	go deploymentTrashPurger.Purge(ctx)
+
+	backupScheduler := backup.NewScheduler(logger, backupService, time.Minute)
+	go backupScheduler.Schedule(ctx)
+
 	r, err := newGinEngine(logger)
 	if err != nil {
 		return err
@@ -266,10 +306,12 @@ func run() (err error) {
 	cluster.Routes(r, authentication, authorization, clusterHandler)
 	group.Routes(r, authentication, authorization, groupHandler)
 	user.Routes(r, authentication, authorization, userHandler, oauthHandler)
-	stack.Routes(r, authentication.TokenAuthentication, stackHandler)
+	stack.Routes(r, authentication, authorization, stackHandler)
 	integration.Routes(r, authentication, integrationHandler)
 	database.Routes(r, authentication.TokenAuthentication, databaseHandler)
 	instance.Routes(r, authentication.TokenAuthentication, instanceHandler)
+	comparison.Routes(r, authentication.TokenAuthentication, comparisonHandler)
+	backup.Routes(r, authentication.TokenAuthentication, backupHandler)
 	event.Routes(r, authentication.TokenAuthentication, eventHandler)
 	notification.Routes(r, authentication.TokenAuthentication, notificationHandler)
 
@@ -514,28 +556,7 @@ func getPrivateKey(ctx context.Context, logger *slog.Logger) (*rsa.PrivateKey, e
 	return privateKey.(*rsa.PrivateKey), nil
 }
 
-func newStackService() (stack.Service, error) {
-	stacks, err := stack.New(
-		stack.DHIS2DB,
-		stack.MINIO,
-		stack.DHIS2Core,
-		stack.DHIS2,
-		stack.PgAdmin,
-		stack.WhoamiGo,
-		stack.IMJobRunner,
-		stack.ChapDB,
-		stack.ChapValkey,
-		stack.ChapWorker,
-		stack.ChapCore,
-	)
-	if err != nil {
-		return stack.Service{}, fmt.Errorf("error in stack config: %v", err)
-	}
-
-	return stack.NewService(stacks), nil
-}
-
-func newInstanceService(logger *slog.Logger, db *gorm.DB, stackService stack.Service, groupService *group.Service, s3Client *storage.S3Client) (*instance.Service, error) {
+func newInstanceService(logger *slog.Logger, db *gorm.DB, stackService stack.Service, stacksFolder string, groupService *group.Service, objectStore storage.ObjectStore) (*instance.Service, error) {
 	instanceParameterEncryptionKey, err := requireEnv("INSTANCE_PARAMETER_ENCRYPTION_KEY")
 	if err != nil {
 		return nil, err
@@ -548,7 +569,7 @@ func newInstanceService(logger *slog.Logger, db *gorm.DB, stackService stack.Ser
 	if err != nil {
 		return nil, err
 	}
-	helmfileService, err := instance.NewHelmfileService(logger, stackService, "./stacks", classification)
+	helmfileService, err := instance.NewHelmfileService(logger, stackService, stacksFolder, classification)
 	if err != nil {
 		return nil, err
 	}
@@ -558,7 +579,7 @@ func newInstanceService(logger *slog.Logger, db *gorm.DB, stackService stack.Ser
 		return nil, err
 	}
 
-	return instance.NewService(logger, instanceRepository, groupService, stackService, helmfileService, s3Client, s3Bucket), nil
+	return instance.NewService(logger, instanceRepository, groupService, stackService, helmfileService, objectStore, s3Bucket), nil
 }
 
 type rabbitMQConfig struct {
@@ -605,13 +626,22 @@ func newRabbitMQ() (rabbitMQConfig, error) {
 	}, nil
 }
 
-func newInstanceHandler(stackService stack.Service, groupService *group.Service, instanceService *instance.Service, deploymentService *deployment.Service) (instance.Handler, error) {
+func newInstanceHandler(stackService stack.Service, groupService *group.Service, instanceService *instance.Service, deploymentService *deployment.Service, databaseService *database.Service) (instance.Handler, error) {
 	defaultTTL, err := requireEnvAsUint("DEFAULT_TTL")
 	if err != nil {
 		return instance.Handler{}, err
 	}
 
-	return instance.NewHandler(stackService, groupService, instanceService, deploymentService, defaultTTL), nil
+	return instance.NewHandler(stackService, groupService, instanceService, deploymentService, databaseService, defaultTTL), nil
+}
+
+func newComparisonHandler(comparisonService *comparison.Service, groupService *group.Service, databaseService *database.Service) (comparison.Handler, error) {
+	defaultTTL, err := requireEnvAsUint("DEFAULT_TTL")
+	if err != nil {
+		return comparison.Handler{}, err
+	}
+
+	return comparison.NewHandler(comparisonService, groupService, databaseService, defaultTTL), nil
 }
 
 func newDatabaseService(ctx context.Context, logger *slog.Logger, db *gorm.DB, groupService *group.Service, env *stream.Environment, streamName string) (*database.Service, *notification.Publisher, error) {
@@ -619,7 +649,31 @@ func newDatabaseService(ctx context.Context, logger *slog.Logger, db *gorm.DB, g
 	if err != nil {
 		return nil, nil, err
 	}
-	s3Client, err := newS3Client(ctx, logger)
+	objectStore, err := newObjectStore(ctx, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	versionsToKeep, err := requireEnvAsUint("DATABASE_VERSIONS_TO_KEEP")
+	if err != nil {
+		return nil, nil, err
+	}
+	jobImage, err := requireEnv("DATABASE_JOB_IMAGE")
+	if err != nil {
+		return nil, nil, err
+	}
+	anonymizationProfiles, err := database.ParseAnonymizationProfiles(os.Getenv("DATABASE_ANONYMIZATION_PROFILES"))
+	if err != nil {
+		return nil, nil, err
+	}
+	importAllowedSchemes, err := requireEnv("DATABASE_IMPORT_ALLOWED_SCHEMES")
+	if err != nil {
+		return nil, nil, err
+	}
+	importMaxSize, err := requireEnvAsUint("DATABASE_IMPORT_MAX_SIZE")
+	if err != nil {
+		return nil, nil, err
+	}
+	presigner, err := newPresigner(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -629,15 +683,54 @@ func newDatabaseService(ctx context.Context, logger *slog.Logger, db *gorm.DB, g
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create database notification publisher: %w", err)
 	}
-	databaseService := database.NewService(logger, s3Bucket, s3Client, groupService, databaseRepository, func(c model.Cluster) (database.PodExecutor, error) {
+	databaseService := database.NewService(logger, s3Bucket, objectStore, groupService, databaseRepository, func(c model.Cluster) (database.PodExecutor, error) {
 		return instance.NewKubernetesService(c)
-	}, publisher)
+	}, publisher, versionsToKeep, jobImage, anonymizationProfiles, database.ParseImportConfig(importAllowedSchemes, importMaxSize), presigner)
 
 	return databaseService, publisher, nil
 }
 
-func newS3Client(ctx context.Context, logger *slog.Logger) (*storage.S3Client, error) {
-	awsClient, err := newAWSS3Client(ctx)
+// storageBackend returns the configured STORAGE_BACKEND which is either "s3" for AWS S3 and MinIO,
+// "s3-compatible" for other services implementing the S3 API or "filesystem". Defaults to "s3".
+func storageBackend() (string, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	switch backend {
+	case "", "s3":
+		return "s3", nil
+	case "s3-compatible", "filesystem":
+		return backend, nil
+	default:
+		return "", fmt.Errorf("invalid STORAGE_BACKEND %q, must be either \"s3\", \"s3-compatible\" or \"filesystem\"", backend)
+	}
+}
+
+// s3Options returns the options of S3 clients of the given backend
+func s3Options(backend string) []func(*s3.Options) {
+	if backend == "s3-compatible" {
+		return []func(*s3.Options){storage.WithoutDefaultChecksums}
+	}
+	return nil
+}
+
+func newObjectStore(ctx context.Context, logger *slog.Logger) (storage.ObjectStore, error) {
+	backend, err := storageBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == "filesystem" {
+		root, err := requireEnv("STORAGE_FILESYSTEM_ROOT")
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewFilesystemStore(root)
+	}
+
+	return newS3Client(ctx, logger, backend)
+}
+
+func newS3Client(ctx context.Context, logger *slog.Logger, backend string) (*storage.S3Client, error) {
+	awsClient, err := newAWSS3Client(ctx, s3Options(backend)...)
 	if err != nil {
 		return nil, err
 	}
@@ -646,7 +739,42 @@ func newS3Client(ctx context.Context, logger *slog.Logger) (*storage.S3Client, e
 	return storage.NewS3Client(logger, awsClient, uploader), nil
 }
 
-func newAWSS3Client(ctx context.Context) (*s3.Client, error) {
+// newPresigner returns a presigner if presigned URLs are enabled by setting S3_PRESIGN_TTL to a
+// non-zero number of seconds. S3_PRESIGN_ENDPOINT overrides S3_ENDPOINT in presigned URLs which is
+// needed if S3 is reached through a different host by IM than by its clients.
+func newPresigner(ctx context.Context) (database.Presigner, error) {
+	ttl, err := requireEnvAsUint("S3_PRESIGN_TTL")
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		return nil, nil
+	}
+	backend, err := storageBackend()
+	if err != nil {
+		return nil, err
+	}
+	if backend == "filesystem" {
+		return nil, fmt.Errorf("presigned URLs require an S3 storage backend but STORAGE_BACKEND is %q", backend)
+	}
+
+	endpoint := os.Getenv("S3_PRESIGN_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	awsClient, err := newAWSS3ClientWithEndpoint(ctx, endpoint, s3Options(backend)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewPresigner(s3.NewPresignClient(awsClient), time.Duration(ttl)*time.Second), nil
+}
+
+func newAWSS3Client(ctx context.Context, optFns ...func(*s3.Options)) (*s3.Client, error) {
+	return newAWSS3ClientWithEndpoint(ctx, os.Getenv("S3_ENDPOINT"), optFns...)
+}
+
+func newAWSS3ClientWithEndpoint(ctx context.Context, endpoint string, optFns ...func(*s3.Options)) (*s3.Client, error) {
 	s3Region, err := requireEnv("S3_REGION")
 	if err != nil {
 		return nil, err
@@ -654,7 +782,7 @@ func newAWSS3Client(ctx context.Context) (*s3.Client, error) {
 
 	// nolint:staticcheck
 	s3Endpoint := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...any) (aws.Endpoint, error) {
-		if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		if endpoint != "" {
 			return aws.Endpoint{URL: endpoint}, nil
 		}
 		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
@@ -668,9 +796,10 @@ func newAWSS3Client(ctx context.Context) (*s3.Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup S3 config: %v", err)
 	}
-	s3AWSClient := s3.NewFromConfig(s3Config, func(o *s3.Options) {
+	optFns = append([]func(*s3.Options){func(o *s3.Options) {
 		o.UsePathStyle = true
-	})
+	}}, optFns...)
+	s3AWSClient := s3.NewFromConfig(s3Config, optFns...)
 
 	return s3AWSClient, nil
 }
@@ -816,7 +945,14 @@ func newGinEngine(logger *slog.Logger) (*gin.Engine, error) {
 		return nil, err
 	}
 
-	r, err := server.GetEngine(logger, basePath, allowedOrigins)
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
+	r, err := server.GetEngine(logger, basePath, allowedOrigins, trustedProxies)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup Gin engine: %v", err)
 	}
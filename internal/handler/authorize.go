@@ -19,6 +19,16 @@ func CanReadDeployment(user *model.User, deployment *model.Deployment) bool {
 	return isAdministrator(user) || isMemberOf(deployment.GroupName, user.Groups)
 }
 
+func CanWriteComparison(user *model.User, comparison *model.Comparison) bool {
+	return isAdministrator(user) ||
+		isMemberOf(comparison.GroupName, user.AdminGroups) ||
+		(user.ID == comparison.UserID && isMemberOf(comparison.GroupName, user.Groups))
+}
+
+func CanReadComparison(user *model.User, comparison *model.Comparison) bool {
+	return isAdministrator(user) || isMemberOf(comparison.GroupName, user.Groups)
+}
+
 func isMemberOf(groupName string, groups []model.Group) bool {
 	for _, group := range groups {
 		if groupName == group.Name {
@@ -33,18 +43,43 @@ func isAdministrator(user *model.User) bool {
 }
 
 // TODO: These are all related to databases and as such should probably be moved into the database package
+// CanAccess reports whether the user can read the database. Databases are readable by the members of
+// their group and of the groups they're shared with.
 func CanAccess(user *model.User, database *model.Database) bool {
+	return CanWrite(user, database) || isSharedWith(database, user)
+}
+
+// CanWrite reports whether the user can modify the database. Shared databases are read-only to the
+// groups they're shared with.
+func CanWrite(user *model.User, database *model.Database) bool {
 	return IsAdministrator(user) ||
 		IsGroupAdministrator(database.GroupName, user.AdminGroups) ||
 		isMemberOf(database.GroupName, user.Groups)
 }
 
+func isSharedWith(database *model.Database, user *model.User) bool {
+	for _, share := range database.Shares {
+		if isMemberOf(share.GroupName, user.Groups) || IsGroupAdministrator(share.GroupName, user.AdminGroups) {
+			return true
+		}
+	}
+	return false
+}
+
 func CanUnlock(user *model.User, database *model.Database) bool {
 	return IsAdministrator(user) ||
 		IsGroupAdministrator(database.GroupName, user.AdminGroups) ||
 		hasLock(user, database)
 }
 
+// CanRestore reports whether the user can restore or purge the trashed database. Only the owner of
+// the database and the administrators of its group can.
+func CanRestore(user *model.User, database *model.Database) bool {
+	return IsAdministrator(user) ||
+		IsGroupAdministrator(database.GroupName, user.AdminGroups) ||
+		(user.ID == database.UserID && isMemberOf(database.GroupName, user.Groups))
+}
+
 func hasLock(user *model.User, database *model.Database) bool {
 	return database.Lock != nil && user.ID == database.Lock.UserID
 }
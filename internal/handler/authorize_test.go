@@ -67,6 +67,43 @@ func TestCanWriteDeployment_isAdministrator(t *testing.T) {
 	assert.True(t, isAdmin)
 }
 
+func TestCanWriteComparison_isMemberButNotOwner(t *testing.T) {
+	var group = "123"
+
+	user := &model.User{
+		ID: 1,
+		Groups: []model.Group{
+			{
+				Name: group,
+			},
+		},
+	}
+
+	comparison := &model.Comparison{UserID: 2, GroupName: group}
+
+	canWrite := CanWriteComparison(user, comparison)
+
+	assert.False(t, canWrite)
+}
+
+func TestCanReadComparison_isMemberOf(t *testing.T) {
+	var group = "123"
+
+	user := &model.User{
+		Groups: []model.Group{
+			{
+				Name: group,
+			},
+		},
+	}
+
+	comparison := &model.Comparison{UserID: 2, GroupName: group}
+
+	canRead := CanReadComparison(user, comparison)
+
+	assert.True(t, canRead)
+}
+
 func TestCanReadDeployment_isMemberOf(t *testing.T) {
 	var group = "123"
 
@@ -119,3 +156,85 @@ func TestCanReadDeployment_AccessDenied(t *testing.T) {
 
 	assert.False(t, isAdmin)
 }
+
+func TestCanAccess_isMemberOfSharedGroup(t *testing.T) {
+	user := &model.User{
+		Groups: []model.Group{
+			{
+				Name: "shared",
+			},
+		},
+	}
+
+	database := &model.Database{
+		GroupName: "owner",
+		Shares: []model.DatabaseShare{
+			{
+				GroupName: "shared",
+			},
+		},
+	}
+
+	assert.True(t, CanAccess(user, database))
+	assert.False(t, CanWrite(user, database))
+}
+
+func TestCanAccess_AccessDenied(t *testing.T) {
+	user := &model.User{
+		Groups: []model.Group{
+			{
+				Name: "other",
+			},
+		},
+	}
+
+	database := &model.Database{
+		GroupName: "owner",
+		Shares: []model.DatabaseShare{
+			{
+				GroupName: "shared",
+			},
+		},
+	}
+
+	assert.False(t, CanAccess(user, database))
+}
+
+func TestCanRestore_isMemberButNotOwner(t *testing.T) {
+	var group = "123"
+
+	user := &model.User{
+		ID: 1,
+		Groups: []model.Group{
+			{
+				Name: group,
+			},
+		},
+	}
+
+	database := &model.Database{UserID: 2, GroupName: group}
+
+	assert.True(t, CanWrite(user, database))
+	assert.False(t, CanRestore(user, database))
+
+	database.UserID = user.ID
+
+	assert.True(t, CanRestore(user, database))
+}
+
+func TestCanRestore_isGroupAdministrator(t *testing.T) {
+	var group = "123"
+
+	user := &model.User{
+		ID: 1,
+		AdminGroups: []model.Group{
+			{
+				Name: group,
+			},
+		},
+	}
+
+	database := &model.Database{UserID: 2, GroupName: group}
+
+	assert.True(t, CanRestore(user, database))
+}
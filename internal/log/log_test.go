@@ -228,7 +228,7 @@ func TestLogs(t *testing.T) {
 func newGinEngine(t *testing.T, logger *slog.Logger, userID uint) *gin.Engine {
 	t.Helper()
 
-	r, err := server.GetEngine(logger, "", []string{"http://localhost"})
+	r, err := server.GetEngine(logger, "", []string{"http://localhost"}, nil)
 	require.NoError(t, err, "failed to set up up Gin")
 
 	auth := middleware.NewAuthentication(rsa.PublicKey{}, SignInService{userID: userID})
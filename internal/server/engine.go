@@ -11,8 +11,13 @@ import (
 	redocMiddleware "github.com/go-openapi/runtime/middleware"
 )
 
-func GetEngine(logger *slog.Logger, basePath string, allowedOrigins []string) (*gin.Engine, error) {
+// GetEngine creates the Gin engine. The client IP is only taken from the X-Forwarded-For header of
+// requests sent by the trusted proxies, none are trusted if trustedProxies is empty.
+func GetEngine(logger *slog.Logger, basePath string, allowedOrigins []string, trustedProxies []string) (*gin.Engine, error) {
 	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("failed to configure trusted proxies: %v", err)
+	}
 	r.Use(gin.Recovery())
 	r.Use(middleware.CorrelationID())
 	r.Use(middleware.RequestLogger(logger))
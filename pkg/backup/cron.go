@@ -0,0 +1,35 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronSchedule is a parsed standard five field cron expression (minute hour day-of-month month
+// day-of-week)
+type cronSchedule struct {
+	schedule cron.Schedule
+}
+
+// parseCron parses a standard cron expression. Descriptors like "@daily" are supported as well.
+func parseCron(expression string) (*cronSchedule, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cronSchedule{schedule}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression never matches: %q", expression)
+	}
+
+	return c, nil
+}
+
+// next returns the first time in UTC strictly after the given time matching the schedule. The zero
+// time is returned if nothing matches within the next five years.
+func (c cronSchedule) next(after time.Time) time.Time {
+	return c.schedule.Next(after.UTC())
+}
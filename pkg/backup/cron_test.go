@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronNext(t *testing.T) {
+	// Monday
+	after := time.Date(2024, time.January, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		cron string
+		want time.Time
+	}{
+		"Nightly":          {cron: "0 2 * * *", want: time.Date(2024, time.January, 16, 2, 0, 0, 0, time.UTC)},
+		"Macro":            {cron: "@daily", want: time.Date(2024, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		"Step":             {cron: "*/15 * * * *", want: time.Date(2024, time.January, 15, 10, 45, 0, 0, time.UTC)},
+		"List":             {cron: "0 9,12 * * *", want: time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)},
+		"WeekdayRange":     {cron: "0 1 * * 5-6", want: time.Date(2024, time.January, 19, 1, 0, 0, 0, time.UTC)},
+		"DayOfMonthOrWeek": {cron: "0 0 1 * 3", want: time.Date(2024, time.January, 17, 0, 0, 0, 0, time.UTC)},
+		"LeapDay":          {cron: "0 0 29 2 *", want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			schedule, err := parseCron(test.cron)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.want, schedule.next(after))
+		})
+	}
+}
+
+func TestCronNext_IsStrictlyAfter(t *testing.T) {
+	schedule, err := parseCron("0 2 * * *")
+	require.NoError(t, err)
+
+	at := time.Date(2024, time.January, 15, 2, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, at.AddDate(0, 0, 1), schedule.next(at))
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"TooFewFields": "0 2 * *",
+		"OutOfRange":   "60 * * * *",
+		"BadStep":      "*/0 * * * *",
+		"BadRange":     "0 5-1 * * *",
+		"NeverMatches": "0 0 31 2 *",
+	}
+
+	for name, cron := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseCron(cron)
+
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	now := time.Date(2024, time.January, 15, 2, 0, 0, 0, time.UTC)
+
+	name := backupName("qa", now)
+
+	assert.Equal(t, "qa-backup-20240115T020000Z.pgc", name)
+}
@@ -0,0 +1,36 @@
+// Package backup runs scheduled backups of the database and filestore of running deployments.
+//
+// Schedules are cron expressions persisted per deployment. Each replica polls for due schedules and
+// claims a run by moving its next run forward, so a run is only executed once across restarts and
+// replicas. Backups are named <deployment>-backup-<timestamp>.pgc and older backups are pruned
+// beyond the number to keep.
+//
+// swagger:meta
+package backup
+
+import "github.com/dhis2-sre/im-manager/pkg/model"
+
+// swagger:response BackupSchedule
+type BackupScheduleBody struct {
+	// in: body
+	Body model.BackupSchedule
+}
+
+// swagger:parameters saveBackupSchedule
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Save backup schedule request body
+	// in: body
+	// required: true
+	Body SaveBackupScheduleRequest
+}
+
+// swagger:parameters findBackupSchedule deleteBackupSchedule
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+}
@@ -0,0 +1,13 @@
+package backup
+
+const kindScheduledBackup = "scheduled-backup"
+
+// backupEvent is the JSON payload published when a scheduled backup finishes.
+type backupEvent struct {
+	Status         string `json:"status"`
+	ScheduleID     uint   `json:"scheduleId"`
+	DeploymentID   uint   `json:"deploymentId"`
+	DeploymentName string `json:"deploymentName"`
+	DatabaseID     uint   `json:"databaseId,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
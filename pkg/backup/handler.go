@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/internal/handler"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/gin-gonic/gin"
+)
+
+func NewHandler(backupService *Service, deploymentService deploymentFinder) Handler {
+	return Handler{
+		backupService:     backupService,
+		deploymentService: deploymentService,
+	}
+}
+
+type deploymentFinder interface {
+	FindDeploymentById(ctx context.Context, id uint) (*model.Deployment, error)
+}
+
+type Handler struct {
+	backupService     *Service
+	deploymentService deploymentFinder
+}
+
+type SaveBackupScheduleRequest struct {
+	// Cron expression in UTC, e.g. "0 2 * * *" for every night at 02:00
+	Cron string `json:"cron" binding:"required"`
+	// Number of backups to keep, 0 keeps all backups
+	Keep    uint  `json:"keep"`
+	Enabled *bool `json:"enabled"`
+}
+
+// Save backup schedule
+func (h Handler) Save(c *gin.Context) {
+	// swagger:route PUT /deployments/{id}/backup-schedule saveBackupSchedule
+	//
+	// Save backup schedule
+	//
+	// Create or replace the schedule which backs up the database and filestore of the deployment
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: BackupSchedule
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	var request SaveBackupScheduleRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	deployment, user, ok := h.authorize(c, handler.CanWriteDeployment)
+	if !ok {
+		return
+	}
+
+	enabled := true
+	if request.Enabled != nil {
+		enabled = *request.Enabled
+	}
+
+	schedule := &model.BackupSchedule{
+		DeploymentID: deployment.ID,
+		UserID:       user.ID,
+		Cron:         request.Cron,
+		Keep:         request.Keep,
+		Enabled:      enabled,
+	}
+
+	err := h.backupService.Save(c.Request.Context(), schedule)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// Find backup schedule
+func (h Handler) Find(c *gin.Context) {
+	// swagger:route GET /deployments/{id}/backup-schedule findBackupSchedule
+	//
+	// Find backup schedule
+	//
+	// Find the backup schedule of the deployment
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: BackupSchedule
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	deployment, _, ok := h.authorize(c, handler.CanReadDeployment)
+	if !ok {
+		return
+	}
+
+	schedule, err := h.backupService.Find(c.Request.Context(), deployment.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// Delete backup schedule
+func (h Handler) Delete(c *gin.Context) {
+	// swagger:route DELETE /deployments/{id}/backup-schedule deleteBackupSchedule
+	//
+	// Delete backup schedule
+	//
+	// Delete the backup schedule of the deployment. Existing backups aren't deleted
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	deployment, _, ok := h.authorize(c, handler.CanWriteDeployment)
+	if !ok {
+		return
+	}
+
+	err := h.backupService.Delete(c.Request.Context(), deployment.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// authorize finds the deployment given by the id path parameter and ensures the user can access it
+func (h Handler) authorize(c *gin.Context, canAccess func(*model.User, *model.Deployment) bool) (*model.Deployment, *model.User, bool) {
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return nil, nil, false
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return nil, nil, false
+	}
+
+	deployment, err := h.deploymentService.FindDeploymentById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return nil, nil, false
+	}
+
+	if !canAccess(user, deployment) {
+		unauthorized := errdef.NewUnauthorized("access denied")
+		_ = c.Error(unauthorized)
+		return nil, nil, false
+	}
+
+	return deployment, user, true
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"gorm.io/gorm"
+)
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func (r repository) save(ctx context.Context, schedule *model.BackupSchedule) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Omit("Deployment", "User").Save(schedule).Error
+	if err != nil {
+		return fmt.Errorf("failed to save backup schedule: %v", err)
+	}
+
+	return nil
+}
+
+func (r repository) findByDeploymentId(ctx context.Context, deploymentId uint) (*model.BackupSchedule, error) {
+	var schedule *model.BackupSchedule
+	err := r.db.
+		WithContext(ctx).
+		Where("deployment_id = ?", deploymentId).
+		First(&schedule).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errdef.NewNotFound("backup schedule not found by deployment id: %d", deploymentId)
+		}
+		return nil, fmt.Errorf("failed to find backup schedule: %v", err)
+	}
+
+	return schedule, nil
+}
+
+func (r repository) findDue(ctx context.Context, now time.Time) ([]model.BackupSchedule, error) {
+	var schedules []model.BackupSchedule
+	err := r.db.
+		WithContext(ctx).
+		Where("enabled = ?", true).
+		Where("next_run_at <= ?", now).
+		Order("next_run_at").
+		Find(&schedules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due backup schedules: %v", err)
+	}
+
+	return schedules, nil
+}
+
+// claim moves the next run of the schedule forward. The update only succeeds if the next run is
+// still the one read by the caller, so only one replica claims each run.
+func (r repository) claim(ctx context.Context, schedule model.BackupSchedule, nextRunAt, now time.Time) (bool, error) {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	result := r.db.
+		WithContext(ctx).
+		Model(&model.BackupSchedule{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, schedule.NextRunAt).
+		UpdateColumns(map[string]any{"next_run_at": nextRunAt, "last_run_at": now, "last_status": statusRunning, "last_error": ""})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim backup schedule: %v", result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (r repository) updateResult(ctx context.Context, id uint, status, errMsg string) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.BackupSchedule{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]any{"last_status": status, "last_error": errMsg}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update backup schedule result: %v", err)
+	}
+
+	return nil
+}
+
+func (r repository) delete(ctx context.Context, id uint) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Delete(&model.BackupSchedule{}, id).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete backup schedule: %v", err)
+	}
+
+	return nil
+}
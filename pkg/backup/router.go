@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func Routes(r *gin.Engine, authenticator gin.HandlerFunc, handler Handler) {
+	tokenAuthenticationRouter := r.Group("/deployments")
+	tokenAuthenticationRouter.Use(authenticator)
+
+	tokenAuthenticationRouter.GET("/:id/backup-schedule", handler.Find)
+	tokenAuthenticationRouter.PUT("/:id/backup-schedule", handler.Save)
+	tokenAuthenticationRouter.DELETE("/:id/backup-schedule", handler.Delete)
+}
@@ -0,0 +1,282 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/instance"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+)
+
+const (
+	statusRunning = "running"
+	statusSuccess = "success"
+	statusSkipped = "skipped"
+	statusError   = "error"
+)
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewService(logger *slog.Logger, repository *repository, instanceService instanceService, stackService stackService, databaseService databaseService, publisher Publisher) *Service {
+	return &Service{
+		logger:          logger,
+		repository:      repository,
+		instanceService: instanceService,
+		stackService:    stackService,
+		databaseService: databaseService,
+		publisher:       publisher,
+	}
+}
+
+type instanceService interface {
+	FindDecryptedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error)
+	GetStatus(instance *model.DeploymentInstance) (instance.InstanceStatus, error)
+	FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database, progress *storage.ProgressTracker) error
+}
+
+type stackService interface {
+	Find(name string) (*model.Stack, error)
+}
+
+type databaseService interface {
+	CreateBackupDatabase(ctx context.Context, userId uint, groupName, name string, scheduleID uint) (*model.Database, error)
+	Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides) (*model.Database, error)
+	FindByBackupSchedule(ctx context.Context, scheduleID uint) ([]model.Database, error)
+	Delete(ctx context.Context, id uint) error
+	CheckStorageQuota(ctx context.Context, groupName string, size int64) (int64, error)
+	NotifyStorageQuota(ctx context.Context, userId uint, groupName string, usedBefore int64)
+}
+
+// Publisher publishes notifications for async cross-service operations.
+type Publisher interface {
+	Publish(ctx context.Context, userID uint, groupName, kind string, payload any)
+}
+
+type Service struct {
+	logger          *slog.Logger
+	repository      *repository
+	instanceService instanceService
+	stackService    stackService
+	databaseService databaseService
+	publisher       Publisher
+}
+
+func (s Service) Find(ctx context.Context, deploymentId uint) (*model.BackupSchedule, error) {
+	return s.repository.findByDeploymentId(ctx, deploymentId)
+}
+
+// Save creates or replaces the backup schedule of the deployment and schedules its next run.
+func (s Service) Save(ctx context.Context, schedule *model.BackupSchedule) error {
+	cron, err := parseCron(schedule.Cron)
+	if err != nil {
+		return errdef.NewBadRequest("invalid cron expression: %v", err)
+	}
+
+	existing, err := s.repository.findByDeploymentId(ctx, schedule.DeploymentID)
+	if err != nil && !errdef.IsNotFound(err) {
+		return err
+	}
+	if existing != nil {
+		schedule.ID = existing.ID
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.LastRunAt = existing.LastRunAt
+		schedule.LastStatus = existing.LastStatus
+		schedule.LastError = existing.LastError
+	}
+
+	schedule.NextRunAt = cron.next(time.Now())
+
+	return s.repository.save(ctx, schedule)
+}
+
+func (s Service) Delete(ctx context.Context, deploymentId uint) error {
+	schedule, err := s.repository.findByDeploymentId(ctx, deploymentId)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.delete(ctx, schedule.ID)
+}
+
+// RunDue claims all schedules which are due and runs their backups in the background.
+func (s Service) RunDue(ctx context.Context) error {
+	now := time.Now()
+	schedules, err := s.repository.findDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		cron, err := parseCron(schedule.Cron)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Invalid backup schedule", "scheduleId", schedule.ID, "cron", schedule.Cron, "error", err)
+			continue
+		}
+
+		claimed, err := s.repository.claim(ctx, schedule, cron.next(now), now)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to claim backup schedule", "scheduleId", schedule.ID, "error", err)
+			continue
+		}
+		if !claimed {
+			// another replica got there first
+			continue
+		}
+
+		go s.run(ctx, schedule, now)
+	}
+
+	return nil
+}
+
+func (s Service) run(ctx context.Context, schedule model.BackupSchedule, now time.Time) {
+	deployment, err := s.instanceService.FindDecryptedDeploymentById(ctx, schedule.DeploymentID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to find deployment of backup schedule", "scheduleId", schedule.ID, "deploymentId", schedule.DeploymentID, "error", err)
+		if err := s.repository.updateResult(ctx, schedule.ID, statusError, err.Error()); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to update backup schedule", "scheduleId", schedule.ID, "error", err)
+		}
+		return
+	}
+
+	event := backupEvent{
+		ScheduleID:     schedule.ID,
+		DeploymentID:   deployment.ID,
+		DeploymentName: deployment.Name,
+	}
+
+	event.Status, err = s.backup(ctx, schedule, deployment, now, &event)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Scheduled backup failed", "scheduleId", schedule.ID, "deploymentId", deployment.ID, "error", err)
+		event.Error = err.Error()
+	}
+
+	if err := s.repository.updateResult(ctx, schedule.ID, event.Status, event.Error); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to update backup schedule", "scheduleId", schedule.ID, "error", err)
+	}
+
+	s.publisher.Publish(ctx, schedule.UserID, deployment.GroupName, kindScheduledBackup, event)
+}
+
+// backup dumps the database and backs up the filestore of the deployment. Deployments which
+// aren't running, e.g. paused deployments, are skipped.
+func (s Service) backup(ctx context.Context, schedule model.BackupSchedule, deployment *model.Deployment, now time.Time, event *backupEvent) (string, error) {
+	dbInstance := findInstanceByStack(deployment, "dhis2-db")
+	if dbInstance == nil {
+		return statusError, fmt.Errorf("deployment %q has no dhis2-db instance", deployment.Name)
+	}
+
+	status, err := s.instanceService.GetStatus(dbInstance)
+	if err != nil {
+		return statusError, err
+	}
+	if status != instance.Running {
+		s.logger.InfoContext(ctx, "Skipping scheduled backup of deployment which isn't running", "deploymentId", deployment.ID, "status", status)
+		return statusSkipped, nil
+	}
+
+	stack, err := s.stackService.Find(dbInstance.StackName)
+	if err != nil {
+		return statusError, err
+	}
+
+	created, err := s.databaseService.CreateBackupDatabase(ctx, schedule.UserID, deployment.GroupName, backupName(deployment.Name, now), schedule.ID)
+	if err != nil {
+		return statusError, err
+	}
+
+	dumped, err := s.databaseService.Dump(ctx, schedule.UserID, created, dbInstance, stack, "custom", model.DumpOverrides{})
+	if err != nil {
+		if deleteErr := s.databaseService.Delete(ctx, created.ID); deleteErr != nil {
+			s.logger.ErrorContext(ctx, "Failed to delete failed backup", "databaseId", created.ID, "error", deleteErr)
+		}
+		return statusError, err
+	}
+	event.DatabaseID = dumped.ID
+
+	coreInstance := findInstanceByStack(deployment, "dhis2-core")
+	if coreInstance != nil {
+		usedBefore, err := s.databaseService.CheckStorageQuota(ctx, deployment.GroupName, 0)
+		if err != nil {
+			return statusError, fmt.Errorf("failed to backup filestore: %v", err)
+		}
+
+		err = s.instanceService.FilestoreBackup(ctx, coreInstance, dumped.Name, dumped, nil)
+		if err != nil {
+			return statusError, fmt.Errorf("failed to backup filestore: %v", err)
+		}
+
+		s.databaseService.NotifyStorageQuota(ctx, schedule.UserID, deployment.GroupName, usedBefore)
+	}
+
+	err = s.prune(ctx, schedule)
+	if err != nil {
+		return statusError, err
+	}
+
+	return statusSuccess, nil
+}
+
+// prune deletes all but the newest schedule.Keep backups of the schedule.
+func (s Service) prune(ctx context.Context, schedule model.BackupSchedule) error {
+	if schedule.Keep == 0 {
+		return nil
+	}
+
+	backups, err := s.databaseService.FindByBackupSchedule(ctx, schedule.ID)
+	if err != nil {
+		return err
+	}
+
+	if uint(len(backups)) <= schedule.Keep {
+		return nil
+	}
+
+	for _, backup := range backups[schedule.Keep:] {
+		err := s.databaseService.Delete(ctx, backup.ID)
+		if err != nil {
+			return fmt.Errorf("failed to prune backup %q: %v", backup.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func findInstanceByStack(deployment *model.Deployment, stackName string) *model.DeploymentInstance {
+	for _, deploymentInstance := range deployment.Instances {
+		if deploymentInstance.StackName == stackName {
+			return deploymentInstance
+		}
+	}
+	return nil
+}
+
+// backupName names backups <deployment>-backup-<UTC timestamp>.pgc so they sort by time
+func backupName(deploymentName string, now time.Time) string {
+	return deploymentName + "-backup-" + now.UTC().Format("20060102T150405Z") + ".pgc"
+}
+
+func NewScheduler(logger *slog.Logger, service *Service, interval time.Duration) scheduler {
+	return scheduler{logger, service, interval}
+}
+
+type scheduler struct {
+	logger   *slog.Logger
+	service  *Service
+	interval time.Duration
+}
+
+// Schedule periodically runs the backups which are due.
+func (s scheduler) Schedule(ctx context.Context) {
+	for {
+		time.Sleep(s.interval)
+
+		err := s.service.RunDue(ctx)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to run scheduled backups", "error", err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/instance"
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	userpkg "github.com/dhis2-sre/im-manager/pkg/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupService(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	repository := NewRepository(db)
+	user, _ := userpkg.CreateUserWithGroup(t, db, "group", "some", "", "user1@dhis2.org")
+
+	ctx := context.Background()
+	createDeployment := func(t *testing.T, name string) *model.Deployment {
+		t.Helper()
+
+		deployment := &model.Deployment{UserID: user.ID, Name: name, GroupName: "group"}
+		require.NoError(t, db.Create(deployment).Error)
+		deployment.Instances = []*model.DeploymentInstance{
+			{Name: name, GroupName: "group", StackName: "dhis2-db", DeploymentID: deployment.ID},
+			{Name: name, GroupName: "group", StackName: "dhis2-core", DeploymentID: deployment.ID},
+		}
+		return deployment
+	}
+	createSchedule := func(t *testing.T, deployment *model.Deployment, nextRunAt time.Time, enabled bool, keep uint) model.BackupSchedule {
+		t.Helper()
+
+		schedule := model.BackupSchedule{DeploymentID: deployment.ID, UserID: user.ID, Cron: "0 2 * * *", Keep: keep, Enabled: enabled, NextRunAt: nextRunAt}
+		require.NoError(t, repository.save(ctx, &schedule))
+		return schedule
+	}
+	newService := func(instanceService *fakeInstanceService, databaseService *fakeDatabaseService, publisher *fakePublisher) Service {
+		return Service{logger: logger, repository: repository, instanceService: instanceService, stackService: fakeStackService{}, databaseService: databaseService, publisher: publisher}
+	}
+
+	t.Run("RunDue", func(t *testing.T) {
+		due := createDeployment(t, "due")
+		notDue := createDeployment(t, "not-due")
+		disabled := createDeployment(t, "disabled")
+		past := time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+		future := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+		dueSchedule := createSchedule(t, due, past, true, 0)
+		createSchedule(t, notDue, future, true, 0)
+		createSchedule(t, disabled, past, false, 0)
+		instanceService := newFakeInstanceService(due, notDue, disabled)
+		databaseService := &fakeDatabaseService{}
+		publisher := &fakePublisher{}
+		s := newService(instanceService, databaseService, publisher)
+
+		err := s.RunDue(ctx)
+
+		require.NoError(t, err)
+		schedule := waitForResult(t, repository, due.ID)
+		assert.Equal(t, statusSuccess, schedule.LastStatus)
+		assert.Empty(t, schedule.LastError)
+		require.NotNil(t, schedule.LastRunAt)
+		cron, err := parseCron(dueSchedule.Cron)
+		require.NoError(t, err)
+		assert.True(t, schedule.NextRunAt.After(time.Now()), "the next run is moved forward")
+		assert.WithinDuration(t, cron.next(*schedule.LastRunAt), schedule.NextRunAt, time.Second)
+		assert.Equal(t, []string{"due"}, databaseService.dumpedDeployments())
+		events := publisher.backupEvents()
+		require.Len(t, events, 1)
+		assert.Equal(t, statusSuccess, events[0].Status)
+		assert.Equal(t, due.ID, events[0].DeploymentID)
+		assert.NotZero(t, events[0].DatabaseID)
+		assert.Equal(t, 1, instanceService.filestoreBackups(), "the filestore is backed up along with the database")
+
+		for _, deployment := range []*model.Deployment{notDue, disabled} {
+			schedule, err := repository.findByDeploymentId(ctx, deployment.ID)
+			require.NoError(t, err)
+			assert.Nil(t, schedule.LastRunAt, "schedule of deployment %q isn't run", deployment.Name)
+			assert.Empty(t, schedule.LastStatus)
+		}
+
+		err = s.RunDue(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"due"}, databaseService.dumpedDeployments(), "a run isn't repeated before the schedule is due again")
+	})
+
+	t.Run("ClaimIsExclusive", func(t *testing.T) {
+		deployment := createDeployment(t, "claim")
+		now := time.Now().Truncate(time.Microsecond)
+		schedule := createSchedule(t, deployment, now.Add(-time.Minute), true, 0)
+
+		claimed, err := repository.claim(ctx, schedule, now.Add(time.Hour), now)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+
+		claimed, err = repository.claim(ctx, schedule, now.Add(time.Hour), now)
+		require.NoError(t, err)
+		assert.False(t, claimed, "the run was claimed by another replica")
+	})
+
+	t.Run("DeploymentNotFound", func(t *testing.T) {
+		deployment := createDeployment(t, "not-found")
+		schedule := createSchedule(t, deployment, time.Now(), true, 0)
+		s := newService(newFakeInstanceService(), &fakeDatabaseService{}, &fakePublisher{})
+
+		s.run(ctx, schedule, time.Now())
+
+		actual, err := repository.findByDeploymentId(ctx, deployment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, statusError, actual.LastStatus)
+		assert.Contains(t, actual.LastError, "not found")
+	})
+
+	t.Run("DeploymentNotRunning", func(t *testing.T) {
+		deployment := createDeployment(t, "paused")
+		schedule := createSchedule(t, deployment, time.Now(), true, 0)
+		instanceService := newFakeInstanceService(deployment)
+		instanceService.status = instance.NotDeployed
+		databaseService := &fakeDatabaseService{}
+		publisher := &fakePublisher{}
+		s := newService(instanceService, databaseService, publisher)
+
+		s.run(ctx, schedule, time.Now())
+
+		actual, err := repository.findByDeploymentId(ctx, deployment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, statusSkipped, actual.LastStatus)
+		assert.Empty(t, databaseService.dumpedDeployments())
+		require.Len(t, publisher.backupEvents(), 1)
+		assert.Equal(t, statusSkipped, publisher.backupEvents()[0].Status)
+	})
+
+	t.Run("DumpFails", func(t *testing.T) {
+		deployment := createDeployment(t, "dump-fails")
+		schedule := createSchedule(t, deployment, time.Now(), true, 0)
+		databaseService := &fakeDatabaseService{dumpErr: errors.New("dump failed")}
+		publisher := &fakePublisher{}
+		instanceService := newFakeInstanceService(deployment)
+		s := newService(instanceService, databaseService, publisher)
+
+		s.run(ctx, schedule, time.Now())
+
+		actual, err := repository.findByDeploymentId(ctx, deployment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, statusError, actual.LastStatus)
+		assert.Equal(t, "dump failed", actual.LastError)
+		assert.Equal(t, databaseService.createdIDs(), databaseService.deletedIDs(), "the failed backup is deleted")
+		assert.Zero(t, instanceService.filestoreBackups())
+		events := publisher.backupEvents()
+		require.Len(t, events, 1)
+		assert.Equal(t, statusError, events[0].Status)
+		assert.Equal(t, "dump failed", events[0].Error)
+	})
+
+	t.Run("FilestoreBackupFails", func(t *testing.T) {
+		deployment := createDeployment(t, "filestore-fails")
+		schedule := createSchedule(t, deployment, time.Now(), true, 1)
+		instanceService := newFakeInstanceService(deployment)
+		instanceService.filestoreErr = errors.New("filestore failed")
+		databaseService := &fakeDatabaseService{backups: []model.Database{{ID: 10}, {ID: 9}}}
+		s := newService(instanceService, databaseService, &fakePublisher{})
+
+		s.run(ctx, schedule, time.Now())
+
+		actual, err := repository.findByDeploymentId(ctx, deployment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, statusError, actual.LastStatus)
+		assert.Contains(t, actual.LastError, "filestore failed")
+		assert.Empty(t, databaseService.deletedIDs(), "backups aren't pruned after a failed backup")
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		deployment := createDeployment(t, "retention")
+		schedule := createSchedule(t, deployment, time.Now(), true, 2)
+		// newest first
+		databaseService := &fakeDatabaseService{backups: []model.Database{{ID: 4}, {ID: 3}, {ID: 2}, {ID: 1}}}
+		s := newService(newFakeInstanceService(deployment), databaseService, &fakePublisher{})
+
+		s.run(ctx, schedule, time.Now())
+
+		actual, err := repository.findByDeploymentId(ctx, deployment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, statusSuccess, actual.LastStatus)
+		assert.Equal(t, []uint{2, 1}, databaseService.deletedIDs(), "all but the newest backups are deleted")
+	})
+
+	t.Run("KeepAll", func(t *testing.T) {
+		deployment := createDeployment(t, "keep-all")
+		schedule := createSchedule(t, deployment, time.Now(), true, 0)
+		databaseService := &fakeDatabaseService{backups: []model.Database{{ID: 4}, {ID: 3}, {ID: 2}, {ID: 1}}}
+		s := newService(newFakeInstanceService(deployment), databaseService, &fakePublisher{})
+
+		s.run(ctx, schedule, time.Now())
+
+		assert.Empty(t, databaseService.deletedIDs())
+	})
+}
+
+// waitForResult waits for the background run of the schedule of the deployment to finish
+func waitForResult(t *testing.T, repository *repository, deploymentID uint) *model.BackupSchedule {
+	t.Helper()
+
+	var schedule *model.BackupSchedule
+	require.Eventually(t, func() bool {
+		var err error
+		schedule, err = repository.findByDeploymentId(context.Background(), deploymentID)
+		require.NoError(t, err)
+		return schedule.LastStatus != "" && schedule.LastStatus != statusRunning
+	}, 10*time.Second, 50*time.Millisecond)
+	return schedule
+}
+
+type fakeInstanceService struct {
+	deployments  map[uint]*model.Deployment
+	status       instance.InstanceStatus
+	filestoreErr error
+
+	mu        sync.Mutex
+	filestore int
+}
+
+func newFakeInstanceService(deployments ...*model.Deployment) *fakeInstanceService {
+	f := &fakeInstanceService{deployments: map[uint]*model.Deployment{}, status: instance.Running}
+	for _, deployment := range deployments {
+		f.deployments[deployment.ID] = deployment
+	}
+	return f
+}
+
+func (f *fakeInstanceService) FindDecryptedDeploymentById(_ context.Context, id uint) (*model.Deployment, error) {
+	deployment, ok := f.deployments[id]
+	if !ok {
+		return nil, errdef.NewNotFound("deployment not found by id: %d", id)
+	}
+	return deployment, nil
+}
+
+func (f *fakeInstanceService) GetStatus(*model.DeploymentInstance) (instance.InstanceStatus, error) {
+	return f.status, nil
+}
+
+func (f *fakeInstanceService) FilestoreBackup(context.Context, *model.DeploymentInstance, string, *model.Database, *storage.ProgressTracker) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filestore++
+	return f.filestoreErr
+}
+
+func (f *fakeInstanceService) filestoreBackups() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.filestore
+}
+
+type fakeStackService struct{}
+
+func (fakeStackService) Find(name string) (*model.Stack, error) {
+	return &model.Stack{Name: name}, nil
+}
+
+type fakeDatabaseService struct {
+	dumpErr error
+	// backups are the backups of the schedule, newest first
+	backups []model.Database
+
+	mu      sync.Mutex
+	nextID  uint
+	created []uint
+	dumped  []string
+	deleted []uint
+}
+
+func (f *fakeDatabaseService) CreateBackupDatabase(_ context.Context, userId uint, groupName, name string, scheduleID uint) (*model.Database, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := 100 + f.nextID
+	f.created = append(f.created, id)
+	return &model.Database{ID: id, Name: name, GroupName: groupName, UserID: userId, BackupScheduleID: scheduleID}, nil
+}
+
+func (f *fakeDatabaseService) Dump(_ context.Context, _ uint, database *model.Database, instance *model.DeploymentInstance, _ *model.Stack, _ string, _ model.DumpOverrides) (*model.Database, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dumpErr != nil {
+		return nil, f.dumpErr
+	}
+	f.dumped = append(f.dumped, instance.Name)
+	return database, nil
+}
+
+func (f *fakeDatabaseService) FindByBackupSchedule(context.Context, uint) ([]model.Database, error) {
+	return f.backups, nil
+}
+
+func (f *fakeDatabaseService) Delete(_ context.Context, id uint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeDatabaseService) CheckStorageQuota(context.Context, string, int64) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeDatabaseService) NotifyStorageQuota(context.Context, uint, string, int64) {}
+
+func (f *fakeDatabaseService) createdIDs() []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created
+}
+
+func (f *fakeDatabaseService) dumpedDeployments() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dumped
+}
+
+func (f *fakeDatabaseService) deletedIDs() []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleted
+}
+
+type fakePublisher struct {
+	mu     sync.Mutex
+	events []backupEvent
+}
+
+func (f *fakePublisher) Publish(_ context.Context, _ uint, _, kind string, payload any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if event, ok := payload.(backupEvent); ok && kind == kindScheduledBackup {
+		f.events = append(f.events, event)
+	}
+}
+
+func (f *fakePublisher) backupEvents() []backupEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.events
+}
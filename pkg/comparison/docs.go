@@ -0,0 +1,37 @@
+// Package comparison runs several DHIS2 versions side by side on copies of the same database.
+//
+// A comparison creates one deployment per image tag. Each deployment is seeded from its own copy of
+// the source database and all deployments share TTL and lifecycle, so they can be listed, paused,
+// resumed and destroyed together.
+//
+// swagger:meta
+package comparison
+
+import "github.com/dhis2-sre/im-manager/pkg/model"
+
+// swagger:response Comparison
+type ComparisonBody struct {
+	// in: body
+	Body model.Comparison
+}
+
+// swagger:response Comparisons
+type ComparisonsBody struct {
+	// in: body
+	Body []model.Comparison
+}
+
+// swagger:parameters createComparison
+type _ struct {
+	// Create comparison request body
+	// in: body
+	// required: true
+	Body CreateComparisonRequest
+}
+
+// swagger:parameters findComparison pauseComparison resumeComparison deleteComparison
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+}
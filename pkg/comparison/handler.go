@@ -0,0 +1,298 @@
+package comparison
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/internal/handler"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/gin-gonic/gin"
+)
+
+func NewHandler(comparisonService *Service, groupService groupService, databaseService databaseFinder, defaultTTL uint) Handler {
+	return Handler{
+		comparisonService: comparisonService,
+		groupService:      groupService,
+		databaseService:   databaseService,
+		defaultTTL:        defaultTTL,
+	}
+}
+
+type databaseFinder interface {
+	FindById(ctx context.Context, id uint) (*model.Database, error)
+}
+
+type Handler struct {
+	comparisonService *Service
+	groupService      groupService
+	databaseService   databaseFinder
+	defaultTTL        uint
+}
+
+type CreateComparisonRequest struct {
+	Name            string   `json:"name" binding:"required,dns_rfc1035_label"`
+	Group           string   `json:"group" binding:"required"`
+	DatabaseID      uint     `json:"databaseId" binding:"required"`
+	ImageTags       []string `json:"imageTags" binding:"required,min=2,dive,required"`
+	ImageRepository string   `json:"imageRepository"`
+	TTL             uint     `json:"ttl"`
+}
+
+// Create comparison
+func (h Handler) Create(c *gin.Context) {
+	// swagger:route POST /comparisons createComparison
+	//
+	// Create comparison
+	//
+	// Create one deployment per image tag, each seeded from its own copy of the given database
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	201: Comparison
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	var request CreateComparisonRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	group, err := h.groupService.Find(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !group.Deployable {
+		forbidden := errdef.NewForbidden("group isn't deployable: %s", group.Name)
+		_ = c.Error(forbidden)
+		return
+	}
+
+	database, err := h.databaseService.FindById(ctx, request.DatabaseID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !handler.CanAccess(user, database) {
+		forbidden := errdef.NewForbidden("access denied")
+		_ = c.Error(forbidden)
+		return
+	}
+
+	if request.TTL == 0 {
+		request.TTL = h.defaultTTL
+	}
+
+	comparison := &model.Comparison{
+		UserID:     user.ID,
+		Name:       request.Name,
+		GroupName:  group.Name,
+		DatabaseID: database.ID,
+		TTL:        request.TTL,
+	}
+
+	if !handler.CanWriteComparison(user, comparison) {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	token, err := handler.GetTokenFromRequest(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.comparisonService.Create(ctx, token, comparison, request.ImageTags, request.ImageRepository)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, comparison)
+}
+
+// Find comparison
+func (h Handler) Find(c *gin.Context) {
+	// swagger:route GET /comparisons/{id} findComparison
+	//
+	// Find comparison
+	//
+	// Find a comparison by id
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: Comparison
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	comparison, err := h.comparisonService.Find(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !handler.CanReadComparison(user, comparison) {
+		unauthorized := errdef.NewUnauthorized("read access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+// FindAll comparisons
+func (h Handler) FindAll(c *gin.Context) {
+	// swagger:route GET /comparisons findComparisons
+	//
+	// Find comparisons
+	//
+	// Find all comparisons in the groups the user is a member of
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: Comparisons
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	comparisons, err := h.comparisonService.FindAll(ctx, user)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparisons)
+}
+
+// Pause comparison
+func (h Handler) Pause(c *gin.Context) {
+	// swagger:route PUT /comparisons/{id}/pause pauseComparison
+	//
+	// Pause comparison
+	//
+	// Pause all deployments of a comparison
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	h.lifecycle(c, h.comparisonService.Pause)
+}
+
+// Resume comparison
+func (h Handler) Resume(c *gin.Context) {
+	// swagger:route PUT /comparisons/{id}/resume resumeComparison
+	//
+	// Resume comparison
+	//
+	// Resume all deployments of a comparison
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	h.lifecycle(c, h.comparisonService.Resume)
+}
+
+// Delete comparison
+func (h Handler) Delete(c *gin.Context) {
+	// swagger:route DELETE /comparisons/{id} deleteComparison
+	//
+	// Delete comparison
+	//
+	// Destroy all deployments of a comparison, the database copies they were seeded from and the comparison itself
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	h.lifecycle(c, h.comparisonService.Delete)
+}
+
+func (h Handler) lifecycle(c *gin.Context, fn func(ctx context.Context, id uint) error) {
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	comparison, err := h.comparisonService.Find(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !handler.CanWriteComparison(user, comparison) {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	err = fn(ctx, comparison.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,82 @@
+package comparison
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"gorm.io/gorm"
+)
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func (r repository) save(ctx context.Context, comparison *model.Comparison) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Omit("Deployments").Save(comparison).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errdef.NewDuplicated("a comparison named %q already exists in group %q", comparison.Name, comparison.GroupName)
+		}
+		return fmt.Errorf("failed to save comparison: %v", err)
+	}
+
+	return nil
+}
+
+func (r repository) find(ctx context.Context, id uint) (*model.Comparison, error) {
+	var comparison *model.Comparison
+	err := r.db.
+		WithContext(ctx).
+		Joins("User").
+		Preload("Deployments.Instances").
+		First(&comparison, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errdef.NewNotFound("comparison not found by id: %d", id)
+		}
+		return nil, fmt.Errorf("failed to find comparison: %v", err)
+	}
+
+	return comparison, nil
+}
+
+func (r repository) findByGroupNames(ctx context.Context, groupNames []string) ([]model.Comparison, error) {
+	var comparisons []model.Comparison
+	err := r.db.
+		WithContext(ctx).
+		Joins("User").
+		Preload("Deployments.Instances").
+		Where("group_name IN ?", groupNames).
+		Order("updated_at desc").
+		Find(&comparisons).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find comparisons: %v", err)
+	}
+
+	return comparisons, nil
+}
+
+func (r repository) delete(ctx context.Context, id uint) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Delete(&model.Comparison{}, id).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete comparison: %v", err)
+	}
+
+	return nil
+}
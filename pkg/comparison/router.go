@@ -0,0 +1,17 @@
+package comparison
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func Routes(r *gin.Engine, authenticator gin.HandlerFunc, handler Handler) {
+	tokenAuthenticationRouter := r.Group("/comparisons")
+	tokenAuthenticationRouter.Use(authenticator)
+
+	tokenAuthenticationRouter.POST("", handler.Create)
+	tokenAuthenticationRouter.GET("", handler.FindAll)
+	tokenAuthenticationRouter.GET("/:id", handler.Find)
+	tokenAuthenticationRouter.PUT("/:id/pause", handler.Pause)
+	tokenAuthenticationRouter.PUT("/:id/resume", handler.Resume)
+	tokenAuthenticationRouter.DELETE("/:id", handler.Delete)
+}
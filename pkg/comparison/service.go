@@ -0,0 +1,289 @@
+package comparison
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+func NewService(logger *slog.Logger, repository *repository, groupService groupService, databaseService databaseService, instanceService instanceService, deploymentService deploymentService) *Service {
+	return &Service{
+		logger:            logger,
+		repository:        repository,
+		groupService:      groupService,
+		databaseService:   databaseService,
+		instanceService:   instanceService,
+		deploymentService: deploymentService,
+	}
+}
+
+type groupService interface {
+	Find(ctx context.Context, name string) (*model.Group, error)
+}
+
+type databaseService interface {
+	FindById(ctx context.Context, id uint) (*model.Database, error)
+	Copy(ctx context.Context, id uint, d *model.Database, group *model.Group) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type instanceService interface {
+	SaveDeployment(ctx context.Context, deployment *model.Deployment) error
+	SaveInstance(ctx context.Context, instance *model.DeploymentInstance) error
+	FindDecryptedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error)
+	DeleteDeployment(ctx context.Context, deployment *model.Deployment) error
+	Pause(ctx context.Context, instance *model.DeploymentInstance) error
+	Resume(ctx context.Context, instance *model.DeploymentInstance) error
+}
+
+type deploymentService interface {
+	DeployDeployment(ctx context.Context, token string, deployment *model.Deployment) error
+}
+
+type Service struct {
+	logger            *slog.Logger
+	repository        *repository
+	groupService      groupService
+	databaseService   databaseService
+	instanceService   instanceService
+	deploymentService deploymentService
+}
+
+// Create creates a comparison with one deployment per image tag. Every deployment consists of a
+// dhis2-db instance seeded from its own copy of the source database and a dhis2-core instance
+// running the given image tag. Deployments are rolled out in the background.
+func (s Service) Create(ctx context.Context, token string, comparison *model.Comparison, imageTags []string, imageRepository string) error {
+	group, err := s.groupService.Find(ctx, comparison.GroupName)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string, len(imageTags))
+	for _, tag := range imageTags {
+		name, err := deploymentName(comparison.Name, tag)
+		if err != nil {
+			return err
+		}
+		if _, ok := names[name]; ok {
+			return errdef.NewBadRequest("image tags %q and %q result in the same deployment name %q", names[name], tag, name)
+		}
+		names[name] = tag
+	}
+
+	err = s.repository.save(ctx, comparison)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range imageTags {
+		deployment, err := s.createDeployment(ctx, comparison, group, tag, imageRepository)
+		if err != nil {
+			return errors.Join(err, s.Delete(ctx, comparison.ID))
+		}
+		comparison.Deployments = append(comparison.Deployments, deployment)
+	}
+
+	go s.deploy(context.WithoutCancel(ctx), token, comparison)
+
+	return nil
+}
+
+func (s Service) createDeployment(ctx context.Context, comparison *model.Comparison, group *model.Group, tag, imageRepository string) (*model.Deployment, error) {
+	name, err := deploymentName(comparison.Name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	database := &model.Database{
+		Name:      name,
+		GroupName: group.Name,
+		UserID:    comparison.UserID,
+		Type:      "database",
+	}
+	err = s.databaseService.Copy(ctx, comparison.DatabaseID, database, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy database for image tag %q: %v", tag, err)
+	}
+
+	deployment := &model.Deployment{
+		UserID:       comparison.UserID,
+		Name:         name,
+		Description:  fmt.Sprintf("Comparison %q running image tag %q", comparison.Name, tag),
+		GroupName:    group.Name,
+		TTL:          comparison.TTL,
+		ComparisonID: &comparison.ID,
+	}
+	err = s.instanceService.SaveDeployment(ctx, deployment)
+	if err != nil {
+		return nil, errors.Join(err, s.databaseService.Delete(ctx, database.ID))
+	}
+
+	coreParameters := model.DeploymentInstanceParameters{
+		"IMAGE_TAG": {ParameterName: "IMAGE_TAG", Value: tag},
+	}
+	if imageRepository != "" {
+		coreParameters["IMAGE_REPOSITORY"] = model.DeploymentInstanceParameter{ParameterName: "IMAGE_REPOSITORY", Value: imageRepository}
+	}
+
+	instances := []*model.DeploymentInstance{
+		{
+			StackName: "dhis2-db",
+			Parameters: model.DeploymentInstanceParameters{
+				"DATABASE_ID": {ParameterName: "DATABASE_ID", Value: strconv.FormatUint(uint64(database.ID), 10)},
+			},
+		},
+		{
+			StackName:  "dhis2-core",
+			Parameters: coreParameters,
+		},
+	}
+	for _, instance := range instances {
+		instance.DeploymentID = deployment.ID
+		instance.Name = deployment.Name
+		instance.Group = group
+		instance.GroupName = group.Name
+		err := s.instanceService.SaveInstance(ctx, instance)
+		if err != nil {
+			err = fmt.Errorf("failed to save %s instance for image tag %q: %v", instance.StackName, tag, err)
+			// the deployment is deleted by the rollback of Create but the copy isn't found without its instance
+			return nil, errors.Join(err, s.databaseService.Delete(ctx, database.ID))
+		}
+	}
+
+	return deployment, nil
+}
+
+func (s Service) deploy(ctx context.Context, token string, comparison *model.Comparison) {
+	for _, d := range comparison.Deployments {
+		deployment, err := s.instanceService.FindDecryptedDeploymentById(ctx, d.ID)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to find comparison deployment", "comparisonId", comparison.ID, "deploymentId", d.ID, "error", err)
+			continue
+		}
+
+		err = s.deploymentService.DeployDeployment(ctx, token, deployment)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to deploy comparison deployment", "comparisonId", comparison.ID, "deploymentId", d.ID, "error", err)
+		}
+	}
+}
+
+func (s Service) Find(ctx context.Context, id uint) (*model.Comparison, error) {
+	return s.repository.find(ctx, id)
+}
+
+func (s Service) FindAll(ctx context.Context, user *model.User) ([]model.Comparison, error) {
+	groups := append(user.Groups, user.AdminGroups...) //nolint:gocritic
+	groupNames := make([]string, len(groups))
+	for i, group := range groups {
+		groupNames[i] = group.Name
+	}
+
+	return s.repository.findByGroupNames(ctx, groupNames)
+}
+
+// Pause pauses every instance of every deployment in the comparison
+func (s Service) Pause(ctx context.Context, id uint) error {
+	return s.eachInstance(ctx, id, s.instanceService.Pause)
+}
+
+// Resume resumes every instance of every deployment in the comparison
+func (s Service) Resume(ctx context.Context, id uint) error {
+	return s.eachInstance(ctx, id, s.instanceService.Resume)
+}
+
+func (s Service) eachInstance(ctx context.Context, id uint, fn func(ctx context.Context, instance *model.DeploymentInstance) error) error {
+	comparison, err := s.repository.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, deployment := range comparison.Deployments {
+		for _, instance := range deployment.Instances {
+			err := fn(ctx, instance)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("instance(%s) %q: %v", instance.StackName, instance.Name, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// Delete destroys all deployments of the comparison, deletes the database copies they were seeded
+// from and finally the comparison itself
+func (s Service) Delete(ctx context.Context, id uint) error {
+	comparison, err := s.repository.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, d := range comparison.Deployments {
+		deployment, err := s.instanceService.FindDecryptedDeploymentById(ctx, d.ID)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		databaseID, hasDatabase := seedDatabaseID(deployment)
+
+		err = s.instanceService.DeleteDeployment(ctx, deployment)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to delete deployment %q: %v", deployment.Name, err))
+			continue
+		}
+
+		if hasDatabase {
+			err = s.databaseService.Delete(ctx, databaseID)
+			if err != nil && !errdef.IsNotFound(err) {
+				errs = errors.Join(errs, fmt.Errorf("failed to delete database copy %d: %v", databaseID, err))
+			}
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	return s.repository.delete(ctx, comparison.ID)
+}
+
+func seedDatabaseID(deployment *model.Deployment) (uint, bool) {
+	for _, instance := range deployment.Instances {
+		if instance.StackName != "dhis2-db" {
+			continue
+		}
+		id, err := strconv.ParseUint(instance.Parameters["DATABASE_ID"].Value, 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint(id), true
+	}
+	return 0, false
+}
+
+var invalidLabelCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// deploymentName derives a valid RFC 1035 label from the comparison name and an image tag
+func deploymentName(comparisonName, tag string) (string, error) {
+	suffix := invalidLabelCharacters.ReplaceAllString(strings.ToLower(tag), "-")
+	suffix = strings.Trim(suffix, "-")
+	if suffix == "" {
+		return "", errdef.NewBadRequest("invalid image tag: %q", tag)
+	}
+
+	name := comparisonName + "-" + suffix
+	if len(name) > 63 {
+		return "", errdef.NewBadRequest("deployment name %q derived from image tag %q exceeds 63 characters", name, tag)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,40 @@
+package comparison
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeploymentName(t *testing.T) {
+	tests := map[string]struct {
+		tag  string
+		want string
+	}{
+		"Version":        {tag: "2.40.2", want: "qa-2-40-2"},
+		"UpperCase":      {tag: "2.41.0-SNAPSHOT", want: "qa-2-41-0-snapshot"},
+		"TrimSeparators": {tag: "_latest_", want: "qa-latest"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := deploymentName("qa", test.tag)
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestDeploymentName_Invalid(t *testing.T) {
+	_, err := deploymentName("qa", "...")
+
+	require.ErrorContains(t, err, "invalid image tag")
+}
+
+func TestDeploymentName_TooLong(t *testing.T) {
+	_, err := deploymentName("a-very-long-comparison-name-which-is-close-to-the-limit", "2.40.2-rc1")
+
+	require.ErrorContains(t, err, "exceeds 63 characters")
+}
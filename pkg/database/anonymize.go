@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/google/uuid"
+)
+
+// AnonymizationProfile describes how a DHIS2 database is anonymized before it's shared
+// swagger:model
+type AnonymizationProfile struct {
+	Name string `json:"name"`
+	// ScrambleUsers replaces the credentials, names, emails and other contact details of users
+	ScrambleUsers bool `json:"scrambleUsers"`
+	// KeepUsers are usernames which aren't scrambled, e.g. an administrator account
+	KeepUsers []string `json:"keepUsers"`
+	// ConfidentialAttributes removes the values of all tracked entity attributes marked confidential
+	ConfidentialAttributes bool `json:"confidentialAttributes"`
+	// AttributeValueTypes removes the values of tracked entity attributes of the given value types,
+	// e.g. EMAIL or PHONE_NUMBER
+	AttributeValueTypes []string `json:"attributeValueTypes"`
+	// Attributes removes the values of tracked entity attributes with the given uids or codes
+	Attributes []string `json:"attributes"`
+}
+
+var defaultAnonymizationProfile = AnonymizationProfile{
+	Name:                   "default",
+	ScrambleUsers:          true,
+	KeepUsers:              []string{"admin"},
+	ConfidentialAttributes: true,
+	AttributeValueTypes:    []string{"EMAIL", "PHONE_NUMBER"},
+}
+
+// ParseAnonymizationProfiles parses a JSON array of anonymization profiles. The "default" profile is
+// always available unless it's overridden.
+func ParseAnonymizationProfiles(profiles string) (map[string]AnonymizationProfile, error) {
+	byName := map[string]AnonymizationProfile{
+		defaultAnonymizationProfile.Name: defaultAnonymizationProfile,
+	}
+
+	if strings.TrimSpace(profiles) == "" {
+		return byName, nil
+	}
+
+	var parsed []AnonymizationProfile
+	err := json.Unmarshal([]byte(profiles), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse anonymization profiles: %v", err)
+	}
+
+	for _, profile := range parsed {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("anonymization profile without name")
+		}
+		byName[profile.Name] = profile
+	}
+
+	return byName, nil
+}
+
+// AnonymizationProfiles returns the available anonymization profiles sorted by name
+func (s Service) AnonymizationProfiles() []AnonymizationProfile {
+	profiles := make([]AnonymizationProfile, 0, len(s.anonymizationProfiles))
+	for _, profile := range s.anonymizationProfiles {
+		profiles = append(profiles, profile)
+	}
+	slices.SortFunc(profiles, func(a, b AnonymizationProfile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return profiles
+}
+
+func (s Service) AnonymizationProfile(name string) (*AnonymizationProfile, error) {
+	profile, ok := s.anonymizationProfiles[name]
+	if !ok {
+		return nil, errdef.NewBadRequest("anonymization profile not found: %s", name)
+	}
+	return &profile, nil
+}
+
+// Anonymize streams the source database through an anonymization job into the target database. The
+// job runs in the namespace of the target's group and the target keeps the format of the source.
+// Directory format sources are anonymized into the custom format since the job can't stream a
+// directory.
+func (s Service) Anonymize(ctx context.Context, userId uint, source, target *model.Database, profileName string) (*model.Database, error) {
+	profile, err := s.AnonymizationProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupService.Find(ctx, target.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceFormat := getFormat(source)
+	format := sourceFormat
+	if format == formatDirectory {
+		format = formatCustom
+	}
+	command := jobCommand(sourceFormat, format, anonymizationSQL(*profile))
+
+	return s.transform(ctx, userId, source, target, group, command, kindDatabaseAnonymize, func(saved *model.Database) {
+		saved.Format = format
+		saved.AnonymizationProfile = profile.Name
+	})
+}
+
+// privatePrefix prefixes the keys of temporary objects. They're kept outside the folders of groups
+// and aren't referenced by any database so they're neither listed nor deployable.
+const privatePrefix = ".private"
+
+// DumpAnonymized dumps the instance's database into a temporary object and anonymizes it into the
+// given record, so the record and its group never reference the original data. The record is
+// deleted if the dump or the anonymization fails.
+func (s Service) DumpAnonymized(ctx context.Context, userId uint, target *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides, profileName string) (*model.Database, error) {
+	saved, err := s.dumpAnonymized(ctx, userId, target, instance, stack, format, overrides, profileName)
+	if err != nil {
+		return nil, errors.Join(err, s.Delete(ctx, target.ID))
+	}
+	return saved, nil
+}
+
+func (s Service) dumpAnonymized(ctx context.Context, userId uint, target *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides, profileName string) (*model.Database, error) {
+	_, err := s.AnonymizationProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.CheckStorageQuota(ctx, target.GroupName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s", privatePrefix, uuid.New().String())
+	defer func() {
+		err := s.objectStore.Delete(s.s3Bucket, key)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to delete temporary dump", "key", key, "error", err)
+		}
+	}()
+
+	result, err := s.dumpTo(ctx, userId, target, instance, stack, format, overrides, key)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &model.Database{
+		Name:      target.Name,
+		GroupName: target.GroupName,
+		Url:       fmt.Sprintf("s3://%s/%s", s.s3Bucket, key),
+		Format:    format,
+		Size:      result.size,
+	}
+	return s.Anonymize(ctx, userId, source, target, profileName)
+}
+
+// CopyAnonymized copies the database with the given id into d while anonymizing it. The record is
+// created right away while the anonymization job runs in the background. Filestores aren't copied
+// since they may contain personal documents.
+func (s Service) CopyAnonymized(ctx context.Context, id uint, d *model.Database, group *model.Group, profileName string) error {
+	_, err := s.AnonymizationProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if source.Url == "" {
+		return errdef.NewBadRequest("database with id %d doesn't reference any url", id)
+	}
+
+	if strings.HasSuffix(source.Name, ".pgc") && !strings.HasSuffix(d.Name, ".pgc") {
+		d.Name += ".pgc"
+	}
+	if strings.HasSuffix(source.Name, ".sql.gz") && !strings.HasSuffix(d.Name, ".sql.gz") {
+		d.Name += ".sql.gz"
+	}
+	d.GroupName = group.Name
+
+	updateSlug(d)
+
+	err = s.repository.Create(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	// Detach from the request context so the anonymization isn't cancelled when the HTTP response is
+	// sent.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		_, err := s.Anonymize(ctx, d.UserID, source, d, profileName)
+		if err != nil {
+			if err := s.Delete(ctx, d.ID); err != nil {
+				s.logger.ErrorContext(ctx, "failed to delete database of failed anonymization", "databaseId", d.ID, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// anonymizationSQL returns the SQL applying the profile to a DHIS2 database. Columns differ between
+// DHIS2 versions so each statement only runs if the columns it references exist. Kept users are
+// collected by id up front since their usernames are stored with the user details only since DHIS2
+// 2.38 and are scrambled by the statements themselves.
+func anonymizationSQL(profile AnonymizationProfile) string {
+	var statements []string
+
+	if profile.ScrambleUsers {
+		userinfoKeep, usersKeep := "", ""
+		if len(profile.KeepUsers) > 0 {
+			statements = append(statements, keptUsersStatement(profile.KeepUsers))
+			userinfoKeep = "userinfoid NOT IN (SELECT userinfoid FROM " + keptUsersTable + ")"
+			usersKeep = "userid NOT IN (SELECT userinfoid FROM " + keptUsersTable + ")"
+		}
+
+		scrambled := []struct{ column, expression string }{
+			{"username", "'user' || userinfoid"},
+			{"password", "md5(random()::text)"},
+			{"firstname", "'User'"},
+			{"surname", "userinfoid::text"},
+			{"email", "NULL"},
+			{"phonenumber", "NULL"},
+			{"openid", "NULL"},
+			{"ldapid", "NULL"},
+			{"secret", "NULL"},
+			{"jobtitle", "NULL"},
+			{"introduction", "NULL"},
+			{"birthday", "NULL"},
+			{"nationality", "NULL"},
+			{"employer", "NULL"},
+			{"education", "NULL"},
+			{"interests", "NULL"},
+			{"languages", "NULL"},
+			{"gender", "NULL"},
+			{"whatsapp", "NULL"},
+			{"facebookmessenger", "NULL"},
+			{"skype", "NULL"},
+			{"telegram", "NULL"},
+			{"twitter", "NULL"},
+			{"avatar", "NULL"},
+		}
+		for _, c := range scrambled {
+			statements = append(statements, updateStatement("userinfo", c.column, c.expression, userinfoKeep, "userinfoid"))
+		}
+
+		// Before DHIS2 2.38 credentials were stored in the users table
+		statements = append(statements,
+			updateStatement("users", "username", "'user' || userid", usersKeep, "userid"),
+			updateStatement("users", "password", "md5(random()::text)", usersKeep, "userid"),
+		)
+	}
+
+	var attributeConditions []string
+	if profile.ConfidentialAttributes {
+		attributeConditions = append(attributeConditions, "confidential")
+	}
+	if len(profile.AttributeValueTypes) > 0 {
+		attributeConditions = append(attributeConditions, "valuetype IN ("+quoteLiterals(profile.AttributeValueTypes)+")")
+	}
+	if len(profile.Attributes) > 0 {
+		identifiers := quoteLiterals(profile.Attributes)
+		attributeConditions = append(attributeConditions, "uid IN ("+identifiers+")", "code IN ("+identifiers+")")
+	}
+	if len(attributeConditions) > 0 {
+		attributes := "trackedentityattributeid IN (SELECT trackedentityattributeid FROM trackedentityattribute WHERE " + strings.Join(attributeConditions, " OR ") + ")"
+		// The history of the values is kept in trackedentityattributevalueaudit before DHIS2 2.41 and in
+		// trackedentityattributevaluechangelog since
+		statements = append(statements,
+			deleteStatement("trackedentityattributevalue", attributes),
+			deleteStatement("trackedentityattributevalueaudit", attributes),
+			deleteStatement("trackedentityattributevaluechangelog", attributes),
+		)
+	}
+
+	return strings.Join(statements, "\n")
+}
+
+// keptUsersTable holds the ids of the users which aren't scrambled
+const keptUsersTable = "im_kept_users"
+
+// keptUsersStatement returns statements collecting the ids of the users with the given usernames
+// into keptUsersTable. The usernames are matched in userinfo since DHIS2 2.38 and in users before.
+// It fails if neither table has a username column so users are never scrambled without knowing
+// which to keep.
+func keptUsersStatement(usernames []string) string {
+	usernamesIn := "username IN (" + quoteLiterals(usernames) + ")"
+	return fmt.Sprintf(`CREATE TEMPORARY TABLE %[1]s (userinfoid bigint);
+DO $$
+BEGIN
+  IF (SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'userinfo' AND column_name = 'username') = 1 THEN
+    EXECUTE %[2]s;
+  ELSIF (SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'username') = 1 THEN
+    EXECUTE %[3]s;
+  ELSE
+    RAISE EXCEPTION 'no username column to match the kept users';
+  END IF;
+END $$;`, keptUsersTable,
+		quoteLiteral("INSERT INTO "+keptUsersTable+" SELECT userinfoid FROM userinfo WHERE "+usernamesIn),
+		quoteLiteral("INSERT INTO "+keptUsersTable+" SELECT userid FROM users WHERE "+usernamesIn))
+}
+
+// updateStatement returns a statement setting the column to the expression which is only executed if
+// the column and any other referenced columns exist
+func updateStatement(table, column, expression, where string, references ...string) string {
+	statement := fmt.Sprintf("UPDATE %s SET %s = %s", table, column, expression)
+	if where != "" {
+		statement += " WHERE " + where
+	}
+	columns := append([]string{column}, references...)
+	return guardedStatement(table, columns, statement)
+}
+
+// deleteStatement returns a statement deleting the matching rows which is only executed if the table
+// exists
+func deleteStatement(table, where string) string {
+	return guardedStatement(table, []string{"trackedentityattributeid"}, fmt.Sprintf("DELETE FROM %s WHERE %s", table, where))
+}
+
+func guardedStatement(table string, columns []string, statement string) string {
+	return fmt.Sprintf(`DO $$
+BEGIN
+  IF (SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = %s AND column_name IN (%s)) = %d THEN
+    EXECUTE %s;
+  END IF;
+END $$;`, quoteLiteral(table), quoteLiterals(columns), len(columns), quoteLiteral(statement))
+}
+
+func quoteLiterals(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = quoteLiteral(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
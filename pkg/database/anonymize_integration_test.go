@@ -0,0 +1,99 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestAnonymizationSQL_Schemas(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	profile := AnonymizationProfile{ScrambleUsers: true, KeepUsers: []string{"admin"}}
+
+	type user struct {
+		Username  string
+		Firstname string
+		Surname   string
+		Email     *string
+	}
+	errRollback := errors.New("rollback")
+	// anonymize creates the schema of a DHIS2 version in its own database schema, applies the profile
+	// and returns the users. Everything is rolled back afterwards.
+	anonymize := func(t *testing.T, schema, query string) []user {
+		t.Helper()
+
+		var users []user
+		err := db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, tx.Exec("CREATE SCHEMA dhis2; SET LOCAL search_path TO dhis2;\n"+schema).Error)
+			require.NoError(t, tx.Exec(anonymizationSQL(profile)).Error)
+			require.NoError(t, tx.Raw(query).Scan(&users).Error)
+			return errRollback
+		})
+		require.ErrorIs(t, err, errRollback)
+		return users
+	}
+	adminEmail := "admin@dhis2.org"
+	want := []user{
+		{Username: "admin", Firstname: "Admin", Surname: "Admin", Email: &adminEmail},
+		{Username: "user2", Firstname: "User", Surname: "2"},
+	}
+
+	t.Run("Before2.38", func(t *testing.T) {
+		users := anonymize(t, `CREATE TABLE userinfo (userinfoid bigint PRIMARY KEY, firstname text, surname text, email text, phonenumber text);
+CREATE TABLE users (userid bigint PRIMARY KEY REFERENCES userinfo, username text, password text);
+INSERT INTO userinfo VALUES (1, 'Admin', 'Admin', 'admin@dhis2.org', '123'), (2, 'Jane', 'Doe', 'jane@dhis2.org', '456');
+INSERT INTO users VALUES (1, 'admin', 'secret'), (2, 'jane', 'secret');`,
+			"SELECT username, firstname, surname, email FROM users JOIN userinfo ON userid = userinfoid ORDER BY userid")
+
+		assert.Equal(t, want, users)
+	})
+
+	t.Run("Since2.38", func(t *testing.T) {
+		users := anonymize(t, `CREATE TABLE userinfo (userinfoid bigint PRIMARY KEY, username text, password text, firstname text, surname text, email text, phonenumber text);
+INSERT INTO userinfo VALUES (1, 'admin', 'secret', 'Admin', 'Admin', 'admin@dhis2.org', '123'), (2, 'jane', 'secret', 'Jane', 'Doe', 'jane@dhis2.org', '456');`,
+			"SELECT username, firstname, surname, email FROM userinfo ORDER BY userinfoid")
+
+		assert.Equal(t, want, users)
+	})
+
+	t.Run("AttributeHistory", func(t *testing.T) {
+		profile := AnonymizationProfile{Attributes: []string{"NATIONAL_ID"}}
+		type counts struct{ Kept, Audits, Changelogs int }
+
+		var remaining counts
+		err := db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, tx.Exec(`CREATE SCHEMA dhis2; SET LOCAL search_path TO dhis2;
+CREATE TABLE trackedentityattribute (trackedentityattributeid bigint PRIMARY KEY, uid text, code text, confidential boolean, valuetype text);
+CREATE TABLE trackedentityattributevalue (trackedentityid bigint, trackedentityattributeid bigint, value text);
+CREATE TABLE trackedentityattributevalueaudit (trackedentityattributevalueauditid bigint, trackedentityattributeid bigint, value text);
+CREATE TABLE trackedentityattributevaluechangelog (trackedentityattributevaluechangelogid bigint, trackedentityattributeid bigint, previousvalue text, currentvalue text);
+INSERT INTO trackedentityattribute VALUES (1, 'abc', 'NATIONAL_ID', false, 'TEXT'), (2, 'def', 'NICKNAME', false, 'TEXT');
+INSERT INTO trackedentityattributevalue VALUES (1, 1, '123'), (1, 2, 'Jo');
+INSERT INTO trackedentityattributevalueaudit VALUES (1, 1, '122'), (2, 2, 'Joe');
+INSERT INTO trackedentityattributevaluechangelog VALUES (1, 1, '122', '123'), (2, 2, 'Joe', 'Jo');`).Error)
+			require.NoError(t, tx.Exec(anonymizationSQL(profile)).Error)
+			require.NoError(t, tx.Raw(`SELECT (SELECT count(*) FROM trackedentityattributevalue) AS kept,
+(SELECT count(*) FROM trackedentityattributevalueaudit) AS audits,
+(SELECT count(*) FROM trackedentityattributevaluechangelog) AS changelogs`).Scan(&remaining).Error)
+			return errRollback
+		})
+		require.ErrorIs(t, err, errRollback)
+
+		assert.Equal(t, counts{Kept: 1, Audits: 1, Changelogs: 1}, remaining, "only the values of other attributes are kept")
+	})
+
+	t.Run("WithoutUsernames", func(t *testing.T) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, tx.Exec("CREATE SCHEMA dhis2; SET LOCAL search_path TO dhis2; CREATE TABLE userinfo (userinfoid bigint PRIMARY KEY, firstname text)").Error)
+			return tx.Exec(anonymizationSQL(profile)).Error
+		})
+
+		require.ErrorContains(t, err, "no username column to match the kept users")
+	})
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnonymizationProfiles(t *testing.T) {
+	profiles, err := ParseAnonymizationProfiles(`[{"name": "partner", "scrambleUsers": true, "attributes": ["NATIONAL_ID"]}]`)
+
+	require.NoError(t, err)
+	assert.Len(t, profiles, 2)
+	assert.Equal(t, defaultAnonymizationProfile, profiles["default"])
+	assert.Equal(t, []string{"NATIONAL_ID"}, profiles["partner"].Attributes)
+}
+
+func TestParseAnonymizationProfiles_Empty(t *testing.T) {
+	profiles, err := ParseAnonymizationProfiles("")
+
+	require.NoError(t, err)
+	assert.Contains(t, profiles, "default")
+}
+
+func TestParseAnonymizationProfiles_MissingName(t *testing.T) {
+	_, err := ParseAnonymizationProfiles(`[{"scrambleUsers": true}]`)
+
+	require.ErrorContains(t, err, "without name")
+}
+
+func TestAnonymizationSQL(t *testing.T) {
+	sql := anonymizationSQL(AnonymizationProfile{
+		ScrambleUsers:          true,
+		KeepUsers:              []string{"admin", "o'brien"},
+		ConfidentialAttributes: true,
+		Attributes:             []string{"NATIONAL_ID"},
+	})
+
+	assert.True(t, strings.HasPrefix(sql, "CREATE TEMPORARY TABLE im_kept_users"), "kept users are collected before any user is scrambled")
+	assert.Contains(t, sql, `EXECUTE 'INSERT INTO im_kept_users SELECT userid FROM users WHERE username IN (''admin'', ''o''''brien'')'`)
+	assert.Contains(t, sql, `EXECUTE 'UPDATE userinfo SET email = NULL WHERE userinfoid NOT IN (SELECT userinfoid FROM im_kept_users)'`)
+	assert.Contains(t, sql, `column_name IN ('email', 'userinfoid')) = 2`)
+	assert.Contains(t, sql, `DELETE FROM trackedentityattributevalue WHERE trackedentityattributeid IN (SELECT trackedentityattributeid FROM trackedentityattribute WHERE confidential OR uid IN (''NATIONAL_ID'') OR code IN (''NATIONAL_ID''))`)
+	assert.Contains(t, sql, `DELETE FROM trackedentityattributevalueaudit WHERE trackedentityattributeid IN`)
+	assert.Contains(t, sql, `DELETE FROM trackedentityattributevaluechangelog WHERE trackedentityattributeid IN`)
+}
+
+func TestAnonymizationSQL_NoRules(t *testing.T) {
+	sql := anonymizationSQL(AnonymizationProfile{Name: "none"})
+
+	assert.Empty(t, sql)
+}
+
+func TestJobCommand(t *testing.T) {
+	t.Run("CustomToPlain", func(t *testing.T) {
+		command := jobCommand("custom", "plain", "")
+
+		assert.Equal(t, "set -euo pipefail; pg_restore --file=- | gzip", command[2])
+	})
+
+	t.Run("PlainToCustom", func(t *testing.T) {
+		script := jobCommand("plain", "custom", "")[2]
+
+		assert.Contains(t, script, "gunzip --stdout | psql --dbname=job")
+		assert.True(t, strings.HasSuffix(script, "pg_dump --format=custom job"))
+		assert.NotContains(t, script, "IM_JOB_SQL")
+	})
+
+	t.Run("Anonymize", func(t *testing.T) {
+		script := jobCommand("custom", "custom", "SELECT 1;")[2]
+
+		assert.Contains(t, script, "pg_restore --no-owner --no-privileges --dbname=job")
+		assert.Contains(t, script, "<<'IM_JOB_SQL'\nSELECT 1;\nIM_JOB_SQL\n")
+	})
+	t.Run("DirectoryToCustom", func(t *testing.T) {
+		script := jobCommand("directory", "custom", "")[2]
+
+		assert.Contains(t, script, `tar -x -f - -C "$dump"`)
+		assert.Contains(t, script, `pg_restore --no-owner --no-privileges --jobs=4 --dbname=job "$dump"`)
+	})
+}
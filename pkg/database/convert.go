@@ -0,0 +1,231 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+)
+
+const (
+	formatPlain     = "plain"
+	formatCustom    = "custom"
+	formatDirectory = "directory"
+
+	// formatHeaderSize is the number of leading bytes needed to detect the format of a dump
+	formatHeaderSize = 262
+)
+
+// detectFormat detects the format of a dump by its magic bytes. pg_dump custom format archives
+// start with "PGDMP", plain dumps are stored gzipped and directory format dumps are stored as tars.
+// An empty string is returned if the format isn't recognised.
+func detectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("PGDMP")):
+		return formatCustom
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatPlain
+	case len(header) >= formatHeaderSize && bytes.Equal(header[257:262], []byte("ustar")):
+		return formatDirectory
+	default:
+		return ""
+	}
+}
+
+// convertedName replaces the extension of the name with the extension of the given format
+func convertedName(name, format string) string {
+	name = strings.TrimSuffix(name, ".pgc")
+	name = strings.TrimSuffix(name, ".sql.gz")
+	if format == formatCustom {
+		return name + ".pgc"
+	}
+	return name + ".sql.gz"
+}
+
+// jobCommand returns the command run by conversion and anonymization jobs. The command reads a
+// dump in the source format from stdin and writes a dump in the target format to stdout. Unless the
+// dump is only converted from the custom to the plain format, which pg_restore handles on its own,
+// it's restored into a throwaway PostgreSQL server, the given SQL is applied and it's dumped again.
+func jobCommand(sourceFormat, targetFormat, sql string) []string {
+	if sourceFormat == formatCustom && targetFormat == formatPlain && sql == "" {
+		return []string{"bash", "-c", "set -euo pipefail; pg_restore --file=- | gzip"}
+	}
+
+	var script strings.Builder
+	script.WriteString(`set -euo pipefail
+data=$(mktemp -d)
+initdb --pgdata="$data" --username=postgres --auth=trust >&2
+pg_ctl --pgdata="$data" --options="-c listen_addresses='' -k $data" --wait start >&2
+export PGHOST="$data" PGUSER=postgres
+createdb job
+# postgis isn't part of every PostgreSQL image and only needed by dumps using it
+psql --dbname=job --quiet --command="create extension if not exists postgis" >&2 || echo "postgis isn't available" >&2
+for extension in pg_trgm btree_gin; do
+  psql --dbname=job --quiet --command="create extension if not exists $extension" >&2
+done
+`)
+
+	switch sourceFormat {
+	case formatCustom:
+		script.WriteString("pg_restore --no-owner --no-privileges --dbname=job >&2\n")
+	case formatDirectory:
+		script.WriteString(`dump=$(mktemp -d)
+tar -x -f - -C "$dump"
+pg_restore --no-owner --no-privileges --jobs=4 --dbname=job "$dump" >&2
+`)
+	default:
+		script.WriteString("gunzip --stdout | psql --dbname=job --quiet >&2\n")
+	}
+
+	if sql != "" {
+		script.WriteString("psql --dbname=job --quiet --set=ON_ERROR_STOP=1 >&2 <<'IM_JOB_SQL'\n")
+		script.WriteString(sql)
+		script.WriteString("\nIM_JOB_SQL\n")
+	}
+
+	if targetFormat == formatCustom {
+		script.WriteString("pg_dump --format=custom job")
+	} else {
+		script.WriteString("pg_dump job | gzip")
+	}
+
+	return []string{"bash", "-c", script.String()}
+}
+
+// Convert creates a new database holding the given database converted into the other format. The
+// record is returned right away while the conversion job runs in the background.
+func (s Service) Convert(ctx context.Context, userId uint, database *model.Database, format string) (*model.Database, error) {
+	if database.Type != "database" {
+		return nil, errdef.NewBadRequest("only databases can be converted, not %q", database.Type)
+	}
+
+	if database.Url == "" {
+		return nil, errdef.NewBadRequest("database with id %d doesn't reference any url", database.ID)
+	}
+
+	if format != formatPlain && format != formatCustom {
+		return nil, errdef.NewBadRequest("unsupported format %q, must be either %q or %q", format, formatPlain, formatCustom)
+	}
+
+	if getFormat(database) == format {
+		return nil, errdef.NewBadRequest("database is already in %s format", format)
+	}
+
+	group, err := s.groupService.Find(ctx, database.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.CreateDatabase(ctx, userId, database.GroupName, convertedName(database.Name, format))
+	if err != nil {
+		return nil, err
+	}
+
+	// Detach from the request context so the conversion isn't cancelled when the HTTP response is
+	// sent.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		command := jobCommand(getFormat(database), format, "")
+		_, err := s.transform(ctx, userId, database, created, group, command, kindDatabaseConvert, func(saved *model.Database) {
+			saved.Format = format
+			saved.AnonymizationProfile = database.AnonymizationProfile
+		})
+		if err != nil {
+			if err := s.Delete(ctx, created.ID); err != nil {
+				s.logger.ErrorContext(ctx, "failed to delete database of failed conversion", "databaseId", created.ID, "error", err)
+			}
+		}
+	}()
+
+	return created, nil
+}
+
+// transform streams the source database from S3 through a job running the given command and the
+// job's output back to S3 as the target database. update is applied to the target record before it's
+// saved.
+func (s Service) transform(ctx context.Context, userId uint, source, target *model.Database, group *model.Group, command []string, kind string, update func(saved *model.Database)) (*model.Database, error) {
+	publish := func(status, errMsg string, size int64) {
+		s.publisher.Publish(ctx, userId, target.GroupName, kind, newDatabaseEvent(target, status, errMsg, size))
+	}
+	fail := func(err error) (*model.Database, error) {
+		s.logger.ErrorContext(ctx, "database job failed", "kind", kind, "sourceId", source.ID, "targetId", target.ID, "error", err)
+		publish("error", err.Error(), 0)
+		return nil, err
+	}
+
+	podExecutor, err := s.podExecutor(group.Cluster)
+	if err != nil {
+		return fail(err)
+	}
+
+	u, err := url.Parse(source.Url)
+	if err != nil {
+		return fail(err)
+	}
+	sourceKey := strings.TrimPrefix(u.Path, "/")
+
+	publish("started", "", 0)
+
+	sourceReader, sourceWriter := io.Pipe()
+	go func() {
+		err := s.objectStore.Download(ctx, s.s3Bucket, sourceKey, sourceWriter, func(int64) {})
+		sourceWriter.CloseWithError(err)
+	}()
+
+	targetReader, targetWriter := io.Pipe()
+	key := fmt.Sprintf("%s/%s", target.GroupName, target.Name)
+
+	type uploadResult struct {
+		size     int64
+		checksum string
+		err      error
+	}
+	uploadDone := make(chan uploadResult, 1)
+	go func() {
+		defer targetReader.Close()
+		checksummed := storage.NewChecksumReader(targetReader)
+		size, err := s.objectStore.StreamUpload(ctx, s.s3Bucket, key, "application/octet-stream", checksummed)
+		uploadDone <- uploadResult{size, checksummed.Checksum(), err}
+	}()
+
+	var stderr strings.Builder
+	err = podExecutor.ExecJob(ctx, group.Namespace, s.jobImage, command, sourceReader, targetWriter, &stderr)
+	// Unblock the download if the job stopped reading
+	sourceReader.Close()
+	if err != nil {
+		targetWriter.CloseWithError(err)
+		<-uploadDone
+		return fail(fmt.Errorf("%w: %s", err, stderr.String()))
+	}
+	targetWriter.Close()
+
+	result := <-uploadDone
+	if result.err != nil {
+		return fail(result.err)
+	}
+
+	saved, err := s.repository.FindById(ctx, target.ID)
+	if err != nil {
+		return fail(err)
+	}
+	saved.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
+	saved.Size = result.size
+	saved.Checksum = result.checksum
+	update(saved)
+	if err := s.repository.Save(ctx, saved); err != nil {
+		return fail(err)
+	}
+
+	s.logger.InfoContext(ctx, "database job completed", "kind", kind, "sourceId", source.ID, "targetId", saved.ID, "size", result.size)
+	publish("success", "", result.size)
+
+	s.extractMetadataAsync(ctx, saved, nil)
+
+	return saved, nil
+}
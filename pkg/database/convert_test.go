@@ -0,0 +1,51 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]struct {
+		header []byte
+		want   string
+	}{
+		"Custom":    {header: []byte("PGDMP\x01\x0e"), want: "custom"},
+		"Plain":     {header: []byte{0x1f, 0x8b, 0x08, 0x00, 0x00}, want: "plain"},
+		"Directory": {header: tarHeader(), want: "directory"},
+		"Unknown":   {header: []byte("-- PostgreSQL dump"), want: ""},
+		"Empty":     {header: []byte{}, want: ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, detectFormat(test.header))
+		})
+	}
+}
+
+// tarHeader returns the header of a tar holding a directory format dump
+func tarHeader() []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "./toc.dat", Mode: 0o600, Size: 0})
+	_ = tw.Close()
+	return buf.Bytes()[:formatHeaderSize]
+}
+
+func TestConvertedName(t *testing.T) {
+	assert.Equal(t, "path/name.pgc", convertedName("path/name.sql.gz", "custom"))
+	assert.Equal(t, "path/name.sql.gz", convertedName("path/name.pgc", "plain"))
+	assert.Equal(t, "name.pgc", convertedName("name", "custom"))
+}
+
+func TestGetFormat(t *testing.T) {
+	assert.Equal(t, "custom", getFormat(&model.Database{Format: "custom", Url: "s3://bucket/name.sql.gz"}))
+	assert.Equal(t, "custom", getFormat(&model.Database{Url: "s3://bucket/name.pgc"}))
+	assert.Equal(t, "plain", getFormat(&model.Database{Url: "s3://bucket/name.sql.gz"}))
+	assert.Equal(t, "directory", getFormat(&model.Database{Url: "s3://bucket/name.tar"}))
+}
@@ -1,8 +1,11 @@
 package database_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -42,7 +45,7 @@ func TestDatabaseHandler(t *testing.T) {
 	s3Client := storage.NewS3Client(logger, s3.Client, uploader)
 
 	databaseRepository := database.NewRepository(db)
-	databaseService := database.NewService(logger, s3Bucket, s3Client, groupService{}, databaseRepository, nil, noopPublisher{})
+	databaseService := database.NewService(logger, s3Bucket, s3Client, groupService{}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
 	deploymentService := deployment.NewService(logger, instanceService{}, databaseService, nil, noopPublisher{})
 
 	client := inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
@@ -310,6 +313,33 @@ func TestDatabaseHandler(t *testing.T) {
 
 		client.Delete(t, "/databases/"+databaseID)
 
+		// Deleted databases are moved to the trash
+		client.Do(t, http.MethodGet, "/databases/"+databaseID, nil, http.StatusNotFound)
+		_, err = s3.Client.GetObject(context.TODO(), &awss3.GetObjectInput{
+			Bucket: aws.String(s3Bucket),
+			Key:    aws.String("packages/path/rename.extension"),
+		})
+		require.NoErrorf(t, err, "trashing a database must not delete its S3 object")
+		var trash []database.GroupsWithDatabases
+		client.GetJSON(t, "/databases/trash", &trash)
+		var trashed []string
+		for _, group := range trash {
+			for _, d := range group.Databases {
+				assert.True(t, d.DeletedAt.Valid)
+				trashed = append(trashed, d.Name)
+			}
+		}
+		assert.Contains(t, trashed, "path/rename.extension")
+
+		restoreBody := client.Do(t, http.MethodPost, "/databases/trash/"+databaseID+"/restore", nil, http.StatusOK)
+		var restored model.Database
+		require.NoError(t, json.Unmarshal(restoreBody, &restored))
+		assert.False(t, restored.DeletedAt.Valid)
+		client.GetJSON(t, "/databases/"+databaseID, &restored)
+
+		client.Delete(t, "/databases/"+databaseID)
+		client.Delete(t, "/databases/trash/"+databaseID)
+
 		_, err = s3.Client.GetObject(context.TODO(), &awss3.GetObjectInput{
 			Bucket: aws.String(s3Bucket),
 			Key:    aws.String("packages/path/rename.extension"),
@@ -320,13 +350,234 @@ func TestDatabaseHandler(t *testing.T) {
 
 }
 
+func TestUploadAccess(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	objectStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	databaseRepository := database.NewRepository(db)
+	databaseService := database.NewService(logger, "database-bucket", objectStore, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
+	deploymentService := deployment.NewService(logger, instanceService{}, databaseService, nil, noopPublisher{})
+
+	owner, _ := userpkg.CreateUserWithGroup(t, db, "packages", "some", "", "owner@dhis2.org")
+	reader, _ := userpkg.CreateUserWithGroup(t, db, "readers", "some", "", "reader@dhis2.org")
+	newClient := func(user *model.User) *inttest.HTTPClient {
+		return inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
+			databaseHandler := database.NewHandler(logger, databaseService, groupService{groupName: "packages"}, instanceService{}, stackService{}, deploymentService)
+			authenticator := func(c *gin.Context) {
+				c.Request = c.Request.WithContext(model.NewContextWithUser(c.Request.Context(), user))
+			}
+			database.Routes(engine, authenticator, databaseHandler)
+		})
+	}
+	ownerClient := newClient(&model.User{ID: owner.ID, Email: owner.Email, Groups: []model.Group{{Name: "packages"}}})
+	readerClient := newClient(&model.User{ID: reader.ID, Email: reader.Email, Groups: []model.Group{{Name: "readers"}}})
+
+	var upload model.DatabaseUpload
+	ownerClient.PostJSON(t, "/databases/uploads", strings.NewReader(`{"group": "packages", "name": "shared.sql.gz"}`), &upload)
+	err = db.Create(&model.DatabaseShare{DatabaseID: upload.DatabaseID, GroupName: "readers", UserID: owner.ID}).Error
+	require.NoError(t, err)
+	path := "/databases/uploads/" + upload.ID.String()
+
+	t.Run("ReadOnlyShareCanFindUpload", func(t *testing.T) {
+		var found model.DatabaseUpload
+		readerClient.GetJSON(t, path, &found)
+
+		assert.Equal(t, upload.ID, found.ID)
+	})
+
+	t.Run("ReadOnlyShareCanNotModifyUpload", func(t *testing.T) {
+		readerClient.Do(t, http.MethodPut, path+"/chunks/1", strings.NewReader("chunk"), http.StatusForbidden)
+		readerClient.Do(t, http.MethodPost, path+"/chunks/1/url?size=5", nil, http.StatusForbidden)
+		readerClient.Do(t, http.MethodPost, path+"/complete", nil, http.StatusForbidden)
+		readerClient.Do(t, http.MethodDelete, path, nil, http.StatusForbidden)
+
+		var found model.DatabaseUpload
+		ownerClient.GetJSON(t, path, &found)
+		assert.Empty(t, found.Parts, "the chunk of the share member isn't stored")
+		_, err := databaseService.FindById(context.Background(), upload.DatabaseID)
+		require.NoError(t, err, "the upload of the share member isn't aborted")
+	})
+
+	t.Run("OwnerCanModifyUpload", func(t *testing.T) {
+		ownerClient.Do(t, http.MethodPut, path+"/chunks/1", strings.NewReader("chunk"), http.StatusOK)
+		ownerClient.Do(t, http.MethodDelete, path, nil, http.StatusAccepted)
+	})
+}
+
+func TestExternalDownloadLimits(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	filesystemStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	objectStore := interruptingStore{ObjectStore: filesystemStore, interruptedKey: "packages/interrupted.sql.gz"}
+	databaseRepository := database.NewRepository(db)
+	databaseService := database.NewService(logger, "database-bucket", objectStore, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
+	deploymentService := deployment.NewService(logger, instanceService{}, databaseService, nil, noopPublisher{})
+
+	user, _ := userpkg.CreateUserWithGroup(t, db, "packages", "some", "", "user1@dhis2.org")
+	client := inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
+		databaseHandler := database.NewHandler(logger, databaseService, groupService{groupName: "packages"}, instanceService{}, stackService{}, deploymentService)
+		authenticator := func(c *gin.Context) {
+			c.Request = c.Request.WithContext(model.NewContextWithUser(c.Request.Context(), &model.User{ID: user.ID, Email: user.Email, Groups: []model.Group{{Name: "packages"}}}))
+		}
+		database.Routes(engine, authenticator, databaseHandler)
+	})
+
+	ctx := context.Background()
+	create := func(t *testing.T, name string) *model.Database {
+		t.Helper()
+
+		d, err := databaseService.CreateDatabase(ctx, user.ID, "packages", name)
+		require.NoError(t, err)
+		d.Url = "s3://database-bucket/packages/" + name
+		require.NoError(t, databaseRepository.Update(ctx, d))
+		return d
+	}
+	store := func(t *testing.T, name, content string) {
+		t.Helper()
+
+		err := objectStore.Upload(ctx, "database-bucket", "packages/"+name, strings.NewReader(content), int64(len(content)))
+		require.NoError(t, err)
+	}
+	link := func(t *testing.T, d *model.Database, maxDownloads uint) string {
+		t.Helper()
+
+		download, err := databaseService.CreateExternalDownload(ctx, user.ID, d.ID, 60, maxDownloads)
+		require.NoError(t, err)
+		return "/databases/external/" + download.UUID.String()
+	}
+	downloads := func(t *testing.T, path string) uint {
+		t.Helper()
+
+		var download model.ExternalDownload
+		require.NoError(t, db.First(&download, "uuid = ?", strings.TrimPrefix(path, "/databases/external/")).Error)
+		return download.Downloads
+	}
+
+	t.Run("MaxDownloads", func(t *testing.T) {
+		d := create(t, "limited.sql.gz")
+		store(t, "limited.sql.gz", "content")
+		path := link(t, d, 2)
+
+		assert.Equal(t, "content", string(client.Get(t, path)))
+		assert.Equal(t, "content", string(client.Get(t, path)))
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound)
+		assert.Equal(t, uint(2), downloads(t, path))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		d := create(t, "expired.sql.gz")
+		store(t, "expired.sql.gz", "content")
+		path := link(t, d, 0)
+		err := db.Model(&model.ExternalDownload{}).
+			Where("uuid = ?", strings.TrimPrefix(path, "/databases/external/")).
+			Update("expiration", time.Now().Add(-time.Minute).Unix()).Error
+		require.NoError(t, err)
+
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound)
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		d := create(t, "revoked.sql.gz")
+		store(t, "revoked.sql.gz", "content")
+		path := link(t, d, 0)
+		assert.Equal(t, "content", string(client.Get(t, path)))
+
+		client.Delete(t, "/databases/"+strconv.FormatUint(uint64(d.ID), 10)+"/external/"+strings.TrimPrefix(path, "/databases/external/"))
+
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound)
+	})
+
+	t.Run("FailureBeforeContentIsNotCounted", func(t *testing.T) {
+		d := create(t, "missing.sql.gz")
+		path := link(t, d, 1)
+
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound)
+		assert.Zero(t, downloads(t, path))
+
+		store(t, "missing.sql.gz", "content")
+		assert.Equal(t, "content", string(client.Get(t, path)), "a download which failed before sending any content can be retried")
+		assert.Equal(t, uint(1), downloads(t, path))
+	})
+
+	t.Run("FailureAfterContentIsCounted", func(t *testing.T) {
+		d := create(t, "interrupted.sql.gz")
+		store(t, "interrupted.sql.gz", "content")
+		path := link(t, d, 1)
+
+		res, err := client.Client.Do(client.NewRequest(t, http.MethodGet, path, nil))
+		require.NoError(t, err)
+		body, _ := io.ReadAll(res.Body)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, "cont", string(body))
+
+		assert.Equal(t, uint(1), downloads(t, path))
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound)
+	})
+
+	t.Run("Accesses", func(t *testing.T) {
+		d := create(t, "audited.sql.gz")
+		path := link(t, d, 0)
+		client.Do(t, http.MethodGet, path, nil, http.StatusNotFound, inttest.WithHeader("User-Agent", "auditor"))
+		store(t, "audited.sql.gz", "content")
+		client.Get(t, path, inttest.WithHeader("User-Agent", "auditor"))
+
+		var accesses []model.ExternalDownloadAccess
+		client.GetJSON(t, "/databases/"+strconv.FormatUint(uint64(d.ID), 10)+"/external/accesses", &accesses)
+
+		require.Len(t, accesses, 2)
+		uuid := strings.TrimPrefix(path, "/databases/external/")
+		for _, access := range accesses {
+			assert.Equal(t, d.ID, access.DatabaseID)
+			assert.Equal(t, uuid, access.DownloadUUID.String())
+			assert.Equal(t, "auditor", access.UserAgent)
+			assert.NotEmpty(t, access.ClientIP)
+			assert.False(t, access.Presigned)
+		}
+		// accesses are ordered by time, the latest first
+		assert.Equal(t, int64(7), accesses[0].BytesSent)
+		assert.Empty(t, accesses[0].Error)
+		assert.Zero(t, accesses[1].BytesSent)
+		assert.NotEmpty(t, accesses[1].Error)
+	})
+}
+
+// interruptingStore fails downloads of the interrupted key after sending half of the content
+type interruptingStore struct {
+	storage.ObjectStore
+	interruptedKey string
+}
+
+func (s interruptingStore) Download(ctx context.Context, bucket string, key string, dst io.Writer, cb func(contentLength int64)) error {
+	if key != s.interruptedKey {
+		return s.ObjectStore.Download(ctx, bucket, key, dst, cb)
+	}
+
+	var content bytes.Buffer
+	err := s.ObjectStore.Download(ctx, bucket, key, &content, func(int64) {})
+	if err != nil {
+		return err
+	}
+	cb(int64(content.Len()))
+	_, err = dst.Write(content.Bytes()[:content.Len()/2])
+	if err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
 func TestSaveLockedUnlocksOnDumpFailure(t *testing.T) {
 	t.Parallel()
 
 	db := inttest.SetupDB(t)
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	databaseRepository := database.NewRepository(db)
-	databaseService := database.NewService(logger, "database-bucket", nil, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{})
+	databaseService := database.NewService(logger, "database-bucket", nil, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
 
 	user, _ := userpkg.CreateUserWithGroup(t, db, "group-name", "some", "", "user1@dhis2.org")
 
@@ -362,6 +613,185 @@ func TestSaveLockedUnlocksOnDumpFailure(t *testing.T) {
 	require.Nil(t, reloaded.Lock, "a failed save must release the lock it acquired")
 }
 
+func TestLockExpiry(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	databaseRepository := database.NewRepository(db)
+	databaseService := database.NewService(logger, "database-bucket", nil, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
+
+	user, _ := userpkg.CreateUserWithGroup(t, db, "packages", "some", "", "user1@dhis2.org")
+
+	deployment := &model.Deployment{
+		UserID:    user.ID,
+		Name:      "name",
+		GroupName: "packages",
+	}
+	db.Create(deployment)
+
+	createInstance := func(name string) *model.DeploymentInstance {
+		instance := &model.DeploymentInstance{
+			Name:         name,
+			GroupName:    "packages",
+			StackName:    "dhis2",
+			DeploymentID: deployment.ID,
+		}
+		require.NoError(t, db.Create(instance).Error)
+		return instance
+	}
+	running := createInstance("running")
+	paused := createInstance("paused")
+	other := createInstance("other")
+
+	ctx := context.Background()
+	lock := func(name string, instanceId uint) *model.Database {
+		t.Helper()
+
+		d, err := databaseService.CreateDatabase(ctx, user.ID, "packages", name)
+		require.NoError(t, err)
+		_, err = databaseService.Lock(ctx, d.ID, instanceId, user.ID)
+		require.NoError(t, err)
+		return d
+	}
+	lockedByRunning := lock("running.sql.gz", running.ID)
+	lockedByPaused := lock("paused.sql.gz", paused.ID)
+	lockedByPausedToo := lock("paused-too.sql.gz", paused.ID)
+
+	// time passes without a heartbeat
+	err := db.Model(&model.Lock{}).Where("true").UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error
+	require.NoError(t, err)
+
+	// the inspector only renews the locks of running instances
+	err = databaseService.RenewLocks(ctx, []uint{running.ID})
+	require.NoError(t, err)
+
+	reloaded, err := databaseService.FindById(ctx, lockedByRunning.ID)
+	require.NoError(t, err)
+	assert.True(t, reloaded.Lock.ExpiresAt.After(time.Now()), "the lock of the running instance is renewed")
+	_, err = databaseService.Lock(ctx, lockedByRunning.ID, other.ID, user.ID)
+	require.ErrorContains(t, err, "database already locked")
+
+	t.Run("LockTakesOverExpiredLock", func(t *testing.T) {
+		lock, err := databaseService.Lock(ctx, lockedByPaused.ID, other.ID, user.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, other.ID, lock.InstanceID)
+		assert.True(t, lock.ExpiresAt.After(time.Now()))
+	})
+
+	t.Run("EnsureLockedTakesOverExpiredLock", func(t *testing.T) {
+		d, err := databaseService.FindById(ctx, lockedByPausedToo.ID)
+		require.NoError(t, err)
+		require.Equal(t, paused.ID, d.Lock.InstanceID)
+
+		locked, wasLocked, err := databaseService.EnsureLocked(ctx, d, other.ID, user.ID)
+		require.NoError(t, err)
+
+		assert.False(t, wasLocked, "an expired lock isn't held by the instance taking it over")
+		assert.Equal(t, other.ID, locked.Lock.InstanceID)
+	})
+
+	t.Run("ReleaseLockOnlyReleasesLockOfInstance", func(t *testing.T) {
+		err := databaseService.ReleaseLock(ctx, lockedByRunning.ID, other.ID)
+		require.NoError(t, err)
+		d, err := databaseService.FindById(ctx, lockedByRunning.ID)
+		require.NoError(t, err)
+		require.NotNil(t, d.Lock, "the lock held by another instance is kept")
+
+		err = databaseService.ReleaseLock(ctx, lockedByRunning.ID, running.ID)
+		require.NoError(t, err)
+		d, err = databaseService.FindById(ctx, lockedByRunning.ID)
+		require.NoError(t, err)
+		assert.Nil(t, d.Lock)
+	})
+}
+
+func TestEnforceRetention(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	databaseRepository := database.NewRepository(db)
+	databaseService := database.NewService(logger, "database-bucket", nil, groupService{groupName: "packages"}, databaseRepository, nil, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
+
+	user, _ := userpkg.CreateUserWithGroup(t, db, "packages", "some", "", "user1@dhis2.org")
+
+	deployment := &model.Deployment{
+		UserID:    user.ID,
+		Name:      "name",
+		GroupName: "packages",
+	}
+	db.Create(deployment)
+
+	instance := &model.DeploymentInstance{
+		Name:         "name",
+		GroupName:    "packages",
+		StackName:    "dhis2",
+		DeploymentID: deployment.ID,
+	}
+	db.Create(instance)
+
+	ctx := context.Background()
+	create := func(groupName, name string, updatedDaysAgo, seededDaysAgo int, labels model.Labels) *model.Database {
+		t.Helper()
+
+		d, err := databaseService.CreateDatabase(ctx, user.ID, groupName, name)
+		require.NoError(t, err)
+
+		columns := map[string]any{"updated_at": time.Now().AddDate(0, 0, -updatedDaysAgo), "labels": labels}
+		if seededDaysAgo > 0 {
+			columns["last_seeded_at"] = time.Now().AddDate(0, 0, -seededDaysAgo)
+		}
+		err = db.Model(&model.Database{}).Where("id = ?", d.ID).UpdateColumns(columns).Error
+		require.NoError(t, err)
+		return d
+	}
+
+	expired := create("packages", "expired.sql.gz", 100, 0, model.Labels{})
+	recent := create("packages", "recent.sql.gz", 10, 0, model.Labels{})
+	seeded := create("packages", "seeded.sql.gz", 100, 10, model.Labels{})
+	kept := create("packages", "kept.sql.gz", 100, 0, model.Labels{model.KeepLabel: ""})
+	locked := create("packages", "locked.sql.gz", 100, 0, model.Labels{})
+	_, err := databaseService.Lock(ctx, locked.ID, instance.ID, user.ID)
+	require.NoError(t, err)
+	otherGroup := create("other", "expired.sql.gz", 100, 0, model.Labels{})
+
+	err = databaseService.SaveRetentionPolicy(ctx, &model.RetentionPolicy{GroupName: "packages", UnusedDays: 90, Enabled: true})
+	require.NoError(t, err)
+
+	report, err := databaseService.RetentionReport(ctx, "packages")
+	require.NoError(t, err)
+	require.Len(t, report.Databases, 1)
+	assert.Equal(t, expired.ID, report.Databases[0].ID)
+
+	err = databaseService.EnforceRetention(ctx)
+	require.NoError(t, err)
+
+	_, err = databaseService.FindById(ctx, expired.ID)
+	require.Error(t, err, "expired databases are moved to the trash")
+	for _, d := range []*model.Database{recent, seeded, kept, locked, otherGroup} {
+		_, err := databaseService.FindById(ctx, d.ID)
+		require.NoErrorf(t, err, "database %q of group %q isn't expired", d.Name, d.GroupName)
+	}
+
+	t.Run("RunExclusive", func(t *testing.T) {
+		ran, err := databaseRepository.RunExclusive(ctx, 1, func(ctx context.Context) error {
+			ran, err := databaseRepository.RunExclusive(ctx, 1, func(context.Context) error { return nil })
+			require.NoError(t, err)
+			assert.False(t, ran, "the lock is held by the outer run")
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.True(t, ran)
+
+		ran, err = databaseRepository.RunExclusive(ctx, 1, func(context.Context) error { return nil })
+		require.NoError(t, err)
+		assert.True(t, ran, "the lock is released after the run")
+	})
+}
+
 type groupService struct {
 	groupName string
 }
@@ -379,7 +809,7 @@ func (is instanceService) FindDeploymentById(ctx context.Context, id uint) (*mod
 	panic("implement me")
 }
 
-func (is instanceService) FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database) error {
+func (is instanceService) FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database, progress *storage.ProgressTracker) error {
 	panic("implement me")
 }
 
@@ -411,6 +841,18 @@ func (is instanceService) UpdateInstanceParameters(ctx context.Context, deployme
 	panic("implement me")
 }
 
+func (is instanceService) RestoreFilestore(ctx context.Context, instance *model.DeploymentInstance, filestore *model.Database) error {
+	panic("implement me")
+}
+
+func (is instanceService) Pause(ctx context.Context, instance *model.DeploymentInstance) error {
+	panic("implement me")
+}
+
+func (is instanceService) Resume(ctx context.Context, instance *model.DeploymentInstance) error {
+	panic("implement me")
+}
+
 type stackService struct{}
 
 func (ss stackService) Find(name string) (*model.Stack, error) {
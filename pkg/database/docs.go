@@ -11,13 +11,24 @@ type Error struct {
 	Message string
 }
 
-//swagger:parameters findDatabase lockDatabaseById unlockDatabaseById downloadDatabase deleteDatabaseById updateDatabaseById createExternalDownloadDatabase
+//swagger:parameters findDatabase lockDatabaseById unlockDatabaseById downloadDatabase deleteDatabaseById updateDatabaseById createExternalDownloadDatabase listDatabaseVersions findForcedUnlocks findExternalDownloads findExternalDownloadAccesses restoreDatabase purgeDatabase
 type _ struct {
 	// in: path
 	// required: true
 	ID uint `json:"id"`
 }
 
+//swagger:parameters downloadDatabaseVersion promoteDatabaseVersion
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// in: path
+	// required: true
+	Version uint `json:"version"`
+}
+
 //swagger:parameters saveAsDatabase
 type _ struct {
 	// in: path
@@ -49,6 +60,159 @@ type _ struct {
 	Body CopyDatabaseRequest
 }
 
+// swagger:parameters listDatabases
+type _ struct {
+	// Only databases whose name or description contain all words of the query
+	// in: query
+	Q string `json:"q"`
+
+	// Only databases of the given groups
+	// in: query
+	Group []string `json:"group"`
+
+	// Either database or fs, defaults to database
+	// in: query
+	Type string `json:"type"`
+
+	// Only databases created by the given user
+	// in: query
+	UserID uint `json:"userId"`
+
+	// Minimum size in bytes
+	// in: query
+	MinSize int64 `json:"minSize"`
+
+	// Maximum size in bytes
+	// in: query
+	MaxSize int64 `json:"maxSize"`
+
+	// Only locked or unlocked databases
+	// in: query
+	Locked bool `json:"locked"`
+
+	// Label selectors of the form key=value or key
+	// in: query
+	Label []string `json:"label"`
+
+	// 1-based page, requires pageSize
+	// in: query
+	Page int `json:"page"`
+
+	// Number of databases per page, omit to list all databases
+	// in: query
+	PageSize int `json:"pageSize"`
+}
+
+// swagger:parameters forceUnlockDatabaseById
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Force unlock database request body parameter
+	// in: body
+	// required: true
+	Body ForceUnlockDatabaseRequest
+}
+
+// swagger:parameters shareDatabase
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Share database request body parameter
+	// in: body
+	// required: true
+	Body ShareDatabaseRequest
+}
+
+// swagger:parameters unshareDatabase
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// in: path
+	// required: true
+	Group string `json:"group"`
+}
+
+// swagger:parameters importDatabase
+type _ struct {
+	// in: body
+	// required: true
+	Body ImportDatabaseRequest
+}
+
+// swagger:parameters createDatabaseUpload
+type _ struct {
+	// in: body
+	// required: true
+	Body CreateUploadRequest
+}
+
+// swagger:parameters findDatabaseUpload completeDatabaseUpload abortDatabaseUpload
+type _ struct {
+	// in: path
+	// required: true
+	UploadID string `json:"uploadId"`
+}
+
+// swagger:parameters presignDatabaseChunkUpload
+type _ struct {
+	// in: path
+	// required: true
+	UploadID string `json:"uploadId"`
+
+	// Number of the chunk starting from 1
+	// in: path
+	// required: true
+	Number uint `json:"number"`
+
+	// Size of the chunk in bytes
+	// in: query
+	// required: true
+	Size int64 `json:"size"`
+}
+
+// swagger:parameters uploadDatabaseChunk
+type _ struct {
+	// in: path
+	// required: true
+	UploadID string `json:"uploadId"`
+
+	// Number of the chunk starting from 1
+	// in: path
+	// required: true
+	Number uint `json:"number"`
+
+	// Content of the chunk
+	// in: body
+	// required: true
+	Body []byte
+}
+
+// swagger:parameters verifyDatabase extractDatabaseMetadata
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+}
+
+// swagger:parameters convertDatabase
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Format to convert the database into, either plain or custom
+	// in: query
+	// required: true
+	// enum: plain,custom
+	Format string `json:"format"`
+}
+
 // swagger:parameters lockDatabaseById unlockDatabaseById
 type _ struct {
 	// Lock/unlock database request body parameter
@@ -105,6 +269,17 @@ type _ struct {
 	Body CreateExternalDatabaseRequest
 }
 
+// swagger:parameters revokeExternalDownload
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// in: path
+	// required: true
+	UUID string `json:"uuid"`
+}
+
 // swagger:response CreateExternalDownloadResponse
 type CreateExternalDownloadBody struct {
 	//in: body
@@ -123,8 +298,74 @@ type GroupsWithDatabasesBody struct {
 	Body GroupsWithDatabases
 }
 
+// swagger:response PresignedUrl
+type PresignedUrlBody struct {
+	//in: body
+	Body PresignedUrl
+}
+
 // swagger:response Lock
 type LockBody struct {
 	//in: body
 	Body model.Lock
 }
+
+// swagger:response DatabaseVersions
+type DatabaseVersionsBody struct {
+	//in: body
+	Body []model.DatabaseVersion
+}
+
+//swagger:parameters findRetentionPolicy deleteRetentionPolicy retentionReport
+type _ struct {
+	// in: path
+	// required: true
+	Group string `json:"group"`
+}
+
+//swagger:parameters saveRetentionPolicy
+type _ struct {
+	// in: path
+	// required: true
+	Group string `json:"group"`
+
+	// Retention policy request body parameter
+	// in: body
+	// required: true
+	Body RetentionPolicyRequest
+}
+
+// swagger:response RetentionPolicy
+type RetentionPolicyBody struct {
+	//in: body
+	Body model.RetentionPolicy
+}
+
+// swagger:response RetentionReport
+type RetentionReportBody struct {
+	//in: body
+	Body RetentionReport
+}
+
+// swagger:parameters listDatabaseFiles
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Path of the directory to list, the root of the filestore if empty
+	// in: query
+	Path string `json:"path"`
+}
+
+// swagger:parameters downloadDatabaseFile
+type _ struct {
+	// in: path
+	// required: true
+	ID uint `json:"id"`
+
+	// Path of the file to download
+	// in: query
+	// required: true
+	Path string `json:"path"`
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"compress/gzip"
+	"slices"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// maxDumpJobs limits the number of connections a parallel dump opens to the database of an instance
+const maxDumpJobs = 16
+
+// directoryDumpScript dumps the database in the directory format into a pod temp dir and streams
+// the directory as a tar. The arguments of the script are passed to pg_dump. The dump needs
+// ~database-size free ephemeral storage on the pod.
+const directoryDumpScript = `set -euo pipefail
+dir=$(mktemp -d /tmp/im-dump-XXXXXXXX)
+trap 'rm -rf "$dir"' EXIT
+pg_dump "$@" --file="$dir/dump"
+tar -C "$dir/dump" -cf - .`
+
+// validateDumpOverrides validates the overrides of a dump in the given format
+func validateDumpOverrides(format string, overrides model.DumpOverrides) error {
+	if format != formatPlain && format != formatCustom && format != formatDirectory {
+		return errdef.NewBadRequest("unsupported format %q, must be either %q, %q or %q", format, formatPlain, formatCustom, formatDirectory)
+	}
+
+	if overrides.Compression != nil && *overrides.Compression > 9 {
+		return errdef.NewBadRequest("compression must be between 0 and 9, got %d", *overrides.Compression)
+	}
+
+	if overrides.Jobs > 0 && format != formatDirectory {
+		return errdef.NewBadRequest("only the %q format can be dumped in parallel", formatDirectory)
+	}
+
+	if overrides.Jobs > maxDumpJobs {
+		return errdef.NewBadRequest("jobs must be at most %d, got %d", maxDumpJobs, overrides.Jobs)
+	}
+
+	return nil
+}
+
+// overrideOptions returns the pg_dump options selecting the tables and the content of the dump
+func overrideOptions(overrides model.DumpOverrides) []string {
+	var options []string
+	for _, table := range overrides.Tables {
+		options = append(options, "--table="+table)
+	}
+	for _, table := range overrides.ExcludeTables {
+		options = append(options, "--exclude-table="+table)
+	}
+	if overrides.SchemaOnly {
+		options = append(options, "--schema-only")
+	}
+	return options
+}
+
+// excludedTableData returns the patterns of the stack, except the ones included by the overrides,
+// along with the patterns excluded by the overrides
+func excludedTableData(stackPatterns []string, overrides model.DumpOverrides) []string {
+	patterns := slices.DeleteFunc(slices.Clone(stackPatterns), func(pattern string) bool {
+		return slices.Contains(overrides.IncludeTableData, pattern)
+	})
+
+	for _, pattern := range overrides.ExcludeTableData {
+		if !slices.Contains(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// gzipLevel returns the level plain dumps are compressed with
+func gzipLevel(overrides model.DumpOverrides) int {
+	if overrides.Compression == nil {
+		return gzip.DefaultCompression
+	}
+	return int(*overrides.Compression)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	pg "github.com/habx/pg-commands"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDumpOverrides(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		compression := uint(9)
+
+		assert.NoError(t, validateDumpOverrides("directory", model.DumpOverrides{Compression: &compression, Jobs: 8}))
+	})
+
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		assert.ErrorContains(t, validateDumpOverrides("tar", model.DumpOverrides{}), `unsupported format "tar"`)
+	})
+
+	t.Run("CompressionTooHigh", func(t *testing.T) {
+		compression := uint(10)
+
+		assert.ErrorContains(t, validateDumpOverrides("custom", model.DumpOverrides{Compression: &compression}), "compression must be between 0 and 9")
+	})
+
+	t.Run("JobsWithoutDirectoryFormat", func(t *testing.T) {
+		assert.ErrorContains(t, validateDumpOverrides("custom", model.DumpOverrides{Jobs: 2}), "can be dumped in parallel")
+	})
+
+	t.Run("TooManyJobs", func(t *testing.T) {
+		assert.ErrorContains(t, validateDumpOverrides("directory", model.DumpOverrides{Jobs: maxDumpJobs + 1}), "jobs must be at most")
+	})
+}
+
+func TestExcludedTableData(t *testing.T) {
+	overrides := model.DumpOverrides{
+		IncludeTableData: []string{"analytics*"},
+		ExcludeTableData: []string{"audit", "_*"},
+	}
+
+	patterns := excludedTableData([]string{"analytics*", "_*"}, overrides)
+
+	assert.Equal(t, []string{"_*", "audit"}, patterns)
+}
+
+func TestOverrideOptions(t *testing.T) {
+	overrides := model.DumpOverrides{Tables: []string{"organisationunit"}, ExcludeTables: []string{"audit"}, SchemaOnly: true}
+
+	options := overrideOptions(overrides)
+
+	assert.Equal(t, []string{"--table=organisationunit", "--exclude-table=audit", "--schema-only"}, options)
+}
+
+func TestBuildPgDumpCommand(t *testing.T) {
+	// pg.NewDump requires pg_dump to be installed, so the dump is created directly
+	newDump := func() *pg.Dump {
+		return &pg.Dump{
+			Postgres:        &pg.Postgres{Host: "localhost", Port: 5432, DB: "dhis2", Username: "dhis", Password: "secret"},
+			Options:         []string{"--no-owner"},
+			IgnoreTableData: []string{"analytics*"},
+		}
+	}
+
+	t.Run("Custom", func(t *testing.T) {
+		compression := uint(3)
+
+		command := buildPgDumpCommand(newDump(), "custom", model.DumpOverrides{Compression: &compression})
+
+		assert.Equal(t, []string{"env", "PGPASSWORD=secret", "pg_dump"}, command[:3])
+		assert.Contains(t, command, "--no-owner")
+		assert.Contains(t, command, "-Fcustom")
+		assert.Contains(t, command, "--compress=3")
+		assert.Contains(t, command, "--exclude-table-data=analytics*")
+	})
+
+	t.Run("PlainIsCompressedWhileStreamed", func(t *testing.T) {
+		compression := uint(3)
+		overrides := model.DumpOverrides{Compression: &compression}
+
+		command := buildPgDumpCommand(newDump(), "plain", overrides)
+
+		assert.NotContains(t, command, "--compress=3")
+		assert.Equal(t, 3, gzipLevel(overrides))
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		command := buildPgDumpCommand(newDump(), "directory", model.DumpOverrides{Jobs: 4})
+
+		assert.Equal(t, []string{"env", "PGPASSWORD=secret", "bash", "-c", directoryDumpScript, "pg_dump"}, command[:6])
+		assert.Contains(t, command, "-Fdirectory")
+		assert.Contains(t, command, "--jobs=4")
+	})
+}
@@ -1,16 +1,36 @@
 package database
 
-import "github.com/dhis2-sre/im-manager/pkg/model"
+import (
+	"time"
 
-const kindDatabaseSave = "database-save"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+)
 
-// databaseEvent is the JSON payload published for database-save events.
+// progressInterval is the minimum interval between progress events of a dump or an import
+const progressInterval = 5 * time.Second
+
+const (
+	kindDatabaseSave      = "database-save"
+	kindDatabaseConvert   = "database-convert"
+	kindDatabaseAnonymize = "database-anonymize"
+	kindDatabaseImport    = "database-import"
+	kindDatabaseVerify    = "database-verify"
+	// kindDatabaseForceUnlock events carry the recorded model.ForcedUnlock
+	kindDatabaseForceUnlock = "database-force-unlock"
+	kindStorageQuota        = "storage-quota"
+)
+
+// databaseEvent is the JSON payload published for database-save, database-convert,
+// database-anonymize and database-import events. Dumps and imports publish "progress" events with
+// the progress of the transfer.
 type databaseEvent struct {
-	Status       string `json:"status"`
-	DatabaseID   uint   `json:"databaseId"`
-	DatabaseName string `json:"databaseName"`
-	Size         int64  `json:"size,omitempty"`
-	Error        string `json:"error,omitempty"`
+	Status       string            `json:"status"`
+	DatabaseID   uint              `json:"databaseId"`
+	DatabaseName string            `json:"databaseName"`
+	Size         int64             `json:"size,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	Progress     *storage.Progress `json:"progress,omitempty"`
 }
 
 func newDatabaseEvent(db *model.Database, status, errMsg string, size int64) databaseEvent {
@@ -22,3 +42,31 @@ func newDatabaseEvent(db *model.Database, status, errMsg string, size int64) dat
 		Error:        errMsg,
 	}
 }
+
+func newDatabaseProgressEvent(db *model.Database, progress storage.Progress) databaseEvent {
+	return databaseEvent{
+		Status:       "progress",
+		DatabaseID:   db.ID,
+		DatabaseName: db.Name,
+		Size:         progress.Bytes,
+		Progress:     &progress,
+	}
+}
+
+// verifyEvent is the JSON payload published for database-verify events of verifications run in the
+// background
+type verifyEvent struct {
+	Status       string        `json:"status"`
+	DatabaseID   uint          `json:"databaseId"`
+	DatabaseName string        `json:"databaseName"`
+	Error        string        `json:"error,omitempty"`
+	Result       *VerifyResult `json:"result,omitempty"`
+}
+
+// storageQuotaEvent is the JSON payload published for storage-quota events once the storage usage
+// of a group reaches the given percentage of its quota
+type storageQuotaEvent struct {
+	Percent int64 `json:"percent"`
+	Used    int64 `json:"used"`
+	Quota   int64 `json:"quota"`
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"io"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// FindFilestore returns the filestore of the database or the database itself if it's a filestore
+func (s Service) FindFilestore(ctx context.Context, d *model.Database) (*model.Database, error) {
+	if d.Type == "fs" {
+		return d, nil
+	}
+
+	if d.FilestoreID == 0 {
+		return nil, errdef.NewBadRequest("database %d has no filestore", d.ID)
+	}
+
+	return s.repository.FindById(ctx, d.FilestoreID)
+}
+
+// ListFiles lists the files and directories of the directory with the given path, "" being the
+// root, of the filestore of the database
+func (s Service) ListFiles(ctx context.Context, d *model.Database, dir string) ([]filestore.DirEntry, error) {
+	fs, err := s.FindFilestore(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filestore.Files(ctx, s.objectStore, s.s3Bucket, objectKey(fs.Url))
+	if err != nil {
+		return nil, err
+	}
+
+	return filestore.ReadDir(files, dir)
+}
+
+// FindFile finds the file with the given path of the filestore of the database
+func (s Service) FindFile(ctx context.Context, d *model.Database, path string) (*filestore.File, error) {
+	fs, err := s.FindFilestore(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return filestore.FindFile(ctx, s.objectStore, s.s3Bucket, objectKey(fs.Url), path)
+}
+
+// DownloadFile writes the content of the file of a filestore to dst
+func (s Service) DownloadFile(ctx context.Context, file filestore.File, dst io.Writer) error {
+	return filestore.Extract(ctx, s.objectStore, s.s3Bucket, file, dst)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"gorm.io/gorm"
+)
+
+const maxPageSize = 500
+
+// DatabaseFilter narrows down the listed databases. Zero values don't filter.
+type DatabaseFilter struct {
+	// Groups restricts the databases to the given groups
+	Groups []string
+	// Query matches databases whose name or description contain all the words of the query
+	Query string
+	// Type is either "database" or "fs", defaults to "database"
+	Type    string
+	UserID  uint
+	MinSize int64
+	MaxSize int64
+	Locked  *bool
+	// Labels matches databases having all the labels. Labels with an empty value match databases
+	// having the label regardless of its value.
+	Labels map[string]string
+	// Page is the 1-based page, only used together with PageSize
+	Page int
+	// PageSize is the number of databases per page, 0 returns all databases
+	PageSize int
+}
+
+func (f DatabaseFilter) validate() error {
+	if f.Type != "" && f.Type != "database" && f.Type != "fs" {
+		return errdef.NewBadRequest("type must be either database or fs, not %q", f.Type)
+	}
+
+	if f.MinSize < 0 || f.MaxSize < 0 {
+		return errdef.NewBadRequest("size range can't be negative")
+	}
+
+	if f.MaxSize > 0 && f.MinSize > f.MaxSize {
+		return errdef.NewBadRequest("minimum size %d is larger than the maximum size %d", f.MinSize, f.MaxSize)
+	}
+
+	if f.PageSize < 0 || f.PageSize > maxPageSize {
+		return errdef.NewBadRequest("page size must be between 0 and %d", maxPageSize)
+	}
+
+	if f.PageSize > 0 && f.Page < 1 {
+		return errdef.NewBadRequest("page must be at least 1")
+	}
+
+	return nil
+}
+
+func (f DatabaseFilter) apply(query *gorm.DB) *gorm.DB {
+	if len(f.Groups) > 0 {
+		query = query.Where("Databases.group_name IN ?", f.Groups)
+	}
+
+	databaseType := f.Type
+	if databaseType == "" {
+		databaseType = "database"
+	}
+	query = query.Where("Databases.type = ?", databaseType)
+
+	// databases which are still being uploaded have no content yet
+	query = query.Where("NOT EXISTS (SELECT 1 FROM database_uploads WHERE database_uploads.database_id = Databases.id)")
+
+	for _, word := range strings.Fields(f.Query) {
+		pattern := "%" + escapeLike(word) + "%"
+		query = query.Where("(Databases.name ILIKE ? OR Databases.description ILIKE ?)", pattern, pattern)
+	}
+
+	if f.UserID != 0 {
+		query = query.Where("Databases.user_id = ?", f.UserID)
+	}
+
+	if f.MinSize > 0 {
+		query = query.Where("Databases.size >= ?", f.MinSize)
+	}
+
+	if f.MaxSize > 0 {
+		query = query.Where("Databases.size <= ?", f.MaxSize)
+	}
+
+	if f.Locked != nil {
+		locked := "EXISTS (SELECT 1 FROM locks WHERE locks.database_id = Databases.id)"
+		if !*f.Locked {
+			locked = "NOT " + locked
+		}
+		query = query.Where(locked)
+	}
+
+	values := map[string]string{}
+	for key, value := range f.Labels {
+		if value == "" {
+			// the key exists operator can use the GIN index. gorm treats every ? as a placeholder so the
+			// operator is passed as an expression.
+			query = query.Where("Databases.labels ? ?", gorm.Expr("?"), key)
+			continue
+		}
+		values[key] = value
+	}
+	if len(values) > 0 {
+		// the labels are marshalled by the model so the containment check can use the GIN index
+		query = query.Where("Databases.labels @> ?", model.Labels(values))
+	}
+
+	return query
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]{0,61}[a-zA-Z0-9])?$`)
+
+const maxLabelValueLength = 255
+
+// validateLabels ensures label keys are made of alphanumerics, dots, dashes, underscores and slashes
+// and values aren't too long
+func validateLabels(labels model.Labels) error {
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return errdef.NewBadRequest("invalid label key %q", key)
+		}
+		if len(value) > maxLabelValueLength {
+			return errdef.NewBadRequest("value of label %q exceeds %d characters", key, maxLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// parseLabelSelectors parses label selectors of the form key=value or key
+func parseLabelSelectors(selectors []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, selector := range selectors {
+		key, value, _ := strings.Cut(selector, "=")
+		if !labelKeyPattern.MatchString(key) {
+			return nil, errdef.NewBadRequest("invalid label selector %q", selector)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestDatabaseFilterValidate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		filter := DatabaseFilter{Type: "fs", MinSize: 1, MaxSize: 2, Page: 1, PageSize: 50}
+
+		assert.NoError(t, filter.validate())
+	})
+
+	t.Run("InvalidType", func(t *testing.T) {
+		filter := DatabaseFilter{Type: "table"}
+
+		assert.ErrorContains(t, filter.validate(), `not "table"`)
+	})
+
+	t.Run("InvalidSizeRange", func(t *testing.T) {
+		filter := DatabaseFilter{MinSize: 2, MaxSize: 1}
+
+		assert.ErrorContains(t, filter.validate(), "larger than the maximum size")
+	})
+
+	t.Run("PageSizeTooLarge", func(t *testing.T) {
+		filter := DatabaseFilter{Page: 1, PageSize: maxPageSize + 1}
+
+		assert.ErrorContains(t, filter.validate(), "page size must be between")
+	})
+
+	t.Run("PageSizeWithoutPage", func(t *testing.T) {
+		filter := DatabaseFilter{PageSize: 10}
+
+		assert.ErrorContains(t, filter.validate(), "page must be at least 1")
+	})
+}
+
+func TestDatabaseFilterApply_Labels(t *testing.T) {
+	conn, err := sql.Open("pgx", "")
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	filter := DatabaseFilter{Labels: map[string]string{"golden": "", "env": "prod"}}
+
+	statement := filter.apply(db.Model(&model.Database{})).Find(&[]model.Database{}).Statement
+
+	query := statement.SQL.String()
+	assert.Contains(t, query, "Databases.labels ? $2", "key selectors use the key exists operator supported by the GIN index")
+	assert.Contains(t, query, "Databases.labels @> $3")
+	assert.Equal(t, "golden", statement.Vars[1])
+	assert.Equal(t, model.Labels{"env": "prod"}, statement.Vars[2])
+}
+
+func TestParseLabelSelectors(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		labels, err := parseLabelSelectors([]string{"env=prod", "country=sierra-leone", "golden"})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "country": "sierra-leone", "golden": ""}, labels)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		_, err := parseLabelSelectors([]string{"=prod"})
+
+		assert.ErrorContains(t, err, "invalid label selector")
+	})
+}
+
+func TestValidateLabels(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		err := validateLabels(model.Labels{"dhis2.org/version": "2.41", "env": ""})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		err := validateLabels(model.Labels{"-env": "prod"})
+
+		assert.ErrorContains(t, err, `invalid label key "-env"`)
+	})
+
+	t.Run("ValueTooLong", func(t *testing.T) {
+		err := validateLabels(model.Labels{"env": strings.Repeat("a", maxLabelValueLength+1)})
+
+		assert.ErrorContains(t, err, "exceeds 255 characters")
+	})
+}
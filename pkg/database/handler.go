@@ -14,6 +14,7 @@ import (
 
 	"github.com/dhis2-sre/im-manager/internal/handler"
 	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -49,7 +50,7 @@ type stackService interface {
 }
 
 type deploymentService interface {
-	SaveAs(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, name string, format string) (*model.Database, error)
+	SaveAs(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, groupName, name string, format string, dumpOverrides model.DumpOverrides, anonymizationProfile string) (*model.Database, error)
 	Save(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance) error
 }
 
@@ -134,15 +135,85 @@ func (h Handler) Upload(c *gin.Context) {
 	c.JSON(http.StatusCreated, save)
 }
 
+type ImportDatabaseRequest struct {
+	// URL the database is fetched from
+	Url   string `json:"url" binding:"required"`
+	Group string `json:"group" binding:"required"`
+	Name  string `json:"name" binding:"required"`
+	// Description of the database
+	Description string `json:"description"`
+	// Optional hex encoded SHA-256 checksum the fetched database must match
+	Checksum string `json:"checksum"`
+}
+
+// Import database
+func (h Handler) Import(c *gin.Context) {
+	// swagger:route POST /databases/import importDatabase
+	//
+	// Import database
+	//
+	// Import a database from a remote URL. The database is fetched in the background and the progress is published as database-import notifications
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202: Database
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	409: Error
+	//	415: Error
+	var request ImportDatabaseRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccessGroup(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	group, err := h.groupService.Find(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	name := strings.Trim(strings.TrimSpace(request.Name), "/")
+	d, err := h.databaseService.Import(ctx, user.ID, group.Name, name, strings.TrimSpace(request.Description), request.Url, request.Checksum)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, d)
+}
+
 type saveAsRequest struct {
 	// Name of the new database
 	Name string `json:"name" binding:"required"`
-	// Database dump format. Currently plain and custom are support, please see https://www.postgresql.org/docs/current/app-pgdump.html
-	Format string `json:"format" binding:"required,oneOf=plain custom"`
+	// Database dump format. Currently plain, custom and directory are supported, please see https://www.postgresql.org/docs/current/app-pgdump.html
+	// Directory format dumps are stored as tars and can be dumped in parallel
+	Format string `json:"format" binding:"required,oneOf=plain custom directory"`
+	// Overrides of the dump options of the stack
+	DumpOptions model.DumpOverrides `json:"dumpOptions"`
+	// Name of the anonymization profile to apply, the database isn't anonymized if empty
+	AnonymizationProfile string `json:"anonymizationProfile"`
 	// TODO: Add InstanceId here rather than as path param?
 	//	InstanceId uint   `json:"instanceId" binding:"required"`
-	// TODO: Allow saving to another group, remember to ensure user is member of the other group
-	//	Group  string `json:"group"`
+	// Group to save the database into, defaults to the group of the instance. The user must be a member of the group
+	Group string `json:"group"`
 }
 
 // SaveAs database
@@ -199,6 +270,15 @@ func (h Handler) SaveAs(c *gin.Context) {
 		return
 	}
 
+	groupName := instance.GroupName
+	if request.Group != "" {
+		if !handler.CanAccessGroup(user, request.Group) {
+			_ = c.Error(errdef.NewForbidden("access denied to group %q", request.Group))
+			return
+		}
+		groupName = request.Group
+	}
+
 	deployment, err := h.instanceService.FindDeploymentById(ctx, instance.DeploymentID)
 	if err != nil {
 		_ = c.Error(err)
@@ -211,7 +291,21 @@ func (h Handler) SaveAs(c *gin.Context) {
 		return
 	}
 
-	savedDatabase, err := h.deploymentService.SaveAs(ctx, user.ID, instance, stack, coreInstance, request.Name, request.Format)
+	if request.AnonymizationProfile != "" {
+		_, err := h.databaseService.AnonymizationProfile(request.AnonymizationProfile)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	err = validateDumpOverrides(request.Format, request.DumpOptions)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	savedDatabase, err := h.deploymentService.SaveAs(ctx, user.ID, instance, stack, coreInstance, groupName, request.Name, request.Format, request.DumpOptions, request.AnonymizationProfile)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -278,7 +372,7 @@ func (h Handler) Save(c *gin.Context) {
 		return
 	}
 
-	err = h.canAccess(c, database)
+	err = h.canWrite(c, database)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -313,6 +407,9 @@ func (h Handler) Save(c *gin.Context) {
 type CopyDatabaseRequest struct {
 	Name  string `json:"name" binding:"required"`
 	Group string `json:"group" binding:"required"`
+	// Name of the anonymization profile to apply, the database isn't anonymized if empty. Filestores
+	// aren't copied along with anonymized databases
+	AnonymizationProfile string `json:"anonymizationProfile"`
 }
 
 // Copy database
@@ -349,6 +446,24 @@ func (h Handler) Copy(c *gin.Context) {
 		return
 	}
 
+	source, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, source)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccessGroup(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
 	d := &model.Database{
 		Name:      request.Name,
 		GroupName: request.Group,
@@ -356,15 +471,19 @@ func (h Handler) Copy(c *gin.Context) {
 		UserID:    user.ID,
 	}
 
-	err = h.canAccess(c, d)
+	group, err := h.groupService.Find(ctx, d.GroupName)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	group, err := h.groupService.Find(ctx, d.GroupName)
-	if err != nil {
-		_ = c.Error(err)
+	if request.AnonymizationProfile != "" {
+		if err := h.databaseService.CopyAnonymized(ctx, id, d, group, request.AnonymizationProfile); err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, d)
 		return
 	}
 
@@ -456,7 +575,7 @@ func (h Handler) Lock(c *gin.Context) {
 		return
 	}
 
-	err = h.canAccess(c, d)
+	err = h.canWrite(c, d)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -527,65 +646,81 @@ func (h Handler) Unlock(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
-// Download database
-func (h Handler) Download(c *gin.Context) {
-	// swagger:route GET /databases/{id}/download downloadDatabase
+type ForceUnlockDatabaseRequest struct {
+	// Reason for releasing the lock on behalf of the user holding it
+	Reason string `json:"reason" binding:"required"`
+}
+
+// ForceUnlock database
+func (h Handler) ForceUnlock(c *gin.Context) {
+	// swagger:route POST /databases/{id}/force-unlock forceUnlockDatabaseById
 	//
-	// Download database
+	// Force unlock database
 	//
-	// Download a database by its identifier. The identifier could be either the actual id of the database or the slug associated with it
+	// Release the lock of a database regardless of who holds it. Only group administrators can force unlock databases and the reason is recorded
 	//
 	// Security:
 	//	oauth2:
 	//
 	// Responses:
-	//	200: DownloadDatabaseResponse
+	//	201: ForcedUnlock
+	//	400: Error
 	//	401: Error
 	//	403: Error
 	//	404: Error
 	//	415: Error
-	identifier := c.Param("id")
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	var request ForceUnlockDatabaseRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
 	ctx := c.Request.Context()
-	d, err := h.databaseService.FindByIdentifier(ctx, identifier)
+	user, err := handler.GetUserFromContext(ctx)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	err = h.canAccess(c, d)
+	d, err := h.databaseService.FindById(ctx, id)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	_, file := path.Split(d.Url)
-	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file}))
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Type", "application/octet-stream")
+	err = h.canAdministerGroup(ctx, d.GroupName, "force unlock databases")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
 
-	err = h.databaseService.Download(ctx, d.ID, c.Writer, func(contentLength int64) {
-		c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
-	})
+	forcedUnlock, err := h.databaseService.ForceUnlock(ctx, user.ID, d, request.Reason)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
+
+	c.JSON(http.StatusCreated, forcedUnlock)
 }
 
-// Delete database
-func (h Handler) Delete(c *gin.Context) {
-	// swagger:route DELETE /databases/{id} deleteDatabaseById
+// FindForcedUnlocks database
+func (h Handler) FindForcedUnlocks(c *gin.Context) {
+	// swagger:route GET /databases/{id}/forced-unlocks findForcedUnlocks
 	//
-	// Delete database
+	// Find forced unlocks
 	//
-	// Delete database by id...
+	// Find the forced unlocks of a database, newest first
 	//
 	// Security:
 	//	oauth2:
 	//
 	// Responses:
-	//	202:
+	//	200: []ForcedUnlock
 	//	401: Error
 	//	403: Error
 	//	404: Error
@@ -608,148 +743,90 @@ func (h Handler) Delete(c *gin.Context) {
 		return
 	}
 
-	err = h.databaseService.Delete(ctx, id)
+	forcedUnlocks, err := h.databaseService.FindForcedUnlocks(ctx, d.ID)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	c.Status(http.StatusAccepted)
+	c.JSON(http.StatusOK, forcedUnlocks)
 }
 
-// swagger:model GroupsWithDatabases
-type GroupsWithDatabases struct {
-	Name      string           `json:"name"`
-	Databases []model.Database `json:"databases"`
+type ShareDatabaseRequest struct {
+	// Group to share the database with
+	Group string `json:"group" binding:"required"`
 }
 
-// List databases
-func (h Handler) List(c *gin.Context) {
-	// swagger:route GET /databases listDatabases
+// Share database
+func (h Handler) Share(c *gin.Context) {
+	// swagger:route POST /databases/{id}/shares shareDatabase
 	//
-	// List databases
+	// Share database
 	//
-	// List databases...
+	// Share a database read-only with another group. Members of the group can find, download and deploy the database and copy it into their own groups. Only administrators of the group owning the database can share it
 	//
 	// Security:
 	//	oauth2:
 	//
 	// Responses:
-	//	200: []GroupsWithDatabases
-	//	401: Error
-	//	403: Error
-	//	415: Error
-	ctx := c.Request.Context()
-	user, err := handler.GetUserFromContext(ctx)
-	if err != nil {
-		_ = c.Error(err)
-		return
-	}
-
-	databases, err := h.databaseService.List(ctx, user)
-	if err != nil {
-		_ = c.Error(err)
-		return
-	}
-
-	c.JSON(http.StatusOK, databases)
-}
-
-type UpdateDatabaseRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description" binding:"required"`
-}
-
-// Update database
-func (h Handler) Update(c *gin.Context) {
-	// swagger:route PUT /databases/{id} updateDatabaseById
-	//
-	// Update database
-	//
-	// Update database by id
-	// TODO: Race condition? If two clients request at the same time... Do we need a transaction between find and update
-	//
-	// Security:
-	//   oauth2:
-	//
-	// Responses:
-	//	200: Database
+	//	201: DatabaseShare
+	//	400: Error
 	//	401: Error
 	//	403: Error
+	//	404: Error
 	//	415: Error
 	id, ok := handler.GetPathParameter(c, "id")
 	if !ok {
 		return
 	}
 
-	var request UpdateDatabaseRequest
+	var request ShareDatabaseRequest
 	if err := handler.DataBinder(c, &request); err != nil {
 		_ = c.Error(err)
 		return
 	}
 
 	ctx := c.Request.Context()
-	d, err := h.databaseService.FindById(ctx, id)
+	user, err := handler.GetUserFromContext(ctx)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	err = h.canAccess(c, d)
+	d, err := h.databaseService.FindById(ctx, id)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	d.Name = request.Name
-	d.Description = request.Description
-
-	err = h.databaseService.Update(ctx, d)
+	err = h.canAdministerGroup(ctx, d.GroupName, "share databases")
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, d)
-}
-
-func (h Handler) canAccess(c *gin.Context, d *model.Database) error {
-	user, err := handler.GetUserFromContext(c.Request.Context())
+	share, err := h.databaseService.Share(ctx, user.ID, d, request.Group)
 	if err != nil {
 		_ = c.Error(err)
-		return err
-	}
-
-	can := handler.CanAccess(user, d)
-	if !can {
-		return errdef.NewForbidden("access denied")
+		return
 	}
 
-	return nil
-}
-
-// maxExternalDownloadExpirationSeconds is the maximum time a database can be downloaded without
-// authentication. 30 is simply chosen for the sake of having a reasonable default.
-const maxExternalDownloadExpirationSeconds uint = 30 * 24 * 60 * 60 // 30 days
-
-type CreateExternalDatabaseRequest struct {
-	// Expiration time in seconds
-	Expiration uint `json:"expiration" binding:"required"`
+	c.JSON(http.StatusCreated, share)
 }
 
-// CreateExternalDownload database
-func (h Handler) CreateExternalDownload(c *gin.Context) {
-	// swagger:route POST /databases/{id}/external createExternalDownloadDatabase
+// Unshare database
+func (h Handler) Unshare(c *gin.Context) {
+	// swagger:route DELETE /databases/{id}/shares/{group} unshareDatabase
 	//
-	// External download link
+	// Unshare database
 	//
-	// Create link so the database can be downloaded without log in
+	// Revoke the access of a group to a shared database. Only administrators of the group owning the database can unshare it
 	//
 	// Security:
 	//	oauth2:
 	//
 	// Responses:
-	//	200: CreateExternalDownloadResponse
+	//	202:
 	//	401: Error
 	//	403: Error
 	//	404: Error
@@ -759,17 +836,6 @@ func (h Handler) CreateExternalDownload(c *gin.Context) {
 		return
 	}
 
-	var request CreateExternalDatabaseRequest
-	if err := handler.DataBinder(c, &request); err != nil {
-		_ = c.Error(err)
-		return
-	}
-
-	if request.Expiration > maxExternalDownloadExpirationSeconds {
-		_ = c.Error(errdef.NewBadRequest("expiration must not exceed 30 days (%d seconds)", maxExternalDownloadExpirationSeconds))
-		return
-	}
-
 	ctx := c.Request.Context()
 	d, err := h.databaseService.FindById(ctx, id)
 	if err != nil {
@@ -777,56 +843,55 @@ func (h Handler) CreateExternalDownload(c *gin.Context) {
 		return
 	}
 
-	err = h.canAccess(c, d)
+	err = h.canAdministerGroup(ctx, d.GroupName, "unshare databases")
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	externalDownload, err := h.databaseService.CreateExternalDownload(ctx, d.ID, request.Expiration)
+	err = h.databaseService.Unshare(ctx, d, c.Param("group"))
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, externalDownload)
+	c.Status(http.StatusAccepted)
 }
 
-// ExternalDownload database
-func (h Handler) ExternalDownload(c *gin.Context) {
-	// swagger:route GET /databases/external/{uuid} externalDownloadDatabase
+// Download database
+func (h Handler) Download(c *gin.Context) {
+	// swagger:route GET /databases/{id}/download downloadDatabase
 	//
-	// Externally download database
+	// Download database
 	//
-	// Download a given database without authentication
+	// Download a database by its identifier. The identifier could be either the actual id of the database or the slug associated with it. Redirects to a presigned S3 URL if presigned URLs are enabled
+	//
+	// Security:
+	//	oauth2:
 	//
 	// Responses:
 	//	200: DownloadDatabaseResponse
+	//	401: Error
+	//	403: Error
 	//	404: Error
 	//	415: Error
-	uuidParam := c.Param("uuid")
-	if uuidParam == "" {
-		badRequest := errdef.NewBadRequest("error missing uuid")
-		_ = c.Error(badRequest)
-		return
-	}
-
-	id, err := uuid.Parse(uuidParam)
+	identifier := c.Param("id")
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindByIdentifier(ctx, identifier)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	ctx := c.Request.Context()
-	download, err := h.databaseService.FindExternalDownload(ctx, id)
+	err = h.canAccess(c, d)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	d, err := h.databaseService.FindById(ctx, download.DatabaseID)
-	if err != nil {
-		_ = c.Error(err)
+	// incremental filestores are assembled from their backups so they can't be downloaded from S3 directly
+	if h.databaseService.Presigns() && !d.Incremental {
+		h.redirectToPresignedUrl(c, d.Url, d.Checksum)
 		return
 	}
 
@@ -835,6 +900,7 @@ func (h Handler) ExternalDownload(c *gin.Context) {
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Type", "application/octet-stream")
+	setChecksumHeaders(c, d.Checksum)
 
 	err = h.databaseService.Download(ctx, d.ID, c.Writer, func(contentLength int64) {
 		c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
@@ -844,3 +910,1638 @@ func (h Handler) ExternalDownload(c *gin.Context) {
 		return
 	}
 }
+
+// ListFiles lists the files of the filestore of a database
+func (h Handler) ListFiles(c *gin.Context) {
+	// swagger:route GET /databases/{id}/files listDatabaseFiles
+	//
+	// List filestore files
+	//
+	// List the files and directories of a directory of the filestore of a database. The identifier could be either the id of the filestore or of the database it belongs to
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []DirEntry
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	entries, err := h.databaseService.ListFiles(ctx, d, c.Query("path"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// DownloadFile downloads a single file of the filestore of a database
+func (h Handler) DownloadFile(c *gin.Context) {
+	// swagger:route GET /databases/{id}/files/download downloadDatabaseFile
+	//
+	// Download filestore file
+	//
+	// Download a single file of the filestore of a database without downloading the whole filestore. The identifier could be either the id of the filestore or of the database it belongs to
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DownloadDatabaseResponse
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	filePath := c.Query("path")
+	if filePath == "" {
+		_ = c.Error(errdef.NewBadRequest("path is required"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	file, err := h.databaseService.FindFile(ctx, d, filePath)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	_, name := path.Split(file.Path)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", strconv.FormatInt(file.Size, 10))
+
+	err = h.databaseService.DownloadFile(ctx, *file, c.Writer)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+}
+
+// Delete database
+func (h Handler) Delete(c *gin.Context) {
+	// swagger:route DELETE /databases/{id} deleteDatabaseById
+	//
+	// Delete database
+	//
+	// Move the database and its filestore to the trash. Trashed databases can be restored by their
+	// owner or a group administrator until they're purged.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.databaseService.Trash(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// FindTrash finds the trashed databases
+func (h Handler) FindTrash(c *gin.Context) {
+	// swagger:route GET /databases/trash findDatabaseTrash
+	//
+	// Find trashed databases
+	//
+	// Find the trashed databases of the groups of the user grouped by group, most recently trashed
+	// first
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []GroupsWithDatabases
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	databases, err := h.databaseService.FindTrash(ctx, user)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, databases)
+}
+
+// Restore trashed database
+func (h Handler) Restore(c *gin.Context) {
+	// swagger:route POST /databases/trash/{id}/restore restoreDatabase
+	//
+	// Restore database
+	//
+	// Restore the database and its filestore from the trash. Only the owner of the database and group
+	// administrators can restore it. Restoring fails if their names have been used by other databases
+	// since they were trashed.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: Database
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	409: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindTrashedById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canRestore(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	restored, err := h.databaseService.Restore(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, restored)
+}
+
+// Purge trashed database
+func (h Handler) Purge(c *gin.Context) {
+	// swagger:route DELETE /databases/trash/{id} purgeDatabase
+	//
+	// Purge database
+	//
+	// Permanently delete the database and its filestore from the trash. Only the owner of the database
+	// and group administrators can purge it.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindTrashedById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canRestore(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.databaseService.Purge(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// swagger:model GroupsWithDatabases
+type GroupsWithDatabases struct {
+	Name      string           `json:"name"`
+	Databases []model.Database `json:"databases"`
+}
+
+// List databases
+func (h Handler) List(c *gin.Context) {
+	// swagger:route GET /databases listDatabases
+	//
+	// List databases
+	//
+	// List databases grouped by group. Databases can be searched and filtered using the query
+	// parameters. The total number of matching databases is returned in the X-Total-Count header.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []GroupsWithDatabases
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	filter, err := parseDatabaseFilter(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	databases, total, err := h.databaseService.List(ctx, user, filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.JSON(http.StatusOK, databases)
+}
+
+func parseDatabaseFilter(c *gin.Context) (DatabaseFilter, error) {
+	filter := DatabaseFilter{
+		Groups: c.QueryArray("group"),
+		Query:  c.Query("q"),
+		Type:   c.Query("type"),
+	}
+
+	var err error
+	if userId := c.Query("userId"); userId != "" {
+		id, err := strconv.ParseUint(userId, 10, 0)
+		if err != nil {
+			return filter, errdef.NewBadRequest("invalid userId %q", userId)
+		}
+		filter.UserID = uint(id)
+	}
+
+	filter.MinSize, err = parseInt64Query(c, "minSize")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.MaxSize, err = parseInt64Query(c, "maxSize")
+	if err != nil {
+		return filter, err
+	}
+
+	if locked := c.Query("locked"); locked != "" {
+		parsed, err := strconv.ParseBool(locked)
+		if err != nil {
+			return filter, errdef.NewBadRequest("invalid locked %q", locked)
+		}
+		filter.Locked = &parsed
+	}
+
+	filter.Labels, err = parseLabelSelectors(c.QueryArray("label"))
+	if err != nil {
+		return filter, err
+	}
+
+	page, err := parseInt64Query(c, "page")
+	if err != nil {
+		return filter, err
+	}
+	filter.Page = int(page)
+
+	pageSize, err := parseInt64Query(c, "pageSize")
+	if err != nil {
+		return filter, err
+	}
+	filter.PageSize = int(pageSize)
+
+	return filter, nil
+}
+
+func parseInt64Query(c *gin.Context, name string) (int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errdef.NewBadRequest("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+type UpdateDatabaseRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description" binding:"required"`
+	// Labels replace the labels of the database, omit to leave unchanged. Databases labelled keep
+	// are excluded from retention policies
+	Labels map[string]string `json:"labels"`
+}
+
+// Update database
+func (h Handler) Update(c *gin.Context) {
+	// swagger:route PUT /databases/{id} updateDatabaseById
+	//
+	// Update database
+	//
+	// Update database by id
+	// TODO: Race condition? If two clients request at the same time... Do we need a transaction between find and update
+	//
+	// Security:
+	//   oauth2:
+	//
+	// Responses:
+	//	200: Database
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	var request UpdateDatabaseRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if request.Labels != nil {
+		err := validateLabels(request.Labels)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		d.Labels = request.Labels
+	}
+
+	d.Name = request.Name
+	d.Description = request.Description
+
+	err = h.databaseService.Update(ctx, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// ListVersions lists the versions of a database
+func (h Handler) ListVersions(c *gin.Context) {
+	// swagger:route GET /databases/{id}/versions listDatabaseVersions
+	//
+	// List database versions
+	//
+	// List the versions of a database, newest first
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DatabaseVersions
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	versions, err := h.databaseService.ListVersions(ctx, d.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// DownloadVersion downloads a specific version of a database
+func (h Handler) DownloadVersion(c *gin.Context) {
+	// swagger:route GET /databases/{id}/versions/{version}/download downloadDatabaseVersion
+	//
+	// Download database version
+	//
+	// Download a specific version of a database. Redirects to a presigned S3 URL if presigned URLs are enabled
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DownloadDatabaseResponse
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := handler.GetPathParameter(c, "version")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	v, err := h.databaseService.FindVersion(ctx, d.ID, version)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if h.databaseService.Presigns() {
+		h.redirectToPresignedUrl(c, v.Url, v.Checksum)
+		return
+	}
+
+	_, file := path.Split(v.Url)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file}))
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Type", "application/octet-stream")
+	setChecksumHeaders(c, v.Checksum)
+
+	err = h.databaseService.DownloadVersion(ctx, d.ID, v.Version, c.Writer, func(contentLength int64) {
+		c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+}
+
+// PromoteVersion promotes a version of a database to be its current content
+func (h Handler) PromoteVersion(c *gin.Context) {
+	// swagger:route POST /databases/{id}/versions/{version}/promote promoteDatabaseVersion
+	//
+	// Promote database version
+	//
+	// Make a specific version the current content of the database
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: Database
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := handler.GetPathParameter(c, "version")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	d, err = h.databaseService.PromoteVersion(ctx, d.ID, version, user.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// Convert database
+func (h Handler) Convert(c *gin.Context) {
+	// swagger:route POST /databases/{id}/convert convertDatabase
+	//
+	// Convert database
+	//
+	// Create a new database holding the database converted into the given format. The conversion runs in the background
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202: Database
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		_ = c.Error(errdef.NewBadRequest("format query parameter is required"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	converted, err := h.databaseService.Convert(ctx, user.ID, d, format)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, converted)
+}
+
+// Verify database
+func (h Handler) Verify(c *gin.Context) {
+	// swagger:route POST /databases/{id}/verify verifyDatabase
+	//
+	// Verify database
+	//
+	// Verify the stored content of a database against its checksum. Databases without a checksum have the checksum of their current content recorded if the user can write the database. Databases larger than 1 GiB are verified in the background and the result is published as a database-verify event
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: VerifyResult
+	//	202:
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccess(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// only users who can write the database record its checksum, others are only reported the result
+	record := handler.CanWrite(user, d)
+
+	if d.Size > verifyInBackgroundSize {
+		h.databaseService.VerifyInBackground(ctx, user.ID, d, record)
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	result, err := h.databaseService.Verify(ctx, d, record)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ExtractMetadata extracts the metadata of a database
+func (h Handler) ExtractMetadata(c *gin.Context) {
+	// swagger:route POST /databases/{id}/metadata extractDatabaseMetadata
+	//
+	// Extract database metadata
+	//
+	// Extract the metadata of a database again, e.g. for databases stored before metadata was extracted automatically
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DatabaseMetadata
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	metadata, err := h.databaseService.ExtractMetadata(context.WithoutCancel(ctx), d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+// AnonymizationProfiles lists the anonymization profiles
+func (h Handler) AnonymizationProfiles(c *gin.Context) {
+	// swagger:route GET /databases/anonymization-profiles listAnonymizationProfiles
+	//
+	// List anonymization profiles
+	//
+	// List the anonymization profiles which can be applied when saving or copying a database
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []AnonymizationProfile
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	c.JSON(http.StatusOK, h.databaseService.AnonymizationProfiles())
+}
+
+type RetentionPolicyRequest struct {
+	// Databases not used to seed a deployment within this number of days are deleted
+	UnusedDays uint `json:"unusedDays" binding:"required,min=1"`
+	Enabled    bool `json:"enabled"`
+}
+
+// SaveRetentionPolicy creates or updates the retention policy of a group
+func (h Handler) SaveRetentionPolicy(c *gin.Context) {
+	// swagger:route PUT /databases/retention-policies/{group} saveRetentionPolicy
+	//
+	// Save retention policy
+	//
+	// Create or update the retention policy of a group. Only group administrators can manage retention policies
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: RetentionPolicy
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	groupName := c.Param("group")
+
+	var request RetentionPolicyRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.canAdministerGroup(ctx, groupName, "manage retention policies")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	policy := &model.RetentionPolicy{
+		GroupName:  groupName,
+		UnusedDays: request.UnusedDays,
+		Enabled:    request.Enabled,
+	}
+	err = h.databaseService.SaveRetentionPolicy(ctx, policy)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// FindRetentionPolicy finds the retention policy of a group
+func (h Handler) FindRetentionPolicy(c *gin.Context) {
+	// swagger:route GET /databases/retention-policies/{group} findRetentionPolicy
+	//
+	// Find retention policy
+	//
+	// Find the retention policy of a group
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: RetentionPolicy
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	groupName := c.Param("group")
+
+	ctx := c.Request.Context()
+	err := h.canAccessGroup(ctx, groupName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	policy, err := h.databaseService.FindRetentionPolicy(ctx, groupName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeleteRetentionPolicy deletes the retention policy of a group
+func (h Handler) DeleteRetentionPolicy(c *gin.Context) {
+	// swagger:route DELETE /databases/retention-policies/{group} deleteRetentionPolicy
+	//
+	// Delete retention policy
+	//
+	// Delete the retention policy of a group. Only group administrators can manage retention policies
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	groupName := c.Param("group")
+
+	ctx := c.Request.Context()
+	err := h.canAdministerGroup(ctx, groupName, "manage retention policies")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.databaseService.DeleteRetentionPolicy(ctx, groupName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// RetentionReport reports what the retention policy of a group would delete
+func (h Handler) RetentionReport(c *gin.Context) {
+	// swagger:route GET /databases/retention-policies/{group}/report retentionReport
+	//
+	// Retention report
+	//
+	// Dry-run the retention policy of a group and report which databases and filestores would be moved to the trash and how many bytes they take up. The bytes are freed once the databases are purged from the trash after the trash retention period
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: RetentionReport
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	groupName := c.Param("group")
+
+	ctx := c.Request.Context()
+	err := h.canAccessGroup(ctx, groupName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	report, err := h.databaseService.RetentionReport(ctx, groupName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h Handler) canAccessGroup(ctx context.Context, groupName string) error {
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !handler.CanAccessGroup(user, groupName) {
+		return errdef.NewForbidden("access denied")
+	}
+
+	return nil
+}
+
+func (h Handler) canAdministerGroup(ctx context.Context, groupName, action string) error {
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !handler.IsAdministrator(user) && !handler.IsGroupAdministrator(groupName, user.AdminGroups) {
+		return errdef.NewForbidden("only group administrators can %s", action)
+	}
+
+	return nil
+}
+
+func (h Handler) canAccess(c *gin.Context, d *model.Database) error {
+	user, err := handler.GetUserFromContext(c.Request.Context())
+	if err != nil {
+		_ = c.Error(err)
+		return err
+	}
+
+	can := handler.CanAccess(user, d)
+	if !can {
+		return errdef.NewForbidden("access denied")
+	}
+
+	return nil
+}
+
+func (h Handler) canWrite(c *gin.Context, d *model.Database) error {
+	user, err := handler.GetUserFromContext(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	if !handler.CanWrite(user, d) {
+		return errdef.NewForbidden("access denied")
+	}
+
+	return nil
+}
+
+func (h Handler) canRestore(c *gin.Context, d *model.Database) error {
+	user, err := handler.GetUserFromContext(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	if !handler.CanRestore(user, d) {
+		return errdef.NewForbidden("only the owner of the database and group administrators can restore or purge it")
+	}
+
+	return nil
+}
+
+// maxExternalDownloadExpirationSeconds is the maximum time a database can be downloaded without
+// authentication. 30 is simply chosen for the sake of having a reasonable default.
+const maxExternalDownloadExpirationSeconds uint = 30 * 24 * 60 * 60 // 30 days
+
+type CreateExternalDatabaseRequest struct {
+	// Expiration time in seconds
+	Expiration uint `json:"expiration" binding:"required"`
+	// MaxDownloads is the number of times the link can be used, omit to not limit the number of downloads
+	MaxDownloads uint `json:"maxDownloads"`
+}
+
+// redirectToPresignedUrl redirects the client to a presigned URL downloading the file directly from
+// S3. It returns false if the URL couldn't be presigned.
+func (h Handler) redirectToPresignedUrl(c *gin.Context, fileUrl, checksum string) bool {
+	presigned, err := h.databaseService.PresignDownload(c.Request.Context(), fileUrl)
+	if err != nil {
+		_ = c.Error(err)
+		return false
+	}
+
+	setChecksumHeaders(c, checksum)
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusTemporaryRedirect, presigned.Url)
+	return true
+}
+
+// setChecksumHeaders sets the Digest and ETag headers of a download if its checksum is known
+func setChecksumHeaders(c *gin.Context, checksum string) {
+	if checksum == "" {
+		return
+	}
+
+	digest, err := storage.DigestHeader(checksum)
+	if err != nil {
+		return
+	}
+
+	c.Header("Digest", digest)
+	c.Header("ETag", strconv.Quote(checksum))
+}
+
+// CreateExternalDownload database
+func (h Handler) CreateExternalDownload(c *gin.Context) {
+	// swagger:route POST /databases/{id}/external createExternalDownloadDatabase
+	//
+	// External download link
+	//
+	// Create link so the database can be downloaded without log in
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: CreateExternalDownloadResponse
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	var request CreateExternalDatabaseRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if request.Expiration > maxExternalDownloadExpirationSeconds {
+		_ = c.Error(errdef.NewBadRequest("expiration must not exceed 30 days (%d seconds)", maxExternalDownloadExpirationSeconds))
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	externalDownload, err := h.databaseService.CreateExternalDownload(ctx, user.ID, d.ID, request.Expiration, request.MaxDownloads)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, externalDownload)
+}
+
+// ExternalDownload database
+func (h Handler) ExternalDownload(c *gin.Context) {
+	// swagger:route GET /databases/external/{uuid} externalDownloadDatabase
+	//
+	// Externally download database
+	//
+	// Download a given database without authentication. Redirects to a presigned S3 URL if presigned URLs are enabled
+	//
+	// Responses:
+	//	200: DownloadDatabaseResponse
+	//	404: Error
+	//	415: Error
+	uuidParam := c.Param("uuid")
+	if uuidParam == "" {
+		badRequest := errdef.NewBadRequest("error missing uuid")
+		_ = c.Error(badRequest)
+		return
+	}
+
+	id, err := uuid.Parse(uuidParam)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	download, err := h.databaseService.ClaimExternalDownload(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	// downloads count against the maximum number of downloads once content has been sent, so a client
+	// can't download nearly all of the file again and again by disconnecting before the end
+	served := false
+	defer func() {
+		if !served {
+			h.databaseService.UnclaimExternalDownload(ctx, download.UUID)
+		}
+	}()
+
+	d, err := h.databaseService.FindById(ctx, download.DatabaseID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	fileUrl, checksum := d.Url, d.Checksum
+	if download.Version != 0 {
+		v, err := h.databaseService.FindVersion(ctx, d.ID, download.Version)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		fileUrl, checksum = v.Url, v.Checksum
+	}
+
+	access := &model.ExternalDownloadAccess{
+		DatabaseID:   d.ID,
+		DownloadUUID: download.UUID,
+		Version:      download.Version,
+		ClientIP:     c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+
+	// Presigned URLs can be reused until they expire so downloads with a maximum are served directly
+	incremental := download.Version == 0 && d.Incremental
+	if h.databaseService.Presigns() && !incremental && download.MaxDownloads == 0 {
+		access.Presigned = true
+		served = h.redirectToPresignedUrl(c, fileUrl, checksum)
+		if !served {
+			access.Error = "failed to presign download"
+		}
+		h.databaseService.RecordExternalDownloadAccess(ctx, access)
+		return
+	}
+
+	_, file := path.Split(fileUrl)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file}))
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Type", "application/octet-stream")
+	setChecksumHeaders(c, checksum)
+
+	setContentLength := func(contentLength int64) {
+		c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+	if download.Version != 0 {
+		err = h.databaseService.DownloadVersion(ctx, d.ID, download.Version, c.Writer, setContentLength)
+	} else {
+		err = h.databaseService.Download(ctx, d.ID, c.Writer, setContentLength)
+	}
+
+	access.BytesSent = int64(max(c.Writer.Size(), 0))
+	served = err == nil || access.BytesSent > 0
+	if err != nil {
+		access.Error = err.Error()
+	}
+	h.databaseService.RecordExternalDownloadAccess(ctx, access)
+
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+}
+
+// FindExternalDownloads database
+func (h Handler) FindExternalDownloads(c *gin.Context) {
+	// swagger:route GET /databases/{id}/external findExternalDownloads
+	//
+	// Find external download links
+	//
+	// Find the external download links of a database which can still be used
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []ExternalDownload
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	downloads, err := h.databaseService.FindExternalDownloads(ctx, d.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, downloads)
+}
+
+// RevokeExternalDownload database
+func (h Handler) RevokeExternalDownload(c *gin.Context) {
+	// swagger:route DELETE /databases/{id}/external/{uuid} revokeExternalDownload
+	//
+	// Revoke external download link
+	//
+	// Revoke an external download link so it can no longer be used
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202:
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	downloadId, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		_ = c.Error(errdef.NewBadRequest("invalid uuid: %v", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.databaseService.RevokeExternalDownload(ctx, d.ID, downloadId)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// FindExternalDownloadAccesses database
+func (h Handler) FindExternalDownloadAccesses(c *gin.Context) {
+	// swagger:route GET /databases/{id}/external/accesses findExternalDownloadAccesses
+	//
+	// Find external download accesses
+	//
+	// Find the downloads of a database through external download links, newest first
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: []ExternalDownloadAccess
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	d, err := h.databaseService.FindById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canWrite(c, d)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	accesses, err := h.databaseService.FindExternalDownloadAccesses(ctx, d.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, accesses)
+}
+
+type CreateUploadRequest struct {
+	Group       string `json:"group" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// CreateUpload creates a resumable database upload
+func (h Handler) CreateUpload(c *gin.Context) {
+	// swagger:route POST /databases/uploads createDatabaseUpload
+	//
+	// Create database upload
+	//
+	// Create a resumable upload of a database. The database is created right away and its content is uploaded in chunks
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	201: DatabaseUpload
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	409: Error
+	//	415: Error
+	var request CreateUploadRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.canAccessGroup(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	group, err := h.groupService.Find(ctx, request.Group)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	name := strings.Trim(strings.TrimSpace(request.Name), "/")
+	upload, err := h.databaseService.CreateUpload(ctx, user.ID, group.Name, name, strings.TrimSpace(request.Description))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
+}
+
+// FindUpload finds a database upload
+func (h Handler) FindUpload(c *gin.Context) {
+	// swagger:route GET /databases/uploads/{uploadId} findDatabaseUpload
+	//
+	// Find database upload
+	//
+	// Find a database upload including the chunks which have been received
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DatabaseUpload
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	upload, ok := h.findUpload(c, h.canAccess)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+// UploadChunk uploads a chunk of a database upload
+func (h Handler) UploadChunk(c *gin.Context) {
+	// swagger:route PUT /databases/uploads/{uploadId}/chunks/{number} uploadDatabaseChunk
+	//
+	// Upload database chunk
+	//
+	// Upload a chunk of a database upload. Chunks are numbered from 1 and all but the last chunk must be at least 5 MiB and at most 100 MiB. Uploading a chunk again replaces it
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: DatabaseUploadPart
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	number, ok := handler.GetPathParameter(c, "number")
+	if !ok {
+		return
+	}
+
+	upload, ok := h.findUpload(c, h.canWrite)
+	if !ok {
+		return
+	}
+
+	part, err := h.databaseService.UploadChunk(c.Request.Context(), upload, number, c.Request.Body)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, part)
+}
+
+// PresignUploadChunk presigns the upload of a database chunk
+func (h Handler) PresignUploadChunk(c *gin.Context) {
+	// swagger:route POST /databases/uploads/{uploadId}/chunks/{number}/url presignDatabaseChunkUpload
+	//
+	// Presign database chunk upload
+	//
+	// Create a short-lived URL uploading a chunk of the given size directly to S3 using a PUT request. S3 rejects chunks of any other size. Chunks uploaded this way are picked up when the upload is completed. Only available if presigned URLs are enabled
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	200: PresignedUrl
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	number, ok := handler.GetPathParameter(c, "number")
+	if !ok {
+		return
+	}
+
+	size, err := parseInt64Query(c, "size")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	upload, ok := h.findUpload(c, h.canWrite)
+	if !ok {
+		return
+	}
+
+	presigned, err := h.databaseService.PresignUploadChunk(c.Request.Context(), upload, number, size)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, presigned)
+}
+
+// CompleteUpload completes a database upload
+func (h Handler) CompleteUpload(c *gin.Context) {
+	// swagger:route POST /databases/uploads/{uploadId}/complete completeDatabaseUpload
+	//
+	// Complete database upload
+	//
+	// Assemble the uploaded chunks into the database
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	201: Database
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	upload, ok := h.findUpload(c, h.canWrite)
+	if !ok {
+		return
+	}
+
+	d, err := h.databaseService.CompleteUpload(c.Request.Context(), upload)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, d)
+}
+
+// AbortUpload aborts a database upload
+func (h Handler) AbortUpload(c *gin.Context) {
+	// swagger:route DELETE /databases/uploads/{uploadId} abortDatabaseUpload
+	//
+	// Abort database upload
+	//
+	// Abort a database upload and delete the database created along with it
+	//
+	// Security:
+	//	oauth2:
+	//
+	// Responses:
+	//	202:
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	upload, ok := h.findUpload(c, h.canWrite)
+	if !ok {
+		return
+	}
+
+	err := h.databaseService.AbortUpload(c.Request.Context(), upload)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// findUpload finds the upload given by the uploadId path parameter and ensures the user is authorized
+// to its database. Uploads are only modified by users who can write their database.
+func (h Handler) findUpload(c *gin.Context, authorize func(c *gin.Context, d *model.Database) error) (*model.DatabaseUpload, bool) {
+	id, err := uuid.Parse(c.Param("uploadId"))
+	if err != nil {
+		_ = c.Error(errdef.NewBadRequest("invalid upload id: %v", err))
+		return nil, false
+	}
+
+	upload, err := h.databaseService.FindUpload(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return nil, false
+	}
+
+	err = authorize(c, upload.Database)
+	if err != nil {
+		_ = c.Error(err)
+		return nil, false
+	}
+
+	return upload, true
+}
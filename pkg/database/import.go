@@ -0,0 +1,286 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+)
+
+// ImportConfig restricts which databases can be imported from remote URLs
+type ImportConfig struct {
+	// AllowedSchemes are the URL schemes databases can be imported from
+	AllowedSchemes []string
+	// MaxSize is the maximum size in bytes of an imported database, 0 doesn't limit the size
+	MaxSize int64
+}
+
+// ParseImportConfig parses a comma separated list of allowed URL schemes, e.g. "https,http"
+func ParseImportConfig(allowedSchemes string, maxSize uint) ImportConfig {
+	var schemes []string
+	for _, scheme := range strings.Split(allowedSchemes, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	return ImportConfig{
+		AllowedSchemes: schemes,
+		MaxSize:        int64(maxSize),
+	}
+}
+
+// Import creates a database which is fetched from the given URL. The record is returned right away
+// while the database is fetched into S3 in the background. If a checksum is given the import fails
+// unless the SHA-256 checksum of the fetched database matches.
+func (s Service) Import(ctx context.Context, userId uint, groupName, name, description, source, checksum string) (*model.Database, error) {
+	u, err := s.validateImportUrl(source)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if checksum != "" {
+		decoded, err := hex.DecodeString(checksum)
+		if err != nil || len(decoded) != 32 {
+			return nil, errdef.NewBadRequest("checksum must be a hex encoded SHA-256 checksum")
+		}
+	}
+
+	usedBefore, err := s.CheckStorageQuota(ctx, groupName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &model.Database{
+		Name:        name,
+		Description: description,
+		GroupName:   groupName,
+		Type:        "database",
+		UserID:      userId,
+	}
+	err = s.repository.Save(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detach from the request context so the import isn't cancelled when the HTTP response is sent.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		err := s.fetch(ctx, userId, d, u, checksum)
+		if err != nil {
+			if err := s.Delete(ctx, d.ID); err != nil {
+				s.logger.ErrorContext(ctx, "failed to delete database of failed import", "databaseId", d.ID, "error", err)
+			}
+			return
+		}
+		s.NotifyStorageQuota(ctx, userId, groupName, usedBefore)
+	}()
+
+	return d, nil
+}
+
+func (s Service) validateImportUrl(source string) (*url.URL, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, errdef.NewBadRequest("invalid url: %v", err)
+	}
+
+	if !slices.Contains(s.importConfig.AllowedSchemes, strings.ToLower(u.Scheme)) {
+		return nil, errdef.NewBadRequest("url scheme %q isn't allowed, allowed schemes are: %s", u.Scheme, strings.Join(s.importConfig.AllowedSchemes, ", "))
+	}
+
+	if u.Host == "" {
+		return nil, errdef.NewBadRequest("url %q has no host", source)
+	}
+
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && isInternalAddr(ip) {
+		return nil, errdef.NewBadRequest("url %q points to an internal address", source)
+	}
+
+	return u, nil
+}
+
+// newImportClient creates the HTTP client fetching imported databases. Connections to internal
+// addresses are refused once the host is resolved so DNS can't be used to reach them. Redirects are
+// validated like the imported URL. Proxies aren't used since the proxy would connect on our behalf.
+func (s Service) newImportClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if isInternalAddr(addrPort.Addr()) {
+				return fmt.Errorf("connecting to internal address %s isn't allowed", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: time.Minute,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			_, err := s.validateImportUrl(request.URL.String())
+			return err
+		},
+	}
+}
+
+// internalPrefixes are the internal ranges not covered by the predicates of netip.Addr: "this
+// network" and the shared address space of carrier-grade NAT, which some cluster networks use for
+// pods and services.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// isInternalAddr reports whether the address is internal to the cluster or host. Link-local
+// addresses include the 169.254.169.254 metadata endpoint of cloud providers. IPv4-mapped IPv6
+// addresses are checked as the IPv4 address they map to.
+func isInternalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return true
+	}
+
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch streams the database at the given URL into S3 and publishes database-import events along the
+// way
+func (s Service) fetch(ctx context.Context, userId uint, d *model.Database, u *url.URL, checksum string) error {
+	publish := func(status, errMsg string, size int64) {
+		s.publisher.Publish(ctx, userId, d.GroupName, kindDatabaseImport, newDatabaseEvent(d, status, errMsg, size))
+	}
+	fail := func(err error) error {
+		s.logger.ErrorContext(ctx, "Database import failed", "databaseId", d.ID, "url", u.Redacted(), "error", err)
+		publish("error", err.Error(), 0)
+		return err
+	}
+
+	publish("started", "", 0)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fail(fmt.Errorf("failed to fetch database: %v", err))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("failed to fetch database: %s", response.Status))
+	}
+
+	maxSize := s.importConfig.MaxSize
+	if maxSize > 0 && response.ContentLength > maxSize {
+		return fail(fmt.Errorf("database of %d bytes exceeds the maximum size of %d bytes", response.ContentLength, maxSize))
+	}
+
+	var body io.Reader = response.Body
+	if maxSize > 0 {
+		body = &maxSizeReader{r: body, remaining: maxSize}
+	}
+	progress := storage.NewProgressTracker(response.ContentLength, progressInterval, func(progress storage.Progress) {
+		s.publisher.Publish(ctx, userId, d.GroupName, kindDatabaseImport, newDatabaseProgressEvent(d, progress))
+	})
+	body = progress.Reader(body)
+
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(formatHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fail(err)
+	}
+	format := detectFormat(header)
+	if format == "" {
+		return fail(errors.New("unrecognised database format, expected a gzipped SQL dump, a pg_dump custom format archive or a tar of a directory format dump"))
+	}
+
+	checksummed := storage.NewChecksumReader(buffered)
+	key := fmt.Sprintf("%s/%s", d.GroupName, d.Name)
+	size, err := s.objectStore.StreamUpload(ctx, s.s3Bucket, key, "application/octet-stream", checksummed)
+	if err != nil {
+		return fail(err)
+	}
+
+	saved, err := s.repository.FindById(ctx, d.ID)
+	if err != nil {
+		return fail(err)
+	}
+	saved.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
+	saved.Size = size
+	saved.Format = format
+	saved.Checksum = checksummed.Checksum()
+
+	if checksum != "" && checksum != saved.Checksum {
+		// Save the url so the object is deleted along with the database
+		if err := s.repository.Update(ctx, saved); err != nil {
+			return fail(err)
+		}
+		return fail(fmt.Errorf("checksum mismatch, expected %s but got %s", checksum, saved.Checksum))
+	}
+
+	err = s.repository.Update(ctx, saved)
+	if err != nil {
+		return fail(err)
+	}
+
+	s.logger.InfoContext(ctx, "Database imported", "databaseId", saved.ID, "url", u.Redacted(), "size", size)
+	publish("success", "", size)
+
+	s.extractMetadataAsync(ctx, saved, nil)
+
+	return nil
+}
+
+// maxSizeReader fails reading once more than the remaining number of bytes have been read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n, errors.New("database exceeds the maximum size")
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportConfig(t *testing.T) {
+	config := ParseImportConfig(" HTTPS, http,,", 1024)
+
+	assert.Equal(t, []string{"https", "http"}, config.AllowedSchemes)
+	assert.Equal(t, int64(1024), config.MaxSize)
+}
+
+func TestValidateImportUrl(t *testing.T) {
+	s := Service{importConfig: ParseImportConfig("https", 0)}
+
+	t.Run("Allowed", func(t *testing.T) {
+		u, err := s.validateImportUrl("HTTPS://databases.dhis2.org/sierra-leone/2.41/dhis2-db-sierra-leone.sql.gz")
+
+		require.NoError(t, err)
+		assert.Equal(t, "databases.dhis2.org", u.Host)
+	})
+
+	t.Run("SchemeNotAllowed", func(t *testing.T) {
+		_, err := s.validateImportUrl("file:///etc/passwd")
+
+		assert.ErrorContains(t, err, `url scheme "file" isn't allowed`)
+	})
+
+	t.Run("NoHost", func(t *testing.T) {
+		_, err := s.validateImportUrl("https:///dump.sql.gz")
+
+		assert.ErrorContains(t, err, "has no host")
+	})
+
+	t.Run("InternalAddress", func(t *testing.T) {
+		for _, source := range []string{"https://127.0.0.1/dump.sql.gz", "https://169.254.169.254/latest/meta-data", "https://10.0.0.1/dump.sql.gz", "https://[::1]/dump.sql.gz"} {
+			_, err := s.validateImportUrl(source)
+
+			assert.ErrorContains(t, err, "points to an internal address", source)
+		}
+	})
+}
+
+func TestIsInternalAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":              true,
+		"10.0.0.1":               true,
+		"172.16.0.1":             true,
+		"192.168.1.1":            true,
+		"169.254.169.254":        true,
+		"0.0.0.0":                true,
+		"0.1.2.3":                true,
+		"100.64.0.1":             true,
+		"100.127.255.254":        true,
+		"224.0.0.1":              true,
+		"::":                     true,
+		"::1":                    true,
+		"fe80::1":                true,
+		"fd00::1":                true,
+		"::ffff:127.0.0.1":       true,
+		"::ffff:10.0.0.1":        true,
+		"::ffff:169.254.169.254": true,
+		"::ffff:100.64.0.1":      true,
+		"8.8.8.8":                false,
+		"100.63.255.255":         false,
+		"100.128.0.1":            false,
+		"::ffff:8.8.8.8":         false,
+		"2001:4860:4860::8888":   false,
+	}
+
+	for addr, internal := range tests {
+		t.Run(addr, func(t *testing.T) {
+			assert.Equal(t, internal, isInternalAddr(netip.MustParseAddr(addr)))
+		})
+	}
+}
+
+func TestImportClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	s := Service{importConfig: ParseImportConfig("http", 0)}
+
+	t.Run("RefusesInternalAddress", func(t *testing.T) {
+		// the host resolves to the loopback address of the test server
+		u := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+		_, err := s.newImportClient().Get(u)
+
+		assert.ErrorContains(t, err, "internal address")
+	})
+
+	t.Run("ValidatesRedirects", func(t *testing.T) {
+		client := s.newImportClient()
+		request := httptest.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+
+		err := client.CheckRedirect(request, nil)
+
+		assert.ErrorContains(t, err, `url scheme "file" isn't allowed`)
+	})
+}
+
+func TestMaxSizeReader(t *testing.T) {
+	t.Run("WithinLimit", func(t *testing.T) {
+		r := &maxSizeReader{r: strings.NewReader("12345"), remaining: 5}
+
+		data, err := io.ReadAll(r)
+
+		require.NoError(t, err)
+		assert.Equal(t, "12345", string(data))
+	})
+
+	t.Run("ExceedsLimit", func(t *testing.T) {
+		r := &maxSizeReader{r: strings.NewReader("123456"), remaining: 5}
+
+		_, err := io.ReadAll(r)
+
+		assert.ErrorContains(t, err, "exceeds the maximum size")
+	})
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// lockTTL is how long a lock is held without being renewed. Locks of running instances are renewed by
+// the inspector which runs every couple of minutes, locks of other instances expire and can be taken
+// over.
+const lockTTL = 15 * time.Minute
+
+// RenewLocks extends the locks held by the given instances, it's the heartbeat of running instances.
+// Locks of instances which aren't given are left to expire.
+func (s Service) RenewLocks(ctx context.Context, instanceIds []uint) error {
+	if len(instanceIds) == 0 {
+		return nil
+	}
+
+	return s.repository.RenewLocks(ctx, instanceIds, time.Now().Add(lockTTL))
+}
+
+// ReleaseLock releases the lock of the database if it's held by the given instance. Locks held by
+// others are left alone.
+func (s Service) ReleaseLock(ctx context.Context, databaseId, instanceId uint) error {
+	return s.repository.ReleaseLock(ctx, databaseId, instanceId)
+}
+
+// ReleaseExpiredLocks releases the expired locks held by instances which no longer exist
+func (s Service) ReleaseExpiredLocks(ctx context.Context) error {
+	locks, err := s.repository.DeleteExpiredLocks(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, lock := range locks {
+		s.logger.InfoContext(ctx, "Released expired lock", "databaseId", lock.DatabaseID, "instanceId", lock.InstanceID, "expiresAt", lock.ExpiresAt)
+	}
+
+	return nil
+}
+
+func NewLockReleaser(logger *slog.Logger, service *Service, interval time.Duration) lockReleaser {
+	return lockReleaser{logger, service, interval}
+}
+
+type lockReleaser struct {
+	logger   *slog.Logger
+	service  *Service
+	interval time.Duration
+}
+
+// Release periodically releases expired locks.
+func (r lockReleaser) Release(ctx context.Context) {
+	for {
+		time.Sleep(r.interval)
+
+		err := r.service.ReleaseExpiredLocks(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "Failed to release expired locks", "error", err)
+		}
+	}
+}
+
+// ForceUnlock releases the lock of the database regardless of who holds it. The reason is recorded
+// and the group is notified.
+func (s Service) ForceUnlock(ctx context.Context, userId uint, d *model.Database, reason string) (*model.ForcedUnlock, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errdef.NewBadRequest("a reason is required to force unlock a database")
+	}
+
+	if d.Lock == nil {
+		return nil, errdef.NewNotFound("lock not found by database id: %d", d.ID)
+	}
+
+	forcedUnlock := &model.ForcedUnlock{
+		DatabaseID: d.ID,
+		InstanceID: d.Lock.InstanceID,
+		LockUserID: d.Lock.UserID,
+		UserID:     userId,
+		Reason:     reason,
+	}
+	err := s.repository.ForceUnlock(ctx, forcedUnlock)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Database lock forcefully released", "databaseId", d.ID, "instanceId", d.Lock.InstanceID, "lockUserId", d.Lock.UserID, "reason", reason)
+	s.publisher.Publish(ctx, userId, d.GroupName, kindDatabaseForceUnlock, forcedUnlock)
+
+	return forcedUnlock, nil
+}
+
+func (s Service) FindForcedUnlocks(ctx context.Context, databaseId uint) ([]model.ForcedUnlock, error) {
+	return s.repository.FindForcedUnlocks(ctx, databaseId)
+}
@@ -0,0 +1,401 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	pg "github.com/habx/pg-commands"
+)
+
+// ExtractMetadata extracts the metadata of a stored database and records it. The metadata is read
+// by a job from the table of contents of custom and directory format dumps and from the SQL of plain
+// dumps. If the metadata can't be extracted the error is recorded along with the metadata. The row
+// estimates recorded when the database was saved from an instance are kept.
+func (s Service) ExtractMetadata(ctx context.Context, d *model.Database) (*model.DatabaseMetadata, error) {
+	return s.saveMetadata(ctx, d, recordedRowEstimates(d.Metadata))
+}
+
+// saveMetadata extracts and records the metadata of the database. The given row estimates are
+// recorded for the tables whose data has been dumped.
+func (s Service) saveMetadata(ctx context.Context, d *model.Database, rowEstimates map[string]int64) (*model.DatabaseMetadata, error) {
+	if d.Type != "database" {
+		return nil, errdef.NewBadRequest("metadata can only be extracted from databases, not %q", d.Type)
+	}
+
+	if d.Url == "" {
+		return nil, errdef.NewBadRequest("database with id %d doesn't reference any url", d.ID)
+	}
+
+	metadata, err := s.extractMetadata(ctx, d)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to extract database metadata", "databaseId", d.ID, "error", err)
+		metadata = &model.DatabaseMetadata{Error: err.Error()}
+	}
+	metadata.DatabaseID = d.ID
+	metadata.Format = getFormat(d)
+	addRowEstimates(metadata, rowEstimates)
+
+	err = s.repository.SaveMetadata(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// recordMetadata extracts the metadata of the database. Failures are only logged since the database
+// itself has been stored.
+func (s Service) recordMetadata(ctx context.Context, d *model.Database, rowEstimates map[string]int64) {
+	_, err := s.saveMetadata(ctx, d, rowEstimates)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record database metadata", "databaseId", d.ID, "error", err)
+	}
+}
+
+// extractMetadataAsync extracts the metadata of the database in the background. The row estimates
+// are only known if the database has just been saved from an instance, they're nil otherwise.
+func (s Service) extractMetadataAsync(ctx context.Context, d *model.Database, rowEstimates map[string]int64) {
+	// Detach from the request context so the extraction isn't cancelled when the HTTP response is
+	// sent.
+	ctx = context.WithoutCancel(ctx)
+	go s.recordMetadata(ctx, d, rowEstimates)
+}
+
+// extractMetadata runs a job reading the metadata of the dump. The job fetches the dump from a
+// presigned URL if presigned URLs are enabled. Otherwise the dump is streamed to the job. Either way
+// the job stops reading custom format dumps once it has read the table of contents and the data of
+// the flyway history, so most of the dump is never transferred.
+func (s Service) extractMetadata(ctx context.Context, d *model.Database) (*model.DatabaseMetadata, error) {
+	group, err := s.groupService.Find(ctx, d.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	podExecutor, err := s.podExecutor(group.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	key := objectKey(d.Url)
+	var presignedUrl string
+	var stdin io.Reader
+	if s.presigner != nil {
+		presignedUrl, err = s.presigner.PresignGet(ctx, s.s3Bucket, key, path.Base(key))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dumpReader, dumpWriter := io.Pipe()
+		go func() {
+			err := s.objectStore.Download(ctx, s.s3Bucket, key, dumpWriter, func(int64) {})
+			dumpWriter.CloseWithError(err)
+		}()
+		// Stops the download once the job is done reading
+		defer dumpReader.Close()
+		stdin = dumpReader
+	}
+
+	metadataReader, metadataWriter := io.Pipe()
+	var stderr strings.Builder
+	go func() {
+		err := podExecutor.ExecJob(ctx, group.Namespace, s.jobImage, metadataCommand(getFormat(d), presignedUrl), stdin, metadataWriter, &stderr)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", err, stderr.String())
+		}
+		metadataWriter.CloseWithError(err)
+	}()
+	defer metadataReader.Close()
+
+	return parseDumpMetadata(metadataReader)
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`^CREATE (?:UNLOGGED )?TABLE (\S+) \($`)
+	copyPattern        = regexp.MustCompile(`^COPY (\S+) \((.*)\) FROM stdin;$`)
+	// tocTablePattern and tocDataPattern match the entries of tables and their data in the table of
+	// contents listed by pg_restore --list
+	tocTablePattern = regexp.MustCompile(`^\d+; \d+ \d+ TABLE (\S+) (\S+) \S+$`)
+	tocDataPattern  = regexp.MustCompile(`^\d+; \d+ \d+ TABLE DATA (\S+) (\S+) \S+$`)
+	// tocVersionPattern matches the versions in the header of the table of contents
+	tocVersionPattern = regexp.MustCompile(`^;\s+Dumped (from database|by pg_dump) version: (.*)$`)
+)
+
+// maxMetadataLineLength is the number of leading bytes of each line which are parsed. Only the data
+// rows of the flyway history are parsed, so longer lines are truncated to keep the memory bounded.
+const maxMetadataLineLength = 64 * 1024
+
+// parseDumpMetadata parses the metadata of a database from the output of the job run by
+// metadataCommand. It's either the table of contents listed by pg_restore --list followed by the SQL
+// of the data of the flyway history or the SQL of a plain dump stripped of all data but the flyway
+// history. Tables without data are reported as excluded.
+func parseDumpMetadata(r io.Reader) (*model.DatabaseMetadata, error) {
+	reader := bufio.NewReaderSize(r, maxMetadataLineLength)
+	metadata := &model.DatabaseMetadata{}
+
+	var created []string
+	dumped := map[string]bool{}
+
+	var (
+		copying     string
+		flyway      bool
+		versionCol  int
+		successCol  int
+		rankCol     int
+		highestRank string
+	)
+
+	for {
+		line, err := readLine(reader)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+
+		if copying != "" {
+			if bytes.Equal(line, []byte(`\.`)) {
+				copying = ""
+				flyway = false
+			} else if flyway {
+				rank, version, ok := flywayRow(string(line), rankCol, versionCol, successCol)
+				if ok && compareRank(rank, highestRank) > 0 {
+					highestRank = rank
+					metadata.SchemaVersion = version
+				}
+			}
+		} else if after, ok := bytes.CutPrefix(line, []byte("-- Dumped from database version ")); ok {
+			metadata.PostgresVersion = string(after)
+		} else if after, ok := bytes.CutPrefix(line, []byte("-- Dumped by pg_dump version ")); ok {
+			metadata.PgDumpVersion = string(after)
+		} else if match := tocVersionPattern.FindSubmatch(line); match != nil {
+			if string(match[1]) == "from database" {
+				metadata.PostgresVersion = string(match[2])
+			} else {
+				metadata.PgDumpVersion = string(match[2])
+			}
+		} else if match := tocDataPattern.FindSubmatch(line); match != nil {
+			dumped[string(match[1])+"."+string(match[2])] = true
+		} else if match := tocTablePattern.FindSubmatch(line); match != nil {
+			created = append(created, string(match[1])+"."+string(match[2]))
+		} else if match := createTablePattern.FindSubmatch(line); match != nil {
+			created = append(created, string(match[1]))
+		} else if match := copyPattern.FindSubmatch(line); match != nil {
+			copying = string(match[1])
+			dumped[copying] = true
+			if unqualified(copying) == "flyway_schema_history" {
+				columns := strings.Split(string(match[2]), ", ")
+				rankCol = slices.Index(columns, "installed_rank")
+				versionCol = slices.Index(columns, "version")
+				successCol = slices.Index(columns, "success")
+				flyway = rankCol >= 0 && versionCol >= 0 && successCol >= 0
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	if copying != "" {
+		return nil, fmt.Errorf("dump ended while reading the data of %s", copying)
+	}
+
+	metadata.DHIS2Version = dhis2Version(metadata.SchemaVersion)
+
+	metadata.Tables = []model.TableMetadata{}
+	metadata.ExcludedTables = []string{}
+	for _, table := range created {
+		if !dumped[table] {
+			metadata.ExcludedTables = append(metadata.ExcludedTables, table)
+		}
+		metadata.Tables = append(metadata.Tables, model.TableMetadata{Name: table})
+	}
+	slices.SortFunc(metadata.Tables, func(a, b model.TableMetadata) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.Sort(metadata.ExcludedTables)
+
+	return metadata, nil
+}
+
+// readLine reads a line without its line ending. Only the first maxMetadataLineLength bytes of the
+// line are returned.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		line = bytes.Clone(line)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = r.ReadSlice('\n')
+		}
+	}
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+func flywayRow(line string, rankCol, versionCol, successCol int) (string, string, bool) {
+	values := strings.Split(line, "\t")
+	if len(values) <= max(rankCol, versionCol, successCol) {
+		return "", "", false
+	}
+
+	version := values[versionCol]
+	if values[successCol] != "t" || version == `\N` {
+		return "", "", false
+	}
+
+	return values[rankCol], version, true
+}
+
+// compareRank compares two installed ranks of the flyway history
+func compareRank(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func unqualified(table string) string {
+	_, name, ok := strings.Cut(table, ".")
+	if !ok {
+		return table
+	}
+	return name
+}
+
+// dhis2Version returns the major DHIS2 version of a flyway schema version, e.g. 2.41 for 2.41.23
+func dhis2Version(schemaVersion string) string {
+	parts := strings.Split(schemaVersion, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// metadataScript prints what parseDumpMetadata needs of the dump. The dump is fetched from the URL
+// given as first argument or read from stdin if no URL is given.
+//
+// Custom format dumps are streamed to two pg_restore commands at once, one listing the table of
+// contents and one restoring the data of the flyway history. pg_restore stops reading once it's done
+// which stops the download. Directory format dumps are extracted first since the order of the files in
+// the archive is unknown. The SQL of plain dumps is stripped of all data but the flyway history.
+const metadataScript = `set -euo pipefail
+url=${1:-}
+format=$2
+
+dump() {
+  if [[ -n "$url" ]]; then
+    curl --fail --silent --show-error "$url"
+  else
+    cat
+  fi
+}
+
+flyway() {
+  pg_restore --data-only --table=flyway_schema_history --file=- "$@"
+}
+
+if [[ "$format" == "custom" ]]; then
+  tmp=$(mktemp -d)
+  mkfifo "$tmp/dump"
+  pg_restore --list <"$tmp/dump" >"$tmp/list" &
+  listing=$!
+  # tee and the download are stopped by a broken pipe once the flyway history has been restored
+  set +o pipefail
+  dump | tee --output-error=warn-nopipe "$tmp/dump" | flyway >"$tmp/flyway"
+  set -o pipefail
+  wait "$listing"
+  cat "$tmp/list" "$tmp/flyway"
+elif [[ "$format" == "directory" ]]; then
+  tmp=$(mktemp -d)
+  dump | tar -x -f - -C "$tmp"
+  pg_restore --list "$tmp"
+  flyway "$tmp"
+else
+  dump | gunzip --stdout | awk '
+    copying && $0 == "\\." { print; copying = 0; next }
+    copying { if (flyway) print; next }
+    /^-- Dumped (from database|by pg_dump) version / || /^CREATE (UNLOGGED )?TABLE / { print; next }
+    /^COPY / { print; copying = 1; flyway = ($2 ~ /(^|\.)flyway_schema_history$/) }
+  '
+fi`
+
+// metadataCommand returns the command of the job extracting the metadata of a dump of the given format
+// fetched from the given URL or read from stdin if the URL is empty
+func metadataCommand(format, url string) []string {
+	return []string{"bash", "-c", metadataScript, "bash", url, format}
+}
+
+// rowEstimatesQuery lists the number of rows of the tables as estimated by PostgreSQL from its
+// statistics, which is cheap compared to counting them. Tables which have never been analyzed have no
+// estimate.
+const rowEstimatesQuery = `SELECT n.nspname || '.' || c.relname, c.reltuples::bigint FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.relkind IN ('r', 'p') AND c.reltuples >= 0 AND n.nspname NOT IN ('pg_catalog', 'information_schema')`
+
+func rowEstimatesCommand(dump *pg.Dump) []string {
+	command := []string{"env", "PGPASSWORD=" + dump.Password, "psql", "--no-psqlrc", "--tuples-only", "--no-align", "--field-separator=\t", "--command=" + rowEstimatesQuery}
+	return append(command, dump.Postgres.Parse()...)
+}
+
+// estimateRows queries the row estimates of the tables of the instance's database
+func estimateRows(ctx context.Context, executor PodExecutor, namespace, podName string, dump *pg.Dump) (map[string]int64, error) {
+	var stdout, stderr bytes.Buffer
+	err := executor.Exec(ctx, namespace, podName, "postgresql", rowEstimatesCommand(dump), &stdout, &stderr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, stderr.String())
+	}
+
+	return parseRowEstimates(&stdout)
+}
+
+// parseRowEstimates parses the tab separated table names and row estimates output by psql
+func parseRowEstimates(r io.Reader) (map[string]int64, error) {
+	estimates := map[string]int64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		table, rows, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(rows, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid row estimate of table %s: %v", table, err)
+		}
+		estimates[table] = n
+	}
+	return estimates, scanner.Err()
+}
+
+// addRowEstimates sets the row estimates of the tables whose data has been dumped
+func addRowEstimates(metadata *model.DatabaseMetadata, rowEstimates map[string]int64) {
+	for i, table := range metadata.Tables {
+		rows, ok := rowEstimates[table.Name]
+		if ok && !slices.Contains(metadata.ExcludedTables, table.Name) {
+			metadata.Tables[i].Rows = &rows
+		}
+	}
+}
+
+// recordedRowEstimates returns the row estimates of the recorded metadata, if any
+func recordedRowEstimates(metadata *model.DatabaseMetadata) map[string]int64 {
+	if metadata == nil {
+		return nil
+	}
+
+	estimates := map[string]int64{}
+	for _, table := range metadata.Tables {
+		if table.Rows != nil {
+			estimates[table.Name] = *table.Rows
+		}
+	}
+	return estimates
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metadataDump = `--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.2 (Debian 16.2-1.pgdg120+2)
+-- Dumped by pg_dump version 16.3
+
+CREATE TABLE public.analytics_2024 (
+    value double precision
+);
+
+CREATE TABLE public.dataelement (
+    dataelementid bigint NOT NULL,
+    name character varying(230)
+);
+
+CREATE UNLOGGED TABLE public.flyway_schema_history (
+    installed_rank integer NOT NULL,
+    version character varying(50)
+);
+
+COPY public.dataelement (dataelementid, name) FROM stdin;
+1	ANC 1st visit
+2	ANC 2nd visit
+\.
+
+COPY public.flyway_schema_history (installed_rank, version, description, type, script, checksum, installed_by, installed_on, execution_time, success) FROM stdin;
+1	2.30.0	Initial	SQL	V2_30_0__Initial.sql	1	dhis	2024-01-01 00:00:00	10	t
+10	2.41.23	Add column	SQL	V2_41_23__Add_column.sql	2	dhis	2024-01-02 00:00:00	10	t
+9	2.41.22	Add table	JAVA	V2_41_22__Add_table	\N	dhis	2024-01-02 00:00:00	10	t
+11	2.41.24	Failed	SQL	V2_41_24__Failed.sql	3	dhis	2024-01-03 00:00:00	10	f
+\.
+`
+
+func TestParseDumpMetadata(t *testing.T) {
+	metadata, err := parseDumpMetadata(strings.NewReader(metadataDump))
+	require.NoError(t, err)
+
+	assert.Equal(t, "16.2 (Debian 16.2-1.pgdg120+2)", metadata.PostgresVersion)
+	assert.Equal(t, "16.3", metadata.PgDumpVersion)
+	assert.Equal(t, "2.41.23", metadata.SchemaVersion)
+	assert.Equal(t, "2.41", metadata.DHIS2Version)
+	assert.Equal(t, []model.TableMetadata{
+		{Name: "public.analytics_2024"},
+		{Name: "public.dataelement"},
+		{Name: "public.flyway_schema_history"},
+	}, metadata.Tables)
+	assert.Equal(t, []string{"public.analytics_2024"}, metadata.ExcludedTables)
+}
+
+// metadataTableOfContents is the output of the metadata job for custom and directory format dumps
+const metadataTableOfContents = `;
+; Archive created at 2024-06-01 10:00:00 UTC
+;     dbname: dhis2
+;     TOC Entries: 7
+;     Compression: gzip
+;     Dump Version: 1.15-0
+;     Format: CUSTOM
+;     Integer: 4 bytes
+;     Offset: 8 bytes
+;     Dumped from database version: 16.2 (Debian 16.2-1.pgdg120+2)
+;     Dumped by pg_dump version: 16.3
+;
+;
+; Selected TOC Entries:
+;
+215; 1259 16386 TABLE public analytics_2024 dhis
+216; 1259 16390 TABLE public dataelement dhis
+217; 1259 16394 TABLE public flyway_schema_history dhis
+218; 1259 16398 TABLE public orgunit_2024 dhis
+219; 0 0 TABLE ATTACH public orgunit_2024 dhis
+3500; 0 16390 TABLE DATA public dataelement dhis
+3501; 0 16394 TABLE DATA public flyway_schema_history dhis
+3502; 0 16398 TABLE DATA public orgunit_2024 dhis
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.2 (Debian 16.2-1.pgdg120+2)
+-- Dumped by pg_dump version 16.3
+
+SET statement_timeout = 0;
+
+COPY public.flyway_schema_history (installed_rank, version, description, type, script, checksum, installed_by, installed_on, execution_time, success) FROM stdin;
+1	2.30.0	Initial	SQL	V2_30_0__Initial.sql	1	dhis	2024-01-01 00:00:00	10	t
+10	2.41.23	Add column	SQL	V2_41_23__Add_column.sql	2	dhis	2024-01-02 00:00:00	10	t
+\.
+`
+
+func TestParseDumpMetadata_TableOfContents(t *testing.T) {
+	metadata, err := parseDumpMetadata(strings.NewReader(metadataTableOfContents))
+	require.NoError(t, err)
+
+	assert.Equal(t, "16.2 (Debian 16.2-1.pgdg120+2)", metadata.PostgresVersion)
+	assert.Equal(t, "16.3", metadata.PgDumpVersion)
+	assert.Equal(t, "2.41.23", metadata.SchemaVersion)
+	assert.Equal(t, "2.41", metadata.DHIS2Version)
+	assert.Equal(t, []model.TableMetadata{
+		{Name: "public.analytics_2024"},
+		{Name: "public.dataelement"},
+		{Name: "public.flyway_schema_history"},
+		{Name: "public.orgunit_2024"},
+	}, metadata.Tables)
+	assert.Equal(t, []string{"public.analytics_2024"}, metadata.ExcludedTables)
+}
+
+func TestParseDumpMetadata_LongLines(t *testing.T) {
+	dump := "CREATE TABLE public.document (\n    content text\n);\nCOPY public.document (content) FROM stdin;\n" +
+		strings.Repeat("x", 3*maxMetadataLineLength) + "\n\\.\n"
+
+	metadata, err := parseDumpMetadata(strings.NewReader(dump))
+	require.NoError(t, err)
+
+	assert.Equal(t, []model.TableMetadata{{Name: "public.document"}}, metadata.Tables)
+	assert.Empty(t, metadata.ExcludedTables)
+}
+
+func TestParseDumpMetadata_Truncated(t *testing.T) {
+	dump := "COPY public.dataelement (dataelementid, name) FROM stdin;\n1\tANC 1st visit\n"
+
+	_, err := parseDumpMetadata(strings.NewReader(dump))
+
+	assert.ErrorContains(t, err, "dump ended while reading the data of public.dataelement")
+}
+
+func TestRowEstimates(t *testing.T) {
+	estimates, err := parseRowEstimates(strings.NewReader("public.analytics_2024\t5000\npublic.dataelement\t2\npublic.flyway_schema_history\t4\n"))
+	require.NoError(t, err)
+	metadata, err := parseDumpMetadata(strings.NewReader(metadataDump))
+	require.NoError(t, err)
+
+	addRowEstimates(metadata, estimates)
+
+	two, four := int64(2), int64(4)
+	assert.Equal(t, []model.TableMetadata{
+		{Name: "public.analytics_2024"},
+		{Name: "public.dataelement", Rows: &two},
+		{Name: "public.flyway_schema_history", Rows: &four},
+	}, metadata.Tables, "excluded tables have no rows in the dump")
+	assert.Equal(t, map[string]int64{"public.dataelement": 2, "public.flyway_schema_history": 4}, recordedRowEstimates(metadata))
+}
+
+func TestRowEstimates_Invalid(t *testing.T) {
+	_, err := parseRowEstimates(strings.NewReader("public.dataelement\tmany\n"))
+
+	require.ErrorContains(t, err, "invalid row estimate of table public.dataelement")
+}
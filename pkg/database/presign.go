@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// Presigner creates short-lived URLs granting direct access to S3 so downloads and uploads don't pass
+// through IM
+type Presigner interface {
+	TTL() time.Duration
+	PresignGet(ctx context.Context, bucket, key, filename string) (string, error)
+	PresignUploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, size int64) (string, error)
+}
+
+// PresignedUrl is a short-lived URL granting direct access to S3
+type PresignedUrl struct {
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Presigns reports whether downloads and uploads go directly to S3 using presigned URLs
+func (s Service) Presigns() bool {
+	return s.presigner != nil
+}
+
+// PresignDownload presigns a URL downloading the stored file
+func (s Service) PresignDownload(ctx context.Context, fileUrl string) (*PresignedUrl, error) {
+	if s.presigner == nil {
+		return nil, errdef.NewBadRequest("presigned downloads aren't enabled")
+	}
+
+	if fileUrl == "" {
+		return nil, errdef.NewBadRequest("database doesn't reference any url")
+	}
+
+	u, err := url.Parse(fileUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.presigner.TTL())
+	presigned, err := s.presigner.PresignGet(ctx, s.s3Bucket, strings.TrimPrefix(u.Path, "/"), path.Base(u.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUrl{Url: presigned, ExpiresAt: expiresAt}, nil
+}
+
+// PresignUploadChunk presigns a URL uploading a chunk of the given size directly to S3 using a PUT
+// request. S3 rejects chunks of any other size. Chunks uploaded this way are picked up when the upload
+// is found or completed. Presigning a chunk marks the upload as active so it isn't aborted as stale
+// while its chunks are being uploaded.
+func (s Service) PresignUploadChunk(ctx context.Context, upload *model.DatabaseUpload, partNumber uint, size int64) (*PresignedUrl, error) {
+	if s.presigner == nil {
+		return nil, errdef.NewBadRequest("presigned uploads aren't enabled")
+	}
+
+	if partNumber < 1 || partNumber > maxChunks {
+		return nil, errdef.NewBadRequest("chunk number must be between 1 and %d", maxChunks)
+	}
+
+	if size < 1 || size > maxChunkSize {
+		return nil, errdef.NewBadRequest("chunk size must be between 1 and %d bytes", maxChunkSize)
+	}
+
+	err := s.checkUploadQuota(ctx, upload, upload.Parts, partNumber, size)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.TouchUpload(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.presigner.TTL())
+	presigned, err := s.presigner.PresignUploadPart(ctx, s.s3Bucket, upload.Key, upload.S3UploadID, int(partNumber), size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUrl{Url: presigned, ExpiresAt: expiresAt}, nil
+}
+
+// listUploadParts lists the chunks which have been uploaded directly to S3 using presigned URLs
+func (s Service) listUploadParts(ctx context.Context, upload *model.DatabaseUpload) ([]model.DatabaseUploadPart, error) {
+	parts, err := s.objectStore.ListParts(ctx, s.s3Bucket, upload.Key, upload.S3UploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploaded chunks: %v", err)
+	}
+
+	uploaded := make([]model.DatabaseUploadPart, len(parts))
+	for i, part := range parts {
+		uploaded[i] = model.DatabaseUploadPart{
+			UploadID:   upload.ID,
+			PartNumber: uint(part.Number),
+			Size:       part.Size,
+			ETag:       part.ETag,
+		}
+	}
+
+	return uploaded, nil
+}
+
+// syncUploadParts records the chunks which have been uploaded directly to S3 using presigned URLs.
+// Only new or replaced chunks are saved, marking the upload as active.
+func (s Service) syncUploadParts(ctx context.Context, upload *model.DatabaseUpload) error {
+	uploaded, err := s.listUploadParts(ctx, upload)
+	if err != nil {
+		return err
+	}
+
+	recorded := make(map[uint]string, len(upload.Parts))
+	for _, part := range upload.Parts {
+		recorded[part.PartNumber] = part.ETag
+	}
+	for i := range uploaded {
+		if etag, ok := recorded[uploaded[i].PartNumber]; ok && etag == uploaded[i].ETag {
+			continue
+		}
+
+		err := s.repository.SaveUploadPart(ctx, &uploaded[i])
+		if err != nil {
+			return err
+		}
+	}
+	upload.Parts = uploaded
+
+	return nil
+}
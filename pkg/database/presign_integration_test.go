@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	userpkg "github.com/dhis2-sre/im-manager/pkg/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresignedUpload(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	objectStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	s := Service{logger: logger, s3Bucket: "bucket", objectStore: objectStore, groupService: fakeGroupService{}, repository: NewRepository(db), presigner: &fakePresigner{ttl: time.Minute}}
+	user, _ := userpkg.CreateUserWithGroup(t, db, "group", "some", "", "user1@dhis2.org")
+
+	ctx := context.Background()
+	created, err := s.CreateUpload(ctx, user.ID, "group", "presigned.sql.gz", "")
+	require.NoError(t, err)
+	// age makes the upload look like it hasn't been active for longer than the upload TTL
+	age := func(t *testing.T) {
+		t.Helper()
+
+		err := db.Model(&model.DatabaseUpload{}).Where("id = ?", created.ID).UpdateColumn("updated_at", time.Now().Add(-2*uploadTTL)).Error
+		require.NoError(t, err)
+	}
+
+	t.Run("PresignChunkMarksUploadActive", func(t *testing.T) {
+		age(t)
+		upload, err := s.FindUpload(ctx, created.ID)
+		require.NoError(t, err)
+
+		presigned, err := s.PresignUploadChunk(ctx, upload, 1, 1024)
+		require.NoError(t, err)
+		assert.Equal(t, "https://s3/bucket/group/presigned.sql.gz?uploadId="+created.S3UploadID+"&partNumber=1&size=1024", presigned.Url)
+
+		require.NoError(t, s.AbortStaleUploads(ctx))
+		_, err = s.FindUpload(ctx, created.ID)
+		require.NoError(t, err, "an upload receiving chunks isn't stale")
+	})
+
+	t.Run("FindUploadReportsChunksUploadedToS3", func(t *testing.T) {
+		// the client puts the chunk to the presigned URL, bypassing IM
+		_, err := objectStore.UploadPart(ctx, "bucket", created.Key, created.S3UploadID, 1, bytes.NewReader([]byte("chunk")), 5)
+		require.NoError(t, err)
+		age(t)
+
+		upload, err := s.FindUpload(ctx, created.ID)
+		require.NoError(t, err)
+
+		require.Len(t, upload.Parts, 1)
+		assert.Equal(t, uint(1), upload.Parts[0].PartNumber)
+		assert.Equal(t, int64(5), upload.Parts[0].Size)
+
+		require.NoError(t, s.AbortStaleUploads(ctx))
+		_, err = s.FindUpload(ctx, created.ID)
+		require.NoError(t, err, "an upload which received a chunk isn't stale")
+	})
+
+	t.Run("FindUploadDoesNotMarkUploadActive", func(t *testing.T) {
+		age(t)
+
+		upload, err := s.FindUpload(ctx, created.ID)
+		require.NoError(t, err)
+		require.Len(t, upload.Parts, 1)
+
+		require.NoError(t, s.AbortStaleUploads(ctx))
+		_, err = s.FindUpload(ctx, created.ID)
+		require.Error(t, err, "polling an upload without new chunks doesn't keep it alive")
+	})
+}
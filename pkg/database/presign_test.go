@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresignDownload(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		s := Service{s3Bucket: "bucket"}
+
+		_, err := s.PresignDownload(context.Background(), "s3://bucket/group/db.sql.gz")
+
+		assert.ErrorContains(t, err, "presigned downloads aren't enabled")
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		presigner := &fakePresigner{ttl: time.Minute}
+		s := Service{s3Bucket: "bucket", presigner: presigner}
+
+		presigned, err := s.PresignDownload(context.Background(), "s3://bucket/group/db.sql.gz")
+
+		require.NoError(t, err)
+		assert.Equal(t, "https://s3/bucket/group/db.sql.gz?filename=db.sql.gz", presigned.Url)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), presigned.ExpiresAt, 5*time.Second)
+	})
+}
+
+func TestPresignUploadChunk(t *testing.T) {
+	ctx := context.Background()
+	objectStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	s3UploadID, err := objectStore.InitiateMultipartUpload(ctx, "bucket", "group/db.sql.gz", "application/octet-stream")
+	require.NoError(t, err)
+	s := Service{s3Bucket: "bucket", objectStore: objectStore, groupService: fakeGroupService{}, presigner: &fakePresigner{ttl: time.Minute}}
+	upload := &model.DatabaseUpload{Key: "group/db.sql.gz", S3UploadID: s3UploadID, Database: &model.Database{GroupName: "group"}}
+
+	t.Run("InvalidChunkNumber", func(t *testing.T) {
+		_, err := s.PresignUploadChunk(ctx, upload, 0, 1024)
+
+		assert.ErrorContains(t, err, "chunk number must be between")
+	})
+
+	t.Run("InvalidChunkSize", func(t *testing.T) {
+		for _, size := range []int64{0, maxChunkSize + 1} {
+			_, err := s.PresignUploadChunk(ctx, upload, 1, size)
+
+			assert.ErrorContains(t, err, "chunk size must be between")
+		}
+	})
+}
+
+type fakeGroupService struct{}
+
+func (fakeGroupService) Find(_ context.Context, name string) (*model.Group, error) {
+	return &model.Group{Name: name}, nil
+}
+
+type fakePresigner struct {
+	ttl time.Duration
+}
+
+func (f *fakePresigner) TTL() time.Duration {
+	return f.ttl
+}
+
+func (f *fakePresigner) PresignGet(_ context.Context, bucket, key, filename string) (string, error) {
+	return "https://s3/" + bucket + "/" + key + "?filename=" + filename, nil
+}
+
+func (f *fakePresigner) PresignUploadPart(_ context.Context, bucket, key, uploadID string, partNumber int, size int64) (string, error) {
+	return "https://s3/" + bucket + "/" + key + "?uploadId=" + uploadID + "&partNumber=" + strconv.Itoa(partNumber) + "&size=" + strconv.FormatInt(size, 10), nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// storageQuotaThresholds are the percentages of the storage quota which trigger a notification once
+// the usage of a group crosses them, highest first
+var storageQuotaThresholds = []int64{100, 80}
+
+// StorageUsage returns the number of bytes stored by the group broken down by user
+func (s Service) StorageUsage(ctx context.Context, groupName string) (*model.StorageUsage, error) {
+	group, err := s.groupService.Find(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storageUsage(ctx, group)
+}
+
+func (s Service) storageUsage(ctx context.Context, group *model.Group) (*model.StorageUsage, error) {
+	rows, err := s.repository.StorageUsage(ctx, group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &model.StorageUsage{Quota: group.StorageQuota, Users: []model.UserStorageUsage{}}
+	byUser := make(map[uint]*model.UserStorageUsage)
+	var userIds []uint
+	for _, row := range rows {
+		user, ok := byUser[row.UserID]
+		if !ok {
+			user = &model.UserStorageUsage{UserID: row.UserID}
+			byUser[row.UserID] = user
+			userIds = append(userIds, row.UserID)
+		}
+		user.Databases += row.Databases
+		user.Filestores += row.Filestores
+		user.Versions += row.Versions
+		user.Total += row.Databases + row.Filestores + row.Versions
+
+		usage.Databases += row.Databases
+		usage.Filestores += row.Filestores
+		usage.Versions += row.Versions
+	}
+	usage.Total = usage.Databases + usage.Filestores + usage.Versions
+
+	if len(userIds) == 0 {
+		return usage, nil
+	}
+
+	emails, err := s.repository.FindUserEmails(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range userIds {
+		user := byUser[id]
+		user.Email = emails[id]
+		usage.Users = append(usage.Users, *user)
+	}
+	slices.SortFunc(usage.Users, func(a, b model.UserStorageUsage) int {
+		return cmp.Compare(b.Total, a.Total)
+	})
+
+	return usage, nil
+}
+
+// CheckStorageQuota returns a Forbidden error if storing size more bytes would exceed the storage
+// quota of the group. The size is 0 if it isn't known upfront, in which case only groups which
+// already exhausted their quota are rejected. The current usage is returned so it can be passed to
+// NotifyStorageQuota once the data is stored.
+func (s Service) CheckStorageQuota(ctx context.Context, groupName string, size int64) (int64, error) {
+	group, err := s.groupService.Find(ctx, groupName)
+	if err != nil {
+		return 0, err
+	}
+
+	if group.StorageQuota == 0 {
+		return 0, nil
+	}
+
+	usage, err := s.storageUsage(ctx, group)
+	if err != nil {
+		return 0, err
+	}
+
+	if usage.Total+size > group.StorageQuota || (size == 0 && usage.Total >= group.StorageQuota) {
+		return 0, errdef.NewForbidden("storage quota of group %q exceeded: %d of %d bytes used", groupName, usage.Total, group.StorageQuota)
+	}
+
+	return usage.Total, nil
+}
+
+// NotifyStorageQuota publishes a storage-quota event if the usage of the group crossed 80% or 100%
+// of its quota since it was usedBefore bytes
+func (s Service) NotifyStorageQuota(ctx context.Context, userId uint, groupName string, usedBefore int64) {
+	group, err := s.groupService.Find(ctx, groupName)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to find group to check its storage quota", "group", groupName, "error", err)
+		return
+	}
+
+	if group.StorageQuota == 0 {
+		return
+	}
+
+	usage, err := s.storageUsage(ctx, group)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to find storage usage", "group", groupName, "error", err)
+		return
+	}
+
+	threshold := crossedStorageQuotaThreshold(group.StorageQuota, usedBefore, usage.Total)
+	if threshold == 0 {
+		return
+	}
+
+	s.publisher.Publish(ctx, userId, groupName, kindStorageQuota, storageQuotaEvent{
+		Percent: threshold,
+		Used:    usage.Total,
+		Quota:   group.StorageQuota,
+	})
+}
+
+// crossedStorageQuotaThreshold returns the highest threshold of the quota crossed when the usage grew
+// from before to after bytes, 0 if none was crossed
+func crossedStorageQuotaThreshold(quota, before, after int64) int64 {
+	for _, threshold := range storageQuotaThresholds {
+		limit := quota * threshold / 100
+		if before < limit && after >= limit {
+			return threshold
+		}
+	}
+	return 0
+}
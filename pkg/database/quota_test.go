@@ -0,0 +1,32 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrossedStorageQuotaThreshold(t *testing.T) {
+	tests := map[string]struct {
+		before, after int64
+		expected      int64
+	}{
+		"BelowWarning":         {before: 10, after: 79, expected: 0},
+		"CrossesWarning":       {before: 79, after: 80, expected: 80},
+		"AboveWarning":         {before: 80, after: 99, expected: 0},
+		"CrossesQuota":         {before: 99, after: 100, expected: 100},
+		"CrossesBoth":          {before: 10, after: 120, expected: 100},
+		"AboveQuota":           {before: 100, after: 150, expected: 0},
+		"ShrinksBelowWarning":  {before: 90, after: 10, expected: 0},
+		"CrossesWarningAgain":  {before: 10, after: 85, expected: 80},
+		"UnchangedAboveQuota":  {before: 120, after: 120, expected: 0},
+		"UnchangedBelowQuota":  {before: 50, after: 50, expected: 0},
+		"StartsAtZeroCrossing": {before: 0, after: 80, expected: 80},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, crossedStorageQuotaThreshold(100, test.before, test.after))
+		})
+	}
+}
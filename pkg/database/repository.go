@@ -17,6 +17,7 @@ import (
 	"github.com/google/uuid"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 //goland:noinspection GoExportedFuncWithUnexportedType
@@ -60,6 +61,8 @@ func (r repository) FindById(ctx context.Context, id uint) (*model.Database, err
 	err := r.db.
 		WithContext(ctx).
 		Preload("Lock").
+		Preload("Metadata").
+		Preload("Shares").
 		Joins("User").
 		First(&d, id).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -82,6 +85,8 @@ func (r repository) FindBySlug(ctx context.Context, slug string) (*model.Databas
 	err := r.db.
 		WithContext(ctx).
 		Preload("Lock").
+		Preload("Metadata").
+		Preload("Shares").
 		Where("slug = ?", slug).
 		First(&d).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -108,14 +113,25 @@ func (r repository) Lock(ctx context.Context, databaseId, instanceId, userId uin
 			return err
 		}
 
-		if d.Lock != nil && d.Lock.InstanceID != 0 {
-			return errdef.NewBadRequest("database already locked by user %d and instance %d", userId, d.Lock.InstanceID)
+		now := time.Now()
+		if d.Lock != nil {
+			if d.Lock.InstanceID != 0 && d.Lock.ExpiresAt.After(now) {
+				return errdef.NewBadRequest("database already locked by user %d and instance %d", d.Lock.UserID, d.Lock.InstanceID)
+			}
+
+			// the lock has expired or isn't held by any instance
+			err := tx.Unscoped().Delete(d.Lock).Error
+			if err != nil {
+				return err
+			}
 		}
 
 		lock = &model.Lock{
 			DatabaseID: databaseId,
 			InstanceID: instanceId,
 			UserID:     userId,
+			AcquiredAt: now,
+			ExpiresAt:  now.Add(lockTTL),
 		}
 		return tx.Create(lock).Error
 	})
@@ -140,13 +156,27 @@ func (r repository) Unlock(ctx context.Context, databaseId uint) error {
 	return nil
 }
 
+// ReleaseLock deletes the lock of the database if it's held by the given instance
+func (r repository) ReleaseLock(ctx context.Context, databaseId, instanceId uint) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Unscoped().Delete(&model.Lock{}, "database_id = ? AND instance_id = ?", databaseId, instanceId).Error
+}
+
 func (r repository) Delete(ctx context.Context, id uint) error {
 	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
 	// cancellation can lead to rollbacks which we should decide individually.
 	ctx = context.WithoutCancel(ctx)
 
-	database, err := r.FindById(ctx, id)
+	// trashed databases are deleted permanently when they're purged
+	var database *model.Database
+	err := r.db.WithContext(ctx).Unscoped().Preload("Lock").First(&database, id).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errdef.NewNotFound("database not found by id: %d", id)
+		}
 		return err
 	}
 
@@ -180,24 +210,139 @@ func (r repository) Delete(ctx context.Context, id uint) error {
 	})
 }
 
-func (r repository) FindByGroupNames(ctx context.Context, groupNames []string) ([]model.Database, error) {
+// Trash moves the database and its filestore to the trash
+func (r repository) Trash(ctx context.Context, d *model.Database) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Delete(&model.Database{}, withFilestore(d)).Error
+}
+
+// Restore restores the database and its filestore from the trash
+func (r repository) Restore(ctx context.Context, d *model.Database) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Model(&model.Database{}).
+		Where("id IN ?", withFilestore(d)).
+		Update("deleted_at", nil).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return errdef.NewDuplicated("database named %q or its filestore can't be restored since the name is used by another database of group %q", d.Name, d.GroupName)
+	}
+
+	return err
+}
+
+func withFilestore(d *model.Database) []uint {
+	if d.FilestoreID == 0 {
+		return []uint{d.ID}
+	}
+	return []uint{d.ID, d.FilestoreID}
+}
+
+func (r repository) FindTrashedById(ctx context.Context, id uint) (*model.Database, error) {
+	var d *model.Database
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Joins("User").
+		Where("databases.deleted_at IS NOT NULL").
+		First(&d, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errdef.NewNotFound("database not found in trash by id: %d", id)
+	}
+	return d, err
+}
+
+// FindTrash finds the trashed databases of the given groups, most recently trashed first
+func (r repository) FindTrash(ctx context.Context, groupNames []string) ([]model.Database, error) {
 	var databases []model.Database
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Joins("User").
+		Where("databases.deleted_at IS NOT NULL").
+		Where("databases.group_name IN ?", groupNames).
+		Order("databases.deleted_at DESC").
+		Find(&databases).Error
+	return databases, err
+}
+
+// FindTrashedBefore finds the databases and filestores which were trashed before the given time.
+// Filestores of trashed databases aren't returned, since they are purged together with their
+// database.
+func (r repository) FindTrashedBefore(ctx context.Context, before time.Time) ([]model.Database, error) {
+	var databases []model.Database
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Where("deleted_at < ?", before).
+		Where("type <> ? OR id NOT IN (?)",
+			"fs", r.db.Unscoped().Model(&model.Database{}).Select("filestore_id").Where("filestore_id IS NOT NULL AND deleted_at IS NOT NULL")).
+		Order("deleted_at").
+		Find(&databases).Error
+	return databases, err
+}
 
-	query := r.db.WithContext(ctx)
+// FindByGroupNames finds the databases of, or shared with, the given groups matching the filter.
+// Administrators find the databases of all groups. The total number of matching databases is
+// returned along with the requested page.
+func (r repository) FindByGroupNames(ctx context.Context, groupNames []string, filter DatabaseFilter) ([]model.Database, int64, error) {
 	isAdmin := slices.Contains(groupNames, model.AdministratorGroupName)
-	if !isAdmin {
-		query = query.Where("Databases.group_name IN ?", groupNames)
+	filtered := func() *gorm.DB {
+		query := r.db.WithContext(ctx).Model(&model.Database{})
+		if !isAdmin {
+			query = query.Where(
+				"Databases.group_name IN ? OR EXISTS (SELECT 1 FROM database_shares WHERE database_shares.database_id = Databases.id AND database_shares.group_name IN ?)",
+				groupNames, groupNames,
+			)
+		}
+		return filter.apply(query)
 	}
 
-	err := query.
-		Where("type = ?", "database").
-		Order("updated_at desc").
+	var total int64
+	err := filtered().Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := filtered().Order("Databases.group_name").Order("Databases.name")
+	if filter.PageSize > 0 {
+		query = query.Limit(filter.PageSize).Offset((filter.Page - 1) * filter.PageSize)
+	}
+
+	var databases []model.Database
+	err = query.
+		Preload("Shares").
 		Joins("User").
 		Joins("Lock.User").
 		Joins("Lock.Instance").
 		Find(&databases).Error
 
-	return databases, err
+	return databases, total, err
+}
+
+// FindByBackupSchedule finds the databases backed up by the backup schedule. The most recently
+// created databases are returned first.
+func (r repository) FindByBackupSchedule(ctx context.Context, scheduleID uint) ([]model.Database, error) {
+	var databases []model.Database
+
+	err := r.db.
+		WithContext(ctx).
+		Where("backup_schedule_id = ?", scheduleID).
+		Where("type = ?", "database").
+		Order("created_at desc").
+		Find(&databases).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find databases by backup schedule: %v", err)
+	}
+
+	return databases, nil
 }
 
 func (r repository) Update(ctx context.Context, d *model.Database) error {
@@ -215,20 +360,15 @@ func updateSlug(d *model.Database) {
 	d.Slug = slug.Make(s)
 }
 
-func (r repository) CreateExternalDownload(ctx context.Context, databaseID uint, expirationInSeconds uint) (*model.ExternalDownload, error) {
+func (r repository) CreateExternalDownload(ctx context.Context, externalDownload *model.ExternalDownload, expirationInSeconds uint) error {
 	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
 	// cancellation can lead to rollbacks which we should decide individually.
 	ctx = context.WithoutCancel(ctx)
 
-	externalDownload := &model.ExternalDownload{
-		UUID:       uuid.New(),
-		Expiration: uint(time.Now().Unix()) + expirationInSeconds,
-		DatabaseID: databaseID,
-	}
+	externalDownload.UUID = uuid.New()
+	externalDownload.Expiration = uint(time.Now().Unix()) + expirationInSeconds
 
-	err := r.db.WithContext(ctx).Save(externalDownload).Error
-
-	return externalDownload, err
+	return r.db.WithContext(ctx).Save(externalDownload).Error
 }
 
 func (r repository) FindExternalDownload(ctx context.Context, uuid uuid.UUID) (*model.ExternalDownload, error) {
@@ -236,6 +376,7 @@ func (r repository) FindExternalDownload(ctx context.Context, uuid uuid.UUID) (*
 	err := r.db.
 		WithContext(ctx).
 		Where("expiration > ?", time.Now().Unix()).
+		Where("revoked_at IS NULL").
 		First(&d, uuid).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, errdef.NewNotFound("external download not found by id: %q", uuid)
@@ -243,6 +384,102 @@ func (r repository) FindExternalDownload(ctx context.Context, uuid uuid.UUID) (*
 	return d, err
 }
 
+// ClaimExternalDownload counts a download of the external download. The download must neither be
+// expired, revoked nor have reached its maximum number of downloads.
+func (r repository) ClaimExternalDownload(ctx context.Context, uuid uuid.UUID) (*model.ExternalDownload, error) {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	var downloads []model.ExternalDownload
+	err := r.db.
+		WithContext(ctx).
+		Model(&downloads).
+		Clauses(clause.Returning{}).
+		Where("uuid = ?", uuid).
+		Where("expiration > ?", time.Now().Unix()).
+		Where("revoked_at IS NULL").
+		Where("max_downloads = 0 OR downloads < max_downloads").
+		Update("downloads", gorm.Expr("downloads + 1")).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(downloads) < 1 {
+		return nil, errdef.NewNotFound("external download not found by id: %q", uuid)
+	}
+
+	return &downloads[0], nil
+}
+
+// UnclaimExternalDownload no longer counts a claimed download against the maximum number of downloads
+func (r repository) UnclaimExternalDownload(ctx context.Context, uuid uuid.UUID) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Model(&model.ExternalDownload{}).
+		Where("uuid = ?", uuid).
+		Where("downloads > 0").
+		Update("downloads", gorm.Expr("downloads - 1")).Error
+}
+
+// FindExternalDownloads finds the external downloads of the database which are neither expired nor
+// revoked
+func (r repository) FindExternalDownloads(ctx context.Context, databaseID uint) ([]model.ExternalDownload, error) {
+	var downloads []model.ExternalDownload
+	err := r.db.
+		WithContext(ctx).
+		Where("database_id = ?", databaseID).
+		Where("expiration > ?", time.Now().Unix()).
+		Where("revoked_at IS NULL").
+		Order("created_at desc").
+		Find(&downloads).Error
+	return downloads, err
+}
+
+func (r repository) RevokeExternalDownload(ctx context.Context, databaseID uint, uuid uuid.UUID) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	db := r.db.
+		WithContext(ctx).
+		Model(&model.ExternalDownload{}).
+		Where("uuid = ? AND database_id = ?", uuid, databaseID).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected < 1 {
+		return errdef.NewNotFound("external download not found by id: %q", uuid)
+	}
+
+	return nil
+}
+
+func (r repository) CreateExternalDownloadAccess(ctx context.Context, access *model.ExternalDownloadAccess) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Create(access).Error
+}
+
+func (r repository) FindExternalDownloadAccesses(ctx context.Context, databaseID uint) ([]model.ExternalDownloadAccess, error) {
+	var accesses []model.ExternalDownloadAccess
+	err := r.db.
+		WithContext(ctx).
+		Where("database_id = ?", databaseID).
+		Order("created_at desc").
+		Find(&accesses).Error
+	return accesses, err
+}
+
 func (r repository) PurgeExternalDownload(ctx context.Context) error {
 	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
 	// cancellation can lead to rollbacks which we should decide individually.
@@ -255,3 +492,444 @@ func (r repository) PurgeExternalDownload(ctx context.Context) error {
 		Delete(&d).Error
 	return err
 }
+
+// CreateVersion saves the given version as the next version of its database
+func (r repository) CreateVersion(ctx context.Context, version *model.DatabaseVersion) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var latest uint
+		err := tx.
+			Model(&model.DatabaseVersion{}).
+			Where("database_id = ?", version.DatabaseID).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error
+		if err != nil {
+			return err
+		}
+
+		version.Version = latest + 1
+		return tx.Create(version).Error
+	})
+}
+
+func (r repository) UpdateVersion(ctx context.Context, version *model.DatabaseVersion) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Omit("User").Save(version).Error
+}
+
+func (r repository) FindVersions(ctx context.Context, databaseID uint) ([]model.DatabaseVersion, error) {
+	var versions []model.DatabaseVersion
+	err := r.db.
+		WithContext(ctx).
+		Joins("User").
+		Where("database_id = ?", databaseID).
+		Order("version desc").
+		Find(&versions).Error
+	return versions, err
+}
+
+func (r repository) FindVersion(ctx context.Context, databaseID, version uint) (*model.DatabaseVersion, error) {
+	var v *model.DatabaseVersion
+	err := r.db.
+		WithContext(ctx).
+		Joins("User").
+		Where("database_id = ? AND version = ?", databaseID, version).
+		First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errdef.NewNotFound("version %d of database %d not found", version, databaseID)
+	}
+	return v, err
+}
+
+// FindVersionByChecksum returns the latest version of the database with the given content
+func (r repository) FindVersionByChecksum(ctx context.Context, databaseID uint, checksum string) (*model.DatabaseVersion, error) {
+	var v *model.DatabaseVersion
+	err := r.db.
+		WithContext(ctx).
+		Where("database_id = ? AND checksum = ? AND url != ''", databaseID, checksum).
+		Order("version desc").
+		First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errdef.NewNotFound("no version of database %d with checksum %q", databaseID, checksum)
+	}
+	return v, err
+}
+
+// CountVersionsByUrl counts the versions, other than the given version, sharing its object
+func (r repository) CountVersionsByUrl(ctx context.Context, version model.DatabaseVersion) (int64, error) {
+	var count int64
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.DatabaseVersion{}).
+		Where("url = ? AND id != ?", version.Url, version.ID).
+		Count(&count).Error
+	return count, err
+}
+
+func (r repository) DeleteVersion(ctx context.Context, id uint) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Unscoped().Delete(&model.DatabaseVersion{}, id).Error
+}
+
+func (r repository) MarkSeeded(ctx context.Context, id uint, at time.Time) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Model(&model.Database{}).
+		Where("id = ?", id).
+		UpdateColumn("last_seeded_at", at).
+		Error
+}
+
+func (r repository) SaveRetentionPolicy(ctx context.Context, policy *model.RetentionPolicy) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Save(policy).Error
+}
+
+func (r repository) FindRetentionPolicy(ctx context.Context, groupName string) (*model.RetentionPolicy, error) {
+	var policy *model.RetentionPolicy
+	err := r.db.
+		WithContext(ctx).
+		Where("group_name = ?", groupName).
+		First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errdef.NewNotFound("retention policy not found for group: %s", groupName)
+	}
+	return policy, err
+}
+
+func (r repository) FindEnabledRetentionPolicies(ctx context.Context) ([]model.RetentionPolicy, error) {
+	var policies []model.RetentionPolicy
+	err := r.db.
+		WithContext(ctx).
+		Where("enabled = ?", true).
+		Find(&policies).Error
+	return policies, err
+}
+
+func (r repository) DeleteRetentionPolicy(ctx context.Context, groupName string) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Delete(&model.RetentionPolicy{}, "group_name = ?", groupName).Error
+}
+
+// FindExpired returns the unlocked databases of a group, which aren't labelled keep and haven't been
+// seeded or updated since the given time. Filestores are only returned if no database references
+// them, since they are otherwise deleted together with their database.
+func (r repository) FindExpired(ctx context.Context, groupName string, unusedSince time.Time) ([]model.Database, error) {
+	var databases []model.Database
+	err := r.db.
+		WithContext(ctx).
+		Joins("Lock").
+		Where("databases.group_name = ?", groupName).
+		Where("NOT jsonb_exists(databases.labels, ?)", model.KeepLabel).
+		Where(`"Lock"."database_id" IS NULL`).
+		Where("GREATEST(databases.updated_at, databases.last_seeded_at) < ?", unusedSince).
+		Where("databases.type = ? OR (databases.type = ? AND databases.id NOT IN (?))",
+			"database", "fs", r.db.Model(&model.Database{}).Select("filestore_id").Where("filestore_id IS NOT NULL")).
+		Order("databases.updated_at").
+		Find(&databases).Error
+	return databases, err
+}
+
+// RunExclusive runs fn unless the advisory lock of the given key is held by another session, so
+// periodic jobs run on a single replica at a time. It reports whether fn was run.
+func (r repository) RunExclusive(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	var ran bool
+	// session level advisory locks belong to a connection, so the lock is taken and released on the
+	// same connection
+	err := r.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		var locked bool
+		err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&locked).Error
+		if err != nil || !locked {
+			return err
+		}
+
+		ran = true
+		err = fn(ctx)
+		return errors.Join(err, conn.Exec("SELECT pg_advisory_unlock(?)", key).Error)
+	})
+	return ran, err
+}
+
+func (r repository) CreateUpload(ctx context.Context, upload *model.DatabaseUpload) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Omit("Database", "Parts").Create(upload).Error
+}
+
+func (r repository) FindUpload(ctx context.Context, id uuid.UUID) (*model.DatabaseUpload, error) {
+	var upload *model.DatabaseUpload
+	err := r.db.
+		WithContext(ctx).
+		Joins("Database").
+		Preload("Database.Shares").
+		Preload("Parts", func(db *gorm.DB) *gorm.DB {
+			return db.Order("part_number")
+		}).
+		First(&upload, "database_uploads.id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errdef.NewNotFound("upload not found by id: %q", id)
+	}
+	return upload, err
+}
+
+// SaveUploadPart records a received part, replacing the part if it has been received before, and
+// marks the upload as active.
+func (r repository) SaveUploadPart(ctx context.Context, part *model.DatabaseUploadPart) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.OnConflict{UpdateAll: true}).
+			Create(part).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.
+			Model(&model.DatabaseUpload{}).
+			Where("id = ?", part.UploadID).
+			Update("updated_at", time.Now()).Error
+	})
+}
+
+func (r repository) UpdateUploadFormat(ctx context.Context, id uuid.UUID, format string) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Model(&model.DatabaseUpload{}).
+		Where("id = ?", id).
+		Update("format", format).Error
+}
+
+// TouchUpload marks the upload as active
+func (r repository) TouchUpload(ctx context.Context, id uuid.UUID) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Model(&model.DatabaseUpload{}).
+		Where("id = ?", id).
+		Update("updated_at", time.Now()).Error
+}
+
+// FindStaleUploads finds the uploads which haven't received any parts since the given time
+func (r repository) FindStaleUploads(ctx context.Context, since time.Time) ([]model.DatabaseUpload, error) {
+	var uploads []model.DatabaseUpload
+	err := r.db.
+		WithContext(ctx).
+		Where("updated_at < ?", since).
+		Find(&uploads).Error
+	return uploads, err
+}
+
+// FindUploadsByDatabase finds the uploads of the given database
+func (r repository) FindUploadsByDatabase(ctx context.Context, databaseID uint) ([]model.DatabaseUpload, error) {
+	var uploads []model.DatabaseUpload
+	err := r.db.
+		WithContext(ctx).
+		Where("database_id = ?", databaseID).
+		Find(&uploads).Error
+	return uploads, err
+}
+
+func (r repository) DeleteUpload(ctx context.Context, id uuid.UUID) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Unscoped().Delete(&model.DatabaseUpload{}, "id = ?", id).Error
+}
+
+// SaveMetadata creates or replaces the metadata of a database
+func (r repository) SaveMetadata(ctx context.Context, metadata *model.DatabaseMetadata) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(metadata).Error
+}
+
+// Share shares the database with the group. Sharing a database with a group it's already shared with
+// does nothing.
+func (r repository) Share(ctx context.Context, share *model.DatabaseShare) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(share).Error
+}
+
+func (r repository) Unshare(ctx context.Context, databaseId uint, groupName string) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	db := r.db.
+		WithContext(ctx).
+		Delete(&model.DatabaseShare{}, "database_id = ? AND group_name = ?", databaseId, groupName)
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected < 1 {
+		return errdef.NewNotFound("database %d isn't shared with group %q", databaseId, groupName)
+	}
+
+	return nil
+}
+
+// RenewLocks extends the expiry of the locks held by the given instances
+func (r repository) RenewLocks(ctx context.Context, instanceIds []uint, expiresAt time.Time) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.
+		WithContext(ctx).
+		Model(&model.Lock{}).
+		Where("instance_id IN ?", instanceIds).
+		Update("expires_at", expiresAt).Error
+}
+
+// DeleteExpiredLocks deletes the locks which expired before the given time and whose instance no
+// longer exists or is in the trash. The deleted locks are returned.
+func (r repository) DeleteExpiredLocks(ctx context.Context, before time.Time) ([]model.Lock, error) {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	var locks []model.Lock
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Clauses(clause.Returning{}).
+		Where("expires_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM deployment_instances WHERE deployment_instances.id = locks.instance_id AND deployment_instances.deleted_at IS NULL)").
+		Delete(&locks).Error
+	return locks, err
+}
+
+// ForceUnlock deletes the lock of the database and records who released it and why
+func (r repository) ForceUnlock(ctx context.Context, forcedUnlock *model.ForcedUnlock) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := tx.Unscoped().Delete(&model.Lock{}, "database_id = ?", forcedUnlock.DatabaseID)
+		if db.Error != nil {
+			return db.Error
+		}
+
+		if db.RowsAffected < 1 {
+			return errdef.NewNotFound("lock not found by database id: %d", forcedUnlock.DatabaseID)
+		}
+
+		return tx.Create(forcedUnlock).Error
+	})
+}
+
+func (r repository) FindForcedUnlocks(ctx context.Context, databaseId uint) ([]model.ForcedUnlock, error) {
+	var forcedUnlocks []model.ForcedUnlock
+	err := r.db.
+		WithContext(ctx).
+		Where("database_id = ?", databaseId).
+		Order("created_at desc").
+		Find(&forcedUnlocks).Error
+	return forcedUnlocks, err
+}
+
+// StorageUsage sums the size of the databases, filestores and database version objects of the group
+// by the user who stored them
+func (r repository) StorageUsage(ctx context.Context, groupName string) ([]model.UserStorageUsage, error) {
+	var databases []model.UserStorageUsage
+	// trashed databases count towards the usage until they're purged
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Model(&model.Database{}).
+		Select("user_id, "+
+			"COALESCE(SUM(size) FILTER (WHERE type <> ?), 0) AS databases, "+
+			"COALESCE(SUM(size) FILTER (WHERE type = ?), 0) AS filestores", "fs", "fs").
+		Where("group_name = ?", groupName).
+		Group("user_id").
+		Scan(&databases).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum database sizes of group %q: %v", groupName, err)
+	}
+
+	// versions of unchanged content share their object so each object is counted once, towards the
+	// user who stored it first
+	objects := r.db.
+		Model(&model.DatabaseVersion{}).
+		Select("DISTINCT ON (database_versions.database_id, database_versions.url) database_versions.user_id, database_versions.size").
+		Joins("JOIN databases ON databases.id = database_versions.database_id").
+		Where("databases.group_name = ?", groupName).
+		Order("database_versions.database_id, database_versions.url, database_versions.version")
+
+	var versions []model.UserStorageUsage
+	err = r.db.
+		WithContext(ctx).
+		Table("(?) AS objects", objects).
+		Select("user_id, COALESCE(SUM(size), 0) AS versions").
+		Group("user_id").
+		Scan(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum database version sizes of group %q: %v", groupName, err)
+	}
+
+	return append(databases, versions...), nil
+}
+
+// FindUserEmails returns the emails of the given users by their id
+func (r repository) FindUserEmails(ctx context.Context, userIds []uint) (map[uint]string, error) {
+	var users []model.User
+	err := r.db.
+		WithContext(ctx).
+		Select("id", "email").
+		Where("id IN ?", userIds).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make(map[uint]string, len(users))
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+	return emails, nil
+}
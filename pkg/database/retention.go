@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// MarkSeeded records that the database was used to seed a deployment.
+func (s Service) MarkSeeded(ctx context.Context, id uint) error {
+	return s.repository.MarkSeeded(ctx, id, time.Now())
+}
+
+func (s Service) FindRetentionPolicy(ctx context.Context, groupName string) (*model.RetentionPolicy, error) {
+	return s.repository.FindRetentionPolicy(ctx, groupName)
+}
+
+func (s Service) SaveRetentionPolicy(ctx context.Context, policy *model.RetentionPolicy) error {
+	if policy.UnusedDays == 0 {
+		return errdef.NewBadRequest("unused days must be greater than 0")
+	}
+
+	_, err := s.groupService.Find(ctx, policy.GroupName)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.SaveRetentionPolicy(ctx, policy)
+}
+
+func (s Service) DeleteRetentionPolicy(ctx context.Context, groupName string) error {
+	_, err := s.repository.FindRetentionPolicy(ctx, groupName)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.DeleteRetentionPolicy(ctx, groupName)
+}
+
+// RetentionReport lists the databases and filestores a retention policy would move to the trash
+type RetentionReport struct {
+	Policy    model.RetentionPolicy `json:"policy"`
+	Databases []model.Database      `json:"databases"`
+	// Bytes of the databases including their filestores. Trashed databases count towards the storage
+	// usage until they're purged, so the bytes are only freed once the trash retention period has
+	// passed.
+	Bytes int64 `json:"bytes"`
+}
+
+// RetentionReport reports what the retention policy of the given group would move to the trash
+// without trashing anything.
+func (s Service) RetentionReport(ctx context.Context, groupName string) (*RetentionReport, error) {
+	policy, err := s.repository.FindRetentionPolicy(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.retentionReport(ctx, *policy, time.Now())
+}
+
+func (s Service) retentionReport(ctx context.Context, policy model.RetentionPolicy, now time.Time) (*RetentionReport, error) {
+	unusedSince := now.AddDate(0, 0, -int(policy.UnusedDays))
+	databases, err := s.repository.FindExpired(ctx, policy.GroupName, unusedSince)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{Policy: policy, Databases: databases}
+	for _, d := range databases {
+		report.Bytes += d.Size
+		if d.FilestoreID == 0 {
+			continue
+		}
+
+		fs, err := s.repository.FindById(ctx, d.FilestoreID)
+		if err != nil {
+			if errdef.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		report.Bytes += fs.Size
+	}
+
+	return report, nil
+}
+
+// retentionLockKey is the key of the advisory lock ensuring retention policies are enforced by a
+// single replica at a time
+const retentionLockKey int64 = 0x726574656e74 // "retent"
+
+// EnforceRetention moves all databases and filestores expired by enabled retention policies to the
+// trash. Nothing is done if the retention policies are being enforced by another replica.
+func (s Service) EnforceRetention(ctx context.Context) error {
+	ran, err := s.repository.RunExclusive(ctx, retentionLockKey, s.enforceRetention)
+	if err != nil {
+		return err
+	}
+	if !ran {
+		s.logger.InfoContext(ctx, "Retention policies are enforced by another replica")
+	}
+	return nil
+}
+
+func (s Service) enforceRetention(ctx context.Context) error {
+	policies, err := s.repository.FindEnabledRetentionPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, policy := range policies {
+		report, err := s.retentionReport(ctx, policy, now)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to evaluate retention policy", "group", policy.GroupName, "error", err)
+			continue
+		}
+
+		for _, d := range report.Databases {
+			err := s.Trash(ctx, d.ID)
+			if err != nil {
+				s.logger.ErrorContext(ctx, "Failed to trash expired database", "group", policy.GroupName, "databaseId", d.ID, "error", err)
+				continue
+			}
+			s.logger.InfoContext(ctx, "Trashed expired database", "group", policy.GroupName, "databaseId", d.ID, "name", d.Name)
+		}
+	}
+
+	return nil
+}
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewRetentionEnforcer(logger *slog.Logger, service *Service, interval time.Duration) retentionEnforcer {
+	return retentionEnforcer{logger, service, interval}
+}
+
+type retentionEnforcer struct {
+	logger   *slog.Logger
+	service  *Service
+	interval time.Duration
+}
+
+// Enforce periodically enforces all enabled retention policies.
+func (r retentionEnforcer) Enforce(ctx context.Context) {
+	for {
+		time.Sleep(r.interval)
+
+		r.logger.InfoContext(ctx, "Enforcing retention policies...")
+
+		err := r.service.EnforceRetention(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "Failed to enforce retention policies", "error", err)
+			continue
+		}
+
+		r.logger.InfoContext(ctx, "Retention policies enforced")
+	}
+}
@@ -10,15 +10,45 @@ func Routes(router *gin.Engine, authenticator gin.HandlerFunc, handler Handler)
 	tokenAuthenticationRouter := router.Group("/databases")
 	tokenAuthenticationRouter.Use(authenticator)
 	tokenAuthenticationRouter.PUT("", handler.Upload)
+	tokenAuthenticationRouter.POST("/import", handler.Import)
+	tokenAuthenticationRouter.POST("/uploads", handler.CreateUpload)
+	tokenAuthenticationRouter.GET("/uploads/:uploadId", handler.FindUpload)
+	tokenAuthenticationRouter.PUT("/uploads/:uploadId/chunks/:number", handler.UploadChunk)
+	tokenAuthenticationRouter.POST("/uploads/:uploadId/chunks/:number/url", handler.PresignUploadChunk)
+	tokenAuthenticationRouter.POST("/uploads/:uploadId/complete", handler.CompleteUpload)
+	tokenAuthenticationRouter.DELETE("/uploads/:uploadId", handler.AbortUpload)
 	tokenAuthenticationRouter.POST("/:id/copy", handler.Copy)
+	tokenAuthenticationRouter.POST("/:id/convert", handler.Convert)
 	tokenAuthenticationRouter.GET("/:id/download", handler.Download)
+	tokenAuthenticationRouter.GET("/:id/files", handler.ListFiles)
+	tokenAuthenticationRouter.GET("/:id/files/download", handler.DownloadFile)
+	tokenAuthenticationRouter.POST("/:id/verify", handler.Verify)
+	tokenAuthenticationRouter.POST("/:id/metadata", handler.ExtractMetadata)
 	tokenAuthenticationRouter.GET("", handler.List)
+	tokenAuthenticationRouter.GET("/anonymization-profiles", handler.AnonymizationProfiles)
 	tokenAuthenticationRouter.GET("/:id", handler.FindByIdentifier)
 	tokenAuthenticationRouter.PUT("/:id", handler.Update)
 	tokenAuthenticationRouter.DELETE("/:id", handler.Delete)
+	tokenAuthenticationRouter.GET("/trash", handler.FindTrash)
+	tokenAuthenticationRouter.POST("/trash/:id/restore", handler.Restore)
+	tokenAuthenticationRouter.DELETE("/trash/:id", handler.Purge)
 	tokenAuthenticationRouter.POST("/:id/lock", handler.Lock)
 	tokenAuthenticationRouter.DELETE("/:id/lock", handler.Unlock)
+	tokenAuthenticationRouter.POST("/:id/force-unlock", handler.ForceUnlock)
+	tokenAuthenticationRouter.GET("/:id/forced-unlocks", handler.FindForcedUnlocks)
+	tokenAuthenticationRouter.POST("/:id/shares", handler.Share)
+	tokenAuthenticationRouter.DELETE("/:id/shares/:group", handler.Unshare)
 	tokenAuthenticationRouter.POST("/save-as/:instanceId", handler.SaveAs)
 	tokenAuthenticationRouter.POST("/save/:instanceId", handler.Save)
 	tokenAuthenticationRouter.POST("/:id/external", handler.CreateExternalDownload)
+	tokenAuthenticationRouter.GET("/:id/external", handler.FindExternalDownloads)
+	tokenAuthenticationRouter.GET("/:id/external/accesses", handler.FindExternalDownloadAccesses)
+	tokenAuthenticationRouter.DELETE("/:id/external/:uuid", handler.RevokeExternalDownload)
+	tokenAuthenticationRouter.GET("/:id/versions", handler.ListVersions)
+	tokenAuthenticationRouter.GET("/:id/versions/:version/download", handler.DownloadVersion)
+	tokenAuthenticationRouter.POST("/:id/versions/:version/promote", handler.PromoteVersion)
+	tokenAuthenticationRouter.GET("/retention-policies/:group", handler.FindRetentionPolicy)
+	tokenAuthenticationRouter.PUT("/retention-policies/:group", handler.SaveRetentionPolicy)
+	tokenAuthenticationRouter.DELETE("/retention-policies/:group", handler.DeleteRetentionPolicy)
+	tokenAuthenticationRouter.GET("/retention-policies/:group/report", handler.RetentionReport)
 }
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// seedScript replaces the database of a running PostgreSQL instance with the dump read from stdin.
+// It mirrors the seed.sh script of the dhis2-db stack which seeds the database at deploy time. The
+// arguments are the name of the database, the owner of the restored objects and the format of the
+// dump.
+const seedScript = `set -euo pipefail
+export PGPASSWORD=$POSTGRES_POSTGRES_PASSWORD
+database=$1
+owner=$2
+format=$3
+
+exec_psql() {
+  psql --username=postgres --no-align --tuples-only --dbname="$database" --set=ON_ERROR_STOP=1 --command="$1"
+}
+
+exec_psql "select pg_terminate_backend(pid) from pg_stat_activity where datname = current_database() and pid <> pg_backend_pid()" >/dev/null
+exec_psql "drop schema if exists public cascade"
+exec_psql "create schema public authorization \"$owner\""
+for extension in postgis pg_trgm btree_gin; do
+  exec_psql "create extension if not exists $extension"
+done
+
+# pg_restore exits with a non zero return code if the dump creates the schema or extensions created
+# above. Those errors are benign but any other error fails the restore.
+pg_restore_benign() {
+  local errors status=0
+  errors=$(mktemp)
+  pg_restore --no-owner --no-acl --username=postgres --dbname="$database" "$@" 2>"$errors" || status=$?
+  cat "$errors" >&2
+  if [[ $status -ne 0 ]]; then
+    if ! grep --quiet "already exists" "$errors" || grep --ignore-case "error:" "$errors" | grep --quiet --invert-match "already exists"; then
+      echo "pg_restore failed with exit code $status" >&2
+      exit 1
+    fi
+  fi
+  rm -f "$errors"
+}
+
+if [[ "$format" == "custom" ]]; then
+  pg_restore_benign
+elif [[ "$format" == "directory" ]]; then
+  dump=$(mktemp -d /tmp/im-seed-XXXXXXXX)
+  trap 'rm -rf "$dump"' EXIT
+  tar -x -f - -C "$dump"
+  pg_restore_benign --jobs=4 "$dump"
+else
+  gunzip --stdout | psql --username=postgres --dbname="$database" --quiet
+fi
+
+change_owner() {
+  for entity in $(exec_psql "$1"); do
+    exec_psql "alter $2 \"$entity\" owner to \"$owner\""
+  done
+}
+
+change_owner "select tablename from pg_tables where schemaname = 'public'" "table"
+change_owner "select sequence_name from information_schema.sequences where sequence_schema = 'public'" "sequence"
+change_owner "select table_name from information_schema.views where table_schema = 'public'" "view"`
+
+// Seed replaces the database of the instance with the given database. The dump is streamed into the
+// PostgreSQL pod of the instance. Clients of the database, like DHIS2, should be stopped since
+// their connections are terminated and the schema is dropped.
+func (s Service) Seed(ctx context.Context, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack) error {
+	if database.Type == "fs" {
+		return errdef.NewBadRequest("database %d is a filestore", database.ID)
+	}
+
+	if database.Url == "" {
+		return errdef.NewBadRequest("database %d has no dump", database.ID)
+	}
+
+	databaseName, exists := instance.Parameters["DATABASE_NAME"]
+	if !exists {
+		return fmt.Errorf("can't find parameter: %s", "DATABASE_NAME")
+	}
+
+	databaseUsername, exists := instance.Parameters["DATABASE_USERNAME"]
+	if !exists {
+		return fmt.Errorf("can't find parameter: %s", "DATABASE_USERNAME")
+	}
+
+	group, err := s.groupService.Find(ctx, instance.GroupName)
+	if err != nil {
+		return err
+	}
+
+	podExecutor, err := s.podExecutor(group.Cluster)
+	if err != nil {
+		return err
+	}
+
+	provider, exists := stack.ParameterProviders["DATABASE_HOSTNAME"]
+	if !exists {
+		return errdef.NewBadRequest("stack %q doesn't run a database", stack.Name)
+	}
+
+	hostname, err := provider.Provide(*instance)
+	if err != nil {
+		return err
+	}
+	// TODO: get pod by label selector instead
+	podName := strings.Split(hostname, ".")[0] + "-0"
+	namespace := instance.Group.Namespace
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := s.Download(ctx, database.ID, pw, func(int64) {})
+		pw.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	dump := bufio.NewReader(pr)
+	header, err := dump.Peek(formatHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read dump header: %v", err)
+	}
+
+	format := detectFormat(header)
+	if format == "" {
+		return errdef.NewBadRequest("unknown format of database %d", database.ID)
+	}
+
+	command := []string{"bash", "-c", seedScript, "seed", databaseName.Value, databaseUsername.Value, format}
+
+	s.logger.InfoContext(ctx, "seeding database", "databaseId", database.ID, "pod", podName, "namespace", namespace, "format", format)
+
+	var stderr strings.Builder
+	err = podExecutor.ExecWithStdin(ctx, namespace, podName, "postgresql", command, dump, io.Discard, &stderr)
+	// unblock the download if the script stopped reading the dump
+	_ = pr.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	// the script only sees the end of the dump if its download failed
+	if err := <-downloaded; err != nil {
+		return fmt.Errorf("failed to download database %d: %v", database.ID, err)
+	}
+
+	s.logger.InfoContext(ctx, "database seeded", "databaseId", database.ID, "pod", podName, "namespace", namespace)
+	return nil
+}
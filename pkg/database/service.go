@@ -1,12 +1,17 @@
 package database
 
 import (
+	"bufio"
 	"cmp"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
 	"slices"
 	"strconv"
@@ -17,6 +22,7 @@ import (
 
 	"github.com/dhis2-sre/im-manager/internal/errdef"
 
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
 	"github.com/dhis2-sre/im-manager/pkg/model"
 	"github.com/dhis2-sre/im-manager/pkg/storage"
 
@@ -32,17 +38,30 @@ type Publisher interface {
 	Publish(ctx context.Context, userID uint, groupName, kind string, payload any)
 }
 
+// NewService creates a database service. versionsToKeep is the number of versions kept per
+// database when pruning, 0 keeps all versions. jobImage is the PostgreSQL image used by conversion
+// and anonymization jobs. anonymizationProfiles are the profiles which can be applied when saving or
+// copying a database. importConfig restricts the URLs databases can be imported from. presigner is
+// optional, if given downloads and uploads go directly to S3 using presigned URLs.
+//
 //goland:noinspection GoExportedFuncWithUnexportedType
-func NewService(logger *slog.Logger, s3Bucket string, s3Client S3Client, groupService groupService, repository *repository, podExecutor podExecutorFunc, publisher Publisher) *Service {
-	return &Service{
-		logger:       logger,
-		s3Bucket:     s3Bucket,
-		s3Client:     s3Client,
-		groupService: groupService,
-		repository:   repository,
-		podExecutor:  podExecutor,
-		publisher:    publisher,
-	}
+func NewService(logger *slog.Logger, s3Bucket string, objectStore storage.ObjectStore, groupService groupService, repository *repository, podExecutor podExecutorFunc, publisher Publisher, versionsToKeep uint, jobImage string, anonymizationProfiles map[string]AnonymizationProfile, importConfig ImportConfig, presigner Presigner) *Service {
+	s := &Service{
+		logger:                logger,
+		s3Bucket:              s3Bucket,
+		objectStore:           objectStore,
+		groupService:          groupService,
+		repository:            repository,
+		podExecutor:           podExecutor,
+		publisher:             publisher,
+		versionsToKeep:        versionsToKeep,
+		jobImage:              jobImage,
+		anonymizationProfiles: anonymizationProfiles,
+		importConfig:          importConfig,
+		presigner:             presigner,
+	}
+	s.httpClient = s.newImportClient()
+	return s
 }
 
 type groupService interface {
@@ -51,27 +70,26 @@ type groupService interface {
 
 type PodExecutor interface {
 	Exec(ctx context.Context, namespace, podName, container string, command []string, stdout, stderr io.Writer) error
+	ExecWithStdin(ctx context.Context, namespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
+	ExecJob(ctx context.Context, namespace, image string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
 }
 
 type podExecutorFunc func(cluster model.Cluster) (PodExecutor, error)
 
 type Service struct {
-	logger       *slog.Logger
-	s3Bucket     string
-	s3Client     S3Client
-	groupService groupService
-	repository   *repository
-	podExecutor  podExecutorFunc
-	publisher    Publisher
-}
-
-type S3Client interface {
-	Copy(bucket string, source string, destination string) error
-	Move(bucket string, source string, destination string) error
-	Upload(ctx context.Context, bucket string, key string, body storage.ReadAtSeeker, size int64) error
-	Delete(bucket string, key string) error
-	Download(ctx context.Context, bucket string, key string, dst io.Writer, cb func(contentLength int64)) error
-	StreamUpload(ctx context.Context, bucket, key, contentType string, r io.Reader) (int64, error)
+	logger                *slog.Logger
+	s3Bucket              string
+	objectStore           storage.ObjectStore
+	groupService          groupService
+	repository            *repository
+	podExecutor           podExecutorFunc
+	publisher             Publisher
+	versionsToKeep        uint
+	jobImage              string
+	anonymizationProfiles map[string]AnonymizationProfile
+	importConfig          ImportConfig
+	presigner             Presigner
+	httpClient            *http.Client
 }
 
 func (s Service) FindByIdentifier(ctx context.Context, identifier string) (*model.Database, error) {
@@ -97,6 +115,18 @@ func (s Service) Copy(ctx context.Context, id uint, d *model.Database, group *mo
 		return err
 	}
 
+	size := source.Size
+	if source.FilestoreID != 0 {
+		fs, err := s.repository.FindById(ctx, source.FilestoreID)
+		if err == nil {
+			size += fs.Size
+		}
+	}
+	usedBefore, err := s.CheckStorageQuota(ctx, group.Name, size)
+	if err != nil {
+		return err
+	}
+
 	if strings.HasSuffix(source.Name, ".pgc") && !strings.HasSuffix(d.Name, ".pgc") {
 		d.Name += ".pgc"
 	}
@@ -111,12 +141,15 @@ func (s Service) Copy(ctx context.Context, id uint, d *model.Database, group *mo
 
 	sourceKey := strings.TrimPrefix(u.Path, "/")
 	destinationKey := fmt.Sprintf("%s/%s", group.Name, d.Name)
-	err = s.s3Client.Copy(s.s3Bucket, sourceKey, destinationKey)
+	err = s.objectStore.Copy(s.s3Bucket, sourceKey, destinationKey)
 	if err != nil {
 		return err
 	}
 
 	d.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, destinationKey)
+	d.Format = source.Format
+	d.Size = source.Size
+	d.Checksum = source.Checksum
 
 	updateSlug(d)
 
@@ -125,7 +158,23 @@ func (s Service) Copy(ctx context.Context, id uint, d *model.Database, group *mo
 		return err
 	}
 
-	return s.copyFS(ctx, d, group, source.FilestoreID)
+	if source.Metadata != nil {
+		metadata := *source.Metadata
+		metadata.DatabaseID = d.ID
+		err := s.repository.SaveMetadata(ctx, &metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.copyFS(ctx, d, group, source.FilestoreID)
+	if err != nil {
+		return err
+	}
+
+	s.NotifyStorageQuota(ctx, d.UserID, group.Name, usedBefore)
+
+	return nil
 }
 
 func (s Service) copyFS(ctx context.Context, d *model.Database, group *model.Group, filestoreID uint) error {
@@ -148,7 +197,13 @@ func (s Service) copyFS(ctx context.Context, d *model.Database, group *model.Gro
 
 	sourceKey := strings.TrimPrefix(fsUrl.Path, "/")
 	destinationKey := fmt.Sprintf("%s/%s", group.Name, newName)
-	err = s.s3Client.Copy(s.s3Bucket, sourceKey, destinationKey)
+	checksum, size := fs.Checksum, fs.Size
+	if fs.Incremental {
+		// the copy is a full backup so it doesn't depend on the backups of the source
+		checksum, size, err = filestore.Assemble(ctx, s.objectStore, s.s3Bucket, sourceKey, destinationKey)
+	} else {
+		err = filestore.Copy(ctx, s.objectStore, s.s3Bucket, sourceKey, destinationKey)
+	}
 	if err != nil {
 		return err
 	}
@@ -159,6 +214,9 @@ func (s Service) copyFS(ctx context.Context, d *model.Database, group *model.Gro
 		GroupName: group.Name,
 		Url:       url,
 		Type:      "fs",
+		Size:      size,
+		Checksum:  checksum,
+		UserID:    d.UserID,
 	}
 
 	updateSlug(fsNewDatabase)
@@ -182,6 +240,12 @@ func (s Service) FindById(ctx context.Context, id uint) (*model.Database, error)
 	return s.repository.FindById(ctx, id)
 }
 
+// FindByBackupSchedule finds the databases backed up by the backup schedule, most recently created
+// first.
+func (s Service) FindByBackupSchedule(ctx context.Context, scheduleID uint) ([]model.Database, error) {
+	return s.repository.FindByBackupSchedule(ctx, scheduleID)
+}
+
 func (s Service) FindBySlug(ctx context.Context, slug string) (*model.Database, error) {
 	return s.repository.FindBySlug(ctx, slug)
 }
@@ -200,8 +264,26 @@ type ReadAtSeeker interface {
 }
 
 func (s Service) Upload(ctx context.Context, d *model.Database, group *model.Group, reader ReadAtSeeker, size int64) (*model.Database, error) {
+	usedBefore, err := s.CheckStorageQuota(ctx, group.Name, size)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, formatHeaderSize)
+	n, err := reader.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	d.Format = detectFormat(header[:n])
+
+	checksum, err := storage.Checksum(io.NewSectionReader(reader, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	d.Checksum = checksum
+
 	key := fmt.Sprintf("%s/%s", group.Name, d.Name)
-	err := s.s3Client.Upload(ctx, s.s3Bucket, key, reader, size)
+	err = s.objectStore.Upload(ctx, s.s3Bucket, key, reader, size)
 	if err != nil {
 		return nil, err
 	}
@@ -214,6 +296,9 @@ func (s Service) Upload(ctx context.Context, d *model.Database, group *model.Gro
 		return nil, err
 	}
 
+	s.NotifyStorageQuota(ctx, d.UserID, group.Name, usedBefore)
+	s.extractMetadataAsync(ctx, d, nil)
+
 	return d, nil
 }
 
@@ -233,19 +318,109 @@ func (s Service) Download(ctx context.Context, id uint, dst io.Writer, cb func(c
 	}
 
 	key := strings.TrimPrefix(u.Path, "/")
-	return s.s3Client.Download(ctx, s.s3Bucket, key, dst, cb)
+	if d.Incremental {
+		// the size of an assembled filestore isn't known upfront
+		return filestore.Download(ctx, s.objectStore, s.s3Bucket, key, dst)
+	}
+	return s.objectStore.Download(ctx, s.s3Bucket, key, dst, cb)
+}
+
+// VerifyResult is the outcome of verifying the stored content of a database against its checksum
+// swagger:model
+type VerifyResult struct {
+	DatabaseID uint `json:"databaseId"`
+	// Checksum is the checksum recorded for the database
+	Checksum string `json:"checksum"`
+	// Actual is the checksum of the content currently stored
+	Actual string `json:"actual"`
+	Valid  bool   `json:"valid"`
+	// Recorded is true if the database had no checksum and the actual checksum has been recorded
+	Recorded bool `json:"recorded"`
+}
+
+// verifyInBackgroundSize is the size above which databases are verified in the background
+const verifyInBackgroundSize = 1 << 30
+
+// Verify computes the checksum of the stored content of the database and compares it with the
+// recorded checksum. Databases stored before checksums were introduced have the computed checksum
+// recorded if record is true, otherwise the result is only reported.
+func (s Service) Verify(ctx context.Context, d *model.Database, record bool) (*VerifyResult, error) {
+	hash := sha256.New()
+	err := s.Download(ctx, d.ID, hash, func(int64) {})
+	if err != nil {
+		return nil, err
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+
+	result := &VerifyResult{
+		DatabaseID: d.ID,
+		Checksum:   d.Checksum,
+		Actual:     actual,
+		Valid:      d.Checksum == "" || d.Checksum == actual,
+	}
+
+	if d.Checksum == "" && record {
+		// Reload the database since it may have changed while its content was read
+		reloaded, err := s.repository.FindById(ctx, d.ID)
+		if err != nil {
+			return nil, err
+		}
+		if reloaded.Checksum == "" && reloaded.Url == d.Url {
+			reloaded.Checksum = actual
+			err := s.repository.Update(ctx, reloaded)
+			if err != nil {
+				return nil, err
+			}
+			result.Checksum = actual
+			result.Recorded = true
+		}
+	}
+
+	if !result.Valid {
+		s.logger.ErrorContext(ctx, "Database checksum mismatch", "databaseId", d.ID, "checksum", d.Checksum, "actual", actual)
+	}
+
+	return result, nil
+}
+
+// VerifyInBackground verifies the database like Verify in the background. The result is published
+// as a database-verify event.
+func (s Service) VerifyInBackground(ctx context.Context, userId uint, d *model.Database, record bool) {
+	// Detach from the request context so the verification isn't cancelled when the HTTP response is
+	// sent.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		result, err := s.Verify(ctx, d, record)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to verify database", "databaseId", d.ID, "error", err)
+			s.publisher.Publish(ctx, userId, d.GroupName, kindDatabaseVerify, verifyEvent{Status: "error", DatabaseID: d.ID, DatabaseName: d.Name, Error: err.Error()})
+			return
+		}
+		s.publisher.Publish(ctx, userId, d.GroupName, kindDatabaseVerify, verifyEvent{Status: "success", DatabaseID: d.ID, DatabaseName: d.Name, Result: result})
+	}()
 }
 
+// Delete permanently deletes the database and its filestore. Use Trash to delete databases
+// restorably.
 func (s Service) Delete(ctx context.Context, id uint) error {
 	d, err := s.repository.FindById(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	return s.delete(ctx, d)
+}
+
+func (s Service) delete(ctx context.Context, d *model.Database) error {
 	if d.Lock != nil {
 		return errdef.NewBadRequest("database is locked")
 	}
 
+	err := s.abortUploads(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+
 	u, err := url.Parse(d.Url)
 	if err != nil {
 		return err
@@ -253,13 +428,18 @@ func (s Service) Delete(ctx context.Context, id uint) error {
 
 	key := strings.TrimPrefix(u.Path, "/")
 	if key != "" {
-		err = s.s3Client.Delete(s.s3Bucket, key)
+		err = s.deleteObject(ctx, d, key)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = s.repository.Delete(ctx, id)
+	err = s.repository.Delete(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.deleteAllVersions(ctx, d.ID)
 	if err != nil {
 		return err
 	}
@@ -268,7 +448,13 @@ func (s Service) Delete(ctx context.Context, id uint) error {
 }
 
 func (s Service) deleteFS(ctx context.Context, d *model.Database) error {
-	fs, err := s.repository.FindById(ctx, d.FilestoreID)
+	findById := s.repository.FindById
+	// the filestore of a trashed database is trashed as well
+	if d.DeletedAt.Valid {
+		findById = s.repository.FindTrashedById
+	}
+
+	fs, err := findById(ctx, d.FilestoreID)
 	if err != nil {
 		if errdef.IsNotFound(err) {
 			return nil
@@ -283,7 +469,7 @@ func (s Service) deleteFS(ctx context.Context, d *model.Database) error {
 
 	fsKey := strings.TrimPrefix(fsUrl.Path, "/")
 	if fsKey != "" {
-		err = s.s3Client.Delete(s.s3Bucket, fsKey)
+		err = s.deleteObject(ctx, fs, fsKey)
 		if err != nil {
 			return err
 		}
@@ -297,7 +483,28 @@ func (s Service) deleteFS(ctx context.Context, d *model.Database) error {
 	return nil
 }
 
-func (s Service) List(ctx context.Context, user *model.User) ([]GroupsWithDatabases, error) {
+// deleteObject deletes the object stored under key for the database. Filestores are deleted along
+// with their manifest and the backups they're based on.
+func (s Service) deleteObject(ctx context.Context, d *model.Database, key string) error {
+	if d.Type != "fs" {
+		return s.objectStore.Delete(s.s3Bucket, key)
+	}
+
+	keys, err := filestore.Chain(ctx, s.objectStore, s.s3Bucket, key)
+	if err != nil {
+		return err
+	}
+	return filestore.Delete(s.objectStore, s.s3Bucket, keys...)
+}
+
+// List lists the databases of the user's groups matching the filter. The total number of matching
+// databases is returned along with the databases so clients can paginate.
+func (s Service) List(ctx context.Context, user *model.User, filter DatabaseFilter) ([]GroupsWithDatabases, int64, error) {
+	err := filter.validate()
+	if err != nil {
+		return nil, 0, err
+	}
+
 	groups := append(user.Groups, user.AdminGroups...) //nolint:gocritic
 	groupsByName := make(map[string]model.Group)
 	for _, group := range groups {
@@ -305,16 +512,16 @@ func (s Service) List(ctx context.Context, user *model.User) ([]GroupsWithDataba
 	}
 	groupNames := maps.Keys(groupsByName)
 
-	databases, err := s.repository.FindByGroupNames(ctx, groupNames)
+	databases, total, err := s.repository.FindByGroupNames(ctx, groupNames, filter)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if len(databases) < 1 {
-		return []GroupsWithDatabases{}, nil
+		return []GroupsWithDatabases{}, total, nil
 	}
 
-	return groupsWithDatabases(databases), nil
+	return groupsWithDatabases(databases), total, nil
 }
 
 func groupsWithDatabases(databases []model.Database) []GroupsWithDatabases {
@@ -360,7 +567,7 @@ func (s Service) Update(ctx context.Context, d *model.Database) error {
 
 	sourceKey := strings.TrimPrefix(sourceUrl.Path, "/")
 	destination := fmt.Sprintf("%s/%s", d.GroupName, d.Name)
-	err = s.s3Client.Move(s.s3Bucket, sourceKey, destination)
+	err = s.objectStore.Move(s.s3Bucket, sourceKey, destination)
 	if err != nil {
 		return err
 	}
@@ -400,9 +607,15 @@ func (s Service) updateFS(ctx context.Context, d *model.Database) error {
 		return err
 	}
 
+	// incremental filestores keep their key since the new key could be the key of one of their
+	// backups, the legacy key of the first backup
+	if fs.Incremental {
+		return s.repository.Update(ctx, fs)
+	}
+
 	sourceKey := strings.TrimPrefix(sourceUrl.Path, "/")
 	destination := fmt.Sprintf("%s/%s", fs.GroupName, fs.Name)
-	err = s.s3Client.Move(s.s3Bucket, sourceKey, destination)
+	err = filestore.Move(ctx, s.objectStore, s.s3Bucket, sourceKey, destination)
 	if err != nil {
 		return err
 	}
@@ -412,13 +625,50 @@ func (s Service) updateFS(ctx context.Context, d *model.Database) error {
 	return s.repository.Update(ctx, fs)
 }
 
-func (s Service) CreateExternalDownload(ctx context.Context, databaseID uint, expiration uint) (*model.ExternalDownload, error) {
+// CreateExternalDownload creates an external download of a database which can be used until it
+// expires, is revoked or has been downloaded maxDownloads times. 0 doesn't limit the number of
+// downloads.
+func (s Service) CreateExternalDownload(ctx context.Context, userId, databaseID uint, expiration, maxDownloads uint) (*model.ExternalDownload, error) {
 	err := s.repository.PurgeExternalDownload(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.repository.CreateExternalDownload(ctx, databaseID, expiration)
+	externalDownload := &model.ExternalDownload{
+		DatabaseID:   databaseID,
+		UserID:       userId,
+		MaxDownloads: maxDownloads,
+	}
+	err = s.repository.CreateExternalDownload(ctx, externalDownload, expiration)
+	if err != nil {
+		return nil, err
+	}
+
+	return externalDownload, nil
+}
+
+// CreateExternalVersionDownload creates an external download of a specific version of a database.
+func (s Service) CreateExternalVersionDownload(ctx context.Context, databaseID, version uint, expiration uint) (*model.ExternalDownload, error) {
+	_, err := s.repository.FindVersion(ctx, databaseID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.PurgeExternalDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	externalDownload := &model.ExternalDownload{
+		DatabaseID: databaseID,
+		Version:    version,
+	}
+	err = s.repository.CreateExternalDownload(ctx, externalDownload, expiration)
+	if err != nil {
+		return nil, err
+	}
+
+	return externalDownload, nil
 }
 
 func (s Service) FindExternalDownload(ctx context.Context, uuid uuid.UUID) (*model.ExternalDownload, error) {
@@ -429,13 +679,68 @@ func (s Service) FindExternalDownload(ctx context.Context, uuid uuid.UUID) (*mod
 	return s.repository.FindExternalDownload(ctx, uuid)
 }
 
+// ClaimExternalDownload finds the external download and counts the download against its maximum
+// number of downloads. The download is claimed before it's served so concurrent downloads can't
+// exceed the maximum. Use UnclaimExternalDownload if it fails before any content is served.
+func (s Service) ClaimExternalDownload(ctx context.Context, uuid uuid.UUID) (*model.ExternalDownload, error) {
+	err := s.repository.PurgeExternalDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.repository.ClaimExternalDownload(ctx, uuid)
+}
+
+// UnclaimExternalDownload no longer counts a download which failed before sending any content against
+// the maximum number of downloads. Failing to unclaim is only logged since the download failed
+// regardless.
+func (s Service) UnclaimExternalDownload(ctx context.Context, uuid uuid.UUID) {
+	err := s.repository.UnclaimExternalDownload(ctx, uuid)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to unclaim external download", "uuid", uuid, "error", err)
+	}
+}
+
+// FindExternalDownloads finds the external downloads of the database which can still be used
+func (s Service) FindExternalDownloads(ctx context.Context, databaseID uint) ([]model.ExternalDownload, error) {
+	return s.repository.FindExternalDownloads(ctx, databaseID)
+}
+
+// RevokeExternalDownload revokes the external download so it can no longer be used
+func (s Service) RevokeExternalDownload(ctx context.Context, databaseID uint, uuid uuid.UUID) error {
+	err := s.repository.RevokeExternalDownload(ctx, databaseID, uuid)
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "External download revoked", "databaseId", databaseID, "uuid", uuid)
+
+	return nil
+}
+
+// RecordExternalDownloadAccess records a download through an external download. Failing to record
+// the access is only logged since the download has already been served.
+func (s Service) RecordExternalDownloadAccess(ctx context.Context, access *model.ExternalDownloadAccess) {
+	err := s.repository.CreateExternalDownloadAccess(ctx, access)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record external download access", "databaseId", access.DatabaseID, "uuid", access.DownloadUUID, "error", err)
+	}
+}
+
+func (s Service) FindExternalDownloadAccesses(ctx context.Context, databaseID uint) ([]model.ExternalDownloadAccess, error) {
+	return s.repository.FindExternalDownloadAccesses(ctx, databaseID)
+}
+
 // EnsureLocked verifies the database may be saved from the given instance, locking it when it
 // isn't already. It returns the reloaded database and whether it was locked beforehand.
 func (s Service) EnsureLocked(ctx context.Context, database *model.Database, instanceId, userId uint) (*model.Database, bool, error) {
 	lock := database.Lock
 	isLocked := lock != nil
 	if isLocked && (lock.InstanceID != instanceId || lock.UserID != userId) {
-		return nil, false, errdef.NewUnauthorized("database is locked")
+		if lock.ExpiresAt.After(time.Now()) {
+			return nil, false, errdef.NewUnauthorized("database is locked")
+		}
+		// the expired lock is taken over
+		isLocked = false
 	}
 
 	if !isLocked {
@@ -456,7 +761,8 @@ func (s Service) EnsureLocked(ctx context.Context, database *model.Database, ins
 
 // SaveLocked overwrites the given locked database with a fresh dump from the instance: it dumps
 // into a temporary record, moves the dump over the original in S3 and re-points the record to the
-// original name and id. It blocks until done and returns the finalized record.
+// original name and id. The new content is recorded as a new version of the database. It blocks
+// until done and returns the finalized record.
 func (s Service) SaveLocked(ctx context.Context, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, wasLocked bool) (*model.Database, error) {
 	if !wasLocked {
 		defer func() {
@@ -474,8 +780,10 @@ func (s Service) SaveLocked(ctx context.Context, database *model.Database, insta
 	if err != nil {
 		return nil, err
 	}
+	// The previous dump is the best estimate of the size of the dump
+	created.Size = database.Size
 
-	saved, err := s.Dump(ctx, database.UserID, created, instance, stack, format)
+	saved, err := s.Dump(ctx, database.UserID, created, instance, stack, format, model.DumpOverrides{})
 	if err != nil {
 		return nil, err
 	}
@@ -483,19 +791,36 @@ func (s Service) SaveLocked(ctx context.Context, database *model.Database, insta
 	return s.finalizeSave(ctx, database, saved)
 }
 
-// finalizeSave moves the freshly dumped temporary record over the original database: S3 move,
-// delete the original row, rename the new row and swap its id back to the original's.
+// finalizeSave moves the freshly dumped temporary record over the original database: version the
+// dump, S3 move, delete the original row, rename the new row and swap its id back to the original's.
 func (s Service) finalizeSave(ctx context.Context, database *model.Database, saved *model.Database) (*model.Database, error) {
+	err := s.ensureBaseVersion(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
 	u, err := url.Parse(saved.Url)
 	if err != nil {
 		return nil, err
 	}
 
 	sourceKey := strings.TrimPrefix(u.Path, "/")
+
+	// the version is created before the database is overwritten so the new content is never
+	// stored without a version
+	current := *database
+	current.Size = saved.Size
+	current.Checksum = saved.Checksum
+	version, err := s.createVersion(ctx, &current, sourceKey, savedBy(database))
+	if err != nil {
+		return nil, err
+	}
+
 	destinationKey := fmt.Sprintf("%s/%s", database.GroupName, database.Name)
-	err = s.s3Client.Move(s.s3Bucket, sourceKey, destinationKey)
+	err = s.objectStore.Move(s.s3Bucket, sourceKey, destinationKey)
 	if err != nil {
-		return nil, fmt.Errorf("moving database failed: %v", err)
+		err = fmt.Errorf("moving database failed: %v", err)
+		return nil, errors.Join(err, s.deleteVersions(ctx, []model.DatabaseVersion{*version}))
 	}
 
 	err = s.repository.Unlock(ctx, database.ID)
@@ -513,6 +838,7 @@ func (s Service) finalizeSave(ctx context.Context, database *model.Database, sav
 	saved.Url = database.Url
 	saved.Slug = database.Slug
 	saved.CreatedAt = database.CreatedAt
+	saved.FilestoreID = database.FilestoreID
 
 	// Use the repository Update method, since the service Update method also performs an S3 move.
 	err = s.repository.Update(ctx, saved)
@@ -538,18 +864,31 @@ func (s Service) finalizeSave(ctx context.Context, database *model.Database, sav
 	return saved, nil
 }
 
+// savedBy returns the id of the user saving the database, which is the user holding the lock
+func savedBy(database *model.Database) uint {
+	if database.Lock != nil {
+		return database.Lock.UserID
+	}
+	return database.UserID
+}
+
 func getFormat(database *model.Database) string {
+	if database.Format != "" {
+		return database.Format
+	}
 	if strings.HasSuffix(database.Url, ".pgc") {
 		return "custom"
 	}
+	if strings.HasSuffix(database.Url, ".tar") {
+		return "directory"
+	}
 	return "plain"
 }
 
 // CreateDatabase creates the database record a subsequent Dump streams into.
 func (s Service) CreateDatabase(ctx context.Context, userId uint, groupName, name string) (*model.Database, error) {
 	newDatabase := &model.Database{
-		Name: name,
-		// TODO: For now, only saving to the same group is supported
+		Name:      name,
 		GroupName: groupName,
 		Type:      "database",
 		UserID:    userId,
@@ -563,10 +902,30 @@ func (s Service) CreateDatabase(ctx context.Context, userId uint, groupName, nam
 	return newDatabase, nil
 }
 
+// CreateBackupDatabase creates an empty database to dump a backup of the backup schedule into
+func (s Service) CreateBackupDatabase(ctx context.Context, userId uint, groupName, name string, scheduleID uint) (*model.Database, error) {
+	newDatabase := &model.Database{
+		Name:             name,
+		GroupName:        groupName,
+		Type:             "database",
+		UserID:           userId,
+		BackupScheduleID: scheduleID,
+	}
+
+	err := s.repository.Save(ctx, newDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDatabase, nil
+}
+
 // Dump streams a pg_dump of the instance's database into S3 and updates the given record with the
-// resulting url and size. It blocks until the dump completes and publishes database-save events
-// along the way.
-func (s Service) Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string) (*model.Database, error) {
+// resulting url and size. The dump options of the stack can be overridden for this dump. It blocks
+// until the dump completes and publishes database-save events along the way. Progress events are
+// published periodically, the size of the given record, if any, is used as the expected size of
+// the dump.
+func (s Service) Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides) (*model.Database, error) {
 	publish := func(status, errMsg string, size int64) {
 		s.publisher.Publish(ctx, userId, database.GroupName, kindDatabaseSave, newDatabaseEvent(database, status, errMsg, size))
 	}
@@ -576,17 +935,75 @@ func (s Service) Dump(ctx context.Context, userId uint, database *model.Database
 		return nil, err
 	}
 
+	usedBefore, err := s.CheckStorageQuota(ctx, database.GroupName, 0)
+	if err != nil {
+		return fail(err)
+	}
+
+	key := fmt.Sprintf("%s/%s", database.GroupName, database.Name)
+	result, err := s.dumpTo(ctx, userId, database, instance, stack, format, overrides, key)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := s.repository.FindById(ctx, database.ID)
+	if err != nil {
+		return fail(err)
+	}
+	saved.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
+	saved.Size = result.size
+	saved.Checksum = result.checksum
+	saved.Format = format
+	if err := s.repository.Save(ctx, saved); err != nil {
+		return fail(err)
+	}
+
+	publish("success", "", result.size)
+	s.NotifyStorageQuota(ctx, userId, saved.GroupName, usedBefore)
+
+	s.extractMetadataAsync(ctx, saved, result.rowEstimates)
+
+	return saved, nil
+}
+
+type dumpResult struct {
+	size     int64
+	checksum string
+	// rowEstimates are the number of rows of the tables estimated by PostgreSQL, nil if unknown
+	rowEstimates map[string]int64
+	err          error
+}
+
+// dumpTo streams a pg_dump of the instance's database into the object with the given key. Started
+// and progress events, as well as errors, are published for the given record.
+func (s Service) dumpTo(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides, key string) (dumpResult, error) {
+	publish := func(status, errMsg string, size int64) {
+		s.publisher.Publish(ctx, userId, database.GroupName, kindDatabaseSave, newDatabaseEvent(database, status, errMsg, size))
+	}
+	fail := func(err error) (dumpResult, error) {
+		s.logError(ctx, err)
+		publish("error", err.Error(), 0)
+		return dumpResult{}, err
+	}
+	progress := storage.NewProgressTracker(database.Size, progressInterval, func(progress storage.Progress) {
+		s.publisher.Publish(ctx, userId, database.GroupName, kindDatabaseSave, newDatabaseProgressEvent(database, progress))
+	})
+
 	group, err := s.groupService.Find(ctx, instance.GroupName)
 	if err != nil {
 		return fail(err)
 	}
 
-	dump, err := newPgDumpConfig(instance, stack)
+	if err := validateDumpOverrides(format, overrides); err != nil {
+		return fail(err)
+	}
+
+	dump, err := newPgDumpConfig(instance, stack, overrides)
 	if err != nil {
 		return fail(err)
 	}
 	dump.Host = "localhost"
-	command := buildPgDumpCommand(dump, format)
+	command := buildPgDumpCommand(dump, format, overrides)
 
 	podExecutor, err := s.podExecutor(group.Cluster)
 	if err != nil {
@@ -603,27 +1020,22 @@ func (s Service) Dump(ctx context.Context, userId uint, database *model.Database
 
 	pr, pw := io.Pipe()
 
-	key := fmt.Sprintf("%s/%s", group.Name, database.Name)
-
-	type uploadResult struct {
-		size int64
-		err  error
-	}
-	uploadDone := make(chan uploadResult, 1)
+	uploadDone := make(chan dumpResult, 1)
 	go func() {
 		defer pr.Close()
-		size, err := s.s3Client.StreamUpload(ctx, s.s3Bucket, key, "application/octet-stream", pr)
-		uploadDone <- uploadResult{size, err}
+		checksummed := storage.NewChecksumReader(progress.Reader(pr))
+		size, err := s.objectStore.StreamUpload(ctx, s.s3Bucket, key, "application/octet-stream", checksummed)
+		uploadDone <- dumpResult{size: size, checksum: checksummed.Checksum(), err: err}
 	}()
 
 	s.logger.InfoContext(ctx, "starting pg_dump", "pod", podName, "namespace", namespace, "command", strings.Join(redactPgPassword(command), " "))
 	publish("started", "", 0)
 
-	if err := execPgDump(ctx, podExecutor, namespace, podName, command, pw, format, database.Name); err != nil {
+	if err := execPgDump(ctx, podExecutor, namespace, podName, command, pw, format, gzipLevel(overrides), database.Name); err != nil {
 		s.logger.ErrorContext(ctx, "failed to exec pg_dump", "error", err)
 		<-uploadDone
 		publish("error", err.Error(), 0)
-		return nil, err
+		return dumpResult{}, err
 	}
 
 	result := <-uploadDone
@@ -631,27 +1043,26 @@ func (s Service) Dump(ctx context.Context, userId uint, database *model.Database
 		return fail(result.err)
 	}
 
-	saved, err := s.repository.FindById(ctx, database.ID)
+	// The estimates are only informational so the dump doesn't fail without them
+	result.rowEstimates, err = estimateRows(ctx, podExecutor, namespace, podName, dump)
 	if err != nil {
-		return fail(err)
-	}
-	saved.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
-	saved.Size = result.size
-	if err := s.repository.Save(ctx, saved); err != nil {
-		return fail(err)
+		s.logger.WarnContext(ctx, "Failed to estimate the rows of the dumped tables", "pod", podName, "namespace", namespace, "error", err)
 	}
 
-	s.logger.InfoContext(ctx, "pg_dump completed successfully", "key", key, "size", result.size)
-	publish("success", "", result.size)
-
-	return saved, nil
+	s.logger.InfoContext(ctx, "pg_dump completed successfully", "key", key, "size", result.size, "throughput", progress.Progress().Throughput)
+	return result, nil
 }
 
-func execPgDump(ctx context.Context, executor PodExecutor, namespace, podName string, command []string, pw *io.PipeWriter, format string, databaseName string) error {
+func execPgDump(ctx context.Context, executor PodExecutor, namespace, podName string, command []string, pw *io.PipeWriter, format string, gzipLevel int, databaseName string) error {
 	var execWriter io.WriteCloser = pw
 	var gzWriter *gzip.Writer
 	if format == "plain" {
-		gzWriter = gzip.NewWriter(pw)
+		var err error
+		gzWriter, err = gzip.NewWriterLevel(pw, gzipLevel)
+		if err != nil {
+			pw.CloseWithError(err)
+			return err
+		}
 		gzWriter.Name = strings.TrimSuffix(databaseName, ".gz")
 		execWriter = gzWriter
 	}
@@ -676,7 +1087,7 @@ func (s Service) logError(ctx context.Context, err error) {
 	s.logger.ErrorContext(ctx, "Failed to SaveAs DB", "error", err)
 }
 
-func newPgDumpConfig(instance *model.DeploymentInstance, stack *model.Stack) (*pg.Dump, error) {
+func newPgDumpConfig(instance *model.DeploymentInstance, stack *model.Stack, overrides model.DumpOverrides) (*pg.Dump, error) {
 	errorMessage := "can't find parameter: %s"
 
 	databaseName, exists := instance.Parameters["DATABASE_NAME"]
@@ -705,12 +1116,13 @@ func newPgDumpConfig(instance *model.DeploymentInstance, stack *model.Stack) (*p
 		return nil, err
 	}
 
-	// Restores target a freshly created, empty database, so replace the
-	// default arguments without the --clean option.
-	dump.Options = []string{"--no-owner", "--no-acl", "--blob"}
-
-	// TODO: This is very DHIS2 specific... More stack meta data?
-	dump.IgnoreTableData = []string{"analytics*", "_*"}
+	// Replace the default arguments, which include the --clean option, with the ones of the stack
+	var options model.DumpOptions
+	if stack.DumpOptions != nil {
+		options = *stack.DumpOptions
+	}
+	dump.Options = append(slices.Clone(options.Options), overrideOptions(overrides)...)
+	dump.IgnoreTableData = excludedTableData(options.ExcludeTableData, overrides)
 
 	return dump, nil
 }
@@ -725,23 +1137,49 @@ func redactPgPassword(command []string) []string {
 	return redacted
 }
 
-func buildPgDumpCommand(dump *pg.Dump, format string) []string {
-	options := dump.Options
+func buildPgDumpCommand(dump *pg.Dump, format string, overrides model.DumpOverrides) []string {
+	options := slices.Clone(dump.Options)
 	options = append(options, dump.Postgres.Parse()...)
 	options = append(options, fmt.Sprintf("-F%s", format))
+	// Plain dumps are compressed while they're streamed
+	if overrides.Compression != nil && format != formatPlain {
+		options = append(options, fmt.Sprintf("--compress=%d", *overrides.Compression))
+	}
+	if overrides.Jobs > 0 {
+		options = append(options, fmt.Sprintf("--jobs=%d", overrides.Jobs))
+	}
 	options = append(options, dump.IgnoreTableDataToString()...)
 
+	if format == formatDirectory {
+		return append([]string{"env", "PGPASSWORD=" + dump.Password, "bash", "-c", directoryDumpScript, "pg_dump"}, options...)
+	}
+
 	return append([]string{"env", "PGPASSWORD=" + dump.Password, "pg_dump"}, options...)
 }
 
 func (s Service) StreamUpload(ctx context.Context, database model.Database, group *model.Group, body io.Reader, contentType string, contentLength int64) (model.Database, error) {
 	ctx = context.WithoutCancel(ctx)
 
+	usedBefore, err := s.CheckStorageQuota(ctx, group.Name, max(contentLength, 0))
+	if err != nil {
+		return model.Database{}, err
+	}
+
 	key := fmt.Sprintf("%s/%s", group.Name, database.Name)
 	s.logger.InfoContext(ctx, "Uploading started", "database", database.Name, "group", group.Name)
 
+	// Peek at the first bytes to detect the format without consuming them
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(formatHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return model.Database{}, err
+	}
+	database.Format = detectFormat(header)
+
+	checksummed := storage.NewChecksumReader(buffered)
+
 	start := time.Now()
-	size, err := s.s3Client.StreamUpload(ctx, s.s3Bucket, key, contentType, body)
+	size, err := s.objectStore.StreamUpload(ctx, s.s3Bucket, key, contentType, checksummed)
 	if err != nil {
 		return model.Database{}, err
 	}
@@ -750,10 +1188,14 @@ func (s Service) StreamUpload(ctx context.Context, database model.Database, grou
 
 	database.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
 	database.Size = size
+	database.Checksum = checksummed.Checksum()
 	err = s.repository.Save(ctx, &database)
 	if err != nil {
 		return model.Database{}, fmt.Errorf("failed to save database record: %w", err)
 	}
 
+	s.NotifyStorageQuota(ctx, database.UserID, group.Name, usedBefore)
+	s.extractMetadataAsync(ctx, &database, nil)
+
 	return database, nil
 }
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// Share grants the members of the group read-only access to the database
+func (s Service) Share(ctx context.Context, userId uint, d *model.Database, groupName string) (*model.DatabaseShare, error) {
+	if groupName == d.GroupName {
+		return nil, errdef.NewBadRequest("database can't be shared with its own group %q", groupName)
+	}
+
+	group, err := s.groupService.Find(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	share := &model.DatabaseShare{
+		DatabaseID: d.ID,
+		GroupName:  group.Name,
+		UserID:     userId,
+	}
+	err = s.repository.Share(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Database shared", "databaseId", d.ID, "group", group.Name)
+
+	return share, nil
+}
+
+// Unshare revokes the access of the group to the database. Copies made by the group are kept.
+func (s Service) Unshare(ctx context.Context, d *model.Database, groupName string) error {
+	err := s.repository.Unshare(ctx, d.ID, groupName)
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "Database unshared", "databaseId", d.ID, "group", groupName)
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"golang.org/x/exp/maps"
+)
+
+// Trash moves the database and its filestore to the trash. Trashed databases can be restored until
+// they're purged and their size counts towards the storage quota of the group until then. Their
+// names can be used by other databases right away so their objects are moved out of the way.
+func (s Service) Trash(ctx context.Context, id uint) error {
+	d, err := s.repository.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if d.Lock != nil {
+		return errdef.NewBadRequest("database is locked")
+	}
+
+	err = s.moveObjects(ctx, d, trashKey)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.Trash(ctx, d)
+}
+
+// FindTrash finds the trashed databases of the groups of the user
+func (s Service) FindTrash(ctx context.Context, user *model.User) ([]GroupsWithDatabases, error) {
+	groups := append(user.Groups, user.AdminGroups...) //nolint:gocritic
+	groupNames := make(map[string]struct{})
+	for _, group := range groups {
+		groupNames[group.Name] = struct{}{}
+	}
+
+	databases, err := s.repository.FindTrash(ctx, maps.Keys(groupNames))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(databases) < 1 {
+		return []GroupsWithDatabases{}, nil
+	}
+
+	return groupsWithDatabases(databases), nil
+}
+
+func (s Service) FindTrashedById(ctx context.Context, id uint) (*model.Database, error) {
+	return s.repository.FindTrashedById(ctx, id)
+}
+
+// Restore restores the database and its filestore from the trash. Restoring fails if their names
+// have been used by other databases since they were trashed.
+func (s Service) Restore(ctx context.Context, id uint) (*model.Database, error) {
+	d, err := s.repository.FindTrashedById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.Restore(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err = s.repository.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.moveObjects(ctx, d, nameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.FindById(ctx, id)
+}
+
+// moveObjects moves the objects of the database and its filestore to the keys returned by key
+func (s Service) moveObjects(ctx context.Context, d *model.Database, key func(d *model.Database) string) error {
+	err := s.moveObject(ctx, d, key(d))
+	if err != nil {
+		return err
+	}
+
+	if d.FilestoreID == 0 {
+		return nil
+	}
+
+	fs, err := s.repository.FindById(ctx, d.FilestoreID)
+	if err != nil {
+		if errdef.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	return s.moveObject(ctx, fs, key(fs))
+}
+
+func (s Service) moveObject(ctx context.Context, d *model.Database, destination string) error {
+	source := objectKey(d.Url)
+	if source == "" || source == destination {
+		return nil
+	}
+
+	var replaced []string
+	var err error
+	switch {
+	case d.Type != "fs":
+		err = s.objectStore.Move(s.s3Bucket, source, destination)
+	case d.Incremental:
+		// the backups an incremental filestore is based on are stored under the name of the filestore
+		// so it's assembled into a full backup which can be moved on its own
+		replaced, err = filestore.Chain(ctx, s.objectStore, s.s3Bucket, source)
+		if err != nil {
+			return err
+		}
+		d.Checksum, d.Size, err = filestore.Assemble(ctx, s.objectStore, s.s3Bucket, source, destination)
+		d.Incremental = false
+	default:
+		err = filestore.Move(ctx, s.objectStore, s.s3Bucket, source, destination)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, destination)
+
+	err = s.repository.Update(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	err = filestore.Delete(s.objectStore, s.s3Bucket, replaced...)
+	if err != nil {
+		// the filestore has been assembled so it no longer depends on the backups
+		s.logger.ErrorContext(ctx, "Failed to delete assembled filestore backups", "filestoreId", d.ID, "keys", replaced, "error", err)
+	}
+
+	return nil
+}
+
+// trashKey returns the key of the object of a trashed database. It's derived from the id of the
+// database rather than its name since the name can be used by other databases while it's trashed.
+func trashKey(d *model.Database) string {
+	return fmt.Sprintf("%s/.trash/%d/%s", d.GroupName, d.ID, path.Base(objectKey(d.Url)))
+}
+
+func nameKey(d *model.Database) string {
+	return fmt.Sprintf("%s/%s", d.GroupName, d.Name)
+}
+
+// Purge permanently deletes the database and its filestore from the trash
+func (s Service) Purge(ctx context.Context, id uint) error {
+	d, err := s.repository.FindTrashedById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.delete(ctx, d)
+}
+
+// PurgeTrash permanently deletes the databases and filestores trashed before the given time.
+func (s Service) PurgeTrash(ctx context.Context, before time.Time) error {
+	databases, err := s.repository.FindTrashedBefore(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range databases {
+		err := s.delete(ctx, &d)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to purge trashed database", "group", d.GroupName, "databaseId", d.ID, "error", err)
+			continue
+		}
+		s.logger.InfoContext(ctx, "Purged trashed database", "group", d.GroupName, "databaseId", d.ID, "name", d.Name)
+	}
+
+	return nil
+}
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewTrashPurger(logger *slog.Logger, service *Service, retentionDays uint, interval time.Duration) trashPurger {
+	return trashPurger{logger, service, retentionDays, interval}
+}
+
+type trashPurger struct {
+	logger        *slog.Logger
+	service       *Service
+	retentionDays uint
+	interval      time.Duration
+}
+
+// Purge periodically purges the databases which have been in the trash for longer than the
+// retention period.
+func (t trashPurger) Purge(ctx context.Context) {
+	for {
+		time.Sleep(t.interval)
+
+		t.logger.InfoContext(ctx, "Purging trashed databases...")
+
+		before := time.Now().AddDate(0, 0, -int(t.retentionDays))
+		err := t.service.PurgeTrash(ctx, before)
+		if err != nil {
+			t.logger.ErrorContext(ctx, "Failed to purge trashed databases", "error", err)
+			continue
+		}
+
+		t.logger.InfoContext(ctx, "Trashed databases purged")
+	}
+}
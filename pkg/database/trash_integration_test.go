@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	userpkg "github.com/dhis2-sre/im-manager/pkg/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	objectStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	s := Service{logger: logger, s3Bucket: "bucket", objectStore: objectStore, groupService: fakeGroupService{}, repository: NewRepository(db)}
+	user, _ := userpkg.CreateUserWithGroup(t, db, "group", "some", "", "user1@dhis2.org")
+
+	ctx := context.Background()
+	create := func(t *testing.T, name, typ, content string) *model.Database {
+		t.Helper()
+
+		d := &model.Database{Name: name, GroupName: "group", Type: typ, Url: "s3://bucket/group/" + name, UserID: user.ID, Size: int64(len(content))}
+		require.NoError(t, s.repository.Create(ctx, d))
+		write(t, objectStore, "group/"+name, content)
+		return d
+	}
+
+	t.Run("RestoreMovesObjectsBack", func(t *testing.T) {
+		d := create(t, "restored.sql.gz", "", "database")
+		fs := create(t, "restored-fs.tar.gz", "fs", "filestore")
+		d.FilestoreID = fs.ID
+		require.NoError(t, s.repository.Update(ctx, d))
+
+		require.NoError(t, s.Trash(ctx, d.ID))
+
+		trashed, err := s.FindTrashedById(ctx, d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "s3://bucket/"+trashKey(d), trashed.Url)
+		assert.False(t, exists(t, objectStore, "group/restored.sql.gz"), "the object of a trashed database is moved out of the way")
+		assert.False(t, exists(t, objectStore, "group/restored-fs.tar.gz"), "the object of a trashed filestore is moved out of the way")
+
+		restored, err := s.Restore(ctx, d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "s3://bucket/group/restored.sql.gz", restored.Url)
+		assert.Equal(t, "database", read(t, objectStore, "group/restored.sql.gz"))
+		restoredFS, err := s.FindById(ctx, fs.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "s3://bucket/group/restored-fs.tar.gz", restoredFS.Url)
+		assert.Equal(t, "filestore", read(t, objectStore, "group/restored-fs.tar.gz"))
+	})
+
+	t.Run("NameCanBeUsedWhileTrashed", func(t *testing.T) {
+		d := create(t, "reused.sql.gz", "", "trashed")
+		require.NoError(t, s.Trash(ctx, d.ID))
+
+		reused := create(t, "reused.sql.gz", "", "reused")
+
+		_, err := s.Restore(ctx, d.ID)
+		require.True(t, errdef.IsDuplicated(err), "restoring a database whose name has been used fails: %v", err)
+		_, err = s.FindTrashedById(ctx, d.ID)
+		require.NoError(t, err, "the database stays in the trash")
+
+		require.NoError(t, s.Purge(ctx, d.ID))
+		_, err = s.FindById(ctx, reused.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "reused", read(t, objectStore, "group/reused.sql.gz"), "purging the trashed database keeps the object of the database using its name")
+	})
+}
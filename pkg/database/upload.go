@@ -0,0 +1,348 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// minChunkSize is the minimum size of all but the last chunk as required by S3 multipart uploads
+	minChunkSize = 5 * 1024 * 1024
+	// maxChunkSize bounds the size of a chunk since chunks are spooled to disk before they're uploaded
+	maxChunkSize = 100 * 1024 * 1024
+	// maxChunks is the maximum number of parts of an S3 multipart upload
+	maxChunks = 10000
+	// uploadTTL is how long an upload is kept without receiving any chunks
+	uploadTTL = 24 * time.Hour
+)
+
+// CreateUpload starts a resumable upload of a database. The database is created right away so its
+// name is reserved while the chunks are being uploaded.
+func (s Service) CreateUpload(ctx context.Context, userId uint, groupName, name, description string) (*model.DatabaseUpload, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	_, err := s.CheckStorageQuota(ctx, groupName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &model.Database{
+		Name:        name,
+		Description: description,
+		GroupName:   groupName,
+		Type:        "database",
+		UserID:      userId,
+	}
+	err = s.repository.Save(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s", groupName, name)
+	s3UploadID, err := s.objectStore.InitiateMultipartUpload(ctx, s.s3Bucket, key, "application/octet-stream")
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to initiate upload: %v", err), s.repository.Delete(ctx, d.ID))
+	}
+
+	upload := &model.DatabaseUpload{
+		ID:         uuid.New(),
+		DatabaseID: d.ID,
+		UserID:     userId,
+		Key:        key,
+		S3UploadID: s3UploadID,
+		Parts:      []model.DatabaseUploadPart{},
+	}
+	err = s.repository.CreateUpload(ctx, upload)
+	if err != nil {
+		return nil, errors.Join(err, s.objectStore.AbortMultipartUpload(ctx, s.s3Bucket, key, s3UploadID), s.repository.Delete(ctx, d.ID))
+	}
+	upload.Database = d
+
+	s.logger.InfoContext(ctx, "Upload created", "uploadId", upload.ID, "database", name, "group", groupName)
+
+	return upload, nil
+}
+
+// FindUpload finds the upload including the chunks which have been received. Chunks uploaded
+// directly to S3 using presigned URLs are recorded first.
+func (s Service) FindUpload(ctx context.Context, id uuid.UUID) (*model.DatabaseUpload, error) {
+	upload, err := s.repository.FindUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Presigns() {
+		err := s.syncUploadParts(ctx, upload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return upload, nil
+}
+
+// UploadChunk uploads a chunk of the upload. Chunks are numbered from 1 and can be uploaded in any
+// order. Uploading a chunk again replaces it.
+func (s Service) UploadChunk(ctx context.Context, upload *model.DatabaseUpload, partNumber uint, body io.Reader) (*model.DatabaseUploadPart, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	if partNumber < 1 || partNumber > maxChunks {
+		return nil, errdef.NewBadRequest("chunk number must be between 1 and %d", maxChunks)
+	}
+
+	// Spool the chunk to a temporary file rather than memory since the object store needs to know
+	// its size up front and may need to read it more than once
+	chunk, err := os.CreateTemp("", "chunk-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(chunk.Name())
+	defer chunk.Close()
+
+	size, err := io.Copy(chunk, io.LimitReader(body, maxChunkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, errdef.NewBadRequest("chunk %d is empty", partNumber)
+	}
+	if size > maxChunkSize {
+		return nil, errdef.NewBadRequest("chunk %d exceeds the maximum size of %d bytes", partNumber, maxChunkSize)
+	}
+
+	err = s.checkUploadQuota(ctx, upload, upload.Parts, partNumber, size)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = chunk.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	completed, err := s.objectStore.UploadPart(ctx, s.s3Bucket, upload.Key, upload.S3UploadID, int(partNumber), chunk, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload chunk %d: %v", partNumber, err)
+	}
+
+	part := &model.DatabaseUploadPart{
+		UploadID:   upload.ID,
+		PartNumber: partNumber,
+		Size:       completed.Size,
+		ETag:       completed.ETag,
+	}
+	err = s.repository.SaveUploadPart(ctx, part)
+	if err != nil {
+		return nil, err
+	}
+
+	if partNumber == 1 {
+		header := make([]byte, formatHeaderSize)
+		n, err := chunk.ReadAt(header, 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		err = s.repository.UpdateUploadFormat(ctx, upload.ID, detectFormat(header[:n]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return part, nil
+}
+
+// CompleteUpload assembles the uploaded chunks into the database. The chunks must be numbered
+// consecutively from 1 and all but the last chunk must be at least 5 MiB.
+func (s Service) CompleteUpload(ctx context.Context, upload *model.DatabaseUpload) (*model.Database, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	if s.Presigns() {
+		err := s.syncUploadParts(ctx, upload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := validateUploadParts(upload.Parts)
+	if err != nil {
+		return nil, err
+	}
+
+	completedParts := make([]storage.Part, len(upload.Parts))
+	var size int64
+	for i, part := range upload.Parts {
+		completedParts[i] = storage.Part{
+			Number: int(part.PartNumber),
+			ETag:   part.ETag,
+		}
+		size += part.Size
+	}
+
+	// the quota is checked again since other databases may have been stored while uploading
+	usedBefore, err := s.CheckStorageQuota(ctx, upload.Database.GroupName, size)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.objectStore.CompleteMultipartUpload(ctx, s.s3Bucket, upload.Key, upload.S3UploadID, completedParts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %v", err)
+	}
+
+	// chunks uploaded using presigned URLs never pass through IM so the format is detected from the
+	// assembled object
+	if upload.Format == "" {
+		header, err := s.objectStore.DownloadRange(ctx, s.s3Bucket, upload.Key, 0, formatHeaderSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect format of upload: %v", err)
+		}
+		upload.Format = detectFormat(header)
+	}
+
+	d, err := s.repository.FindById(ctx, upload.DatabaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The checksum isn't known since chunks may arrive in any order. It's computed from the assembled
+	// object in the background.
+	d.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, upload.Key)
+	d.Size = size
+	d.Format = upload.Format
+	err = s.repository.Update(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.DeleteUpload(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.NotifyStorageQuota(ctx, upload.UserID, d.GroupName, usedBefore)
+	s.VerifyInBackground(ctx, upload.UserID, d, true)
+	s.extractMetadataAsync(ctx, d, nil)
+
+	s.logger.InfoContext(ctx, "Upload completed", "uploadId", upload.ID, "databaseId", d.ID, "chunks", len(upload.Parts), "size", size)
+
+	return d, nil
+}
+
+// checkUploadQuota ensures the storage quota of the group has room for the chunks uploaded so far
+// and another chunk of the given size. The chunk is replaced if it has been uploaded before.
+func (s Service) checkUploadQuota(ctx context.Context, upload *model.DatabaseUpload, parts []model.DatabaseUploadPart, partNumber uint, size int64) error {
+	for _, part := range parts {
+		if part.PartNumber != partNumber {
+			size += part.Size
+		}
+	}
+
+	_, err := s.CheckStorageQuota(ctx, upload.Database.GroupName, size)
+	return err
+}
+
+func validateUploadParts(parts []model.DatabaseUploadPart) error {
+	if len(parts) == 0 {
+		return errdef.NewBadRequest("no chunks have been uploaded")
+	}
+
+	for i, part := range parts {
+		if part.PartNumber != uint(i+1) {
+			return errdef.NewBadRequest("chunk %d is missing", i+1)
+		}
+		if part.Size > maxChunkSize {
+			return errdef.NewBadRequest("chunk %d exceeds the maximum size of %d bytes", part.PartNumber, maxChunkSize)
+		}
+		if i < len(parts)-1 && part.Size < minChunkSize {
+			return errdef.NewBadRequest("chunk %d is smaller than the minimum size of %d bytes", part.PartNumber, minChunkSize)
+		}
+	}
+
+	return nil
+}
+
+// AbortUpload aborts the upload and deletes the database created along with it
+func (s Service) AbortUpload(ctx context.Context, upload *model.DatabaseUpload) error {
+	ctx = context.WithoutCancel(ctx)
+
+	err := s.objectStore.AbortMultipartUpload(ctx, s.s3Bucket, upload.Key, upload.S3UploadID)
+	if err != nil {
+		return fmt.Errorf("failed to abort upload: %v", err)
+	}
+
+	err = s.repository.DeleteUpload(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.Delete(ctx, upload.DatabaseID)
+}
+
+// abortUploads aborts the multipart uploads of the database so their parts don't linger in S3. The
+// upload records are deleted along with the database.
+func (s Service) abortUploads(ctx context.Context, databaseID uint) error {
+	uploads, err := s.repository.FindUploadsByDatabase(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		err := s.objectStore.AbortMultipartUpload(ctx, s.s3Bucket, upload.Key, upload.S3UploadID)
+		if err != nil && !errdef.IsNotFound(err) {
+			return fmt.Errorf("failed to abort upload %q: %v", upload.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// AbortStaleUploads aborts all uploads which haven't received any chunks within the upload TTL
+func (s Service) AbortStaleUploads(ctx context.Context) error {
+	uploads, err := s.repository.FindStaleUploads(ctx, time.Now().Add(-uploadTTL))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, upload := range uploads {
+		err := s.AbortUpload(ctx, &upload)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to abort upload %q: %v", upload.ID, err))
+			continue
+		}
+		s.logger.InfoContext(ctx, "Aborted stale upload", "uploadId", upload.ID, "databaseId", upload.DatabaseID)
+	}
+
+	return errors.Join(errs...)
+}
+
+func NewUploadReaper(logger *slog.Logger, service *Service, interval time.Duration) uploadReaper {
+	return uploadReaper{logger, service, interval}
+}
+
+type uploadReaper struct {
+	logger   *slog.Logger
+	service  *Service
+	interval time.Duration
+}
+
+// Reap periodically aborts stale uploads.
+func (r uploadReaper) Reap(ctx context.Context) {
+	for {
+		time.Sleep(r.interval)
+
+		err := r.service.AbortStaleUploads(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "Failed to abort stale uploads", "error", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUploadParts(t *testing.T) {
+	part := func(number uint, size int64) model.DatabaseUploadPart {
+		return model.DatabaseUploadPart{PartNumber: number, Size: size}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		err := validateUploadParts([]model.DatabaseUploadPart{part(1, minChunkSize), part(2, minChunkSize), part(3, 1)})
+
+		require.NoError(t, err)
+	})
+
+	t.Run("SingleSmallChunk", func(t *testing.T) {
+		err := validateUploadParts([]model.DatabaseUploadPart{part(1, 1)})
+
+		require.NoError(t, err)
+	})
+
+	t.Run("NoChunks", func(t *testing.T) {
+		err := validateUploadParts(nil)
+
+		assert.ErrorContains(t, err, "no chunks")
+	})
+
+	t.Run("MissingChunk", func(t *testing.T) {
+		err := validateUploadParts([]model.DatabaseUploadPart{part(1, minChunkSize), part(3, 1)})
+
+		assert.ErrorContains(t, err, "chunk 2 is missing")
+	})
+
+	t.Run("SmallChunkBeforeLast", func(t *testing.T) {
+		err := validateUploadParts([]model.DatabaseUploadPart{part(1, minChunkSize-1), part(2, 1)})
+
+		assert.ErrorContains(t, err, "chunk 1 is smaller")
+	})
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// ListVersions returns all versions of the given database, newest first.
+func (s Service) ListVersions(ctx context.Context, databaseID uint) ([]model.DatabaseVersion, error) {
+	return s.repository.FindVersions(ctx, databaseID)
+}
+
+func (s Service) FindVersion(ctx context.Context, databaseID, version uint) (*model.DatabaseVersion, error) {
+	return s.repository.FindVersion(ctx, databaseID, version)
+}
+
+func (s Service) DownloadVersion(ctx context.Context, databaseID, version uint, dst io.Writer, cb func(contentLength int64)) error {
+	v, err := s.repository.FindVersion(ctx, databaseID, version)
+	if err != nil {
+		return err
+	}
+
+	return s.objectStore.Download(ctx, s.s3Bucket, objectKey(v.Url), dst, cb)
+}
+
+// PromoteVersion makes the given version the current content of its database. The promotion is
+// recorded as a new version so the latest version always reflects the current content.
+func (s Service) PromoteVersion(ctx context.Context, databaseID, version, userID uint) (*model.Database, error) {
+	d, err := s.repository.FindById(ctx, databaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Lock != nil {
+		return nil, errdef.NewBadRequest("database is locked")
+	}
+
+	if d.Url == "" {
+		return nil, errdef.NewBadRequest("database with id %d doesn't reference any url", databaseID)
+	}
+
+	v, err := s.repository.FindVersion(ctx, databaseID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.objectStore.Copy(s.s3Bucket, objectKey(v.Url), objectKey(d.Url))
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote version %d of database %d: %v", version, databaseID, err)
+	}
+
+	d.Size = v.Size
+	d.Checksum = v.Checksum
+	err = s.repository.Update(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.createVersion(ctx, d, objectKey(d.Url), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.extractMetadataAsync(ctx, d, nil)
+
+	return d, nil
+}
+
+// ensureBaseVersion records the current content of a database as its first version, so content
+// which predates versioning isn't lost when the database is overwritten.
+func (s Service) ensureBaseVersion(ctx context.Context, d *model.Database) error {
+	if d.Url == "" {
+		return nil
+	}
+
+	versions, err := s.repository.FindVersions(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) > 0 {
+		return nil
+	}
+
+	_, err = s.createVersion(ctx, d, objectKey(d.Url), d.UserID)
+	return err
+}
+
+// createVersion snapshots the content of the object at sourceKey into an immutable version of the
+// database and prunes versions exceeding the configured number of versions to keep. Size and
+// checksum of the version are taken from the database. Versions with the same checksum share their
+// object so saving or promoting unchanged content doesn't copy it again.
+func (s Service) createVersion(ctx context.Context, d *model.Database, sourceKey string, userID uint) (*model.DatabaseVersion, error) {
+	version := &model.DatabaseVersion{
+		DatabaseID: d.ID,
+		Size:       d.Size,
+		Checksum:   d.Checksum,
+		UserID:     userID,
+	}
+	err := s.repository.CreateVersion(ctx, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create version of database %d: %v", d.ID, err)
+	}
+
+	if d.Checksum != "" {
+		same, err := s.repository.FindVersionByChecksum(ctx, d.ID, d.Checksum)
+		if err != nil && !errdef.IsNotFound(err) {
+			return nil, errors.Join(err, s.repository.DeleteVersion(ctx, version.ID))
+		}
+		if same != nil {
+			version.Url = same.Url
+			return version, s.finalizeVersion(ctx, version)
+		}
+	}
+
+	key := versionKey(d, version.Version)
+	err = s.objectStore.Copy(s.s3Bucket, sourceKey, key)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to copy version %d of database %d: %v", version.Version, d.ID, err), s.repository.DeleteVersion(ctx, version.ID))
+	}
+
+	version.Url = fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
+	return version, s.finalizeVersion(ctx, version)
+}
+
+// finalizeVersion stores the url of the version and prunes the versions of its database
+func (s Service) finalizeVersion(ctx context.Context, version *model.DatabaseVersion) error {
+	err := s.repository.UpdateVersion(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	err = s.pruneVersions(ctx, version.DatabaseID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to prune database versions", "databaseId", version.DatabaseID, "error", err)
+	}
+
+	return nil
+}
+
+// pruneVersions deletes all but the newest versions of a database. Nothing is pruned if the number
+// of versions to keep is 0.
+func (s Service) pruneVersions(ctx context.Context, databaseID uint) error {
+	if s.versionsToKeep == 0 {
+		return nil
+	}
+
+	versions, err := s.repository.FindVersions(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+
+	if uint(len(versions)) <= s.versionsToKeep {
+		return nil
+	}
+
+	return s.deleteVersions(ctx, versions[s.versionsToKeep:])
+}
+
+func (s Service) deleteAllVersions(ctx context.Context, databaseID uint) error {
+	versions, err := s.repository.FindVersions(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteVersions(ctx, versions)
+}
+
+func (s Service) deleteVersions(ctx context.Context, versions []model.DatabaseVersion) error {
+	var errs error
+	for _, version := range versions {
+		shared, err := s.repository.CountVersionsByUrl(ctx, version)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		// the object is deleted along with the last version sharing it
+		if key := objectKey(version.Url); key != "" && shared == 0 {
+			err := s.objectStore.Delete(s.s3Bucket, key)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("failed to delete version %d of database %d: %v", version.Version, version.DatabaseID, err))
+				continue
+			}
+		}
+
+		err = s.repository.DeleteVersion(ctx, version.ID)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// versionKey returns the S3 key of a version. The file name of the database is kept so the format
+// can still be derived from the key.
+func versionKey(d *model.Database, version uint) string {
+	return fmt.Sprintf("%s/.versions/%d/%d/%s", d.GroupName, d.ID, version, path.Base(objectKey(d.Url)))
+}
+
+func objectKey(s3Url string) string {
+	u, err := url.Parse(s3Url)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
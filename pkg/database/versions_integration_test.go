@@ -0,0 +1,179 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	userpkg "github.com/dhis2-sre/im-manager/pkg/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	t.Parallel()
+
+	db := inttest.SetupDB(t)
+	objectStore, err := storage.NewFilesystemStore(t.TempDir())
+	require.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	s := Service{logger: logger, s3Bucket: "bucket", objectStore: objectStore, groupService: fakeGroupService{}, repository: NewRepository(db)}
+	user, _ := userpkg.CreateUserWithGroup(t, db, "group", "some", "", "user1@dhis2.org")
+
+	ctx := context.Background()
+	create := func(t *testing.T, name, content, checksum string) *model.Database {
+		t.Helper()
+
+		d := &model.Database{Name: name, GroupName: "group", Url: "s3://bucket/group/" + name, UserID: user.ID, Size: int64(len(content)), Checksum: checksum}
+		require.NoError(t, s.repository.Create(ctx, d))
+		write(t, objectStore, "group/"+name, content)
+		return d
+	}
+	// overwrite replaces the content of the database like a save does
+	overwrite := func(t *testing.T, d *model.Database, content, checksum string) *model.DatabaseVersion {
+		t.Helper()
+
+		write(t, objectStore, "group/"+d.Name, content)
+		d.Size = int64(len(content))
+		d.Checksum = checksum
+		require.NoError(t, s.repository.Update(ctx, d))
+		version, err := s.createVersion(ctx, d, objectKey(d.Url), user.ID)
+		require.NoError(t, err)
+		return version
+	}
+
+	t.Run("ShareObjectOfUnchangedContent", func(t *testing.T) {
+		d := create(t, "dedup.sql.gz", "one", "checksum-one")
+
+		first := overwrite(t, d, "one", "checksum-one")
+		unchanged := overwrite(t, d, "one", "checksum-one")
+		changed := overwrite(t, d, "two", "checksum-two")
+
+		assert.Equal(t, "s3://bucket/"+versionKey(d, first.Version), first.Url)
+		assert.Equal(t, first.Url, unchanged.Url, "versions of the same content share their object")
+		assert.False(t, exists(t, objectStore, versionKey(d, unchanged.Version)), "unchanged content isn't copied again")
+		assert.Equal(t, "s3://bucket/"+versionKey(d, changed.Version), changed.Url)
+		assert.Equal(t, "two", read(t, objectStore, objectKey(changed.Url)))
+	})
+
+	t.Run("StorageUsageCountsSharedObjectOnce", func(t *testing.T) {
+		before, err := s.StorageUsage(ctx, "group")
+		require.NoError(t, err)
+		d := create(t, "usage.sql.gz", "one", "checksum-one")
+
+		first := overwrite(t, d, "one", "checksum-one")
+		unchanged := overwrite(t, d, "one", "checksum-one")
+		overwrite(t, d, "three", "checksum-three")
+
+		require.Equal(t, first.Url, unchanged.Url)
+		usage, err := s.StorageUsage(ctx, "group")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("one")+len("three")), usage.Versions-before.Versions, "the object shared by two versions is counted once")
+	})
+
+	t.Run("PromoteVersion", func(t *testing.T) {
+		d := create(t, "promote.sql.gz", "one", "checksum-one")
+		first := overwrite(t, d, "one", "checksum-one")
+		overwrite(t, d, "two", "checksum-two")
+
+		promoted, err := s.PromoteVersion(ctx, d.ID, first.Version, user.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, "checksum-one", promoted.Checksum)
+		assert.Equal(t, int64(3), promoted.Size)
+		assert.Equal(t, "one", read(t, objectStore, "group/promote.sql.gz"))
+		versions, err := s.ListVersions(ctx, d.ID)
+		require.NoError(t, err)
+		require.Len(t, versions, 3, "the promotion is recorded as a new version")
+		assert.Equal(t, "checksum-one", versions[0].Checksum)
+		assert.Equal(t, first.Url, versions[0].Url)
+	})
+
+	t.Run("PromoteVersionOfLockedDatabase", func(t *testing.T) {
+		d := create(t, "locked.sql.gz", "one", "checksum-one")
+		first := overwrite(t, d, "one", "checksum-one")
+		deployment := &model.Deployment{UserID: user.ID, Name: "name", GroupName: "group"}
+		require.NoError(t, db.Create(deployment).Error)
+		instance := &model.DeploymentInstance{Name: "name", GroupName: "group", StackName: "dhis2", DeploymentID: deployment.ID}
+		require.NoError(t, db.Create(instance).Error)
+		require.NoError(t, db.Create(&model.Lock{DatabaseID: d.ID, InstanceID: instance.ID, UserID: user.ID}).Error)
+
+		_, err := s.PromoteVersion(ctx, d.ID, first.Version, user.ID)
+
+		assert.ErrorContains(t, err, "database is locked")
+	})
+
+	t.Run("PruneVersions", func(t *testing.T) {
+		s := s
+		s.versionsToKeep = 2
+		d := create(t, "prune.sql.gz", "one", "checksum-one")
+
+		first := overwrite(t, d, "one", "checksum-one")
+		second := overwrite(t, d, "two", "checksum-two")
+		// shares the object of the first version which is pruned
+		third := overwrite(t, d, "one", "checksum-one")
+
+		versions, err := s.ListVersions(ctx, d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []uint{third.Version, second.Version}, versionNumbers(versions))
+		assert.Equal(t, first.Url, third.Url)
+		assert.Equal(t, "one", read(t, objectStore, objectKey(third.Url)), "the object shared by a retained version is kept")
+
+		fourth := overwrite(t, d, "three", "checksum-three")
+
+		versions, err = s.ListVersions(ctx, d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []uint{fourth.Version, third.Version}, versionNumbers(versions))
+		assert.False(t, exists(t, objectStore, objectKey(second.Url)), "the object of a pruned version is deleted")
+		assert.Equal(t, "one", read(t, objectStore, objectKey(third.Url)))
+	})
+
+	t.Run("KeepAllVersions", func(t *testing.T) {
+		d := create(t, "keep.sql.gz", "one", "checksum-one")
+
+		for _, content := range []string{"one", "two", "three", "four"} {
+			overwrite(t, d, content, "checksum-"+content)
+		}
+
+		versions, err := s.ListVersions(ctx, d.ID)
+		require.NoError(t, err)
+		assert.Len(t, versions, 4)
+	})
+}
+
+func versionNumbers(versions []model.DatabaseVersion) []uint {
+	numbers := make([]uint, len(versions))
+	for i, version := range versions {
+		numbers[i] = version.Version
+	}
+	return numbers
+}
+
+func write(t *testing.T, objectStore storage.ObjectStore, key, content string) {
+	t.Helper()
+
+	err := objectStore.Upload(context.Background(), "bucket", key, strings.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+}
+
+func read(t *testing.T, objectStore storage.ObjectStore, key string) string {
+	t.Helper()
+
+	var content bytes.Buffer
+	err := objectStore.Download(context.Background(), "bucket", key, &content, func(int64) {})
+	require.NoError(t, err)
+	return content.String()
+}
+
+func exists(t *testing.T, objectStore storage.ObjectStore, key string) bool {
+	t.Helper()
+
+	err := objectStore.Download(context.Background(), "bucket", key, &bytes.Buffer{}, func(int64) {})
+	return err == nil
+}
@@ -1,21 +1,75 @@
 package deployment
 
-import "github.com/dhis2-sre/im-manager/pkg/model"
+import (
+	"time"
 
-const kindFilestoreBackup = "filestore-backup"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+)
+
+// progressInterval is the minimum interval between two progress events of a filestore backup
+const progressInterval = 5 * time.Second
+
+const (
+	kindFilestoreBackup       = "filestore-backup"
+	kindDatabaseCompatibility = "database-compatibility"
+	kindDatabaseRestore       = "database-restore"
+)
 
 // filestoreEvent is the JSON payload published for filestore-backup events. It matches the wire
-// format these events had when they were published from the database package.
+// format these events had when they were published from the database package. Progress is only set
+// on events with the status progress.
 type filestoreEvent struct {
+	Status       string            `json:"status"`
+	DatabaseID   uint              `json:"databaseId"`
+	DatabaseName string            `json:"databaseName"`
+	Error        string            `json:"error,omitempty"`
+	Progress     *storage.Progress `json:"progress,omitempty"`
+}
+
+func newFilestoreEvent(db *model.Database, status, errMsg string) filestoreEvent {
+	return filestoreEvent{
+		Status:       status,
+		DatabaseID:   db.ID,
+		DatabaseName: db.Name,
+		Error:        errMsg,
+	}
+}
+
+func newFilestoreProgressEvent(db *model.Database, progress storage.Progress) filestoreEvent {
+	return filestoreEvent{
+		Status:       "progress",
+		DatabaseID:   db.ID,
+		DatabaseName: db.Name,
+		Progress:     &progress,
+	}
+}
+
+// databaseCompatibilityEvent is the JSON payload published when a deployment seeds DHIS2 with a
+// database which is incompatible with its image tag.
+type databaseCompatibilityEvent struct {
+	DeploymentID uint   `json:"deploymentId"`
+	DatabaseID   uint   `json:"databaseId"`
+	DatabaseName string `json:"databaseName"`
+	DHIS2Version string `json:"dhis2Version"`
+	ImageTag     string `json:"imageTag"`
+	Warning      string `json:"warning"`
+}
+
+// restoreEvent is the JSON payload published for database-restore events. Status is one of started,
+// restoring-filestore, stopping, restoring-database, starting, success and error.
+type restoreEvent struct {
 	Status       string `json:"status"`
+	InstanceID   uint   `json:"instanceId"`
 	DatabaseID   uint   `json:"databaseId"`
 	DatabaseName string `json:"databaseName"`
 	Error        string `json:"error,omitempty"`
 }
 
-func newFilestoreEvent(db *model.Database, status, errMsg string) filestoreEvent {
-	return filestoreEvent{
+func newRestoreEvent(instance *model.DeploymentInstance, db *model.Database, status, errMsg string) restoreEvent {
+	return restoreEvent{
 		Status:       status,
+		InstanceID:   instance.ID,
 		DatabaseID:   db.ID,
 		DatabaseName: db.Name,
 		Error:        errMsg,
@@ -6,9 +6,12 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/dhis2-sre/im-manager/internal/errdef"
 	"github.com/dhis2-sre/im-manager/pkg/instance"
 	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
 	"github.com/dhis2-sre/im-manager/pkg/token"
 )
 
@@ -29,16 +32,27 @@ type instanceService interface {
 	FindDecryptedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error)
 	SaveDeployment(ctx context.Context, deployment *model.Deployment) error
 	UpdateInstanceParameters(ctx context.Context, deploymentId, instanceId uint, parameters instance.Parameters, public *bool) (*model.DeploymentInstance, error)
-	FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database) error
+	FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database, progress *storage.ProgressTracker) error
+	RestoreFilestore(ctx context.Context, instance *model.DeploymentInstance, filestore *model.Database) error
+	Pause(ctx context.Context, instance *model.DeploymentInstance) error
+	Resume(ctx context.Context, instance *model.DeploymentInstance) error
 }
 
 type databaseService interface {
 	FindById(ctx context.Context, id uint) (*model.Database, error)
-	CreateExternalDownload(ctx context.Context, databaseID uint, expiration uint) (*model.ExternalDownload, error)
+	CreateExternalDownload(ctx context.Context, userId, databaseID uint, expiration, maxDownloads uint) (*model.ExternalDownload, error)
+	CreateExternalVersionDownload(ctx context.Context, databaseID, version uint, expiration uint) (*model.ExternalDownload, error)
+	MarkSeeded(ctx context.Context, id uint) error
 	CreateDatabase(ctx context.Context, userId uint, groupName, name string) (*model.Database, error)
-	Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string) (*model.Database, error)
+	Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides) (*model.Database, error)
 	EnsureLocked(ctx context.Context, database *model.Database, instanceId, userId uint) (*model.Database, bool, error)
 	SaveLocked(ctx context.Context, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, wasLocked bool) (*model.Database, error)
+	DumpAnonymized(ctx context.Context, userId uint, target *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides, profileName string) (*model.Database, error)
+	Delete(ctx context.Context, id uint) error
+	CheckStorageQuota(ctx context.Context, groupName string, size int64) (int64, error)
+	NotifyStorageQuota(ctx context.Context, userId uint, groupName string, usedBefore int64)
+	Seed(ctx context.Context, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack) error
+	ReleaseLock(ctx context.Context, databaseId, instanceId uint) error
 }
 
 // Publisher publishes notifications for async cross-service operations.
@@ -62,6 +76,8 @@ func (s Service) DeployDeployment(ctx context.Context, token string, deployment
 
 	deployment.Instances = instances
 
+	s.warnIncompatibleDatabase(ctx, deployment)
+
 	for _, instance := range instances {
 		var err error
 		token, err = s.tokenService.RefreshAccessToken(token)
@@ -138,6 +154,8 @@ func (s Service) UpdateInstance(ctx context.Context, token string, deploymentId,
 		return nil, err
 	}
 
+	s.warnIncompatibleDatabase(ctx, deployment)
+
 	err = s.deployInstance(ctx, refreshedToken, decryptedInstance, deployment.TTL, deployment.Instances)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deploy updated instance: %v", err)
@@ -156,9 +174,11 @@ func findInstanceById(instances []*model.DeploymentInstance, id uint) (*model.De
 }
 
 // SaveAs dumps the instance's database into a new record. The record is returned right away
-// while the dump and the filestore backup of the dhis2-core sibling run in the background.
-func (s Service) SaveAs(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, name string, format string) (*model.Database, error) {
-	created, err := s.databaseService.CreateDatabase(ctx, userId, instance.GroupName, name)
+// while the dump and the filestore backup of the dhis2-core sibling run in the background. If an
+// anonymization profile is given the dump is anonymized before it's stored in the record and the
+// filestore isn't backed up since it may contain personal documents.
+func (s Service) SaveAs(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, groupName, name string, format string, dumpOverrides model.DumpOverrides, anonymizationProfile string) (*model.Database, error) {
+	created, err := s.databaseService.CreateDatabase(ctx, userId, groupName, name)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +187,12 @@ func (s Service) SaveAs(ctx context.Context, userId uint, instance *model.Deploy
 	// HTTP response is sent.
 	ctx = context.WithoutCancel(ctx)
 	go func() {
-		dumped, err := s.databaseService.Dump(ctx, userId, created, instance, stack, format)
+		if anonymizationProfile != "" {
+			s.saveAnonymized(ctx, userId, created, instance, stack, format, dumpOverrides, anonymizationProfile)
+			return
+		}
+
+		dumped, err := s.databaseService.Dump(ctx, userId, created, instance, stack, format, dumpOverrides)
 		if err != nil {
 			return
 		}
@@ -177,6 +202,15 @@ func (s Service) SaveAs(ctx context.Context, userId uint, instance *model.Deploy
 	return created, nil
 }
 
+// saveAnonymized dumps the instance's database and anonymizes it into the given record, so the
+// record never references the original data.
+func (s Service) saveAnonymized(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, dumpOverrides model.DumpOverrides, anonymizationProfile string) {
+	_, err := s.databaseService.DumpAnonymized(ctx, userId, database, instance, stack, format, dumpOverrides, anonymizationProfile)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "anonymize database failed", "databaseName", database.Name, "error", err)
+	}
+}
+
 // Save overwrites the instance's source database with a fresh dump. The lock check runs before
 // returning; the dump, finalization and filestore backup run in the background.
 func (s Service) Save(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance) error {
@@ -205,13 +239,124 @@ func (s Service) saveFilestore(ctx context.Context, userId uint, coreInstance *m
 		return
 	}
 
+	usedBefore, err := s.databaseService.CheckStorageQuota(ctx, database.GroupName, 0)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "filestore backup skipped", "groupName", database.GroupName, "databaseName", database.Name, "error", err)
+		s.publisher.Publish(ctx, userId, database.GroupName, kindFilestoreBackup, newFilestoreEvent(database, "error", err.Error()))
+		return
+	}
+
+	// The previous filestore of the database, if any, is the best estimate of the size of the backup
+	var expectedSize int64
+	if database.FilestoreID != 0 {
+		previous, err := s.databaseService.FindById(ctx, database.FilestoreID)
+		if err == nil {
+			expectedSize = previous.Size
+		}
+	}
+	progress := storage.NewProgressTracker(expectedSize, progressInterval, func(p storage.Progress) {
+		s.publisher.Publish(ctx, userId, database.GroupName, kindFilestoreBackup, newFilestoreProgressEvent(database, p))
+	})
+
 	s.publisher.Publish(ctx, userId, database.GroupName, kindFilestoreBackup, newFilestoreEvent(database, "started", ""))
-	if err := s.instanceService.FilestoreBackup(ctx, coreInstance, database.Name, database); err != nil {
+	if err := s.instanceService.FilestoreBackup(ctx, coreInstance, database.Name, database, progress); err != nil {
 		s.logger.ErrorContext(ctx, "filestore backup failed", "groupName", database.GroupName, "databaseName", database.Name, "error", err)
 		s.publisher.Publish(ctx, userId, database.GroupName, kindFilestoreBackup, newFilestoreEvent(database, "error", err.Error()))
 		return
 	}
 	s.publisher.Publish(ctx, userId, database.GroupName, kindFilestoreBackup, newFilestoreEvent(database, "success", ""))
+	s.databaseService.NotifyStorageQuota(ctx, userId, database.GroupName, usedBefore)
+}
+
+// RestoreDatabase replaces the database of the running instance with the given database. The
+// filestore of the database is restored into the dhis2-core sibling if requested. DHIS2 is stopped
+// while the database is replaced and started again afterwards. The restore runs in
+// the background and its progress is published as database-restore events.
+func (s Service) RestoreDatabase(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, database *model.Database, filestore bool) error {
+	if database.Type == "fs" {
+		return errdef.NewBadRequest("database %d is a filestore", database.ID)
+	}
+
+	var filestoreBackup *model.Database
+	if filestore {
+		if coreInstance == nil {
+			return errdef.NewBadRequest("deployment %d has no dhis2-core instance to restore the filestore into", instance.DeploymentID)
+		}
+		if database.FilestoreID == 0 {
+			return errdef.NewBadRequest("database %d has no filestore", database.ID)
+		}
+
+		var err error
+		filestoreBackup, err = s.databaseService.FindById(ctx, database.FilestoreID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Detach from the request context so the restore isn't cancelled when the HTTP response is sent.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		publish := func(status, errMsg string) {
+			s.publisher.Publish(ctx, userId, instance.GroupName, kindDatabaseRestore, newRestoreEvent(instance, database, status, errMsg))
+		}
+
+		publish("started", "")
+		if err := s.restoreDatabase(ctx, instance, stack, coreInstance, database, filestoreBackup, publish); err != nil {
+			s.logger.ErrorContext(ctx, "restore database failed", "instanceId", instance.ID, "databaseId", database.ID, "error", err)
+			publish("error", err.Error())
+			return
+		}
+		publish("success", "")
+	}()
+
+	return nil
+}
+
+func (s Service) restoreDatabase(ctx context.Context, dbInstance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, database, filestoreBackup *model.Database, publish func(status, errMsg string)) (err error) {
+	if coreInstance != nil {
+		publish("stopping", "")
+		if err := s.instanceService.Pause(ctx, coreInstance); err != nil {
+			return err
+		}
+		// DHIS2 is started again even if the restore fails so the instance isn't left stopped
+		defer func() {
+			publish("starting", "")
+			if resumeErr := s.instanceService.Resume(ctx, coreInstance); resumeErr != nil && err == nil {
+				err = resumeErr
+			}
+		}()
+	}
+
+	if filestoreBackup != nil {
+		publish("restoring-filestore", "")
+		if err := s.instanceService.RestoreFilestore(ctx, coreInstance, filestoreBackup); err != nil {
+			return fmt.Errorf("failed to restore filestore: %w", err)
+		}
+	}
+
+	publish("restoring-database", "")
+	if err := s.databaseService.Seed(ctx, database, dbInstance, stack); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	// Point the instance at the restored database so it's seeded with it if it's reset or redeployed
+	previousID, hadDatabase := databaseIDFromInstances([]*model.DeploymentInstance{dbInstance})
+	parameters := instance.Parameters{"DATABASE_ID": {Value: strconv.FormatUint(uint64(database.ID), 10)}}
+	_, err = s.instanceService.UpdateInstanceParameters(ctx, dbInstance.DeploymentID, dbInstance.ID, parameters, nil)
+	if err != nil {
+		return err
+	}
+
+	// The instance no longer uses the previous database, so a lock it holds on it would otherwise be
+	// renewed for as long as the instance runs
+	if hadDatabase && previousID != database.ID {
+		err = s.databaseService.ReleaseLock(ctx, previousID, dbInstance.ID)
+		if err != nil {
+			return fmt.Errorf("failed to release lock of database %d: %w", previousID, err)
+		}
+	}
+
+	return nil
 }
 
 func (s Service) deployInstance(ctx context.Context, token string, instance *model.DeploymentInstance, ttl uint, instances []*model.DeploymentInstance) error {
@@ -225,6 +370,84 @@ func (s Service) deployInstance(ctx context.Context, token string, instance *mod
 
 const seedDownloadTTLSeconds uint = 1800
 
+// warnIncompatibleDatabase publishes a warning if the deployment seeds DHIS2 with a database whose
+// schema is newer than the IMAGE_TAG of the deployment, since DHIS2 can't downgrade its database.
+// The deployment isn't blocked since the image tag or the metadata might not be conclusive.
+func (s Service) warnIncompatibleDatabase(ctx context.Context, deployment *model.Deployment) {
+	databaseID, ok := databaseIDFromInstances(deployment.Instances)
+	if !ok {
+		return
+	}
+
+	var imageTag string
+	for _, instance := range deployment.Instances {
+		if instance.StackName == "dhis2-core" || instance.StackName == "dhis2" {
+			imageTag = instance.Parameters["IMAGE_TAG"].Value
+		}
+	}
+	if imageTag == "" {
+		return
+	}
+
+	db, err := s.databaseService.FindById(ctx, databaseID)
+	if err != nil || db.Metadata == nil || db.Metadata.DHIS2Version == "" {
+		return
+	}
+
+	if !incompatibleImageTag(db.Metadata.DHIS2Version, imageTag) {
+		return
+	}
+
+	warning := fmt.Sprintf("database %q was created by DHIS2 %s which is newer than the image tag %q, DHIS2 can't downgrade its database", db.Name, db.Metadata.DHIS2Version, imageTag)
+	s.logger.WarnContext(ctx, "Incompatible database", "deploymentId", deployment.ID, "databaseId", db.ID, "dhis2Version", db.Metadata.DHIS2Version, "imageTag", imageTag)
+	s.publisher.Publish(ctx, deployment.UserID, deployment.GroupName, kindDatabaseCompatibility, databaseCompatibilityEvent{
+		DeploymentID: deployment.ID,
+		DatabaseID:   db.ID,
+		DatabaseName: db.Name,
+		DHIS2Version: db.Metadata.DHIS2Version,
+		ImageTag:     imageTag,
+		Warning:      warning,
+	})
+}
+
+// incompatibleImageTag reports whether the DHIS2 image tag is older than the DHIS2 version of the
+// database. Tags which aren't versions, e.g. latest, are never reported.
+func incompatibleImageTag(dhis2Version, imageTag string) bool {
+	databaseMajor, ok := dhis2Major(dhis2Version)
+	if !ok {
+		return false
+	}
+
+	imageMajor, ok := dhis2Major(imageTag)
+	if !ok {
+		return false
+	}
+
+	return imageMajor < databaseMajor
+}
+
+// dhis2Major returns the major version of a DHIS2 version, e.g. 41 for 2.41.2 and 42 for 42.1.0
+func dhis2Major(version string) (int, bool) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) > 1 && parts[0] == "2" {
+		parts = parts[1:]
+	}
+
+	digits := parts[0]
+	for i, r := range digits {
+		if r < '0' || r > '9' {
+			digits = digits[:i]
+			break
+		}
+	}
+
+	major, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, false
+	}
+	return major, true
+}
+
 // databaseIDFromInstances resolves the DATABASE_ID parameter from whichever instance in the
 // deployment carries it. DATABASE_ID lives on the db instance, while storage parameters live on
 // the core instance, so callers operating on the core must look across siblings to find it.
@@ -245,6 +468,29 @@ func databaseIDFromInstances(instances []*model.DeploymentInstance) (uint, bool)
 	return 0, false
 }
 
+// databaseSnapshotVersionFromInstances resolves the DATABASE_SNAPSHOT_VERSION parameter from the
+// instance carrying DATABASE_ID. 0 is returned if the current version should be used.
+func databaseSnapshotVersionFromInstances(instances []*model.DeploymentInstance) (uint, error) {
+	for _, instance := range instances {
+		if _, ok := instance.Parameters["DATABASE_ID"]; !ok {
+			continue
+		}
+
+		param, ok := instance.Parameters["DATABASE_SNAPSHOT_VERSION"]
+		if !ok || param.Value == "" {
+			return 0, nil
+		}
+
+		version, err := strconv.ParseUint(param.Value, 10, strconv.IntSize)
+		if err != nil {
+			return 0, errdef.NewBadRequest("invalid database snapshot version %q: %v", param.Value, err)
+		}
+
+		return uint(version), nil
+	}
+	return 0, nil
+}
+
 // buildSeed resolves the database referenced by the deployment's DATABASE_ID parameter into the
 // environment variables and filestore backup record needed to seed an instance at deploy time.
 func (s Service) buildSeed(ctx context.Context, instances []*model.DeploymentInstance) (map[string]string, *model.Database, error) {
@@ -261,15 +507,32 @@ func (s Service) buildSeed(ctx context.Context, instances []*model.DeploymentIns
 		return nil, nil, fmt.Errorf("database %d not found: %w", databaseID, err)
 	}
 
-	dbDownload, err := s.databaseService.CreateExternalDownload(ctx, db.ID, seedDownloadTTLSeconds)
+	version, err := databaseSnapshotVersionFromInstances(instances)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Seed links are created by the system rather than a user and don't limit the number of downloads
+	// since the seeding containers may be restarted
+	var dbDownload *model.ExternalDownload
+	if version != 0 {
+		dbDownload, err = s.databaseService.CreateExternalVersionDownload(ctx, db.ID, version, seedDownloadTTLSeconds)
+	} else {
+		dbDownload, err = s.databaseService.CreateExternalDownload(ctx, 0, db.ID, seedDownloadTTLSeconds, 0)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create seed download link for database %d: %w", db.ID, err)
 	}
 	extraEnv["DATABASE_DOWNLOAD_URL"] = hostname + "/databases/external/" + dbDownload.UUID.String()
 
+	err = s.databaseService.MarkSeeded(ctx, db.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to mark database %d as seeded: %w", db.ID, err)
+	}
+
 	var filestore *model.Database
 	if db.FilestoreID != 0 {
-		fsDownload, err := s.databaseService.CreateExternalDownload(ctx, db.FilestoreID, seedDownloadTTLSeconds)
+		fsDownload, err := s.databaseService.CreateExternalDownload(ctx, 0, db.FilestoreID, seedDownloadTTLSeconds, 0)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to create seed download link for filestore %d: %w", db.FilestoreID, err)
 		}
@@ -9,12 +9,15 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/dhis2-sre/im-manager/pkg/instance"
 	"github.com/dhis2-sre/im-manager/pkg/model"
 )
 
 // fakeDatabaseService resolves database records by id and mints deterministic download links.
 type fakeDatabaseService struct {
 	byID map[uint]*model.Database
+	// released records the locks released by database id
+	released map[uint]uint
 }
 
 func (f fakeDatabaseService) FindById(ctx context.Context, id uint) (*model.Database, error) {
@@ -25,15 +28,23 @@ func (f fakeDatabaseService) FindById(ctx context.Context, id uint) (*model.Data
 	return db, nil
 }
 
-func (f fakeDatabaseService) CreateExternalDownload(ctx context.Context, databaseID uint, expiration uint) (*model.ExternalDownload, error) {
+func (f fakeDatabaseService) CreateExternalDownload(ctx context.Context, userId, databaseID uint, expiration, maxDownloads uint) (*model.ExternalDownload, error) {
 	return &model.ExternalDownload{UUID: uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprint(databaseID))), DatabaseID: databaseID}, nil
 }
 
+func (f fakeDatabaseService) CreateExternalVersionDownload(ctx context.Context, databaseID, version uint, expiration uint) (*model.ExternalDownload, error) {
+	return &model.ExternalDownload{UUID: uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%d@%d", databaseID, version))), DatabaseID: databaseID, Version: version}, nil
+}
+
+func (f fakeDatabaseService) MarkSeeded(ctx context.Context, id uint) error {
+	return nil
+}
+
 func (f fakeDatabaseService) CreateDatabase(ctx context.Context, userId uint, groupName, name string) (*model.Database, error) {
 	panic("not used")
 }
 
-func (f fakeDatabaseService) Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string) (*model.Database, error) {
+func (f fakeDatabaseService) Dump(ctx context.Context, userId uint, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides) (*model.Database, error) {
 	panic("not used")
 }
 
@@ -45,6 +56,42 @@ func (f fakeDatabaseService) SaveLocked(ctx context.Context, database *model.Dat
 	panic("not used")
 }
 
+func (f fakeDatabaseService) DumpAnonymized(ctx context.Context, userId uint, target *model.Database, instance *model.DeploymentInstance, stack *model.Stack, format string, overrides model.DumpOverrides, profileName string) (*model.Database, error) {
+	panic("not used")
+}
+
+func (f fakeDatabaseService) Delete(ctx context.Context, id uint) error {
+	panic("not used")
+}
+
+func (f fakeDatabaseService) CheckStorageQuota(ctx context.Context, groupName string, size int64) (int64, error) {
+	panic("not used")
+}
+
+func (f fakeDatabaseService) NotifyStorageQuota(ctx context.Context, userId uint, groupName string, usedBefore int64) {
+	panic("not used")
+}
+
+func (f fakeDatabaseService) Seed(ctx context.Context, database *model.Database, instance *model.DeploymentInstance, stack *model.Stack) error {
+	return nil
+}
+
+func (f fakeDatabaseService) ReleaseLock(ctx context.Context, databaseId, instanceId uint) error {
+	f.released[databaseId] = instanceId
+	return nil
+}
+
+// fakeInstanceService records the parameters the instances are updated with
+type fakeInstanceService struct {
+	instanceService
+	updated map[uint]instance.Parameters
+}
+
+func (f fakeInstanceService) UpdateInstanceParameters(ctx context.Context, deploymentId, instanceId uint, parameters instance.Parameters, public *bool) (*model.DeploymentInstance, error) {
+	f.updated[instanceId] = parameters
+	return &model.DeploymentInstance{ID: instanceId}, nil
+}
+
 func TestBuildSeed(t *testing.T) {
 	t.Setenv("HOSTNAME", "http://im")
 	s := Service{databaseService: fakeDatabaseService{byID: map[uint]*model.Database{
@@ -104,9 +151,95 @@ func TestBuildSeedNoFilestore(t *testing.T) {
 	assert.Nil(t, filestore, "no filestore backup means nothing to restore")
 }
 
+func TestBuildSeedSnapshotVersion(t *testing.T) {
+	t.Setenv("HOSTNAME", "http://im")
+	s := Service{databaseService: fakeDatabaseService{byID: map[uint]*model.Database{
+		10: {ID: 10},
+	}}}
+	instance := &model.DeploymentInstance{Parameters: model.DeploymentInstanceParameters{
+		"DATABASE_ID":               {Value: "10"},
+		"DATABASE_SNAPSHOT_VERSION": {Value: "3"},
+	}}
+
+	extraEnv, _, err := s.buildSeed(context.Background(), []*model.DeploymentInstance{instance})
+	require.NoError(t, err)
+	versionUUID := uuid.NewSHA1(uuid.NameSpaceOID, []byte("10@3")).String()
+	assert.Equal(t, "http://im/databases/external/"+versionUUID, extraEnv["DATABASE_DOWNLOAD_URL"])
+}
+
+func TestBuildSeedInvalidSnapshotVersion(t *testing.T) {
+	s := Service{databaseService: fakeDatabaseService{byID: map[uint]*model.Database{
+		10: {ID: 10},
+	}}}
+	instance := &model.DeploymentInstance{Parameters: model.DeploymentInstanceParameters{
+		"DATABASE_ID":               {Value: "10"},
+		"DATABASE_SNAPSHOT_VERSION": {Value: "latest"},
+	}}
+
+	_, _, err := s.buildSeed(context.Background(), []*model.DeploymentInstance{instance})
+	require.ErrorContains(t, err, "invalid database snapshot version")
+}
+
 func TestBuildSeedNoDatabaseID(t *testing.T) {
 	extraEnv, filestore, err := Service{}.buildSeed(context.Background(), []*model.DeploymentInstance{{}})
 	require.NoError(t, err)
 	assert.Nil(t, extraEnv, "a fresh instance with no DATABASE_ID has nothing to seed")
 	assert.Nil(t, filestore)
 }
+
+func TestIncompatibleImageTag(t *testing.T) {
+	tests := map[string]struct {
+		dhis2Version string
+		imageTag     string
+		want         bool
+	}{
+		"SameVersion":  {dhis2Version: "2.41", imageTag: "2.41.2", want: false},
+		"NewerImage":   {dhis2Version: "2.40", imageTag: "2.41.2", want: false},
+		"OlderImage":   {dhis2Version: "2.41", imageTag: "2.40.5", want: true},
+		"NewScheme":    {dhis2Version: "2.42", imageTag: "42.1.0", want: false},
+		"Suffix":       {dhis2Version: "2.42", imageTag: "2.41.3-ubuntu", want: true},
+		"NotAVersion":  {dhis2Version: "2.41", imageTag: "latest", want: false},
+		"UnknownDHIS2": {dhis2Version: "", imageTag: "2.40.5", want: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, incompatibleImageTag(test.dhis2Version, test.imageTag))
+		})
+	}
+}
+
+func TestRestoreDatabaseReleasesLockOfPreviousDatabase(t *testing.T) {
+	restore := func(t *testing.T, parameters model.DeploymentInstanceParameters, restored uint) (fakeDatabaseService, fakeInstanceService) {
+		t.Helper()
+
+		databaseService := fakeDatabaseService{released: map[uint]uint{}}
+		instanceService := fakeInstanceService{updated: map[uint]instance.Parameters{}}
+		s := Service{databaseService: databaseService, instanceService: instanceService}
+		dbInstance := &model.DeploymentInstance{ID: 3, DeploymentID: 2, Parameters: parameters}
+
+		err := s.restoreDatabase(context.Background(), dbInstance, &model.Stack{}, nil, &model.Database{ID: restored}, nil, func(string, string) {})
+
+		require.NoError(t, err)
+		assert.Equal(t, "11", instanceService.updated[3]["DATABASE_ID"].Value)
+		return databaseService, instanceService
+	}
+
+	t.Run("PreviousDatabase", func(t *testing.T) {
+		databaseService, _ := restore(t, model.DeploymentInstanceParameters{"DATABASE_ID": {Value: "10"}}, 11)
+
+		assert.Equal(t, map[uint]uint{10: 3}, databaseService.released)
+	})
+
+	t.Run("SameDatabase", func(t *testing.T) {
+		databaseService, _ := restore(t, model.DeploymentInstanceParameters{"DATABASE_ID": {Value: "11"}}, 11)
+
+		assert.Empty(t, databaseService.released, "the lock on the restored database is kept")
+	})
+
+	t.Run("NoPreviousDatabase", func(t *testing.T) {
+		databaseService, _ := restore(t, model.DeploymentInstanceParameters{}, 11)
+
+		assert.Empty(t, databaseService.released)
+	})
+}
@@ -0,0 +1,348 @@
+// Package filestore stores the manifests of filestore backups and assembles incremental backups.
+//
+// Every filestore backup, a gzip'd tar, is stored along with a manifest listing the objects of the
+// filestore at the time of the backup. Incremental backups only contain the objects which changed
+// since the backup they're based on, their parent. The content of an incremental backup is assembled
+// by walking the chain of backups from the newest to the oldest and taking the newest version of each
+// object listed by the manifest of the newest backup.
+package filestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// Manifest lists the objects of a filestore at the time it was backed up
+type Manifest struct {
+	// Parent is the key of the backup an incremental backup is based on, empty for full backups
+	Parent string `json:"parent,omitempty"`
+	// Depth is the number of backups an incremental backup is based on, 0 for full backups
+	Depth   uint     `json:"depth"`
+	Objects []Object `json:"objects"`
+}
+
+// Object is an object of a filestore
+type Object struct {
+	// Path of the object relative to the root of the filestore
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// unchanged reports whether the objects have the same content. The ETag is compared if both objects
+// have one, the modification time otherwise.
+func (o Object) unchanged(other Object) bool {
+	if o.Size != other.Size {
+		return false
+	}
+	if o.ETag != "" && other.ETag != "" {
+		return o.ETag == other.ETag
+	}
+	return o.LastModified.Truncate(time.Second).Equal(other.LastModified.Truncate(time.Second))
+}
+
+// Changed returns the objects which aren't listed by the manifest or differ from the listed object
+func (m Manifest) Changed(objects []Object) []Object {
+	listed := make(map[string]Object, len(m.Objects))
+	for _, object := range m.Objects {
+		listed[object.Path] = object
+	}
+
+	changed := []Object{}
+	for _, object := range objects {
+		previous, ok := listed[object.Path]
+		if !ok || !object.unchanged(previous) {
+			changed = append(changed, object)
+		}
+	}
+	return changed
+}
+
+// ManifestKey returns the key of the manifest of the backup stored under key
+func ManifestKey(key string) string {
+	return strings.TrimSuffix(key, ".tar.gz") + ".manifest.json"
+}
+
+// ReadManifest reads the manifest of the backup stored under key. It returns nil if the backup has
+// no manifest, like backups made before manifests were introduced.
+func ReadManifest(ctx context.Context, store storage.ObjectStore, bucket, key string) (*Manifest, error) {
+	var buf bytes.Buffer
+	err := store.Download(ctx, bucket, ManifestKey(key), &buf, func(int64) {})
+	if err != nil {
+		if errdef.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("download manifest of %q: %v", key, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest of %q: %v", key, err)
+	}
+	return &manifest, nil
+}
+
+// WriteManifest stores the manifest of the backup stored under key
+func WriteManifest(ctx context.Context, store storage.ObjectStore, bucket, key string, manifest Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("encode manifest of %q: %v", key, err)
+	}
+
+	err = store.Upload(ctx, bucket, ManifestKey(key), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("upload manifest of %q: %v", key, err)
+	}
+	return nil
+}
+
+// Chain returns the keys of the backup stored under key and the backups it's based on, newest first
+func Chain(ctx context.Context, store storage.ObjectStore, bucket, key string) ([]string, error) {
+	keys := []string{key}
+	for {
+		manifest, err := ReadManifest(ctx, store, bucket, keys[len(keys)-1])
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil || manifest.Parent == "" {
+			return keys, nil
+		}
+		for _, k := range keys {
+			if k == manifest.Parent {
+				return nil, fmt.Errorf("backup %q is its own ancestor", k)
+			}
+		}
+		keys = append(keys, manifest.Parent)
+	}
+}
+
+// Download writes the content of the filestore backed up under key into w as a gzip'd tar. Full
+// backups are downloaded as is while incremental backups are assembled from their chain.
+func Download(ctx context.Context, store storage.ObjectStore, bucket, key string, w io.Writer) error {
+	manifest, err := ReadManifest(ctx, store, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if manifest == nil || manifest.Parent == "" {
+		return store.Download(ctx, bucket, key, w, func(int64) {})
+	}
+
+	return assemble(ctx, store, bucket, key, manifest, w)
+}
+
+func assemble(ctx context.Context, store storage.ObjectStore, bucket, key string, manifest *Manifest, w io.Writer) error {
+	keys, err := Chain(ctx, store, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[string]bool, len(manifest.Objects))
+	for _, object := range manifest.Objects {
+		missing[object.Path] = true
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, k := range keys {
+		if len(missing) == 0 {
+			break
+		}
+		if err := copyObjects(ctx, store, bucket, k, tw, missing); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%d objects of %q are missing from its backups", len(missing), key)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %v", err)
+	}
+	return gw.Close()
+}
+
+// copyObjects copies the entries of the backup stored under key which are missing into tw. Copied
+// objects are no longer missing.
+func copyObjects(ctx context.Context, store storage.ObjectStore, bucket, key string, tw *tar.Writer, missing map[string]bool) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := store.Download(ctx, bucket, key, pw, func(int64) {})
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		err := copyTarGzEntries(pr, tw, missing)
+		// drain the backup so the download isn't blocked once all missing objects are copied
+		if err == nil {
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("read backup %q: %v", key, err)
+	}
+	return nil
+}
+
+func copyTarGzEntries(r io.Reader, tw *tar.Writer, missing map[string]bool) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("open gzip stream: %v", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar entry: %v", err)
+		}
+
+		// backups of the filesystem and minio backends contain keys prefixed by "./"
+		path := strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || !missing[path] {
+			continue
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write tar header for %s: %v", path, err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("copy object %s: %v", path, err)
+		}
+		delete(missing, path)
+	}
+}
+
+// Copy copies the full backup stored under source, its manifest and its index to destination
+func Copy(ctx context.Context, store storage.ObjectStore, bucket, source, destination string) error {
+	err := store.Copy(bucket, source, destination)
+	if err != nil {
+		return err
+	}
+
+	return copySidecars(ctx, store, bucket, source, destination)
+}
+
+// Assemble stores the content of the incremental backup stored under source as a full backup under
+// destination. It returns the SHA-256 checksum and the size of the full backup.
+func Assemble(ctx context.Context, store storage.ObjectStore, bucket, source, destination string) (string, int64, error) {
+	manifest, err := ReadManifest(ctx, store, bucket, source)
+	if err != nil {
+		return "", 0, err
+	}
+	if manifest == nil {
+		return "", 0, fmt.Errorf("backup %q has no manifest", source)
+	}
+
+	pr, pw := io.Pipe()
+	checksummed := storage.NewChecksumReader(pr)
+	var size int64
+
+	g, streamCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := assemble(streamCtx, store, bucket, source, manifest, pw)
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		n, err := store.StreamUpload(streamCtx, bucket, destination, "application/x-gzip", checksummed)
+		size = n
+		pr.CloseWithError(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return "", 0, err
+	}
+
+	err = WriteManifest(ctx, store, bucket, destination, Manifest{Objects: manifest.Objects})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return checksummed.Checksum(), size, nil
+}
+
+// Move moves the backup stored under source, its manifest and its index to destination. The backups
+// it's based on aren't moved.
+func Move(ctx context.Context, store storage.ObjectStore, bucket, source, destination string) error {
+	err := store.Move(bucket, source, destination)
+	if err != nil {
+		return err
+	}
+
+	err = copySidecars(ctx, store, bucket, source, destination)
+	if err != nil {
+		return err
+	}
+
+	for _, sidecar := range sidecars(source) {
+		if err := store.Delete(bucket, sidecar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the backups stored under the given keys, their manifests and their indexes
+func Delete(store storage.ObjectStore, bucket string, keys ...string) error {
+	for _, key := range keys {
+		for _, k := range append([]string{key}, sidecars(key)...) {
+			if err := store.Delete(bucket, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sidecars returns the keys of the objects stored along with the backup stored under key
+func sidecars(key string) []string {
+	return []string{ManifestKey(key), IndexKey(key)}
+}
+
+// copySidecars copies the manifest and the index of the backup stored under source, if it has them,
+// to destination. They're copied through memory since object stores don't consistently report
+// missing objects when copying or moving them.
+func copySidecars(ctx context.Context, store storage.ObjectStore, bucket, source, destination string) error {
+	for i, sidecar := range sidecars(source) {
+		var buf bytes.Buffer
+		err := store.Download(ctx, bucket, sidecar, &buf, func(int64) {})
+		if err != nil {
+			if errdef.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("download %q: %v", sidecar, err)
+		}
+
+		target := sidecars(destination)[i]
+		err = store.Upload(ctx, bucket, target, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			return fmt.Errorf("upload %q: %v", target, err)
+		}
+	}
+	return nil
+}
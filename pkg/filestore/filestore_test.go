@@ -0,0 +1,270 @@
+package filestore_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bucket = "database-bucket"
+
+func TestManifestChanged(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	manifest := filestore.Manifest{Objects: []filestore.Object{
+		{Path: "etag", Size: 3, ETag: "abc"},
+		{Path: "mtime", Size: 3, LastModified: modified},
+		{Path: "size", Size: 3, ETag: "abc"},
+	}}
+
+	changed := manifest.Changed([]filestore.Object{
+		{Path: "etag", Size: 3, ETag: "abd"},
+		{Path: "mtime", Size: 3, LastModified: modified.Add(500 * time.Millisecond)},
+		{Path: "size", Size: 4, ETag: "abc"},
+		{Path: "new", Size: 1},
+	})
+
+	var paths []string
+	for _, object := range changed {
+		paths = append(paths, object.Path)
+	}
+	assert.Equal(t, []string{"etag", "size", "new"}, paths)
+	assert.NotNil(t, manifest.Changed(nil), "no changes is an empty list of objects")
+}
+
+func TestDownload(t *testing.T) {
+	t.Run("FullBackup", func(t *testing.T) {
+		store := newStore(t)
+		backup := tarGz(t, map[string]string{"./a.txt": "a"})
+		put(t, store, "group/full-fs.tar.gz", backup)
+		writeManifest(t, store, "group/full-fs.tar.gz", filestore.Manifest{Objects: objects("a.txt")})
+
+		var buf bytes.Buffer
+		require.NoError(t, filestore.Download(context.Background(), store, bucket, "group/full-fs.tar.gz", &buf))
+
+		assert.Equal(t, backup, buf.Bytes(), "full backups are downloaded as is")
+	})
+
+	t.Run("BackupWithoutManifest", func(t *testing.T) {
+		store := newStore(t)
+		backup := tarGz(t, map[string]string{"a.txt": "a"})
+		put(t, store, "group/legacy-fs.tar.gz", backup)
+
+		var buf bytes.Buffer
+		require.NoError(t, filestore.Download(context.Background(), store, bucket, "group/legacy-fs.tar.gz", &buf))
+
+		assert.Equal(t, backup, buf.Bytes())
+	})
+
+	t.Run("IncrementalBackup", func(t *testing.T) {
+		store := newChain(t)
+
+		var buf bytes.Buffer
+		require.NoError(t, filestore.Download(context.Background(), store, bucket, "group/db-fs-2.tar.gz", &buf))
+
+		assert.Equal(t, map[string]string{
+			"kept.txt":    "kept",
+			"changed.txt": "changed twice",
+			"added.txt":   "added",
+		}, extract(t, buf.Bytes()), "the newest version of each object listed by the newest manifest is assembled")
+	})
+
+	t.Run("MissingObject", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "group/db-fs.tar.gz", tarGz(t, map[string]string{"a.txt": "a"}))
+		writeManifest(t, store, "group/db-fs.tar.gz", filestore.Manifest{Objects: objects("a.txt")})
+		put(t, store, "group/db-fs-1.tar.gz", tarGz(t, map[string]string{}))
+		writeManifest(t, store, "group/db-fs-1.tar.gz", filestore.Manifest{Parent: "group/db-fs.tar.gz", Depth: 1, Objects: objects("a.txt", "b.txt")})
+
+		err := filestore.Download(context.Background(), store, bucket, "group/db-fs-1.tar.gz", io.Discard)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 objects")
+	})
+}
+
+func TestChain(t *testing.T) {
+	store := newChain(t)
+
+	keys, err := filestore.Chain(context.Background(), store, bucket, "group/db-fs-2.tar.gz")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"group/db-fs-2.tar.gz", "group/db-fs-1.tar.gz", "group/db-fs.tar.gz"}, keys)
+}
+
+func TestChainDetectsCycles(t *testing.T) {
+	store := newStore(t)
+	writeManifest(t, store, "group/a.tar.gz", filestore.Manifest{Parent: "group/b.tar.gz", Depth: 1})
+	writeManifest(t, store, "group/b.tar.gz", filestore.Manifest{Parent: "group/a.tar.gz", Depth: 1})
+
+	_, err := filestore.Chain(context.Background(), store, bucket, "group/a.tar.gz")
+
+	require.Error(t, err)
+}
+
+func TestDelete(t *testing.T) {
+	store := newChain(t)
+	_, err := filestore.LoadIndex(context.Background(), store, bucket, "group/db-fs-2.tar.gz")
+	require.NoError(t, err)
+
+	require.NoError(t, filestore.Delete(store, bucket, "group/db-fs-2.tar.gz", "group/db-fs-1.tar.gz"))
+
+	for _, key := range []string{"group/db-fs-2.tar.gz", "group/db-fs-2.manifest.json", "group/db-fs-2.index.json", "group/db-fs-1.tar.gz", "group/db-fs-1.manifest.json"} {
+		err := store.Download(context.Background(), bucket, key, io.Discard, func(int64) {})
+		assert.True(t, errdef.IsNotFound(err), "expected %q to be deleted but got %v", key, err)
+	}
+	manifest, err := filestore.ReadManifest(context.Background(), store, bucket, "group/db-fs.tar.gz")
+	require.NoError(t, err)
+	assert.NotNil(t, manifest)
+}
+
+func TestCopy(t *testing.T) {
+	store := newChain(t)
+
+	require.NoError(t, filestore.Copy(context.Background(), store, bucket, "group/db-fs.tar.gz", "other/db-fs.tar.gz"))
+
+	var buf bytes.Buffer
+	require.NoError(t, filestore.Download(context.Background(), store, bucket, "other/db-fs.tar.gz", &buf))
+	assert.Equal(t, map[string]string{"kept.txt": "kept", "changed.txt": "original", "deleted.txt": "deleted"}, extract(t, buf.Bytes()))
+	manifest, err := filestore.ReadManifest(context.Background(), store, bucket, "other/db-fs.tar.gz")
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Len(t, manifest.Objects, 3)
+}
+
+func TestAssemble(t *testing.T) {
+	store := newChain(t)
+
+	checksum, size, err := filestore.Assemble(context.Background(), store, bucket, "group/db-fs-2.tar.gz", "other/db-fs.tar.gz")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Download(context.Background(), bucket, "other/db-fs.tar.gz", &buf, func(int64) {}))
+	assert.Equal(t, map[string]string{"kept.txt": "kept", "changed.txt": "changed twice", "added.txt": "added"}, extract(t, buf.Bytes()))
+	assert.Equal(t, int64(buf.Len()), size)
+	expectedChecksum, err := storage.Checksum(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, expectedChecksum, checksum)
+
+	manifest, err := filestore.ReadManifest(context.Background(), store, bucket, "other/db-fs.tar.gz")
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Empty(t, manifest.Parent, "the assembled backup is a full backup")
+	assert.Len(t, manifest.Objects, 3)
+}
+
+func TestMove(t *testing.T) {
+	store := newChain(t)
+	_, err := filestore.LoadIndex(context.Background(), store, bucket, "group/db-fs.tar.gz")
+	require.NoError(t, err)
+
+	require.NoError(t, filestore.Move(context.Background(), store, bucket, "group/db-fs.tar.gz", "group/renamed-fs.tar.gz"))
+
+	manifest, err := filestore.ReadManifest(context.Background(), store, bucket, "group/renamed-fs.tar.gz")
+	require.NoError(t, err)
+	assert.NotNil(t, manifest)
+	manifest, err = filestore.ReadManifest(context.Background(), store, bucket, "group/db-fs.tar.gz")
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+
+	require.NoError(t, store.Download(context.Background(), bucket, "group/renamed-fs.index.json", io.Discard, func(int64) {}))
+	err = store.Download(context.Background(), bucket, "group/db-fs.index.json", io.Discard, func(int64) {})
+	assert.True(t, errdef.IsNotFound(err), "the index is moved along with the backup")
+}
+
+// newChain stores a full backup and two incremental backups based on it
+func newChain(t *testing.T) *storage.FilesystemStore {
+	t.Helper()
+
+	store := newStore(t)
+
+	put(t, store, "group/db-fs.tar.gz", tarGz(t, map[string]string{"./kept.txt": "kept", "./changed.txt": "original", "./deleted.txt": "deleted"}))
+	writeManifest(t, store, "group/db-fs.tar.gz", filestore.Manifest{Objects: objects("kept.txt", "changed.txt", "deleted.txt")})
+
+	put(t, store, "group/db-fs-1.tar.gz", tarGz(t, map[string]string{"./changed.txt": "changed once"}))
+	writeManifest(t, store, "group/db-fs-1.tar.gz", filestore.Manifest{Parent: "group/db-fs.tar.gz", Depth: 1, Objects: objects("kept.txt", "changed.txt", "deleted.txt")})
+
+	put(t, store, "group/db-fs-2.tar.gz", tarGz(t, map[string]string{"changed.txt": "changed twice", "added.txt": "added"}))
+	writeManifest(t, store, "group/db-fs-2.tar.gz", filestore.Manifest{Parent: "group/db-fs-1.tar.gz", Depth: 2, Objects: objects("kept.txt", "changed.txt", "added.txt")})
+
+	return store
+}
+
+func newStore(t *testing.T) *storage.FilesystemStore {
+	t.Helper()
+
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, bucket), 0o750))
+	store, err := storage.NewFilesystemStore(root)
+	require.NoError(t, err)
+	return store
+}
+
+func put(t *testing.T, store storage.ObjectStore, key string, data []byte) {
+	t.Helper()
+
+	require.NoError(t, store.Upload(context.Background(), bucket, key, bytes.NewReader(data), int64(len(data))))
+}
+
+func writeManifest(t *testing.T, store storage.ObjectStore, key string, manifest filestore.Manifest) {
+	t.Helper()
+
+	require.NoError(t, filestore.WriteManifest(context.Background(), store, bucket, key, manifest))
+}
+
+func objects(paths ...string) []filestore.Object {
+	objects := make([]filestore.Object, len(paths))
+	for i, path := range paths {
+		objects[i] = filestore.Object{Path: path}
+	}
+	return objects
+}
+
+func tarGz(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func extract(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer gr.Close()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries[filepath.Clean(header.Name)] = string(content)
+	}
+}
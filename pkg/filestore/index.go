@@ -0,0 +1,402 @@
+package filestore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxRangeLength is the length of the largest gzip member which is downloaded into memory to extract
+// a file. Files of larger members are extracted by streaming the backup.
+const maxRangeLength = 16 << 20
+
+// Index lists the files of a backup along with their location in the backup so single files can be
+// extracted without reading the whole backup.
+//
+// A gzip'd tar can consist of several gzip members. Backups made by the S3 API source store every
+// object in a gzip member of its own so extracting an object only downloads its member. Backups made
+// by tar in a pod are a single gzip member which has to be read up to the extracted file.
+type Index struct {
+	Files []IndexEntry `json:"files"`
+}
+
+// IndexEntry is a file of a backup
+type IndexEntry struct {
+	// Path of the file relative to the root of the filestore
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Offset and Length locate the gzip member containing the file in the backup
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	// Skip is the offset of the tar header of the file in the decompressed member
+	Skip int64 `json:"skip"`
+}
+
+// IndexKey returns the key of the index of the backup stored under key
+func IndexKey(key string) string {
+	return strings.TrimSuffix(key, ".tar.gz") + ".index.json"
+}
+
+// BuildIndex indexes the gzip'd tar read from r. The whole stream is read, even after the end of
+// the tar, so it can be used as the sink of a tee.
+func BuildIndex(r io.Reader) (*Index, error) {
+	members := &memberReader{r: &countingReader{r: bufio.NewReader(r)}}
+	tr := tar.NewReader(members)
+
+	type indexed struct {
+		entry  IndexEntry
+		member int
+	}
+	var entries []indexed
+	// next is the offset of the next tar header in the decompressed backup
+	var next int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar entry: %v", err)
+		}
+
+		start := next
+		// tar pads the content of entries to blocks of 512 bytes
+		next = (members.n + header.Size + 511) &^ 511
+
+		name := strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || name == "" {
+			continue
+		}
+
+		member := members.find(start)
+		entries = append(entries, indexed{
+			entry: IndexEntry{
+				Path:    name,
+				Size:    header.Size,
+				ModTime: header.ModTime,
+				Offset:  members.members[member].offset,
+				Skip:    start - members.members[member].start,
+			},
+			member: member,
+		})
+	}
+
+	if _, err := io.Copy(io.Discard, members); err != nil {
+		return nil, fmt.Errorf("read end of backup: %v", err)
+	}
+
+	index := &Index{Files: make([]IndexEntry, len(entries))}
+	for i, e := range entries {
+		end := members.r.n
+		if e.member+1 < len(members.members) {
+			end = members.members[e.member+1].offset
+		}
+		e.entry.Length = end - e.entry.Offset
+		index.Files[i] = e.entry
+	}
+	return index, nil
+}
+
+// countingReader counts the bytes read. It's a byte reader so gzip doesn't read ahead of the end of
+// a member.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+type member struct {
+	// offset of the member in the backup
+	offset int64
+	// start is the offset of the member in the decompressed backup
+	start int64
+}
+
+// memberReader decompresses a gzip stream member by member keeping track of where the members start
+type memberReader struct {
+	r       *countingReader
+	gr      gzip.Reader
+	open    bool
+	members []member
+	// n is the number of decompressed bytes read
+	n int64
+}
+
+func (m *memberReader) Read(p []byte) (int, error) {
+	for {
+		if !m.open {
+			if _, err := m.r.r.Peek(1); err != nil {
+				return 0, err
+			}
+			m.members = append(m.members, member{offset: m.r.n, start: m.n})
+			if err := m.gr.Reset(m.r); err != nil {
+				return 0, err
+			}
+			m.gr.Multistream(false)
+			m.open = true
+		}
+
+		n, err := m.gr.Read(p)
+		m.n += int64(n)
+		if err == io.EOF {
+			m.open = false
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// find returns the index of the member containing the given offset of the decompressed backup
+func (m *memberReader) find(offset int64) int {
+	return sort.Search(len(m.members), func(i int) bool { return m.members[i].start > offset }) - 1
+}
+
+// WriteIndex stores the index of the backup stored under key
+func WriteIndex(ctx context.Context, store storage.ObjectStore, bucket, key string, index Index) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("encode index of %q: %v", key, err)
+	}
+
+	err = store.Upload(ctx, bucket, IndexKey(key), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("upload index of %q: %v", key, err)
+	}
+	return nil
+}
+
+// LoadIndex reads the index of the backup stored under key. Backups without an index, like backups
+// made before indexes were introduced, are indexed and the index is stored.
+func LoadIndex(ctx context.Context, store storage.ObjectStore, bucket, key string) (*Index, error) {
+	var buf bytes.Buffer
+	err := store.Download(ctx, bucket, IndexKey(key), &buf, func(int64) {})
+	if err == nil {
+		var index Index
+		if err := json.Unmarshal(buf.Bytes(), &index); err != nil {
+			return nil, fmt.Errorf("decode index of %q: %v", key, err)
+		}
+		return &index, nil
+	}
+	if !errdef.IsNotFound(err) {
+		return nil, fmt.Errorf("download index of %q: %v", key, err)
+	}
+
+	pr, pw := io.Pipe()
+	var index *Index
+	g, downloadCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := store.Download(downloadCtx, bucket, key, pw, func(int64) {})
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		index, err = BuildIndex(pr)
+		pr.CloseWithError(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("index backup %q: %v", key, err)
+	}
+
+	err = WriteIndex(ctx, store, bucket, key, *index)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// File is a file of a filestore
+type File struct {
+	IndexEntry
+	// Key of the backup containing the file
+	Key string `json:"-"`
+}
+
+// Files lists the files of the filestore backed up under key ordered by path. The files of
+// incremental backups are looked up in the backups they're based on.
+func Files(ctx context.Context, store storage.ObjectStore, bucket, key string) ([]File, error) {
+	manifest, err := ReadManifest(ctx, store, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := Chain(ctx, store, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var listed map[string]bool
+	if manifest != nil {
+		listed = make(map[string]bool, len(manifest.Objects))
+		for _, object := range manifest.Objects {
+			listed[object.Path] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	files := []File{}
+	for _, k := range keys {
+		index, err := LoadIndex(ctx, store, bucket, k)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range index.Files {
+			if seen[entry.Path] || (listed != nil && !listed[entry.Path]) {
+				continue
+			}
+			seen[entry.Path] = true
+			files = append(files, File{IndexEntry: entry, Key: k})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// FindFile finds the file with the given path of the filestore backed up under key
+func FindFile(ctx context.Context, store storage.ObjectStore, bucket, key, path string) (*File, error) {
+	files, err := Files(ctx, store, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(files), func(i int) bool { return files[i].Path >= path })
+	if i == len(files) || files[i].Path != path {
+		return nil, errdef.NewNotFound("file %q not found", path)
+	}
+	return &files[i], nil
+}
+
+// Extract writes the content of the file into w
+func Extract(ctx context.Context, store storage.ObjectStore, bucket string, file File, w io.Writer) error {
+	var r io.Reader
+	if file.Length <= maxRangeLength {
+		data, err := store.DownloadRange(ctx, bucket, file.Key, file.Offset, file.Length)
+		if err != nil {
+			return fmt.Errorf("download %q: %v", file.Key, err)
+		}
+		r = bytes.NewReader(data)
+	} else {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			err := store.Download(ctx, bucket, file.Key, pw, func(int64) {})
+			pw.CloseWithError(err)
+		}()
+
+		if _, err := io.CopyN(io.Discard, pr, file.Offset); err != nil {
+			return fmt.Errorf("download %q: %v", file.Key, err)
+		}
+		r = pr
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("open gzip member of %s: %v", file.Path, err)
+	}
+	defer gr.Close()
+	gr.Multistream(false)
+
+	if _, err := io.CopyN(io.Discard, gr, file.Skip); err != nil {
+		return fmt.Errorf("seek %s: %v", file.Path, err)
+	}
+
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("read tar header of %s: %v", file.Path, err)
+	}
+	if strings.TrimPrefix(header.Name, "./") != file.Path {
+		return fmt.Errorf("expected %s in %q but found %s", file.Path, file.Key, header.Name)
+	}
+
+	if _, err := io.Copy(w, tr); err != nil {
+		return fmt.Errorf("copy %s: %v", file.Path, err)
+	}
+	return nil
+}
+
+// DirEntry is a file or a directory of a directory of a filestore
+// swagger:model
+type DirEntry struct {
+	Name string `json:"name"`
+	// Path of the entry relative to the root of the filestore
+	Path string `json:"path"`
+	Dir  bool   `json:"dir"`
+	// Size of the file or the total size of the files of the directory
+	Size int64 `json:"size"`
+	// ModTime of the file or the latest modification time of the files of the directory
+	ModTime time.Time `json:"modTime"`
+}
+
+// ReadDir lists the entries of the directory with the given path, "" being the root, ordered by name
+func ReadDir(files []File, dir string) ([]DirEntry, error) {
+	dir = strings.Trim(dir, "/")
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	entries := []DirEntry{}
+	byName := map[string]int{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+
+		name, rest, isDir := strings.Cut(strings.TrimPrefix(file.Path, prefix), "/")
+		i, ok := byName[name]
+		if !ok {
+			i = len(entries)
+			byName[name] = i
+			entries = append(entries, DirEntry{Name: name, Path: path.Join(dir, name), Dir: isDir && rest != ""})
+		}
+
+		entries[i].Size += file.Size
+		if file.ModTime.After(entries[i].ModTime) {
+			entries[i].ModTime = file.ModTime
+		}
+	}
+
+	if len(entries) == 0 && dir != "" {
+		return nil, errdef.NewNotFound("directory %q not found", dir)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
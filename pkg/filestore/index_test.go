@@ -0,0 +1,176 @@
+package filestore_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIndex(t *testing.T) {
+	t.Run("MemberPerFile", func(t *testing.T) {
+		backup := tarGzMembers(t, []string{"apps/a.txt", "b.txt"}, map[string]string{"apps/a.txt": "aaa", "b.txt": "bbbbb"})
+
+		index, err := filestore.BuildIndex(bytes.NewReader(backup))
+		require.NoError(t, err)
+
+		require.Len(t, index.Files, 2)
+		a, b := index.Files[0], index.Files[1]
+		assert.Equal(t, "apps/a.txt", a.Path)
+		assert.Equal(t, int64(3), a.Size)
+		assert.Equal(t, int64(0), a.Offset)
+		assert.Equal(t, int64(0), a.Skip)
+		assert.Equal(t, "b.txt", b.Path)
+		assert.Equal(t, a.Offset+a.Length, b.Offset, "every file has a gzip member of its own")
+		assert.Equal(t, int64(0), b.Skip)
+		assert.Less(t, b.Offset+b.Length, int64(len(backup)), "the end of the tar is in a member of its own")
+	})
+
+	t.Run("SingleMember", func(t *testing.T) {
+		backup := tarGz(t, map[string]string{"./apps/a.txt": "aaa"})
+
+		index, err := filestore.BuildIndex(bytes.NewReader(backup))
+		require.NoError(t, err)
+
+		require.Len(t, index.Files, 1)
+		assert.Equal(t, "apps/a.txt", index.Files[0].Path, "the ./ prefix of tar made backups is removed")
+		assert.Equal(t, int64(len(backup)), index.Files[0].Length)
+	})
+
+	t.Run("SkipsDirectories", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./apps/", Typeflag: tar.TypeDir, Mode: 0o755}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./apps/a.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3}))
+		_, err := tw.Write([]byte("aaa"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		index, err := filestore.BuildIndex(&buf)
+		require.NoError(t, err)
+
+		require.Len(t, index.Files, 1)
+		assert.Equal(t, "apps/a.txt", index.Files[0].Path)
+		assert.Equal(t, int64(2*512), index.Files[0].Skip, "the header of the file follows the headers of both directories")
+	})
+}
+
+func TestExtract(t *testing.T) {
+	contents := map[string]string{"apps/a.txt": "aaa", "b.txt": "bbbbb", "c.txt": "c"}
+	for name, backup := range map[string][]byte{
+		"MemberPerFile": tarGzMembers(t, []string{"apps/a.txt", "b.txt", "c.txt"}, contents),
+		"SingleMember":  tarGz(t, contents),
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			put(t, store, "group/db-fs.tar.gz", backup)
+
+			for path, content := range contents {
+				file, err := filestore.FindFile(context.Background(), store, bucket, "group/db-fs.tar.gz", path)
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+				require.NoError(t, filestore.Extract(context.Background(), store, bucket, *file, &buf))
+
+				assert.Equal(t, content, buf.String())
+			}
+		})
+	}
+}
+
+func TestFiles(t *testing.T) {
+	store := newChain(t)
+
+	files, err := filestore.Files(context.Background(), store, bucket, "group/db-fs-2.tar.gz")
+	require.NoError(t, err)
+
+	keys := map[string]string{}
+	for _, file := range files {
+		keys[file.Path] = file.Key
+	}
+	assert.Equal(t, map[string]string{
+		"added.txt":   "group/db-fs-2.tar.gz",
+		"changed.txt": "group/db-fs-2.tar.gz",
+		"kept.txt":    "group/db-fs.tar.gz",
+	}, keys, "files are looked up in the newest backup containing them")
+	assert.Equal(t, "added.txt", files[0].Path)
+
+	index, err := filestore.LoadIndex(context.Background(), store, bucket, "group/db-fs.tar.gz")
+	require.NoError(t, err)
+	assert.Len(t, index.Files, 3, "backups are indexed when they're browsed")
+
+	file, err := filestore.FindFile(context.Background(), store, bucket, "group/db-fs-2.tar.gz", "kept.txt")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, filestore.Extract(context.Background(), store, bucket, *file, &buf))
+	assert.Equal(t, "kept", buf.String())
+
+	_, err = filestore.FindFile(context.Background(), store, bucket, "group/db-fs-2.tar.gz", "deleted.txt")
+	assert.True(t, errdef.IsNotFound(err), "files deleted since a backup aren't found")
+}
+
+func TestReadDir(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	files := []filestore.File{
+		{IndexEntry: filestore.IndexEntry{Path: "apps/a/index.html", Size: 1, ModTime: modified}},
+		{IndexEntry: filestore.IndexEntry{Path: "apps/b.zip", Size: 2, ModTime: modified.Add(time.Hour)}},
+		{IndexEntry: filestore.IndexEntry{Path: "document.pdf", Size: 4, ModTime: modified}},
+	}
+
+	t.Run("Root", func(t *testing.T) {
+		entries, err := filestore.ReadDir(files, "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []filestore.DirEntry{
+			{Name: "apps", Path: "apps", Dir: true, Size: 3, ModTime: modified.Add(time.Hour)},
+			{Name: "document.pdf", Path: "document.pdf", Size: 4, ModTime: modified},
+		}, entries)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		entries, err := filestore.ReadDir(files, "/apps/")
+		require.NoError(t, err)
+
+		assert.Equal(t, []filestore.DirEntry{
+			{Name: "a", Path: "apps/a", Dir: true, Size: 1, ModTime: modified},
+			{Name: "b.zip", Path: "apps/b.zip", Size: 2, ModTime: modified.Add(time.Hour)},
+		}, entries)
+	})
+
+	t.Run("MissingDirectory", func(t *testing.T) {
+		_, err := filestore.ReadDir(files, "userAvatar")
+
+		assert.True(t, errdef.IsNotFound(err))
+	})
+}
+
+// tarGzMembers writes every file as a gzip member of its own like the S3 API source does
+func tarGzMembers(t *testing.T, paths []string, contents map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, path := range paths {
+		content := contents[path]
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: path, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, tw.Flush())
+		require.NoError(t, gw.Close())
+		gw.Reset(&buf)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
@@ -49,7 +49,7 @@ func TestGroupHandler(t *testing.T) {
 
 	newClient := func(u *model.User) *inttest.HTTPClient {
 		return inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
-			handler := group.NewHandler(groupService)
+			handler := group.NewHandler(groupService, storageService{})
 			authentication := TestAuthenticationMiddleware{user: u}
 			authorization := TestAuthorizationMiddleware{}
 			group.Routes(engine, authentication, authorization, handler)
@@ -275,6 +275,12 @@ func TestGroupHandler(t *testing.T) {
 	})
 }
 
+type storageService struct{}
+
+func (s storageService) StorageUsage(ctx context.Context, groupName string) (*model.StorageUsage, error) {
+	return &model.StorageUsage{Users: []model.UserStorageUsage{}}, nil
+}
+
 type fakeDialer struct{}
 
 func (f fakeDialer) DialAndSend(m ...*mail.Message) error {
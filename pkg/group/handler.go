@@ -1,6 +1,7 @@
 package group
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
@@ -8,17 +9,24 @@ import (
 	"github.com/dhis2-sre/im-manager/internal/errdef"
 
 	"github.com/dhis2-sre/im-manager/internal/handler"
+	"github.com/dhis2-sre/im-manager/pkg/model"
 	"github.com/gin-gonic/gin"
 )
 
-func NewHandler(groupService *Service) Handler {
+func NewHandler(groupService *Service, storageService storageService) Handler {
 	return Handler{
-		groupService: groupService,
+		groupService:   groupService,
+		storageService: storageService,
 	}
 }
 
+type storageService interface {
+	StorageUsage(ctx context.Context, groupName string) (*model.StorageUsage, error)
+}
+
 type Handler struct {
-	groupService *Service
+	groupService   *Service
+	storageService storageService
 }
 
 type CreateGroupRequest struct {
@@ -69,6 +77,8 @@ type UpdateGroupRequest struct {
 	Hostname    string `json:"hostname" binding:"required"`
 	Deployable  bool   `json:"deployable"`
 	ClusterID   *uint  `json:"clusterId"`
+	// StorageQuota in bytes, 0 if unlimited. The quota is kept if omitted
+	StorageQuota *int64 `json:"storageQuota"`
 }
 
 // Update group
@@ -97,7 +107,7 @@ func (h Handler) Update(c *gin.Context) {
 		return
 	}
 
-	group, err := h.groupService.Update(c.Request.Context(), name, request.Namespace, request.Description, request.Hostname, request.Deployable, request.ClusterID)
+	group, err := h.groupService.Update(c.Request.Context(), name, request.Namespace, request.Description, request.Hostname, request.Deployable, request.ClusterID, request.StorageQuota)
 	if err != nil {
 		if errdef.IsNotFound(err) {
 			_ = c.AbortWithError(http.StatusNotFound, err)
@@ -299,7 +309,7 @@ func (h Handler) FindWithDetails(c *gin.Context) {
 	//
 	// Find group with details
 	//
-	// Find a group by its name with details
+	// Find a group by its name with details including its storage usage
 	//
 	// responses:
 	//   200: Group
@@ -329,6 +339,13 @@ func (h Handler) FindWithDetails(c *gin.Context) {
 		return
 	}
 
+	usage, err := h.storageService.StorageUsage(c.Request.Context(), name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	group.StorageUsage = usage
+
 	c.JSON(http.StatusOK, group)
 }
 
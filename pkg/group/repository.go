@@ -179,18 +179,23 @@ func (r repository) findByGroupNames(ctx context.Context, groupNames []string) (
 	return databases, err
 }
 
-func (r repository) update(ctx context.Context, name, namespace, description, hostname string, deployable bool, clusterID *uint) error {
+func (r repository) update(ctx context.Context, name, namespace, description, hostname string, deployable bool, clusterID *uint, storageQuota *int64) error {
 	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
 	// cancellation can lead to rollbacks which we should decide individually.
 	ctx = context.WithoutCancel(ctx)
 
-	err := r.db.WithContext(ctx).Model(&model.Group{Name: name}).Updates(map[string]interface{}{
+	values := map[string]interface{}{
 		"namespace":   namespace,
 		"description": description,
 		"hostname":    hostname,
 		"deployable":  deployable,
 		"cluster_id":  clusterID,
-	}).Error
+	}
+	if storageQuota != nil {
+		values["storage_quota"] = *storageQuota
+	}
+
+	err := r.db.WithContext(ctx).Model(&model.Group{Name: name}).Updates(values).Error
 	if errors.Is(err, gorm.ErrDuplicatedKey) {
 		return errdef.NewDuplicated("group hostname already exists: %s", err)
 	}
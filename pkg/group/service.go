@@ -3,6 +3,8 @@ package group
 import (
 	"context"
 
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+
 	"github.com/dhis2-sre/im-manager/pkg/cluster"
 
 	"github.com/dhis2-sre/im-manager/pkg/instance"
@@ -133,19 +135,24 @@ func (s *Service) RemoveAdminUser(ctx context.Context, groupName string, userId
 	return s.groupRepository.removeAdminUser(ctx, group, u)
 }
 
-func (s *Service) Update(ctx context.Context, name, namespace, description, hostname string, deployable bool, clusterID *uint) (*model.Group, error) {
+// Update updates the group. The storage quota is kept if nil.
+func (s *Service) Update(ctx context.Context, name, namespace, description, hostname string, deployable bool, clusterID *uint, storageQuota *int64) (*model.Group, error) {
 	_, err := s.groupRepository.find(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
+	if storageQuota != nil && *storageQuota < 0 {
+		return nil, errdef.NewBadRequest("storage quota must not be negative")
+	}
+
 	if clusterID != nil {
 		if _, err = s.clusterService.Find(ctx, *clusterID); err != nil {
 			return nil, err
 		}
 	}
 
-	if err = s.groupRepository.update(ctx, name, namespace, description, hostname, deployable, clusterID); err != nil {
+	if err = s.groupRepository.update(ctx, name, namespace, description, hostname, deployable, clusterID, storageQuota); err != nil {
 		return nil, err
 	}
 
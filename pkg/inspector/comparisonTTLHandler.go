@@ -0,0 +1,45 @@
+package inspector
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+func NewComparisonTTLHandler(logger *slog.Logger, comparisonService comparisonService) comparisonTTLHandler {
+	return comparisonTTLHandler{logger, comparisonService}
+}
+
+type comparisonService interface {
+	Delete(ctx context.Context, id uint) error
+}
+
+// comparisonTTLHandler destroys all deployments of a comparison together once one of them expires
+type comparisonTTLHandler struct {
+	logger            *slog.Logger
+	comparisonService comparisonService
+}
+
+func (t comparisonTTLHandler) Handle(ctx context.Context, deployment model.Deployment) error {
+	if deployment.ComparisonID == nil || !ttlBeforeNow(deployment.CreatedAt, deployment.TTL) {
+		return nil
+	}
+
+	comparisonID := *deployment.ComparisonID
+	t.logger.Info("Comparison TTL handler invoked", "comparisonId", comparisonID, "deploymentId", deployment.ID)
+
+	err := t.comparisonService.Delete(ctx, comparisonID)
+	if err != nil {
+		// the comparison was already destroyed while handling another of its deployments
+		if errdef.IsNotFound(err) {
+			return nil
+		}
+		t.logger.ErrorContext(ctx, "Comparison TTL destroy failed", "comparisonId", comparisonID, "error", err)
+		return err
+	}
+	t.logger.Info("Comparison TTL destroy completed", "comparisonId", comparisonID)
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package inspector
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ComparisonTTLHandler_NotExpired(t *testing.T) {
+	comparisonID := uint(1)
+	comparisonService := &mockComparisonService{}
+	handler := NewComparisonTTLHandler(slog.Default(), comparisonService)
+
+	deployment := model.Deployment{
+		CreatedAt:    time.Now(),
+		TTL:          300,
+		ComparisonID: &comparisonID,
+	}
+
+	err := handler.Handle(context.TODO(), deployment)
+
+	require.NoError(t, err)
+	comparisonService.AssertExpectations(t)
+}
+
+func Test_ComparisonTTLHandler_Expired(t *testing.T) {
+	ctx := context.TODO()
+	comparisonID := uint(1)
+	deployment := model.Deployment{
+		ID:           123,
+		CreatedAt:    time.Now().Add(time.Minute * -10),
+		TTL:          300,
+		ComparisonID: &comparisonID,
+	}
+	comparisonService := &mockComparisonService{}
+	comparisonService.On("Delete", ctx, comparisonID).Return(nil)
+
+	handler := NewComparisonTTLHandler(slog.Default(), comparisonService)
+
+	err := handler.Handle(ctx, deployment)
+
+	require.NoError(t, err)
+	comparisonService.AssertExpectations(t)
+}
+
+func Test_ComparisonTTLHandler_AlreadyDestroyed(t *testing.T) {
+	ctx := context.TODO()
+	comparisonID := uint(1)
+	deployment := model.Deployment{
+		ID:           123,
+		CreatedAt:    time.Now().Add(time.Minute * -10),
+		TTL:          300,
+		ComparisonID: &comparisonID,
+	}
+	comparisonService := &mockComparisonService{}
+	comparisonService.On("Delete", ctx, comparisonID).Return(errdef.NewNotFound("comparison not found by id: %d", comparisonID))
+
+	handler := NewComparisonTTLHandler(slog.Default(), comparisonService)
+
+	err := handler.Handle(ctx, deployment)
+
+	require.NoError(t, err)
+	comparisonService.AssertExpectations(t)
+}
+
+type mockComparisonService struct{ mock.Mock }
+
+func (m *mockComparisonService) Delete(ctx context.Context, id uint) error {
+	called := m.Called(ctx, id)
+	return called.Error(0)
+}
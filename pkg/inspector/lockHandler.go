@@ -0,0 +1,66 @@
+package inspector
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dhis2-sre/im-manager/pkg/instance"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+func NewLockHandler(logger *slog.Logger, lockService lockService, instanceService instanceStatusService) lockHandler {
+	return lockHandler{logger, lockService, instanceService}
+}
+
+type lockService interface {
+	RenewLocks(ctx context.Context, instanceIds []uint) error
+}
+
+type instanceStatusService interface {
+	FindDeploymentInstanceById(ctx context.Context, id uint) (*model.DeploymentInstance, error)
+	GetStatus(instance *model.DeploymentInstance) (instance.InstanceStatus, error)
+}
+
+// lockHandler renews the database locks held by the running instances of a deployment. Locks of
+// instances which aren't running, because they are paused, failing or gone, aren't renewed so they
+// expire and can be taken over.
+type lockHandler struct {
+	logger          *slog.Logger
+	lockService     lockService
+	instanceService instanceStatusService
+}
+
+func (l lockHandler) Handle(ctx context.Context, deployment model.Deployment) error {
+	var instanceIds []uint
+	for _, deploymentInstance := range deployment.Instances {
+		if l.isRunning(ctx, deploymentInstance.ID) {
+			instanceIds = append(instanceIds, deploymentInstance.ID)
+		}
+	}
+
+	err := l.lockService.RenewLocks(ctx, instanceIds)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "Failed to renew database locks", "deploymentId", deployment.ID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// isRunning returns whether the instance is running. Instances whose status can't be determined
+// are considered not running, their locks are renewed once the status is known again.
+func (l lockHandler) isRunning(ctx context.Context, instanceId uint) bool {
+	deploymentInstance, err := l.instanceService.FindDeploymentInstanceById(ctx, instanceId)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "Failed to find instance", "instanceId", instanceId, "error", err)
+		return false
+	}
+
+	status, err := l.instanceService.GetStatus(deploymentInstance)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "Failed to get instance status", "instanceId", instanceId, "error", err)
+		return false
+	}
+
+	return status == instance.Running
+}
@@ -0,0 +1,112 @@
+package inspector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/pkg/instance"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LockHandler_Renews(t *testing.T) {
+	ctx := context.TODO()
+	deployment := model.Deployment{
+		ID: 1,
+		Instances: []*model.DeploymentInstance{
+			{ID: 2},
+			{ID: 3},
+		},
+	}
+	instanceService := &mockInstanceStatusService{}
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(2)).Return(&model.DeploymentInstance{ID: 2}, nil)
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(3)).Return(&model.DeploymentInstance{ID: 3}, nil)
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 2}).Return(instance.Running, nil)
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 3}).Return(instance.Running, nil)
+	lockService := &mockLockService{}
+	lockService.On("RenewLocks", ctx, []uint{2, 3}).Return(nil)
+
+	handler := NewLockHandler(slog.Default(), lockService, instanceService)
+
+	err := handler.Handle(ctx, deployment)
+
+	require.NoError(t, err)
+	lockService.AssertExpectations(t)
+	instanceService.AssertExpectations(t)
+}
+
+func Test_LockHandler_SkipsInstancesNotRunning(t *testing.T) {
+	ctx := context.TODO()
+	deployment := model.Deployment{
+		ID: 1,
+		Instances: []*model.DeploymentInstance{
+			{ID: 2},
+			{ID: 3},
+			{ID: 4},
+			{ID: 5},
+		},
+	}
+	instanceService := &mockInstanceStatusService{}
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(2)).Return(&model.DeploymentInstance{ID: 2}, nil)
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(3)).Return(&model.DeploymentInstance{ID: 3}, nil)
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(4)).Return(&model.DeploymentInstance{ID: 4}, nil)
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(5)).Return((*model.DeploymentInstance)(nil), errors.New("not found"))
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 2}).Return(instance.NotDeployed, nil)
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 3}).Return(instance.Running, nil)
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 4}).Return(instance.InstanceStatus(""), errors.New("cluster unavailable"))
+	lockService := &mockLockService{}
+	lockService.On("RenewLocks", ctx, []uint{3}).Return(nil)
+
+	handler := NewLockHandler(slog.Default(), lockService, instanceService)
+
+	err := handler.Handle(ctx, deployment)
+
+	require.NoError(t, err)
+	lockService.AssertExpectations(t)
+	instanceService.AssertExpectations(t)
+}
+
+func Test_LockHandler_RenewFailed(t *testing.T) {
+	ctx := context.TODO()
+	deployment := model.Deployment{
+		ID: 1,
+		Instances: []*model.DeploymentInstance{
+			{ID: 2},
+		},
+	}
+	instanceService := &mockInstanceStatusService{}
+	instanceService.On("FindDeploymentInstanceById", ctx, uint(2)).Return(&model.DeploymentInstance{ID: 2}, nil)
+	instanceService.On("GetStatus", &model.DeploymentInstance{ID: 2}).Return(instance.Running, nil)
+	lockService := &mockLockService{}
+	lockService.On("RenewLocks", ctx, []uint{2}).Return(errors.New("renew failed"))
+
+	handler := NewLockHandler(slog.Default(), lockService, instanceService)
+
+	err := handler.Handle(ctx, deployment)
+
+	require.ErrorContains(t, err, "renew failed")
+	lockService.AssertExpectations(t)
+}
+
+type mockLockService struct{ mock.Mock }
+
+func (m *mockLockService) RenewLocks(ctx context.Context, instanceIds []uint) error {
+	called := m.Called(ctx, instanceIds)
+	return called.Error(0)
+}
+
+type mockInstanceStatusService struct{ mock.Mock }
+
+func (m *mockInstanceStatusService) FindDeploymentInstanceById(ctx context.Context, id uint) (*model.DeploymentInstance, error) {
+	called := m.Called(ctx, id)
+	return called.Get(0).(*model.DeploymentInstance), called.Error(1)
+}
+
+func (m *mockInstanceStatusService) GetStatus(deploymentInstance *model.DeploymentInstance) (instance.InstanceStatus, error) {
+	called := m.Called(deploymentInstance)
+	return called.Get(0).(instance.InstanceStatus), called.Error(1)
+}
@@ -13,7 +13,7 @@ func NewTTLDestroyHandler(logger *slog.Logger, instanceService instanceService)
 }
 
 type instanceService interface {
-	DeleteDeployment(ctx context.Context, deployment *model.Deployment) error
+	TrashDeployment(ctx context.Context, deployment *model.Deployment) error
 	FindDecryptedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error)
 }
 
@@ -23,16 +23,21 @@ type ttlDestroyHandler struct {
 }
 
 func (t ttlDestroyHandler) Handle(ctx context.Context, deployment model.Deployment) error {
+	// deployments belonging to a comparison are destroyed together by the comparison TTL handler
+	if deployment.ComparisonID != nil {
+		return nil
+	}
+
 	t.logger.Info("TTL handler invoked", "deploymentId", deployment.ID)
 
-	if t.ttlBeforeNow(deployment.CreatedAt, deployment.TTL) {
+	if ttlBeforeNow(deployment.CreatedAt, deployment.TTL) {
 		decryptedDeployment, err := t.instanceService.FindDecryptedDeploymentById(ctx, deployment.ID)
 		if err != nil {
 			t.logger.ErrorContext(ctx, "TTL handler failed to decrypt deployment", "deploymentId", deployment.ID, "error", err)
 			return err
 		}
 
-		err = t.instanceService.DeleteDeployment(ctx, decryptedDeployment)
+		err = t.instanceService.TrashDeployment(ctx, decryptedDeployment)
 		if err != nil {
 			t.logger.ErrorContext(ctx, "TTL destroy failed", "deploymentId", deployment.ID, "error", err)
 			return err
@@ -45,7 +50,7 @@ func (t ttlDestroyHandler) Handle(ctx context.Context, deployment model.Deployme
 
 // ttlBeforeNow returns true if creationTimestamp + ttl is before now.
 // ttl is the deployments time-to-live in seconds.
-func (t ttlDestroyHandler) ttlBeforeNow(creationTimestamp time.Time, ttl uint) bool {
+func ttlBeforeNow(creationTimestamp time.Time, ttl uint) bool {
 	expiration := creationTimestamp.Add(time.Duration(ttl) * time.Second)
 	return expiration.Before(time.Now())
 }
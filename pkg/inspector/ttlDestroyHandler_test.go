@@ -41,7 +41,7 @@ func Test_TTLDestroyHandler_Expired(t *testing.T) {
 	}
 	instanceService := &mockInstanceService{}
 	instanceService.On("FindDecryptedDeploymentById", ctx, deployment.ID).Return(decryptedDeployment, nil)
-	instanceService.On("DeleteDeployment", ctx, decryptedDeployment).Return(nil)
+	instanceService.On("TrashDeployment", ctx, decryptedDeployment).Return(nil)
 
 	handler := NewTTLDestroyHandler(slog.Default(), instanceService)
 
@@ -51,9 +51,27 @@ func Test_TTLDestroyHandler_Expired(t *testing.T) {
 	instanceService.AssertExpectations(t)
 }
 
+func Test_TTLDestroyHandler_SkipsComparisonDeployments(t *testing.T) {
+	comparisonID := uint(1)
+	deployment := model.Deployment{
+		ID:           123,
+		CreatedAt:    time.Now().Add(time.Minute * -10),
+		TTL:          300,
+		ComparisonID: &comparisonID,
+	}
+	instanceService := &mockInstanceService{}
+
+	handler := NewTTLDestroyHandler(slog.Default(), instanceService)
+
+	err := handler.Handle(context.TODO(), deployment)
+
+	require.NoError(t, err)
+	instanceService.AssertExpectations(t)
+}
+
 type mockInstanceService struct{ mock.Mock }
 
-func (m *mockInstanceService) DeleteDeployment(ctx context.Context, deployment *model.Deployment) error {
+func (m *mockInstanceService) TrashDeployment(ctx context.Context, deployment *model.Deployment) error {
 	called := m.Called(ctx, deployment)
 	return called.Error(0)
 }
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
 	"github.com/dhis2-sre/im-manager/pkg/storage"
 	"golang.org/x/sync/errgroup"
 )
@@ -23,44 +24,67 @@ type BackupSource interface {
 type BackupObject struct {
 	Path         string
 	Size         int64
+	ETag         string
 	LastModified time.Time
 	Err          error
 }
 
-func NewBackupService(logger *slog.Logger, uploader *storage.S3Client) *BackupService {
+func NewBackupService(logger *slog.Logger, uploader storage.ObjectStore) *BackupService {
 	return &BackupService{logger: logger, uploader: uploader}
 }
 
 // BackupService streams a filestoreStreamer's gzip'd tar to S3.
 type BackupService struct {
 	logger   *slog.Logger
-	uploader *storage.S3Client
+	uploader storage.ObjectStore
 }
 
-// PerformBackup uploads the streamer's output to key in s3Bucket.
-func (s *BackupService) PerformBackup(ctx context.Context, streamer filestoreStreamer, s3Bucket, key string) error {
+// PerformBackup uploads the streamer's output to key in s3Bucket and returns the SHA-256 checksum and
+// the size of the uploaded object. The uploaded bytes are counted by the progress tracker. The backup
+// is indexed while it's uploaded so its files can be browsed and extracted.
+func (s *BackupService) PerformBackup(ctx context.Context, streamer filestoreStreamer, s3Bucket, key string, progress *storage.ProgressTracker) (string, int64, error) {
 	start := time.Now()
 	pr, pw := io.Pipe()
+	ir, iw := io.Pipe()
 
-	g, ctx := errgroup.WithContext(ctx)
+	g, streamCtx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		err := streamer.stream(ctx, pw)
+		err := streamer.stream(streamCtx, pw)
 		pw.CloseWithError(err)
 		return err
 	})
 	var uploaded int64
+	checksummed := storage.NewChecksumReader(progress.Reader(pr))
 	g.Go(func() error {
-		n, err := s.uploader.StreamUpload(ctx, s3Bucket, key, "application/x-gzip", pr)
+		n, err := s.uploader.StreamUpload(streamCtx, s3Bucket, key, "application/x-gzip", io.TeeReader(checksummed, iw))
 		uploaded = n
 		pr.CloseWithError(err)
+		iw.CloseWithError(err)
 		return err
 	})
+	var index *filestore.Index
+	g.Go(func() error {
+		var err error
+		index, err = filestore.BuildIndex(ir)
+		if err != nil {
+			// keep reading so the upload isn't blocked, the backup is indexed once it's browsed
+			s.logger.WarnContext(ctx, "Failed to index filestore backup", "key", key, "error", err)
+			_, _ = io.Copy(io.Discard, ir)
+		}
+		return nil
+	})
 
 	if err := g.Wait(); err != nil {
-		return fmt.Errorf("backup failed: %v", err)
+		return "", 0, fmt.Errorf("backup failed: %v", err)
+	}
+
+	if index != nil {
+		if err := filestore.WriteIndex(ctx, s.uploader, s3Bucket, key, *index); err != nil {
+			s.logger.WarnContext(ctx, "Failed to store filestore backup index", "key", key, "error", err)
+		}
 	}
 
 	s.logger.InfoContext(ctx, "Filestore backup completed", "key", key, "duration", time.Since(start))
 	s.logger.DebugContext(ctx, "Filestore backup stats", "key", key, "bytesUploaded", uploaded)
-	return nil
+	return checksummed.Checksum(), uploaded, nil
 }
@@ -55,9 +55,14 @@ func TestBackupServiceIntegration(t *testing.T) {
 	backupService := NewBackupService(logger, storage.NewS3Client(logger, s3Test.Client, nil))
 
 	s3Key := "group/save-name-fs.tar.gz"
-	require.NoError(t, backupService.PerformBackup(ctx, s3APISource{source}, s3Bucket, s3Key))
+	checksum, size, err := backupService.PerformBackup(ctx, s3APISource{source: source}, s3Bucket, s3Key, nil)
+	require.NoError(t, err)
 
 	tarContent := s3Test.GetObject(t, s3Bucket, s3Key)
+	expectedChecksum, err := storage.Checksum(bytes.NewReader(tarContent))
+	require.NoError(t, err)
+	assert.Equal(t, expectedChecksum, checksum)
+	assert.Equal(t, int64(len(tarContent)), size)
 	entries := extractTarGz(t, tarContent)
 
 	var paths []string
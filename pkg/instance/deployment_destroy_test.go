@@ -65,7 +65,8 @@ func TestDeleteDeploymentPreservesInstanceRecordWhenDestroyFails(t *testing.T) {
 	}
 	require.NoError(t, instanceRepo.SaveDeployment(context.Background(), deployment))
 
-	stackService := stack.NewService(stack.Stacks{"whoami-go": stack.WhoamiGo})
+	stackService, err := stack.NewServiceFromFolder("../../stacks")
+	require.NoError(t, err)
 	service := NewService(logger, instanceRepo, stubGroupService{group: &group}, stackService, failingDestroyHelmfile{failStack: "whoami-go"}, nil, "")
 
 	err = service.DeleteDeployment(context.Background(), deployment)
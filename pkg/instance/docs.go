@@ -30,7 +30,7 @@ type _ struct {
 	Selector string `json:"selector"`
 }
 
-// swagger:parameters deleteInstance findById findByIdDecrypted saveInstance pauseInstance resumeInstance resetInstance findDeploymentById deployDeployment deleteDeployment status instanceWithDetails filestoreBackup
+// swagger:parameters deleteInstance findById findByIdDecrypted saveInstance pauseInstance resumeInstance resetInstance findDeploymentById deployDeployment deleteDeployment restoreDeployment purgeDeployment status instanceWithDetails filestoreBackup restoreInstanceDatabase restoreInstanceFile
 type _ struct {
 	// in: path
 	// required: true
@@ -105,6 +105,22 @@ type _ struct {
 	Payload SaveInstanceRequest
 }
 
+// swagger:parameters restoreInstanceDatabase
+type _ struct {
+	// Restore database request body parameter
+	// in: body
+	// required: true
+	Payload RestoreDatabaseRequest
+}
+
+// swagger:parameters restoreInstanceFile
+type _ struct {
+	// Restore file request body parameter
+	// in: body
+	// required: true
+	Payload RestoreFileRequest
+}
+
 // swagger:response DeploymentInstance
 type DeploymentInstanceBody struct {
 	// in: body
@@ -5,12 +5,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dhis2-sre/im-manager/pkg/model"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"golang.org/x/sync/errgroup"
@@ -27,14 +30,63 @@ type filestoreStreamer interface {
 	stream(ctx context.Context, w io.Writer) error
 }
 
-// writeTarGz builds a gzip'd tar of source's objects into w.
-func writeTarGz(ctx context.Context, source BackupSource, w io.Writer) error {
+// objectStreamer is a filestoreStreamer which can list the objects of the filestore and stream some
+// of them, so incremental backups only contain the objects which changed since their parent.
+type objectStreamer interface {
+	filestoreStreamer
+	// list lists the objects of the filestore
+	list(ctx context.Context) ([]BackupObject, error)
+	// streamObjects streams a gzip'd tar of the given objects into w
+	streamObjects(ctx context.Context, objects []BackupObject, w io.Writer) error
+}
+
+// changedObjects streams the objects of a filestore which changed since the parent of a backup
+type changedObjects struct {
+	streamer objectStreamer
+	objects  []BackupObject
+}
+
+func (c changedObjects) stream(ctx context.Context, w io.Writer) error {
+	return c.streamer.streamObjects(ctx, c.objects, w)
+}
+
+// listedSource is a BackupSource listing the given objects of its source
+type listedSource struct {
+	BackupSource
+	objects []BackupObject
+}
+
+func (l listedSource) List(context.Context) (<-chan BackupObject, error) {
+	ch := make(chan BackupObject, len(l.objects))
+	for _, object := range l.objects {
+		ch <- object
+	}
+	close(ch)
+	return ch, nil
+}
+
+// writeTarGz builds a gzip'd tar of source's objects into w. Every archived object is counted by the
+// progress tracker. Every object is written as a gzip member of its own so it can be extracted
+// without decompressing the objects preceding it, see filestore.Index.
+func writeTarGz(ctx context.Context, source BackupSource, w io.Writer, progress *storage.ProgressTracker) error {
 	gw := gzip.NewWriter(w)
 	defer gw.Close()
 
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
 
+	archived := func() error {
+		progress.AddObject()
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("flush tar: %v", err)
+		}
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("close gzip member: %v", err)
+		}
+		gw.Reset(w)
+		return nil
+	}
+
 	objectCh, err := source.List(ctx)
 	if err != nil {
 		return fmt.Errorf("list objects: %v", err)
@@ -88,11 +140,17 @@ func writeTarGz(ctx context.Context, source BackupSource, w io.Writer) error {
 				if err := streamTarObject(ctx, tw, source, f.object); err != nil {
 					return err
 				}
+				if err := archived(); err != nil {
+					return err
+				}
 				continue
 			}
 			if err := writeTarEntry(tw, f.object, int64(len(f.data)), bytes.NewReader(f.data)); err != nil {
 				return err
 			}
+			if err := archived(); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -214,15 +272,41 @@ func ensureBucket(ctx context.Context, client *minio.Client, bucket, region stri
 
 // s3APISource is the filestore streamer for the external S3 backend.
 type s3APISource struct {
-	source BackupSource
+	source   BackupSource
+	progress *storage.ProgressTracker
 }
 
 func (s s3APISource) stream(ctx context.Context, w io.Writer) error {
-	return writeTarGz(ctx, s.source, w)
+	return writeTarGz(ctx, s.source, w, s.progress)
+}
+
+func (s s3APISource) list(ctx context.Context) ([]BackupObject, error) {
+	objectCh, err := s.source.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %v", err)
+	}
+
+	var objects []BackupObject
+	// the channel is drained on errors so the listing goroutine isn't left blocked
+	for object := range objectCh {
+		if err == nil && object.Err != nil {
+			err = object.Err
+		}
+		objects = append(objects, object)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s s3APISource) streamObjects(ctx context.Context, objects []BackupObject, w io.Writer) error {
+	return writeTarGz(ctx, listedSource{BackupSource: s.source, objects: objects}, w, s.progress)
 }
 
 type podExecutor interface {
 	Exec(ctx context.Context, namespace, podName, container string, command []string, stdout, stderr io.Writer) error
+	ExecWithStdin(ctx context.Context, namespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
 }
 
 // podExec runs commands in one pod container and wraps a failed command with its stderr.
@@ -241,6 +325,31 @@ func (p podExec) run(ctx context.Context, stdout io.Writer, command ...string) e
 	return nil
 }
 
+func (p podExec) runWithStdin(ctx context.Context, stdin io.Reader, stdout io.Writer, command ...string) error {
+	var stderr strings.Builder
+	if err := p.executor.ExecWithStdin(ctx, p.namespace, p.podName, p.container, command, stdin, stdout, &stderr); err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// tarObjects tars the given objects of the directory into w. The paths are streamed to tar through
+// stdin since the list of objects can exceed the size limit of a command line. The paths are
+// prefixed by "./", like the entries of full backups, which also keeps tar from reading paths
+// starting with "-" as options.
+func (p podExec) tarObjects(ctx context.Context, dir string, objects []BackupObject, w io.Writer) error {
+	if len(objects) == 0 {
+		// tar refuses to create an empty archive
+		return writeTarGz(ctx, listedSource{}, w, nil)
+	}
+
+	var paths strings.Builder
+	for _, object := range objects {
+		paths.WriteString("./" + object.Path + "\n")
+	}
+	return p.runWithStdin(ctx, strings.NewReader(paths.String()), w, "tar", "-C", dir, "-czf", "-", "-T", "-")
+}
+
 // execStreamer streams a command's stdout (a gzip'd tar) out of a pod.
 type execStreamer struct {
 	podExec
@@ -263,6 +372,28 @@ type minioExecSource struct {
 }
 
 func (m minioExecSource) stream(ctx context.Context, w io.Writer) error {
+	return m.mirror(ctx, func(dir string) error {
+		return m.run(ctx, w, "tar", "-C", dir, "-czf", "-", ".")
+	})
+}
+
+// list lists the objects of the bucket along with their ETag
+func (m minioExecSource) list(ctx context.Context) ([]BackupObject, error) {
+	var out strings.Builder
+	if err := m.run(ctx, &out, "env", minioClientHostEnv, "mc", "ls", "--recursive", "--json", "backup/dhis2"); err != nil {
+		return nil, err
+	}
+	return parseMinioListing(out.String())
+}
+
+func (m minioExecSource) streamObjects(ctx context.Context, objects []BackupObject, w io.Writer) error {
+	return m.mirror(ctx, func(dir string) error {
+		return m.tarObjects(ctx, dir, objects, w)
+	})
+}
+
+// mirror mirrors the bucket to a pod temp dir, calls f with the dir and removes the dir afterwards
+func (m minioExecSource) mirror(ctx context.Context, f func(dir string) error) error {
 	var out strings.Builder
 	if err := m.run(ctx, &out, "mktemp", "-d", "/tmp/im-filestore-backup-XXXXXXXX"); err != nil {
 		return err
@@ -282,12 +413,100 @@ func (m minioExecSource) stream(ctx context.Context, w io.Writer) error {
 	if err := m.run(ctx, io.Discard, "env", minioClientHostEnv, "mc", "mirror", "--quiet", "backup/dhis2", tmpDir); err != nil {
 		return err
 	}
-	return m.run(ctx, w, "tar", "-C", tmpDir, "-czf", "-", ".")
+	return f(tmpDir)
+}
+
+// parseMinioListing parses the JSON lines printed by mc ls --json
+func parseMinioListing(listing string) ([]BackupObject, error) {
+	var objects []BackupObject
+	for _, line := range strings.Split(listing, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var entry struct {
+			Status       string    `json:"status"`
+			Type         string    `json:"type"`
+			Key          string    `json:"key"`
+			Size         int64     `json:"size"`
+			ETag         string    `json:"etag"`
+			LastModified time.Time `json:"lastModified"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("parse mc ls output %q: %v", line, err)
+		}
+		if entry.Status != "success" {
+			return nil, fmt.Errorf("mc ls failed: %s", line)
+		}
+		if entry.Type != "file" {
+			continue
+		}
+
+		objects = append(objects, BackupObject{Path: entry.Key, Size: entry.Size, ETag: entry.ETag, LastModified: entry.LastModified})
+	}
+	return objects, nil
 }
 
 func filesystemTarCommand(dhis2Home string) []string {
-	files := strings.TrimRight(dhis2Home, "/") + "/files"
-	return []string{"tar", "-C", files, "-czf", "-", "."}
+	return []string{"tar", "-C", filesystemFiles(dhis2Home), "-czf", "-", "."}
+}
+
+func filesystemFiles(dhis2Home string) string {
+	return strings.TrimRight(dhis2Home, "/") + "/files"
+}
+
+// filesystemListCommand prints the size, modification time and path of the files separated by NUL
+// since paths can contain any other character
+func filesystemListCommand(dhis2Home string) []string {
+	return []string{"find", filesystemFiles(dhis2Home), "-type", "f", "-printf", `%s %T@ %P\0`}
+}
+
+// filesystemExecSource is the filestore streamer for the filesystem backend. The files are streamed
+// out of the DHIS2 pod.
+type filesystemExecSource struct {
+	execStreamer
+	dhis2Home string
+}
+
+func (f filesystemExecSource) list(ctx context.Context) ([]BackupObject, error) {
+	var out strings.Builder
+	if err := f.run(ctx, &out, filesystemListCommand(f.dhis2Home)...); err != nil {
+		return nil, err
+	}
+	return parseFilesystemListing(out.String())
+}
+
+func (f filesystemExecSource) streamObjects(ctx context.Context, objects []BackupObject, w io.Writer) error {
+	return f.tarObjects(ctx, filesystemFiles(f.dhis2Home), objects, w)
+}
+
+// parseFilesystemListing parses the output of the filesystemListCommand. Modification times are
+// truncated to seconds since that's what tar preserves.
+func parseFilesystemListing(listing string) ([]BackupObject, error) {
+	var objects []BackupObject
+	for _, record := range strings.Split(listing, "\x00") {
+		if record == "" {
+			continue
+		}
+
+		size, rest, _ := strings.Cut(record, " ")
+		modified, path, ok := strings.Cut(rest, " ")
+		if !ok {
+			return nil, fmt.Errorf("unexpected find output %q", record)
+		}
+
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse size of %q: %v", path, err)
+		}
+		seconds, err := strconv.ParseFloat(modified, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse modification time of %q: %v", path, err)
+		}
+
+		objects = append(objects, BackupObject{Path: path, Size: n, LastModified: time.Unix(int64(seconds), 0)})
+	}
+	return objects, nil
 }
 
 func storageType(core *model.DeploymentInstance) string {
@@ -309,8 +528,9 @@ func newExternalS3Client(core *model.DeploymentInstance) (*minio.Client, error)
 }
 
 // filestoreStreamerFor selects the backend-specific streamer: minio and filesystem
-// stream via pod exec, s3 reads the external bucket directly.
-func (s Service) filestoreStreamerFor(core *model.DeploymentInstance, cluster model.Cluster) (filestoreStreamer, error) {
+// stream via pod exec, s3 reads the external bucket directly. The tar is built in the pod by the
+// exec streamers so only the s3 streamer counts the archived objects.
+func (s Service) filestoreStreamerFor(core *model.DeploymentInstance, cluster model.Cluster, progress *storage.ProgressTracker) (objectStreamer, error) {
 	switch storageType(core) {
 	case "filesystem":
 		ks, err := NewKubernetesService(cluster)
@@ -321,21 +541,25 @@ func (s Service) filestoreStreamerFor(core *model.DeploymentInstance, cluster mo
 		if err != nil {
 			return nil, err
 		}
-		return execStreamer{
-			podExec: podExec{
-				executor:  ks,
-				namespace: pod.Namespace,
-				podName:   pod.Name,
-				container: coreContainerName(pod),
+		dhis2Home := core.Parameters["DHIS2_HOME"].Value
+		return filesystemExecSource{
+			execStreamer: execStreamer{
+				podExec: podExec{
+					executor:  ks,
+					namespace: pod.Namespace,
+					podName:   pod.Name,
+					container: coreContainerName(pod),
+				},
+				command: filesystemTarCommand(dhis2Home),
 			},
-			command: filesystemTarCommand(core.Parameters["DHIS2_HOME"].Value),
+			dhis2Home: dhis2Home,
 		}, nil
 	case "s3":
 		client, err := newExternalS3Client(core)
 		if err != nil {
 			return nil, err
 		}
-		return s3APISource{source: NewMinioBackupSource(s.logger, client, core.Parameters["S3_BUCKET"].Value)}, nil
+		return s3APISource{source: NewMinioBackupSource(s.logger, client, core.Parameters["S3_BUCKET"].Value), progress: progress}, nil
 	default: // minio
 		ks, err := NewKubernetesService(cluster)
 		if err != nil {
@@ -372,3 +596,136 @@ func coreContainerName(pod v1.Pod) string {
 	}
 	return pod.Spec.Containers[0].Name
 }
+
+type stdinPodExecutor interface {
+	ExecWithStdin(ctx context.Context, namespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// execRestorer restores a filestore by streaming its gzip'd tar into a command in a pod.
+type execRestorer struct {
+	executor  stdinPodExecutor
+	namespace string
+	podName   string
+	container string
+	command   []string
+}
+
+func (e execRestorer) restore(ctx context.Context, r io.Reader) error {
+	var stderr strings.Builder
+	if err := e.executor.ExecWithStdin(ctx, e.namespace, e.podName, e.container, e.command, r, io.Discard, &stderr); err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// filesystemRestoreCommand replaces the files of the filesystem backend with the gzip'd tar read
+// from stdin.
+func filesystemRestoreCommand(dhis2Home string) []string {
+	files := filesystemFiles(dhis2Home)
+	return []string{"sh", "-c", `mkdir -p "$1" && find "$1" -mindepth 1 -delete && tar -C "$1" -xzf -`, "restore", files}
+}
+
+// fileRestoreCommand writes the file read from stdin to the given path of the filestore of the
+// minio and filesystem backends
+func fileRestoreCommand(core *model.DeploymentInstance, path string) []string {
+	if storageType(core) == "filesystem" {
+		file := filesystemFiles(core.Parameters["DHIS2_HOME"].Value) + "/" + path
+		return []string{"sh", "-c", `mkdir -p "$(dirname "$1")" && cat > "$1"`, "restore", file}
+	}
+	return []string{"env", minioClientHostEnv, "mc", "pipe", "--quiet", "backup/dhis2/" + path}
+}
+
+// minioRestoreScript extracts the gzip'd tar read from stdin into a pod temp dir and mirrors it into
+// the bucket removing all other objects. Like the backup the staging copy needs ~filestore-size free
+// ephemeral storage on the pod.
+const minioRestoreScript = `set -e
+tmp=$(mktemp -d /tmp/im-filestore-restore-XXXXXXXX)
+trap 'rm -rf "$tmp"' EXIT
+tar -C "$tmp" -xzf -
+env ` + minioClientHostEnv + ` mc mirror --quiet --overwrite --remove "$tmp" backup/dhis2`
+
+// dhis2HomeVolume is the name of the volume of DHIS2_HOME in the dhis2/core chart
+const dhis2HomeVolume = "dhis-home"
+
+// volumeRestorer restores a filestore by streaming its gzip'd tar into a command in a job pod
+// mounting the persistent volume claim of the filestore. It's used while DHIS2 is stopped.
+type volumeRestorer struct {
+	ks        *kubernetesService
+	namespace string
+	claimName string
+	mountPath string
+	command   []string
+}
+
+func (v volumeRestorer) restore(ctx context.Context, r io.Reader) error {
+	var stderr strings.Builder
+	if err := v.ks.execVolumeJob(ctx, v.namespace, v.claimName, v.mountPath, v.command, r, io.Discard, &stderr); err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// filesystemRestorerFor restores the filesystem backend of the stopped DHIS2 instance into its
+// DHIS2_HOME volume. The restore waits for the pods of DHIS2 to be deleted so the volume is released.
+func filesystemRestorerFor(ctx context.Context, core *model.DeploymentInstance, cluster model.Cluster) (volumeRestorer, error) {
+	ks, err := NewKubernetesService(cluster)
+	if err != nil {
+		return volumeRestorer{}, err
+	}
+
+	claimName, err := ks.findVolumeClaim(core, dhis2HomeVolume)
+	if err != nil {
+		return volumeRestorer{}, err
+	}
+
+	if err := ks.waitForPodsDeleted(ctx, core); err != nil {
+		return volumeRestorer{}, err
+	}
+
+	dhis2Home := core.Parameters["DHIS2_HOME"].Value
+	return volumeRestorer{
+		ks:        ks,
+		namespace: core.Group.Namespace,
+		claimName: claimName,
+		mountPath: dhis2Home,
+		command:   filesystemRestoreCommand(dhis2Home),
+	}, nil
+}
+
+// filestoreRestorerFor selects the pod and command restoring the filestore of the minio and
+// filesystem backends. The s3 backend is restored using the RestoreService.
+func filestoreRestorerFor(core *model.DeploymentInstance, cluster model.Cluster) (execRestorer, error) {
+	ks, err := NewKubernetesService(cluster)
+	if err != nil {
+		return execRestorer{}, err
+	}
+
+	if storageType(core) == "filesystem" {
+		pod, err := ks.getPod(core.ID, "")
+		if err != nil {
+			return execRestorer{}, err
+		}
+		return execRestorer{
+			executor:  ks,
+			namespace: pod.Namespace,
+			podName:   pod.Name,
+			container: coreContainerName(pod),
+			command:   filesystemRestoreCommand(core.Parameters["DHIS2_HOME"].Value),
+		}, nil
+	}
+
+	pod, err := ks.getPodByLabels(map[string]string{
+		"im-type":          "minio",
+		"im-deployment-id": fmt.Sprint(core.DeploymentID),
+	})
+	if err != nil {
+		return execRestorer{}, err
+	}
+	return execRestorer{
+		executor:  ks,
+		namespace: pod.Namespace,
+		podName:   pod.Name,
+		container: "minio",
+		command:   []string{"sh", "-c", minioRestoreScript},
+	}, nil
+}
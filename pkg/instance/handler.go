@@ -16,12 +16,13 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func NewHandler(stackService stack.Service, groupService groupServiceHandler, instanceService *Service, deploymentService deploymentService, defaultTTL uint) Handler {
+func NewHandler(stackService stack.Service, groupService groupServiceHandler, instanceService *Service, deploymentService deploymentService, databaseService databaseServiceHandler, defaultTTL uint) Handler {
 	return Handler{
 		stackService:      stackService,
 		groupService:      groupService,
 		instanceService:   instanceService,
 		deploymentService: deploymentService,
+		databaseService:   databaseService,
 		defaultTTL:        defaultTTL,
 	}
 }
@@ -31,6 +32,7 @@ type Handler struct {
 	groupService      groupServiceHandler
 	instanceService   *Service
 	deploymentService deploymentService
+	databaseService   databaseServiceHandler
 	defaultTTL        uint
 }
 
@@ -43,6 +45,12 @@ type deploymentService interface {
 	UpdateDeployment(ctx context.Context, token string, deploymentId uint, ttl uint, description string) (*model.Deployment, error)
 	UpdateInstance(ctx context.Context, token string, deploymentId, instanceId uint, parameters Parameters, public *bool) (*model.DeploymentInstance, error)
 	Reset(ctx context.Context, token string, instance *model.DeploymentInstance, ttl uint) error
+	RestoreDatabase(ctx context.Context, userId uint, instance *model.DeploymentInstance, stack *model.Stack, coreInstance *model.DeploymentInstance, database *model.Database, filestore bool) error
+}
+
+type databaseServiceHandler interface {
+	FindById(ctx context.Context, id uint) (*model.Database, error)
+	FindFilestore(ctx context.Context, d *model.Database) (*model.Database, error)
 }
 
 func (h Handler) DeployDeployment(c *gin.Context) {
@@ -418,6 +426,196 @@ func (h Handler) Pause(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+type RestoreDatabaseRequest struct {
+	DatabaseID uint `json:"databaseId" binding:"required"`
+	// Filestore restores the filestore of the database into the dhis2-core instance of the deployment
+	Filestore bool `json:"filestore"`
+}
+
+// RestoreDatabase restores a database into a running instance
+func (h Handler) RestoreDatabase(c *gin.Context) {
+	// swagger:route POST /instances/{id}/restore-database restoreInstanceDatabase
+	//
+	// Restore database into instance
+	//
+	// Replace the database of a running instance with the given database. DHIS2 is stopped while the database is replaced and started again afterwards. The filestore of the database can be restored into the dhis2-core instance of the deployment as well. The restore runs in the background and its progress is published as database-restore events.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	var request RestoreDatabaseRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	instance, err := h.instanceService.FindDecryptedDeploymentInstanceById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	deployment, err := h.instanceService.FindDeploymentById(ctx, instance.DeploymentID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	canWrite := handler.CanWriteDeployment(user, deployment)
+	if !canWrite {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	database, err := h.databaseService.FindById(ctx, request.DatabaseID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !handler.CanAccess(user, database) {
+		forbidden := errdef.NewForbidden("access denied to database %d", database.ID)
+		_ = c.Error(forbidden)
+		return
+	}
+
+	//goland:noinspection GoImportUsedAsName
+	stack, err := h.stackService.Find(instance.StackName)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if _, ok := stack.ParameterProviders["DATABASE_HOSTNAME"]; !ok {
+		_ = c.Error(errdef.NewBadRequest("instance %d doesn't run a database", instance.ID))
+		return
+	}
+
+	coreInstance := findInstanceByStackName("dhis2-core", deployment)
+
+	err = h.deploymentService.RestoreDatabase(ctx, user.ID, instance, stack, coreInstance, database, request.Filestore)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+type RestoreFileRequest struct {
+	// DatabaseID is the id of the filestore or of the database it belongs to
+	DatabaseID uint `json:"databaseId" binding:"required"`
+	// Path of the file relative to the root of the filestore
+	Path string `json:"path" binding:"required"`
+}
+
+// RestoreFile restores a single file of a filestore into a running instance
+func (h Handler) RestoreFile(c *gin.Context) {
+	// swagger:route POST /instances/{id}/restore-file restoreInstanceFile
+	//
+	// Restore file into instance
+	//
+	// Restore a single file of the filestore of a database into the filestore of a running dhis2-core instance. The other files of the instance are kept.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	400: Error
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	var request RestoreFileRequest
+	if err := handler.DataBinder(c, &request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	instance, err := h.instanceService.FindDeploymentInstanceById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if instance.StackName != "dhis2-core" {
+		_ = c.Error(errdef.NewBadRequest("instance %d isn't a dhis2-core instance", instance.ID))
+		return
+	}
+
+	deployment, err := h.instanceService.FindDeploymentById(ctx, instance.DeploymentID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	canWrite := handler.CanWriteDeployment(user, deployment)
+	if !canWrite {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	database, err := h.databaseService.FindById(ctx, request.DatabaseID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if !handler.CanAccess(user, database) {
+		forbidden := errdef.NewForbidden("access denied to database %d", database.ID)
+		_ = c.Error(forbidden)
+		return
+	}
+
+	fs, err := h.databaseService.FindFilestore(ctx, database)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.instanceService.RestoreFilestoreFile(ctx, instance, fs, request.Path)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
 // InstanceWithDetails instance
 func (h Handler) InstanceWithDetails(c *gin.Context) {
 	// swagger:route PUT /instances/{id}/details instanceWithDetails
@@ -861,7 +1059,9 @@ func (h Handler) DeleteDeployment(c *gin.Context) {
 	//
 	// Delete deployment
 	//
-	// Delete an deployment by id
+	// Destroy the instances of the deployment and move the deployment to the trash. Trashed
+	// deployments, including the parameters of their instances, can be restored by their owner or a
+	// group administrator until they're purged.
 	//
 	// Security:
 	//	oauth2:
@@ -897,7 +1097,7 @@ func (h Handler) DeleteDeployment(c *gin.Context) {
 		return
 	}
 
-	err = h.instanceService.DeleteDeployment(ctx, deployment)
+	err = h.instanceService.TrashDeployment(ctx, deployment)
 	if err != nil {
 		_ = c.Error(fmt.Errorf("unable to delete deployment: %v", err))
 		return
@@ -906,6 +1106,156 @@ func (h Handler) DeleteDeployment(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+// FindTrashedDeployments finds the trashed deployments
+func (h Handler) FindTrashedDeployments(c *gin.Context) {
+	// swagger:route GET /deployments/trash listTrashedDeployments
+	//
+	// Find trashed deployments
+	//
+	// Find all trashed deployments accessible by the user
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: GroupsWithDeployments
+	//	401: Error
+	//	403: Error
+	//	415: Error
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	groupsWithDeployments, err := h.instanceService.FindTrashedDeployments(ctx, user)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, group := range groupsWithDeployments {
+		for _, deployment := range group.Deployments {
+			err := h.stripDeploymentSensitiveParameterValues(deployment)
+			if err != nil {
+				_ = c.Error(err)
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, groupsWithDeployments)
+}
+
+// RestoreDeployment restores a trashed deployment
+func (h Handler) RestoreDeployment(c *gin.Context) {
+	// swagger:route POST /deployments/trash/{id}/restore restoreDeployment
+	//
+	// Restore deployment
+	//
+	// Restore the deployment and its instances from the trash. The instances need to be deployed again. The TTL is extended so the deployment lives for its TTL again from the time it is restored.
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	200: Deployment
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	deployment, err := h.instanceService.FindTrashedDeploymentById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	canWrite := handler.CanWriteDeployment(user, deployment)
+	if !canWrite {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	restored, err := h.instanceService.RestoreDeployment(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.stripDeploymentSensitiveParameterValues(restored)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, restored)
+}
+
+// PurgeDeployment permanently deletes a trashed deployment
+func (h Handler) PurgeDeployment(c *gin.Context) {
+	// swagger:route DELETE /deployments/trash/{id} purgeDeployment
+	//
+	// Purge deployment
+	//
+	// Permanently delete the deployment and its instances from the trash
+	//
+	// Security:
+	//	oauth2:
+	//
+	// responses:
+	//	202:
+	//	401: Error
+	//	403: Error
+	//	404: Error
+	//	415: Error
+	id, ok := handler.GetPathParameter(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := handler.GetUserFromContext(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	deployment, err := h.instanceService.FindTrashedDeploymentById(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	canWrite := handler.CanWriteDeployment(user, deployment)
+	if !canWrite {
+		unauthorized := errdef.NewUnauthorized("write access denied")
+		_ = c.Error(unauthorized)
+		return
+	}
+
+	err = h.instanceService.PurgeDeployment(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
 // Status returns the status of an instance
 func (h Handler) Status(c *gin.Context) {
 	// swagger:route GET /instances/{id}/status status
@@ -0,0 +1,74 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// maxFilestoreChainDepth limits the number of backups an incremental filestore backup can be based
+// on. A full backup is made once the chain is this long so restores don't have to read an ever
+// growing number of backups and objects which have been replaced are eventually deleted.
+const maxFilestoreChainDepth = 10
+
+// filestoreParent returns the current filestore of the database, nil if it has none, along with its
+// manifest if the next backup can be based on it. Backups without a manifest, made before manifests
+// were introduced, and backups ending a chain of maxFilestoreChainDepth backups can't be a parent.
+func (s Service) filestoreParent(ctx context.Context, database *model.Database) (*model.Database, *filestore.Manifest, error) {
+	if database.FilestoreID == 0 {
+		return nil, nil, nil
+	}
+
+	current, err := s.instanceRepository.FindDatabaseById(ctx, database.FilestoreID)
+	if err != nil {
+		if errdef.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	manifest, err := filestore.ReadManifest(ctx, s.objectStore, s.s3Bucket, s.objectKey(current.Url))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if manifest == nil || manifest.Depth >= maxFilestoreChainDepth {
+		return current, nil, nil
+	}
+
+	return current, manifest, nil
+}
+
+func (s Service) objectKey(s3Uri string) string {
+	return strings.TrimPrefix(s3Uri, fmt.Sprintf("s3://%s/", s.s3Bucket))
+}
+
+func manifestObjects(objects []BackupObject) []filestore.Object {
+	manifestObjects := make([]filestore.Object, len(objects))
+	for i, object := range objects {
+		manifestObjects[i] = filestore.Object{
+			Path:         object.Path,
+			Size:         object.Size,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		}
+	}
+	return manifestObjects
+}
+
+func backupObjects(objects []filestore.Object) []BackupObject {
+	backupObjects := make([]BackupObject, len(objects))
+	for i, object := range objects {
+		backupObjects[i] = BackupObject{
+			Path:         object.Path,
+			Size:         object.Size,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		}
+	}
+	return backupObjects
+}
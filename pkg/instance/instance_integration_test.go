@@ -97,14 +97,8 @@ func TestInstanceHandler(t *testing.T) {
 	instanceRepo, err := instance.NewRepository(db, encryptionKey)
 	require.NoError(t, err)
 	groupService := groupService{group: group}
-	stacks := stack.Stacks{
-		"minio":      stack.MINIO,
-		"whoami-go":  stack.WhoamiGo,
-		"dhis2-db":   stack.DHIS2DB,
-		"dhis2-core": stack.DHIS2Core,
-		"dhis2":      stack.DHIS2,
-	}
-	stackService := stack.NewService(stacks)
+	stackService, err := stack.NewServiceFromFolder("../../stacks")
+	require.NoError(t, err)
 	// classification 'test' does not actually exist, this is used to decrypt the stack parameters
 	helmfileService, err := instance.NewHelmfileService(logger, stackService, "../../stacks", "test")
 	require.NoError(t, err, "failed to create helmfile service")
@@ -125,7 +119,7 @@ func TestInstanceHandler(t *testing.T) {
 	databaseRepository := database.NewRepository(db)
 	databaseService := database.NewService(logger, s3Bucket, s3Client, groupService, databaseRepository, func(c model.Cluster) (database.PodExecutor, error) {
 		return instance.NewKubernetesService(c)
-	}, noopPublisher{})
+	}, noopPublisher{}, 0, "", nil, database.ImportConfig{}, nil)
 	deploymentService := deployment.NewService(logger, instanceService, databaseService, tokenService, noopPublisher{})
 
 	// this is only to allow testing using multiple users without bringing in all our auth stack
@@ -139,7 +133,7 @@ func TestInstanceHandler(t *testing.T) {
 	}
 	client := inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
 		var twoDayTTL uint = 172800
-		instanceHandler := instance.NewHandler(stackService, groupService, instanceService, deploymentService, twoDayTTL)
+		instanceHandler := instance.NewHandler(stackService, groupService, instanceService, deploymentService, databaseService, twoDayTTL)
 		instance.Routes(engine, authenticator, instanceHandler)
 
 		databaseHandler := database.NewHandler(logger, databaseService, groupService, instanceService, stackService, deploymentService)
@@ -272,7 +266,7 @@ func TestInstanceHandler(t *testing.T) {
 
 		// The shared instanceService is wired with a nil S3 client; build one with the real client.
 		fsService := instance.NewService(logger, instanceRepo, groupService, stackService, helmfileService, s3Client, s3Bucket)
-		require.NoError(t, fsService.FilestoreBackup(context.Background(), &coreInstance, target.Name, target))
+		require.NoError(t, fsService.FilestoreBackup(context.Background(), &coreInstance, target.Name, target, nil))
 
 		content := s3.GetObject(t, s3Bucket, "group-name/fs-backup-target-fs.tar.gz")
 		require.NotEmpty(t, content)
@@ -314,7 +308,7 @@ func TestInstanceHandler(t *testing.T) {
 
 		// The shared instanceService is wired with a nil S3 client; build one with the real client.
 		fsService := instance.NewService(logger, instanceRepo, groupService, stackService, helmfileService, s3Client, s3Bucket)
-		require.NoError(t, fsService.FilestoreBackup(context.Background(), &coreInstance, target.Name, target))
+		require.NoError(t, fsService.FilestoreBackup(context.Background(), &coreInstance, target.Name, target, nil))
 
 		content := s3.GetObject(t, s3Bucket, "group-name/fsstore-backup-target-fs.tar.gz")
 		require.NotEmpty(t, content)
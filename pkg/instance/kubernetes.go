@@ -3,6 +3,7 @@ package instance
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -24,6 +25,7 @@ import (
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -265,6 +267,12 @@ func (ks kubernetesService) getLogs(instance *model.DeploymentInstance, typeSele
 }
 
 func (ks kubernetesService) Exec(ctx context.Context, namespace, podName, container string, command []string, stdout, stderr io.Writer) error {
+	return ks.ExecWithStdin(ctx, namespace, podName, container, command, nil, stdout, stderr)
+}
+
+// ExecWithStdin executes the command in the container streaming stdin to it. A nil stdin isn't
+// attached.
+func (ks kubernetesService) ExecWithStdin(ctx context.Context, namespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	req := ks.client.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(podName).
@@ -274,7 +282,153 @@ func (ks kubernetesService) Exec(ctx context.Context, namespace, podName, contai
 	req.VersionedParams(&v1.PodExecOptions{
 		Container: container,
 		Command:   command,
-		Stdin:     false,
+		Stdin:     stdin != nil,
+		Stdout:    true,
+		Stderr:    true,
+		TTY:       false,
+	}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(ks.restConfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// jobUserID is the id of the postgres user of the PostgreSQL images jobs are run with. initdb and
+// pg_ctl refuse to run as root.
+const jobUserID int64 = 999
+
+// jobResources are the resources of job pods. Jobs restoring dumps run a PostgreSQL server.
+var jobResources = v1.ResourceRequirements{
+	Requests: v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("250m"),
+		v1.ResourceMemory: resource.MustParse("512Mi"),
+	},
+	Limits: v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("2"),
+		v1.ResourceMemory: resource.MustParse("4Gi"),
+	},
+}
+
+// ExecJob runs the command in a short-lived pod created from the given image. The pod idles until
+// the command has been executed in it. stdin is streamed to the command and the pod is deleted when
+// the command returns. The command is run as the postgres user.
+func (ks kubernetesService) ExecJob(ctx context.Context, namespace, image string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	userID, runAsNonRoot, allowPrivilegeEscalation := jobUserID, true, false
+	spec := v1.PodSpec{
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsUser:    &userID,
+			RunAsGroup:   &userID,
+			RunAsNonRoot: &runAsNonRoot,
+		},
+		Containers: []v1.Container{
+			{
+				Name:      "job",
+				Image:     image,
+				Resources: jobResources,
+				SecurityContext: &v1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+				},
+			},
+		},
+	}
+
+	return ks.runJob(ctx, namespace, spec, command, stdin, stdout, stderr)
+}
+
+// filestoreJobImage is the image of jobs restoring the filesystem backend. It's the image of the init
+// container seeding the filestore when DHIS2 is deployed.
+const filestoreJobImage = "alpine:3.18"
+
+// execVolumeJob runs the command like ExecJob in a pod mounting the persistent volume claim at the
+// given path. The command runs as root like the init container seeding the filestore since the files
+// are owned by the user of DHIS2. Their ownership is fixed by DHIS2 when it starts.
+func (ks kubernetesService) execVolumeJob(ctx context.Context, namespace, claimName, mountPath string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	allowPrivilegeEscalation := false
+	spec := v1.PodSpec{
+		Containers: []v1.Container{
+			{
+				Name:      "job",
+				Image:     filestoreJobImage,
+				Resources: jobResources,
+				SecurityContext: &v1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+				},
+				VolumeMounts: []v1.VolumeMount{{Name: "volume", MountPath: mountPath}},
+			},
+		},
+		Volumes: []v1.Volume{
+			{
+				Name: "volume",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			},
+		},
+	}
+
+	return ks.runJob(ctx, namespace, spec, command, stdin, stdout, stderr)
+}
+
+// runJob runs the command in a short-lived pod with the given spec. The command of the first container
+// is replaced so the pod idles until the command has been executed in it.
+func (ks kubernetesService) runJob(ctx context.Context, namespace string, spec v1.PodSpec, command []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	spec.RestartPolicy = v1.RestartPolicyNever
+	spec.Containers[0].Command = []string{"sleep", "infinity"}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "im-job-",
+			Labels: map[string]string{
+				"im":     "true",
+				"im-job": "true",
+			},
+		},
+		Spec: spec,
+	}
+
+	pods := ks.client.CoreV1().Pods(namespace)
+	created, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("error creating job pod: %v", err)
+	}
+	defer func() {
+		// Delete the pod even if ctx was cancelled
+		deleteErr := pods.Delete(context.WithoutCancel(ctx), created.Name, metav1.DeleteOptions{})
+		if deleteErr != nil {
+			err = errors.Join(err, fmt.Errorf("error deleting job pod %q: %v", created.Name, deleteErr))
+		}
+	}()
+
+	err = wait.PollUntilContextTimeout(ctx, 2*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+		p, err := pods.Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if p.Status.Phase == v1.PodFailed || p.Status.Phase == v1.PodSucceeded {
+			return false, fmt.Errorf("job pod %q terminated: %s", created.Name, p.Status.Phase)
+		}
+		return p.Status.Phase == v1.PodRunning, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for job pod: %v", err)
+	}
+
+	req := ks.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(created.Name).
+		Namespace(namespace).
+		SubResource("exec")
+
+	req.VersionedParams(&v1.PodExecOptions{
+		Container: spec.Containers[0].Name,
+		Command:   command,
+		Stdin:     stdin != nil,
 		Stdout:    true,
 		Stderr:    true,
 		TTY:       false,
@@ -286,11 +440,73 @@ func (ks kubernetesService) Exec(ctx context.Context, namespace, podName, contai
 	}
 
 	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
 		Stdout: stdout,
 		Stderr: stderr,
 	})
 }
 
+// findVolumeClaim finds the name of the persistent volume claim backing the volume with the given name
+// of the pods of the instance. The claim is found from the workloads so it's found while the instance
+// is paused.
+func (ks kubernetesService) findVolumeClaim(instance *model.DeploymentInstance, volumeName string) (string, error) {
+	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("im-id=%d", instance.ID)}
+
+	deployments, err := ks.client.AppsV1().Deployments(instance.Group.Namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return "", fmt.Errorf("error finding deployments of instance %d: %v", instance.ID, err)
+	}
+	for _, d := range deployments.Items {
+		if claim, ok := volumeClaim(d.Spec.Template.Spec.Volumes, volumeName); ok {
+			return claim, nil
+		}
+	}
+
+	sets, err := ks.client.AppsV1().StatefulSets(instance.Group.Namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return "", fmt.Errorf("error finding StatefulSets of instance %d: %v", instance.ID, err)
+	}
+	for _, set := range sets.Items {
+		if claim, ok := volumeClaim(set.Spec.Template.Spec.Volumes, volumeName); ok {
+			return claim, nil
+		}
+		for _, template := range set.Spec.VolumeClaimTemplates {
+			if template.Name == volumeName {
+				// the claim of the first and only replica
+				return fmt.Sprintf("%s-%s-0", template.Name, set.Name), nil
+			}
+		}
+	}
+
+	return "", errdef.NewNotFound("volume %q of instance %d not found", volumeName, instance.ID)
+}
+
+func volumeClaim(volumes []v1.Volume, name string) (string, bool) {
+	for _, volume := range volumes {
+		if volume.Name == name && volume.PersistentVolumeClaim != nil {
+			return volume.PersistentVolumeClaim.ClaimName, true
+		}
+	}
+	return "", false
+}
+
+// waitForPodsDeleted waits until the pods of the paused instance have been deleted
+func (ks kubernetesService) waitForPodsDeleted(ctx context.Context, instance *model.DeploymentInstance) error {
+	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("im-id=%d", instance.ID)}
+	pods := ks.client.CoreV1().Pods(instance.Group.Namespace)
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+		list, err := pods.List(ctx, listOptions)
+		if err != nil {
+			return false, err
+		}
+		return len(list.Items) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for the pods of instance %d to be deleted: %v", instance.ID, err)
+	}
+	return nil
+}
+
 func (ks kubernetesService) getPod(instanceID uint, typeSelector string) (v1.Pod, error) {
 	selector, err := labelSelector(instanceID, typeSelector)
 	if err != nil {
@@ -41,7 +41,7 @@ func (m *MinioBackupSource) List(ctx context.Context) (<-chan BackupObject, erro
 			if obj.Err == nil && obj.Key == filestoreRestoreMarker {
 				continue
 			}
-			object := BackupObject{Path: obj.Key, Size: obj.Size, LastModified: obj.LastModified}
+			object := BackupObject{Path: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified}
 			if obj.Err != nil {
 				object = BackupObject{Err: fmt.Errorf("list objects: %v", obj.Err)}
 			}
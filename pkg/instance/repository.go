@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/gosimple/slug"
 
@@ -68,6 +69,104 @@ func (r repository) DeleteDeployment(ctx context.Context, deployment *model.Depl
 	return nil
 }
 
+// TrashDeployment moves the deployment and its instances to the trash releasing the database locks
+// held by the instances
+func (r repository) TrashDeployment(ctx context.Context, deployment *model.Deployment) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		instanceIds := tx.Model(&model.DeploymentInstance{}).Select("id").Where("deployment_id = ?", deployment.ID)
+		if err := tx.Unscoped().Delete(&model.Lock{}, "instance_id IN (?)", instanceIds).Error; err != nil {
+			return fmt.Errorf("failed to release database locks held by deployment %q: %v", deployment.Name, err)
+		}
+		if err := tx.Delete(&model.DeploymentInstance{}, "deployment_id = ?", deployment.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Deployment{}, deployment.ID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to trash deployment %q: %v", deployment.Name, err)
+	}
+
+	return nil
+}
+
+// RestoreDeployment restores the deployment and its instances from the trash
+func (r repository) RestoreDeployment(ctx context.Context, deployment *model.Deployment) error {
+	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
+	// cancellation can lead to rollbacks which we should decide individually.
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&model.DeploymentInstance{}).Where("deployment_id = ?", deployment.ID).Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&model.Deployment{}).Where("id = ?", deployment.ID).Updates(map[string]any{"deleted_at": nil, "ttl": deployment.TTL}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore deployment %q: %v", deployment.Name, err)
+	}
+
+	return nil
+}
+
+func (r repository) FindTrashedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error) {
+	var deployment *model.Deployment
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Joins("Group").
+		Joins("User").
+		Preload("Instances").
+		Where("deployments.deleted_at IS NOT NULL").
+		First(&deployment, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errdef.NewNotFound("deployment not found in trash by id: %d", id)
+		}
+		return nil, fmt.Errorf("failed to find trashed deployment: %v", err)
+	}
+
+	return deployment, nil
+}
+
+// FindTrashedDeployments finds the trashed deployments of the given groups, most recently trashed first
+func (r repository) FindTrashedDeployments(ctx context.Context, groupNames []string) ([]*model.Deployment, error) {
+	db := r.db.WithContext(ctx).Unscoped()
+
+	isAdmin := slices.Contains(groupNames, administratorGroupName)
+	if !isAdmin {
+		db = db.Where("deployments.group_name IN ?", groupNames)
+	}
+
+	var deployments []*model.Deployment
+	err := db.
+		Joins("Group").
+		Joins("User").
+		Preload("Instances").
+		Where("deployments.deleted_at IS NOT NULL").
+		Order("deployments.deleted_at desc").
+		Find(&deployments).Error
+
+	return deployments, err
+}
+
+// FindDeploymentsTrashedBefore finds the deployments trashed before the given time
+func (r repository) FindDeploymentsTrashedBefore(ctx context.Context, before time.Time) ([]model.Deployment, error) {
+	var deployments []model.Deployment
+	err := r.db.
+		WithContext(ctx).
+		Unscoped().
+		Where("deleted_at < ?", before).
+		Order("deleted_at").
+		Find(&deployments).Error
+
+	return deployments, err
+}
+
 func (r repository) SaveDeployment(ctx context.Context, deployment *model.Deployment) error {
 	// only use ctx for values (logging) and not cancellation signals on cud operations for now. ctx
 	// cancellation can lead to rollbacks which we should decide individually.
@@ -230,6 +329,19 @@ func (r repository) RecordBackup(ctx context.Context, database *model.Database)
 	return err
 }
 
+func (r repository) FindDatabaseById(ctx context.Context, id uint) (*model.Database, error) {
+	var database *model.Database
+	err := r.db.WithContext(ctx).First(&database, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errdef.NewNotFound("database not found by id: %d", id)
+		}
+		return nil, fmt.Errorf("failed to find database: %v", err)
+	}
+
+	return database, nil
+}
+
 func (r repository) SaveDatabase(ctx context.Context, database *model.Database) error {
 	return r.db.WithContext(ctx).Save(&database).Error
 }
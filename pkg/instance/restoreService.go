@@ -12,25 +12,28 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
 	"github.com/minio/minio-go/v7"
 	"golang.org/x/sync/errgroup"
 )
 
-func NewRestoreService(logger *slog.Logger, minioClient MinioRestoreClient, s3Client S3RestoreClient) *RestoreService {
+func NewRestoreService(logger *slog.Logger, minioClient MinioRestoreClient, objectStore storage.ObjectStore) *RestoreService {
 	return &RestoreService{
 		minioClient: minioClient,
-		s3Client:    s3Client,
+		objectStore: objectStore,
 		logger:      logger,
 	}
 }
 
+// RestoreService restores filestore backups, gzip'd tars, into a bucket
 type RestoreService struct {
 	minioClient MinioRestoreClient
-	s3Client    S3RestoreClient
+	objectStore storage.ObjectStore
 	logger      *slog.Logger
 }
 
@@ -40,10 +43,6 @@ type MinioRestoreClient interface {
 	RemoveObjects(ctx context.Context, bucketName string, objectsCh <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) <-chan minio.RemoveObjectError
 }
 
-type S3RestoreClient interface {
-	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
-}
-
 type RestoreStats struct {
 	ObjectsRestored int64
 	BytesRestored   int64
@@ -58,70 +57,63 @@ func (rs *RestoreStats) increment(size int64) {
 	rs.BytesRestored += size
 }
 
+// PerformRestore restores the filestore backup stored under s3Key into minioBucket. Incremental
+// backups are restored along with the chain of backups they're based on.
 func (s *RestoreService) PerformRestore(ctx context.Context, s3Bucket, s3Key, minioBucket string) error {
 	stats := &RestoreStats{StartTime: time.Now()}
 
-	output, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &s3Bucket,
-		Key:    &s3Key,
-	})
-	if err != nil {
-		return fmt.Errorf("get s3 object: %w", err)
-	}
-	if output == nil || output.Body == nil {
-		return errors.New("received nil response or body from S3")
-	}
-	defer output.Body.Close()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		err := filestore.Download(ctx, s.objectStore, s3Bucket, s3Key, pw)
+		pw.CloseWithError(err)
+	}()
 
-	gzReader, err := gzip.NewReader(output.Body)
+	gzReader, err := gzip.NewReader(pr)
 	if err != nil {
 		return fmt.Errorf("create gzip reader: %w", err)
 	}
 	defer gzReader.Close()
 
+	// tar entries have to be read sequentially, so objects are restored one at a time
 	tarReader := tar.NewReader(gzReader)
-	objectCh := make(chan *tar.Header)
-
-	g, ctx := errgroup.WithContext(ctx)
-
-	// Start header reader goroutine
-	g.Go(func() error {
-		defer close(objectCh)
-		for {
-			header, err := tarReader.Next()
-			if err == io.EOF {
-				return nil
-			}
-			if err != nil {
-				return fmt.Errorf("read tar header: %w", err)
-			}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar header: %w", err)
+		}
+		// backups of the filesystem backend contain directories and keys prefixed by "./"
+		header.Name = strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || header.Name == "" {
+			continue
+		}
 
-			select {
-			case objectCh <- header:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+		if err := s.restoreObject(ctx, minioBucket, header, tarReader, stats); err != nil {
+			return fmt.Errorf("restore object %s: %w", header.Name, err)
 		}
-	})
+	}
 
-	// Start restore worker goroutine
-	g.Go(func() error {
-		for header := range objectCh {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+	s.logRestoreStats(stats)
+	return nil
+}
 
-			if err := s.restoreObject(ctx, minioBucket, header, tarReader, stats); err != nil {
-				return fmt.Errorf("restore object %s: %w", header.Name, err)
-			}
-		}
-		return nil
-	})
+// PerformFileRestore restores a single file of a filestore backup stored in s3Bucket into minioBucket
+func (s *RestoreService) PerformFileRestore(ctx context.Context, s3Bucket string, file filestore.File, minioBucket string) error {
+	stats := &RestoreStats{StartTime: time.Now()}
 
-	if err := g.Wait(); err != nil {
-		return err
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		err := filestore.Extract(ctx, s.objectStore, s3Bucket, file, pw)
+		pw.CloseWithError(err)
+	}()
+
+	header := &tar.Header{Name: file.Path, Size: file.Size}
+	if err := s.restoreObject(ctx, minioBucket, header, pr, stats); err != nil {
+		return fmt.Errorf("restore object %s: %w", file.Path, err)
 	}
 
 	s.logRestoreStats(stats)
@@ -17,11 +17,16 @@ func Routes(r *gin.Engine, authenticator gin.HandlerFunc, handler Handler) {
 	tokenAuthenticationRouter.GET("/instances/:id/logs", handler.Logs)
 	tokenAuthenticationRouter.GET("/instances/:id/status", handler.Status)
 	tokenAuthenticationRouter.GET("/instances/:id/details", handler.InstanceWithDetails)
+	tokenAuthenticationRouter.POST("/instances/:id/restore-database", handler.RestoreDatabase)
+	tokenAuthenticationRouter.POST("/instances/:id/restore-file", handler.RestoreFile)
 
 	tokenAuthenticationRouter.POST("/deployments", handler.SaveDeployment)
 	tokenAuthenticationRouter.GET("/deployments", handler.FindDeployments)
 	tokenAuthenticationRouter.GET("/deployments/:id", handler.FindDeploymentById)
 	tokenAuthenticationRouter.DELETE("/deployments/:id", handler.DeleteDeployment)
+	tokenAuthenticationRouter.GET("/deployments/trash", handler.FindTrashedDeployments)
+	tokenAuthenticationRouter.POST("/deployments/trash/:id/restore", handler.RestoreDeployment)
+	tokenAuthenticationRouter.DELETE("/deployments/trash/:id", handler.PurgeDeployment)
 	tokenAuthenticationRouter.POST("/deployments/:id/instance", handler.SaveInstance)
 	tokenAuthenticationRouter.PATCH("/deployments/:id/instance/:instanceId", handler.UpdateInstance)
 	tokenAuthenticationRouter.DELETE("/deployments/:id/instance/:instanceId", handler.DeleteDeploymentInstance)
@@ -10,10 +10,12 @@ import (
 	"log/slog"
 	"maps"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/dhis2-sre/im-manager/pkg/filestore"
 	"github.com/dhis2-sre/im-manager/pkg/storage"
 	"golang.org/x/sync/errgroup"
 
@@ -27,14 +29,14 @@ import (
 	"github.com/dhis2-sre/im-manager/pkg/model"
 )
 
-func NewService(logger *slog.Logger, instanceRepository *repository, groupService groupService, stackService stack.Service, helmfileService helmfile, s3Client *storage.S3Client, s3Bucket string) *Service {
+func NewService(logger *slog.Logger, instanceRepository *repository, groupService groupService, stackService stack.Service, helmfileService helmfile, objectStore storage.ObjectStore, s3Bucket string) *Service {
 	return &Service{
 		logger:             logger,
 		instanceRepository: instanceRepository,
 		groupService:       groupService,
 		stackService:       stackService,
 		helmfileService:    helmfileService,
-		s3Client:           s3Client,
+		objectStore:        objectStore,
 		s3Bucket:           s3Bucket,
 	}
 }
@@ -55,14 +57,14 @@ type Service struct {
 	groupService       groupService
 	stackService       stack.Service
 	helmfileService    helmfile
-	s3Client           *storage.S3Client
+	objectStore        storage.ObjectStore
 	s3Bucket           string
 }
 
 // restoreFilestoreToS3 restores the given filestore backup into the instance's external
 // S3 bucket, since external S3 has no pod to seed the way minio/filesystem do.
-func (s Service) restoreFilestoreToS3(ctx context.Context, core *model.DeploymentInstance, filestore *model.Database) error {
-	key := strings.TrimPrefix(filestore.Url, fmt.Sprintf("s3://%s/", s.s3Bucket))
+func (s Service) restoreFilestoreToS3(ctx context.Context, core *model.DeploymentInstance, backup *model.Database) error {
+	key := s.objectKey(backup.Url)
 
 	client, err := newExternalS3Client(core)
 	if err != nil {
@@ -88,7 +90,7 @@ func (s Service) restoreFilestoreToS3(ctx context.Context, core *model.Deploymen
 	// g.Wait returns, so the marker write below must use the outer detached ctx.
 	g, streamCtx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		err := s.s3Client.Download(streamCtx, s.s3Bucket, key, pw, func(int64) {})
+		err := filestore.Download(streamCtx, s.objectStore, s.s3Bucket, key, pw)
 		pw.CloseWithError(err)
 		return err
 	})
@@ -502,7 +504,26 @@ func (s Service) Delete(ctx context.Context, deploymentInstanceId uint) error {
 	return nil
 }
 
+// DeleteDeployment destroys the instances of the deployment and permanently deletes it. Use
+// TrashDeployment to delete deployments restorably.
 func (s Service) DeleteDeployment(ctx context.Context, deployment *model.Deployment) error {
+	err := s.destroyInstances(ctx, deployment, func(instance *model.DeploymentInstance) error {
+		err := s.instanceRepository.DeleteDeploymentInstance(ctx, instance)
+		if err != nil {
+			return fmt.Errorf("failed to delete instance(%s) %q: %v", instance.StackName, instance.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.instanceRepository.DeleteDeployment(ctx, deployment)
+}
+
+// destroyInstances destroys the instances of the deployment in reverse deployment order calling
+// destroyed for each destroyed instance
+func (s Service) destroyInstances(ctx context.Context, deployment *model.Deployment, destroyed func(instance *model.DeploymentInstance) error) error {
 	instances, err := s.DeploymentOrder(deployment)
 	if err != nil {
 		return err
@@ -517,16 +538,10 @@ func (s Service) DeleteDeployment(ctx context.Context, deployment *model.Deploym
 			continue
 		}
 
-		err = s.instanceRepository.DeleteDeploymentInstance(ctx, instance)
-		if err != nil {
-			errs = errors.Join(errs, fmt.Errorf("failed to delete instance(%s) %q: %v", instance.StackName, instance.Name, err))
-		}
-	}
-	if errs != nil {
-		return errs
+		errs = errors.Join(errs, destroyed(instance))
 	}
 
-	return s.instanceRepository.DeleteDeployment(ctx, deployment)
+	return errs
 }
 
 func (s Service) DestroyInstance(ctx context.Context, instance *model.DeploymentInstance) error {
@@ -855,7 +870,11 @@ func (s Service) GetStatus(instance *model.DeploymentInstance) (InstanceStatus,
 	return "", fmt.Errorf("failed to get instance status")
 }
 
-func (s Service) FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database) error {
+// FilestoreBackup backs up the filestore of the instance and links the backup to the given database.
+// If the database already has a filestore the backup is incremental: it only contains the objects
+// which changed since the current filestore of the database, which is replaced by the backup. The
+// progress of the backup is tracked by the given progress tracker, which can be nil.
+func (s Service) FilestoreBackup(ctx context.Context, instance *model.DeploymentInstance, name string, database *model.Database, progress *storage.ProgressTracker) error {
 	// Detach from the request context so the backup isn't cancelled if the client disconnects.
 	ctx = context.WithoutCancel(ctx)
 
@@ -875,34 +894,244 @@ func (s Service) FilestoreBackup(ctx context.Context, instance *model.Deployment
 	baseName = strings.TrimSuffix(baseName, ".pgc")
 	baseName = strings.TrimSuffix(baseName, ".tar.gz")
 
-	streamer, err := s.filestoreStreamerFor(core, group.Cluster)
+	streamer, err := s.filestoreStreamerFor(core, group.Cluster, progress)
+	if err != nil {
+		return err
+	}
+
+	objects, err := streamer.list(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list filestore: %v", err)
+	}
+
+	current, parent, err := s.filestoreParent(ctx, database)
 	if err != nil {
 		return err
 	}
 
 	key := fmt.Sprintf("%s/%s-%s.tar.gz", instance.GroupName, baseName, "fs")
-	backupService := NewBackupService(s.logger, s.s3Client)
-	if err := backupService.PerformBackup(ctx, streamer, s.s3Bucket, key); err != nil {
+	if current != nil {
+		// The current filestore isn't overwritten so it stays intact until it's replaced
+		key = fmt.Sprintf("%s/%s-%s-%d.tar.gz", instance.GroupName, baseName, "fs", time.Now().Unix())
+	}
+
+	manifest := filestore.Manifest{Objects: manifestObjects(objects)}
+	var backupStreamer filestoreStreamer = streamer
+	if parent != nil {
+		manifest.Parent = s.objectKey(current.Url)
+		manifest.Depth = parent.Depth + 1
+		changed := backupObjects(parent.Changed(manifest.Objects))
+		backupStreamer = changedObjects{streamer: streamer, objects: changed}
+		s.logger.InfoContext(ctx, "Incremental filestore backup", "key", key, "parent", manifest.Parent, "objects", len(objects), "changed", len(changed))
+	}
+
+	backupService := NewBackupService(s.logger, s.objectStore)
+	checksum, size, err := backupService.PerformBackup(ctx, backupStreamer, s.s3Bucket, key, progress)
+	if err != nil {
+		return err
+	}
+
+	err = filestore.WriteManifest(ctx, s.objectStore, s.s3Bucket, key, manifest)
+	if err != nil {
 		return err
 	}
 
 	s3Uri := fmt.Sprintf("s3://%s/%s", s.s3Bucket, key)
-	filestore, err := s.recordBackup(ctx, instance.GroupName, s3Uri, baseName+"-fs.tar.gz", database.UserID)
+	if current == nil {
+		backup, err := s.recordBackup(ctx, instance.GroupName, s3Uri, baseName+"-fs.tar.gz", checksum, size, database.UserID)
+		if err != nil {
+			return err
+		}
+
+		database.FilestoreID = backup.ID
+
+		return s.instanceRepository.SaveDatabase(ctx, database)
+	}
+
+	return s.replaceFilestore(ctx, current, parent != nil, s3Uri, checksum, size)
+}
+
+// replaceFilestore points the filestore record to its new backup. The backups of the filestore are
+// deleted unless the new backup is based on them.
+func (s Service) replaceFilestore(ctx context.Context, current *model.Database, incremental bool, s3Uri, checksum string, size int64) error {
+	var replaced []string
+	if incremental {
+		size += current.Size
+		// the checksum of the backup doesn't match the assembled filestore served by downloads
+		checksum = ""
+	} else {
+		keys, err := filestore.Chain(ctx, s.objectStore, s.s3Bucket, s.objectKey(current.Url))
+		if err != nil {
+			return err
+		}
+		replaced = keys
+	}
+
+	current.Url = s3Uri
+	current.Checksum = checksum
+	current.Size = size
+	current.Incremental = incremental
+
+	err := s.instanceRepository.SaveDatabase(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	err = filestore.Delete(s.objectStore, s.s3Bucket, replaced...)
+	if err != nil {
+		// The filestore has been replaced so the backup succeeded
+		s.logger.ErrorContext(ctx, "Failed to delete replaced filestore backups", "filestoreId", current.ID, "keys", replaced, "error", err)
+	}
+
+	return nil
+}
+
+// RestoreFilestore replaces the filestore of the instance with the given filestore backup. DHIS2
+// must be stopped while its filestore is replaced. The filesystem backend is restored into the volume
+// of DHIS2 once its pods are gone.
+func (s Service) RestoreFilestore(ctx context.Context, instance *model.DeploymentInstance, backup *model.Database) error {
+	group, err := s.groupService.Find(ctx, instance.GroupName)
+	if err != nil {
+		return err
+	}
+
+	// Re-fetch decrypted so STORAGE_TYPE and any external S3 credentials are populated.
+	core, err := s.FindDecryptedDeploymentInstanceById(ctx, instance.ID)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(backup.Url)
+
+	if storageType(core) == "s3" {
+		client, err := newExternalS3Client(core)
+		if err != nil {
+			return err
+		}
+
+		bucket := core.Parameters["S3_BUCKET"].Value
+		if err := ensureBucket(ctx, client, bucket, core.Parameters["S3_REGION"].Value); err != nil {
+			return err
+		}
+
+		restoreService := NewRestoreService(s.logger, client, s.objectStore)
+		if err := restoreService.PerformPurge(ctx, bucket); err != nil {
+			return err
+		}
+		if err := restoreService.PerformRestore(ctx, s.s3Bucket, key, bucket); err != nil {
+			return err
+		}
+
+		// The marker keeps a redeploy from restoring the filestore configured at deploy time over this one
+		return markFilestoreRestored(ctx, client, bucket)
+	}
+
+	var restorer interface {
+		restore(ctx context.Context, r io.Reader) error
+	}
+	if storageType(core) == "filesystem" {
+		restorer, err = filesystemRestorerFor(ctx, core, group.Cluster)
+	} else {
+		restorer, err = filestoreRestorerFor(core, group.Cluster)
+	}
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	g, streamCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := filestore.Download(streamCtx, s.objectStore, s.s3Bucket, key, pw)
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		err := restorer.restore(streamCtx, pr)
+		pr.CloseWithError(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("filestore restore failed: %v", err)
+	}
+
+	s.logger.InfoContext(ctx, "Filestore restored", "instanceId", instance.ID, "storageType", storageType(core), "key", key)
+	return nil
+}
+
+// RestoreFilestoreFile restores the file with the given path of the given filestore backup into the
+// filestore of the instance. Unlike RestoreFilestore the other files of the instance are kept so
+// DHIS2 doesn't need to be stopped.
+func (s Service) RestoreFilestoreFile(ctx context.Context, instance *model.DeploymentInstance, backup *model.Database, filePath string) error {
+	group, err := s.groupService.Find(ctx, instance.GroupName)
+	if err != nil {
+		return err
+	}
+
+	// Re-fetch decrypted so STORAGE_TYPE and any external S3 credentials are populated.
+	core, err := s.FindDecryptedDeploymentInstanceById(ctx, instance.ID)
+	if err != nil {
+		return err
+	}
+
+	file, err := filestore.FindFile(ctx, s.objectStore, s.s3Bucket, s.objectKey(backup.Url), filePath)
+	if err != nil {
+		return err
+	}
+
+	// the path is taken from the backup and would otherwise be able to escape the filestore
+	if !filepath.IsLocal(file.Path) {
+		return errdef.NewBadRequest("invalid path %q", file.Path)
+	}
+
+	if storageType(core) == "s3" {
+		client, err := newExternalS3Client(core)
+		if err != nil {
+			return err
+		}
+
+		bucket := core.Parameters["S3_BUCKET"].Value
+		if err := ensureBucket(ctx, client, bucket, core.Parameters["S3_REGION"].Value); err != nil {
+			return err
+		}
+
+		restoreService := NewRestoreService(s.logger, client, s.objectStore)
+		return restoreService.PerformFileRestore(ctx, s.s3Bucket, *file, bucket)
+	}
+
+	restorer, err := filestoreRestorerFor(core, group.Cluster)
 	if err != nil {
 		return err
 	}
+	restorer.command = fileRestoreCommand(core, file.Path)
 
-	database.FilestoreID = filestore.ID
+	pr, pw := io.Pipe()
+	g, streamCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := filestore.Extract(streamCtx, s.objectStore, s.s3Bucket, *file, pw)
+		pw.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		err := restorer.restore(streamCtx, pr)
+		pr.CloseWithError(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("filestore file restore failed: %v", err)
+	}
 
-	return s.instanceRepository.SaveDatabase(ctx, database)
+	s.logger.InfoContext(ctx, "Filestore file restored", "instanceId", instance.ID, "storageType", storageType(core), "path", file.Path)
+	return nil
 }
 
-func (s Service) recordBackup(ctx context.Context, groupName, s3uri, name string, userID uint) (*model.Database, error) {
+func (s Service) recordBackup(ctx context.Context, groupName, s3uri, name, checksum string, size int64, userID uint) (*model.Database, error) {
 	database := &model.Database{
 		Name:      name,
 		GroupName: groupName,
 		Url:       s3uri,
 		Type:      "fs",
+		Size:      size,
+		Checksum:  checksum,
 		UserID:    userID,
 	}
 	err := s.instanceRepository.RecordBackup(ctx, database)
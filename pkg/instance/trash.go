@@ -0,0 +1,129 @@
+package instance
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+)
+
+// TrashDeployment destroys the instances of the deployment and moves the deployment to the trash.
+// The deployment and the parameters of its instances can be restored and deployed again until the
+// deployment is purged. The names of the deployment and its instances stay reserved until then.
+func (s Service) TrashDeployment(ctx context.Context, deployment *model.Deployment) error {
+	err := s.destroyInstances(ctx, deployment, func(*model.DeploymentInstance) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.instanceRepository.TrashDeployment(ctx, deployment)
+}
+
+// FindTrashedDeployments finds the trashed deployments of the groups of the user
+func (s Service) FindTrashedDeployments(ctx context.Context, user *model.User) ([]GroupWithDeployments, error) {
+	groups := append(user.Groups, user.AdminGroups...) //nolint:gocritic
+
+	groupsByName := make(map[string]model.Group)
+	for _, group := range groups {
+		groupsByName[group.Name] = group
+	}
+	groupNames := slices.Collect(maps.Keys(groupsByName))
+
+	deployments, err := s.instanceRepository.FindTrashedDeployments(ctx, groupNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deployments) < 1 {
+		return []GroupWithDeployments{}, nil
+	}
+
+	return s.groupDeployments(deployments)
+}
+
+func (s Service) FindTrashedDeploymentById(ctx context.Context, id uint) (*model.Deployment, error) {
+	return s.instanceRepository.FindTrashedDeploymentById(ctx, id)
+}
+
+// RestoreDeployment restores the deployment and its instances from the trash. The instances aren't
+// deployed. The TTL is extended so the deployment lives for its TTL again from now on rather than
+// being trashed again by the inspector if it already expired.
+func (s Service) RestoreDeployment(ctx context.Context, id uint) (*model.Deployment, error) {
+	deployment, err := s.instanceRepository.FindTrashedDeploymentById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	deployment.TTL += uint(time.Since(deployment.CreatedAt).Seconds())
+
+	err = s.instanceRepository.RestoreDeployment(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.instanceRepository.FindDeploymentById(ctx, id)
+}
+
+// PurgeDeployment permanently deletes the deployment and its instances from the trash
+func (s Service) PurgeDeployment(ctx context.Context, id uint) error {
+	deployment, err := s.instanceRepository.FindTrashedDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.instanceRepository.DeleteDeployment(ctx, deployment)
+}
+
+// PurgeTrash permanently deletes the deployments trashed before the given time.
+func (s Service) PurgeTrash(ctx context.Context, before time.Time) error {
+	deployments, err := s.instanceRepository.FindDeploymentsTrashedBefore(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	for _, deployment := range deployments {
+		err := s.instanceRepository.DeleteDeployment(ctx, &deployment)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to purge trashed deployment", "group", deployment.GroupName, "deploymentId", deployment.ID, "error", err)
+			continue
+		}
+		s.logger.InfoContext(ctx, "Purged trashed deployment", "group", deployment.GroupName, "deploymentId", deployment.ID, "name", deployment.Name)
+	}
+
+	return nil
+}
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewTrashPurger(logger *slog.Logger, service *Service, retentionDays uint, interval time.Duration) trashPurger {
+	return trashPurger{logger, service, retentionDays, interval}
+}
+
+type trashPurger struct {
+	logger        *slog.Logger
+	service       *Service
+	retentionDays uint
+	interval      time.Duration
+}
+
+// Purge periodically purges the deployments which have been in the trash for longer than the
+// retention period.
+func (t trashPurger) Purge(ctx context.Context) {
+	for {
+		time.Sleep(t.interval)
+
+		t.logger.InfoContext(ctx, "Purging trashed deployments...")
+
+		before := time.Now().AddDate(0, 0, -int(t.retentionDays))
+		err := t.service.PurgeTrash(ctx, before)
+		if err != nil {
+			t.logger.ErrorContext(ctx, "Failed to purge trashed deployments", "error", err)
+			continue
+		}
+
+		t.logger.InfoContext(ctx, "Trashed deployments purged")
+	}
+}
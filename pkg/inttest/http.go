@@ -31,7 +31,7 @@ func SetupHTTPServer(t *testing.T, f func(engine *gin.Engine)) *HTTPClient {
 	gin.SetMode(gin.TestMode)
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	engine, err := server.GetEngine(logger, "", []string{"http://localhost"})
+	engine, err := server.GetEngine(logger, "", []string{"http://localhost"}, nil)
 	require.NoError(t, err, "failed to setup Gin")
 	f(engine)
 
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// BackupSchedule periodically backs up the database and filestore of a deployment
+// swagger:model
+type BackupSchedule struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	DeploymentID uint        `json:"deploymentId" gorm:"uniqueIndex"`
+	Deployment   *Deployment `json:"deployment,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	// UserID is the user owning the backups
+	UserID uint  `json:"userId"`
+	User   *User `json:"user,omitempty"`
+
+	// Cron expression in UTC, e.g. "0 2 * * *" for every night at 02:00
+	Cron string `json:"cron"`
+	// Keep is the number of backups kept, older backups are deleted. 0 keeps all backups
+	Keep    uint `json:"keep"`
+	Enabled bool `json:"enabled"`
+
+	NextRunAt  time.Time  `json:"nextRunAt" gorm:"index"`
+	LastRunAt  *time.Time `json:"lastRunAt"`
+	LastStatus string     `json:"lastStatus"`
+	LastError  string     `json:"lastError"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Comparison groups deployments running different DHIS2 versions on copies of the same database
+// swagger:model
+type Comparison struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	UserID uint  `json:"userId"`
+	User   *User `json:"user,omitempty"`
+
+	Name      string `json:"name" gorm:"index:comparison_name_group_idx,unique"`
+	GroupName string `json:"groupName" gorm:"index:comparison_name_group_idx,unique; references:Name"`
+	Group     *Group `json:"group,omitempty"`
+
+	// DatabaseID is the id of the source database which each deployment is seeded from a copy of
+	DatabaseID uint `json:"databaseId"`
+
+	TTL uint `json:"ttl"`
+
+	Deployments []*Deployment `json:"deployments"`
+}
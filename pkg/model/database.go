@@ -1,31 +1,104 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // swagger:model
 type Database struct {
-	ID                uint               `json:"id" gorm:"primaryKey"`
-	CreatedAt         time.Time          `json:"createdAt"`
-	UpdatedAt         time.Time          `json:"updatedAt"`
-	Name              string             `json:"name" gorm:"index:database_name_group_idx,unique"`
-	GroupName         string             `json:"groupName" gorm:"index:database_name_group_idx,unique"`
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// DeletedAt is set while the database is in the trash
+	DeletedAt         gorm.DeletedAt     `json:"deletedAt" gorm:"index"`
+	Name              string             `json:"name" gorm:"index:database_name_group_idx,unique,where:deleted_at IS NULL"`
+	GroupName         string             `json:"groupName" gorm:"index:database_name_group_idx,unique,where:deleted_at IS NULL"`
 	Description       string             `json:"description" gorm:"type:text"`
 	Url               string             `json:"url"` // s3... Path?
 	ExternalDownloads []ExternalDownload `json:"externalDownloads" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Lock              *Lock              `json:"lock" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
-	Slug              string             `json:"slug" gorm:"uniqueIndex"`
+	Slug              string             `json:"slug" gorm:"uniqueIndex:idx_databases_slug,where:deleted_at IS NULL"`
 	Type              string             `json:"type"` // TODO: Strictly sql or fs?
-	FilestoreID       uint               `json:"filestoreId"`
-	Filestore         *Database          `json:"filestore" gorm:"foreignKey:ID"`
-	UserID            uint               `json:"userId"`
-	User              User               `json:"user"`
-	Size              int64              `json:"size"`
+	// Format of the dump, either "plain" (gzipped SQL), "custom" (pg_dump custom format) or "directory" (tar of a pg_dump directory format dump). Empty if unknown
+	Format      string    `json:"format"`
+	FilestoreID uint      `json:"filestoreId"`
+	Filestore   *Database `json:"filestore" gorm:"foreignKey:ID"`
+	UserID      uint      `json:"userId"`
+	User        User      `json:"user"`
+	Size        int64     `json:"size"`
+	// Checksum is the hex encoded SHA-256 checksum of the stored object
+	Checksum string `json:"checksum"`
+	// Incremental is set on filestores whose stored object only contains the objects which changed
+	// since the backup it's based on. Their size includes the size of the backups they're based on.
+	Incremental bool `json:"incremental"`
+	// BackupScheduleID is the id of the backup schedule which created the database, 0 if it wasn't
+	// created by a backup schedule
+	BackupScheduleID uint `json:"backupScheduleId" gorm:"index"`
+	// LastSeededAt is the last time the database was used to seed a deployment
+	LastSeededAt *time.Time `json:"lastSeededAt"`
+	// AnonymizationProfile is the name of the anonymization profile applied to the database, empty
+	// if the database isn't anonymized
+	AnonymizationProfile string `json:"anonymizationProfile"`
+	// Labels are key/value pairs used to organise and filter databases
+	Labels Labels `json:"labels" gorm:"type:jsonb;not null;default:'{}'"`
+	// Metadata extracted from the stored dump, only loaded when finding a single database
+	Metadata *DatabaseMetadata `json:"metadata,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Shares are the groups the database is shared with
+	Shares []DatabaseShare `json:"shares" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
+// DatabaseShare grants the members of a group read-only access to a database of another group. They
+// can find, download and deploy the database and copy it into their own groups.
+// swagger:model
+type DatabaseShare struct {
+	DatabaseID uint      `json:"databaseId" gorm:"primaryKey;autoIncrement:false"`
+	GroupName  string    `json:"groupName" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// UserID is the id of the user who shared the database
+	UserID uint `json:"userId"`
+}
+
+// DatabaseMetadata describes the content of a stored database dump. It's extracted after the database
+// is stored.
+// swagger:model
+type DatabaseMetadata struct {
+	DatabaseID uint      `json:"databaseId" gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	// Format of the dump, either "plain", "custom" or "directory"
+	Format string `json:"format"`
+	// PostgresVersion is the version of the PostgreSQL server the database was dumped from
+	PostgresVersion string `json:"postgresVersion"`
+	// PgDumpVersion is the version of pg_dump used to dump the database
+	PgDumpVersion string `json:"pgDumpVersion"`
+	// SchemaVersion is the latest successfully applied flyway migration, e.g. 2.41.23
+	SchemaVersion string `json:"schemaVersion"`
+	// DHIS2Version is the DHIS2 version derived from the schema version, e.g. 2.41
+	DHIS2Version string `json:"dhis2Version"`
+	// Tables are the tables of the database sorted by name
+	Tables []TableMetadata `json:"tables" gorm:"serializer:json"`
+	// ExcludedTables are the tables whose data wasn't dumped, e.g. analytics tables
+	ExcludedTables []string `json:"excludedTables" gorm:"serializer:json"`
+	// Error is set if the metadata couldn't be extracted
+	Error string `json:"error,omitempty" gorm:"type:text"`
+}
+
+type TableMetadata struct {
+	Name string `json:"name"`
+	// Rows is the number of rows estimated by PostgreSQL when the database was saved from an
+	// instance. It's unknown for databases which were uploaded or imported since only the table of
+	// contents of their dump is read.
+	Rows *int64 `json:"rows,omitempty"`
+}
+
+// Lock prevents a database from being modified by others than the instance holding it. Locks expire
+// unless they're renewed by the heartbeat of the instance holding them.
 // swagger:model
 type Lock struct {
 	DatabaseID uint               `json:"databaseId" gorm:"primaryKey"`
@@ -33,11 +106,150 @@ type Lock struct {
 	Instance   DeploymentInstance `json:"instance,omitempty"`
 	UserID     uint               `json:"userId"`
 	User       User               `json:"user,omitempty"`
+	AcquiredAt time.Time          `json:"acquiredAt" gorm:"not null;default:now()"`
+	ExpiresAt  time.Time          `json:"expiresAt" gorm:"not null;default:now();index"`
+}
+
+// ForcedUnlock records a lock which was released by a group administrator on behalf of the user
+// holding it.
+// swagger:model
+type ForcedUnlock struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	DatabaseID uint      `json:"databaseId" gorm:"index"`
+	Database   *Database `json:"database,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// InstanceID is the id of the instance which held the lock
+	InstanceID uint `json:"instanceId"`
+	// LockUserID is the id of the user who held the lock
+	LockUserID uint `json:"lockUserId"`
+	// UserID is the id of the administrator who released the lock
+	UserID uint   `json:"userId"`
+	Reason string `json:"reason" gorm:"type:text"`
 }
 
 // swagger:model
 type ExternalDownload struct {
 	UUID       uuid.UUID `json:"uuid" gorm:"primaryKey;type:uuid"`
+	CreatedAt  time.Time `json:"createdAt"`
 	Expiration uint      `json:"expiration"`
 	DatabaseID uint      `json:"databaseId"`
+	// Version of the database to download, 0 refers to the current version
+	Version uint `json:"version"`
+	// UserID is the id of the user who created the link, 0 if created by the system
+	UserID uint `json:"userId"`
+	// MaxDownloads is the number of times the link can be used, 0 doesn't limit the number of downloads.
+	// Limited downloads are served directly rather than redirected to a reusable presigned URL.
+	MaxDownloads uint `json:"maxDownloads"`
+	// Downloads is the number of times content has been sent through the link
+	Downloads uint `json:"downloads"`
+	// RevokedAt is the time the link was revoked, revoked links can't be used
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ExternalDownloadAccess records a download through an external download link. Accesses are kept
+// after the link expires so downloads of the database can be audited.
+// swagger:model
+type ExternalDownloadAccess struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time `json:"createdAt"`
+	DatabaseID   uint      `json:"databaseId" gorm:"index"`
+	Database     *Database `json:"database,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	DownloadUUID uuid.UUID `json:"downloadUuid" gorm:"type:uuid;index"`
+	Version      uint      `json:"version"`
+	ClientIP     string    `json:"clientIp"`
+	UserAgent    string    `json:"userAgent"`
+	// BytesSent is the number of bytes sent to the client, 0 for presigned downloads
+	BytesSent int64 `json:"bytesSent"`
+	// Presigned is set if the client was redirected to download the file directly from S3
+	Presigned bool `json:"presigned"`
+	// Error is set if the download failed
+	Error string `json:"error,omitempty" gorm:"type:text"`
+}
+
+// DatabaseVersion is an immutable snapshot of a database. Each save of a database creates a new
+// version under the same logical database.
+// swagger:model
+type DatabaseVersion struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	DatabaseID uint      `json:"databaseId" gorm:"index:database_version_idx,unique"`
+	Version    uint      `json:"version" gorm:"index:database_version_idx,unique"`
+	Url        string    `json:"url"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	UserID     uint      `json:"userId"`
+	User       User      `json:"user"`
+}
+
+// KeepLabel excludes the databases labelled with it from retention policies regardless of its value
+const KeepLabel = "keep"
+
+// RetentionPolicy expires databases and filestores of a group which haven't been used to seed a
+// deployment within the given number of days. Databases labelled keep are never expired.
+// swagger:model
+type RetentionPolicy struct {
+	GroupName  string    `json:"groupName" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	UnusedDays uint      `json:"unusedDays"`
+	Enabled    bool      `json:"enabled"`
+}
+
+// DatabaseUpload is a resumable upload of a database. Chunks are uploaded as parts of an S3 multipart
+// upload into the database created along with the upload.
+// swagger:model
+type DatabaseUpload struct {
+	ID         uuid.UUID `json:"id" gorm:"primaryKey;type:uuid"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" gorm:"index"`
+	DatabaseID uint      `json:"databaseId"`
+	Database   *Database `json:"database,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserID     uint      `json:"userId"`
+	Key        string    `json:"-"`
+	// S3UploadID is the id of the S3 multipart upload
+	S3UploadID string `json:"-"`
+	// Format of the dump detected from the first chunk
+	Format string               `json:"format"`
+	Parts  []DatabaseUploadPart `json:"parts" gorm:"foreignKey:UploadID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// DatabaseUploadPart is a chunk which has been received by a DatabaseUpload
+// swagger:model
+type DatabaseUploadPart struct {
+	UploadID   uuid.UUID `json:"-" gorm:"primaryKey;type:uuid"`
+	PartNumber uint      `json:"partNumber" gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Size       int64     `json:"size"`
+	ETag       string    `json:"-"`
+}
+
+// Labels are key/value pairs attached to a database. They're stored as a JSON object so they can be
+// filtered using the containment operator backed by a GIN index.
+type Labels map[string]string
+
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	value, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(value), nil
+}
+
+func (l *Labels) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = Labels{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type %T for labels", value)
+	}
+	return json.Unmarshal(data, l)
 }
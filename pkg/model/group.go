@@ -20,4 +20,34 @@ type Group struct {
 	AdminUsers  []User    `json:"adminUsers" gorm:"many2many:user_groups_admin;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 	ClusterID   *uint     `json:"clusterId"`
 	Cluster     Cluster   `json:"cluster" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	// StorageQuota is the number of bytes the databases, filestores and database versions of the group
+	// may occupy, 0 if unlimited
+	StorageQuota int64 `json:"storageQuota"`
+	// StorageUsage is only populated when finding a group with details
+	StorageUsage *StorageUsage `json:"storageUsage,omitempty" gorm:"-"`
+}
+
+// StorageUsage is the number of bytes stored by a group
+// swagger:model
+type StorageUsage struct {
+	Databases  int64 `json:"databases"`
+	Filestores int64 `json:"filestores"`
+	// Versions are the previous versions kept of databases and filestores
+	Versions int64 `json:"versions"`
+	Total    int64 `json:"total"`
+	// Quota of the group, 0 if unlimited
+	Quota int64 `json:"quota"`
+	// Users breaks the usage down by the users who stored the data
+	Users []UserStorageUsage `json:"users"`
+}
+
+// UserStorageUsage is the number of bytes stored by a user within a group
+// swagger:model
+type UserStorageUsage struct {
+	UserID     uint   `json:"userId"`
+	Email      string `json:"email"`
+	Databases  int64  `json:"databases"`
+	Filestores int64  `json:"filestores"`
+	Versions   int64  `json:"versions"`
+	Total      int64  `json:"total"`
 }
@@ -10,6 +10,8 @@ type Deployment struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// DeletedAt is set while the deployment is in the trash
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
 
 	UserID uint  `json:"userId"`
 	User   *User `json:"user,omitempty"`
@@ -21,6 +23,8 @@ type Deployment struct {
 
 	TTL uint `json:"ttl"`
 
+	ComparisonID *uint `json:"comparisonId,omitempty"`
+
 	Instances []*DeploymentInstance `json:"instances" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
@@ -30,6 +34,8 @@ type DeploymentInstance struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// DeletedAt is set while the deployment of the instance is in the trash
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
 
 	// TODO: FK to name of Deployment?
 	Name      string `json:"name" gorm:"index:deployment_instance_name_group_stack_idx,unique"`
@@ -7,6 +7,24 @@ const (
 	StatefulSetResource = KubernetesResource("statefulSet")
 )
 
+// ParameterType is the type of the values of a stack parameter. Values are passed as strings
+// regardless of their type.
+type ParameterType string
+
+const (
+	StringParameter = ParameterType("string")
+	IntParameter    = ParameterType("int")
+	BoolParameter   = ParameterType("bool")
+	EnumParameter   = ParameterType("enum")
+	// QuantityParameter is a Kubernetes quantity like 8Gi or 250m
+	QuantityParameter = ParameterType("quantity")
+	HostnameParameter = ParameterType("hostname")
+	// SecretParameter is a sensitive string like a password
+	SecretParameter = ParameterType("secret")
+	// TextParameter is a string spanning multiple lines
+	TextParameter = ParameterType("text")
+)
+
 // swagger:model StackDetail
 type Stack struct {
 	Name             string          `json:"name"`
@@ -20,6 +38,34 @@ type Stack struct {
 	// Companions are optional stacks that can be deployed alongside this stack. Certain parameters can require a companion stack.
 	Companions         []Stack `json:"companions"`
 	KubernetesResource KubernetesResource
+	// DumpOptions configure how the database of the stack is dumped. Only stacks running a database have dump options.
+	DumpOptions *DumpOptions `json:"dumpOptions,omitempty"`
+}
+
+// DumpOptions configure how pg_dump dumps the database of a stack
+type DumpOptions struct {
+	// Options passed to pg_dump
+	Options []string `json:"options"`
+	// ExcludeTableData are patterns of the tables whose data isn't dumped
+	ExcludeTableData []string `json:"excludeTableData"`
+}
+
+// DumpOverrides override the dump options of a stack for a single dump
+type DumpOverrides struct {
+	// Tables are patterns of the tables to dump, all tables are dumped if empty
+	Tables []string `json:"tables"`
+	// ExcludeTables are patterns of the tables which aren't dumped
+	ExcludeTables []string `json:"excludeTables"`
+	// ExcludeTableData are patterns of the tables whose data isn't dumped in addition to the ones of the stack
+	ExcludeTableData []string `json:"excludeTableData"`
+	// IncludeTableData are patterns of the stack whose table data is dumped after all
+	IncludeTableData []string `json:"includeTableData"`
+	// SchemaOnly dumps the schema without any data
+	SchemaOnly bool `json:"schemaOnly"`
+	// Compression level from 0 to 9, the default of pg_dump is used if not set
+	Compression *uint `json:"compression"`
+	// Jobs is the number of tables dumped in parallel. Only the directory format can be dumped in parallel
+	Jobs uint `json:"jobs"`
 }
 
 // swagger:model StackDetailParameters
@@ -32,6 +78,15 @@ type StackParameter struct {
 	DefaultValue *string `json:"defaultValue,omitempty"`
 	// Consumed signals that this parameter is provided by another stack i.e. one of the stacks required stacks.
 	Consumed bool `json:"consumed"`
+	// Type of the values of the parameter, StringParameter if empty.
+	Type ParameterType `json:"type,omitempty"`
+	// Enum lists the valid values of enum parameters.
+	Enum []string `json:"enum,omitempty"`
+	// Minimum and Maximum bound the values of int parameters.
+	Minimum *int64 `json:"minimum,omitempty"`
+	Maximum *int64 `json:"maximum,omitempty"`
+	// Pattern is a regular expression the whole value of string parameters has to match.
+	Pattern string `json:"pattern,omitempty"`
 	// Validator ensures that the actual stack parameters are valid according to its rules.
 	Validator func(value string) error `json:"-"`
 	// Priority determines the order in which the parameter is shown.
@@ -26,7 +26,7 @@ func (h Handler) Find(c *gin.Context) {
 	//
 	// Find stack
 	//
-	// Find stack by name
+	// Find stack by name. The schema of the stack is a JSON Schema of its parameters which can be used to render and validate forms
 	//
 	// Security:
 	//  oauth2:
@@ -50,7 +50,10 @@ func (h Handler) Find(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, toResponseStack(*stack))
+	response := toResponseStack(*stack)
+	schema := NewSchema(*stack)
+	response.Schema = &schema
+	c.JSON(http.StatusOK, response)
 }
 
 func toResponseStack(stack model.Stack) Stack {
@@ -69,6 +72,11 @@ func toResponseStack(stack model.Stack) Stack {
 			Consumed:      parameter.Consumed,
 			Priority:      parameter.Priority,
 			Sensitive:     parameter.Sensitive,
+			Type:          parameter.Type,
+			Enum:          parameter.Enum,
+			Minimum:       parameter.Minimum,
+			Maximum:       parameter.Maximum,
+			Pattern:       parameter.Pattern,
 		})
 	}
 
@@ -83,6 +91,12 @@ type StackParameter struct {
 	Consumed      bool    `json:"consumed"`
 	Priority      uint    `json:"priority"`
 	Sensitive     bool    `json:"sensitive"`
+	// Type of the values of the parameter, string if empty
+	Type    model.ParameterType `json:"type,omitempty"`
+	Enum    []string            `json:"enum,omitempty"`
+	Minimum *int64              `json:"minimum,omitempty"`
+	Maximum *int64              `json:"maximum,omitempty"`
+	Pattern string              `json:"pattern,omitempty"`
 }
 
 // swagger:model Stack
@@ -90,6 +104,8 @@ type Stack struct {
 	Name       string           `json:"name"`
 	Parameters []StackParameter `json:"parameters,omitempty"`
 	Requires   []Stack          `json:"requires,omitempty"`
+	// Schema is only returned when finding a single stack
+	Schema *Schema `json:"schema,omitempty"`
 }
 
 // FindAll stack
@@ -121,3 +137,33 @@ func (h Handler) FindAll(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, response)
 }
+
+// Reload stacks
+func (h Handler) Reload(c *gin.Context) {
+	// swagger:route POST /stacks/reload reloadStacks
+	//
+	// Reload stacks
+	//
+	// Reload the stacks from the manifests of the stacks folder. The current stacks are kept if any manifest is invalid. Only administrators can reload stacks
+	//
+	// Security:
+	//  oauth2:
+	//
+	// Responses:
+	//   200: Stacks
+	//   400: Error
+	//   401: Error
+	//   403: Error
+	//   415: Error
+	stacks, err := h.service.Reload()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	response := make([]Stack, len(stacks))
+	for i, stack := range stacks {
+		response[i] = toResponseStack(stack)
+	}
+	c.JSON(http.StatusOK, response)
+}
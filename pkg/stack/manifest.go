@@ -0,0 +1,359 @@
+package stack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	manifestFile = "stack.yaml"
+	helmfileFile = "helmfile.yaml.gotmpl"
+)
+
+// manifest describes a stack. It's stored as stack.yaml next to the helmfile of the stack, the name
+// of the folder being the name of the stack.
+//
+//	hostnamePattern: "%s-database-postgresql.%s.svc"
+//	hostnameVariable: DATABASE_HOSTNAME
+//	kubernetesResource: deployment # or statefulSet
+//	requires: [dhis2-db]
+//	companions: [minio]
+//	parameters:
+//	  STORAGE_TYPE:
+//	    displayName: Storage type
+//	    priority: 1
+//	    default: minio # parameters without a default have to be given
+//	    sensitive: false
+//	    consumed: false # provided by one of the required stacks
+//	    type: enum # string (default), int, bool, enum, quantity, hostname, secret or text
+//	    enum: [minio, s3]
+//	    requireCompanion:
+//	      minio: minio # the value of the parameter requiring a companion
+//	  REPLICA_COUNT:
+//	    type: int
+//	    minimum: 0 # minimum and maximum bound int parameters
+//	  IMAGE_TAG:
+//	    pattern: "[\\w.-]+" # a regular expression the whole value of string parameters has to match
+//	providers:
+//	  MINIO_HOSTNAME: "{{ .Name }}-minio.{{ .Group.Namespace }}.svc"
+//	dumpOptions:
+//	  options: [--no-owner]
+//	  excludeTableData: ["analytics*"]
+//
+// Parameters are validated according to their type. Secret parameters are sensitive. Providers are
+// text/template templates executed with the model.DeploymentInstance the parameter is provided for.
+type manifest struct {
+	HostnamePattern    string                       `yaml:"hostnamePattern"`
+	HostnameVariable   string                       `yaml:"hostnameVariable"`
+	KubernetesResource model.KubernetesResource     `yaml:"kubernetesResource"`
+	Requires           []string                     `yaml:"requires"`
+	Companions         []string                     `yaml:"companions"`
+	Parameters         map[string]manifestParameter `yaml:"parameters"`
+	Providers          map[string]string            `yaml:"providers"`
+	DumpOptions        *manifestDumpOptions         `yaml:"dumpOptions"`
+}
+
+type manifestParameter struct {
+	DisplayName      string              `yaml:"displayName"`
+	Priority         uint                `yaml:"priority"`
+	Default          *string             `yaml:"default"`
+	Consumed         bool                `yaml:"consumed"`
+	Sensitive        bool                `yaml:"sensitive"`
+	Type             model.ParameterType `yaml:"type"`
+	Enum             []string            `yaml:"enum"`
+	Minimum          *int64              `yaml:"minimum"`
+	Maximum          *int64              `yaml:"maximum"`
+	Pattern          string              `yaml:"pattern"`
+	RequireCompanion map[string]string   `yaml:"requireCompanion"`
+}
+
+type manifestDumpOptions struct {
+	Options          []string `yaml:"options"`
+	ExcludeTableData []string `yaml:"excludeTableData"`
+}
+
+// Load loads the stacks described by the manifests of the stack folders in dir. The stacks are
+// validated like the stacks given to New. Every parameter the helmfile of a stack requires, besides
+// the parameters set by the instance manager, has to be declared by its manifest.
+func Load(dir string) (Stacks, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stacks folder %q: %v", dir, err)
+	}
+
+	manifests := make(map[string]manifest)
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		m, err := readManifest(dir, entry.Name())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stack %q: %v", entry.Name(), err))
+			continue
+		}
+		manifests[entry.Name()] = m
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	for name, m := range manifests {
+		for _, required := range slices.Concat(m.Requires, m.Companions) {
+			if _, ok := manifests[required]; !ok {
+				errs = append(errs, fmt.Errorf("stack %q refers to unknown stack %q", name, required))
+			}
+		}
+		for parameterName, parameter := range m.Parameters {
+			for _, companion := range parameter.RequireCompanion {
+				if !slices.Contains(m.Companions, companion) {
+					errs = append(errs, fmt.Errorf("stack %q parameter %q requires %q which isn't a companion", name, parameterName, companion))
+				}
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	// stubs only carry the names of the required stacks so cycles are found before stacks are resolved
+	stubs := make([]model.Stack, 0, len(manifests))
+	for name, m := range manifests {
+		stub := model.Stack{Name: name}
+		for _, required := range m.Requires {
+			stub.Requires = append(stub.Requires, model.Stack{Name: required})
+		}
+		stubs = append(stubs, stub)
+	}
+	_, err = ValidateNoCycles(stubs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := resolver{manifests: manifests, stacks: make(Stacks, len(manifests)), resolving: map[string]bool{}}
+	resolved := make([]model.Stack, 0, len(manifests))
+	for name := range manifests {
+		stack, err := r.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, stack)
+	}
+
+	return New(resolved...)
+}
+
+func readManifest(dir, name string) (manifest, error) {
+	var m manifest
+
+	data, err := os.ReadFile(filepath.Join(dir, name, manifestFile)) // #nosec
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	if m.KubernetesResource != "" && m.KubernetesResource != model.DeploymentResource && m.KubernetesResource != model.StatefulSetResource {
+		return m, fmt.Errorf("unknown kubernetesResource %q", m.KubernetesResource)
+	}
+
+	helmfile, err := os.ReadFile(filepath.Join(dir, name, helmfileFile)) // #nosec
+	if err != nil {
+		return m, fmt.Errorf("failed to read helmfile: %v", err)
+	}
+
+	var errs []error
+	for _, parameter := range requiredParameters(helmfile) {
+		if _, ok := m.Parameters[parameter]; !ok {
+			errs = append(errs, fmt.Errorf("parameter %q required by the helmfile isn't declared", parameter))
+		}
+	}
+	return m, errors.Join(errs...)
+}
+
+var requiredEnvPattern = regexp.MustCompile(`requiredEnv "([\w_]+)"`)
+
+// requiredParameters returns the parameters required by the helmfile which aren't system parameters
+func requiredParameters(helmfile []byte) []string {
+	var parameters []string
+	for _, match := range requiredEnvPattern.FindAllStringSubmatch(string(helmfile), -1) {
+		if !isSystemParameter(match[1]) && !slices.Contains(parameters, match[1]) {
+			parameters = append(parameters, match[1])
+		}
+	}
+	return parameters
+}
+
+// systemParameters are set by the instance manager on every deployment
+var systemParameters = []string{
+	"HOSTNAME",
+	"DEPLOYMENT_ID",
+	"INSTANCE_ID",
+	"INSTANCE_TTL",
+	"INSTANCE_NAME",
+	"INSTANCE_PATH_NAME",
+	"INSTANCE_HOSTNAME",
+	"INSTANCE_NAMESPACE",
+	"IM_ACCESS_TOKEN",
+	"INSTANCE_CREATION_TIMESTAMP",
+}
+
+func isSystemParameter(parameter string) bool {
+	return slices.Contains(systemParameters, parameter)
+}
+
+// resolver turns manifests into stacks. Required stacks and companions are resolved first since
+// stacks embed them.
+type resolver struct {
+	manifests map[string]manifest
+	stacks    Stacks
+	// resolving holds the stacks being resolved to detect stacks which are their own companions
+	resolving map[string]bool
+}
+
+func (r resolver) resolve(name string) (model.Stack, error) {
+	if stack, ok := r.stacks[name]; ok {
+		return stack, nil
+	}
+	if r.resolving[name] {
+		return model.Stack{}, fmt.Errorf("stack %q is its own companion", name)
+	}
+	r.resolving[name] = true
+	defer delete(r.resolving, name)
+
+	m := r.manifests[name]
+	stack := model.Stack{
+		Name:               name,
+		HostnamePattern:    m.HostnamePattern,
+		HostnameVariable:   m.HostnameVariable,
+		Parameters:         make(model.StackParameters, len(m.Parameters)),
+		KubernetesResource: m.KubernetesResource,
+	}
+	if m.DumpOptions != nil {
+		stack.DumpOptions = &model.DumpOptions{
+			Options:          m.DumpOptions.Options,
+			ExcludeTableData: m.DumpOptions.ExcludeTableData,
+		}
+	}
+
+	for parameterName, p := range m.Parameters {
+		parameter := model.StackParameter{
+			DisplayName:  p.DisplayName,
+			DefaultValue: p.Default,
+			Consumed:     p.Consumed,
+			Priority:     p.Priority,
+			Sensitive:    p.Sensitive || p.Type == model.SecretParameter,
+			Type:         p.Type,
+			Enum:         p.Enum,
+			Minimum:      p.Minimum,
+			Maximum:      p.Maximum,
+			Pattern:      p.Pattern,
+		}
+
+		validator, err := TypeValidator(parameter)
+		if err != nil {
+			return model.Stack{}, fmt.Errorf("stack %q parameter %q: %v", name, parameterName, err)
+		}
+		if p.Default != nil {
+			if err := validator(*p.Default); err != nil {
+				return model.Stack{}, fmt.Errorf("stack %q parameter %q has an invalid default: %v", name, parameterName, err)
+			}
+		}
+		parameter.Validator = validator
+
+		if len(p.RequireCompanion) > 0 {
+			parameter.RequireCompanion = r.requireCompanion(p.RequireCompanion)
+		}
+		stack.Parameters[parameterName] = parameter
+	}
+
+	if len(m.Providers) > 0 {
+		stack.ParameterProviders = make(model.ParameterProviders, len(m.Providers))
+	}
+	for parameterName, text := range m.Providers {
+		provider, err := newTemplateProvider(parameterName, text)
+		if err != nil {
+			return model.Stack{}, fmt.Errorf("stack %q provider %q: %v", name, parameterName, err)
+		}
+		stack.ParameterProviders[parameterName] = provider
+	}
+
+	for _, required := range m.Requires {
+		requiredStack, err := r.resolve(required)
+		if err != nil {
+			return model.Stack{}, err
+		}
+		stack.Requires = append(stack.Requires, requiredStack)
+	}
+
+	for _, companion := range m.Companions {
+		companionStack, err := r.resolve(companion)
+		if err != nil {
+			return model.Stack{}, err
+		}
+		stack.Companions = append(stack.Companions, companionStack)
+	}
+
+	r.stacks[name] = stack
+	return stack, nil
+}
+
+// requireCompanion requires the companion mapped to the value of the parameter. The companions are
+// looked up once all stacks are resolved.
+func (r resolver) requireCompanion(companions map[string]string) model.RequireCompanionFunc {
+	return func(parameter model.DeploymentInstanceParameter) (*model.Stack, error) {
+		name, ok := companions[parameter.Value]
+		if !ok {
+			return nil, nil
+		}
+		stack := r.stacks[name]
+		return &stack, nil
+	}
+}
+
+// Pattern creates a function returning an error when called with a value that does not entirely
+// match the regular expression pattern.
+func Pattern(pattern string) (func(value string) error, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+
+	return func(value string) error {
+		if re.MatchString(value) {
+			return nil
+		}
+
+		return fmt.Errorf("%q is not valid, it must match %q", value, pattern)
+	}, nil
+}
+
+func newTemplateProvider(name, text string) (model.ParameterProviderFunc, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(instance model.DeploymentInstance) (string, error) {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, instance); err != nil {
+			return "", fmt.Errorf("failed to provide %q: %v", name, err)
+		}
+		return value.String(), nil
+	}, nil
+}
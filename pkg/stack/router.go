@@ -4,10 +4,22 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Routes(r *gin.Engine, authenticator gin.HandlerFunc, handler Handler) {
+type AuthenticationMiddleware interface {
+	TokenAuthentication(context *gin.Context)
+}
+
+type AuthorizationMiddleware interface {
+	RequireAdministrator(context *gin.Context)
+}
+
+func Routes(r *gin.Engine, authenticationMiddleware AuthenticationMiddleware, authorizationMiddleware AuthorizationMiddleware, handler Handler) {
 	tokenAuthenticationRouter := r.Group("")
-	tokenAuthenticationRouter.Use(authenticator)
+	tokenAuthenticationRouter.Use(authenticationMiddleware.TokenAuthentication)
 
 	tokenAuthenticationRouter.GET("/stacks", handler.FindAll)
 	tokenAuthenticationRouter.GET("/stacks/:name", handler.Find)
+
+	administratorRestrictedRouter := tokenAuthenticationRouter.Group("")
+	administratorRestrictedRouter.Use(authorizationMiddleware.RequireAdministrator)
+	administratorRestrictedRouter.POST("/stacks/reload", handler.Reload)
 }
@@ -1,21 +1,46 @@
 package stack
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/dhis2-sre/im-manager/internal/errdef"
 	"github.com/dhis2-sre/im-manager/pkg/model"
 	"golang.org/x/exp/maps"
 )
 
 func NewService(stacks Stacks) Service {
-	return Service{stacks}
+	return Service{&registry{stacks: stacks}}
+}
+
+// NewServiceFromFolder creates a service serving the stacks loaded from the manifests in folder.
+// The stacks can be reloaded from the folder.
+func NewServiceFromFolder(folder string) (Service, error) {
+	stacks, err := Load(folder)
+	if err != nil {
+		return Service{}, err
+	}
+
+	return Service{&registry{stacks: stacks, folder: folder}}, nil
 }
 
+// Service is passed by value so the stacks are kept in a registry shared by all copies
 type Service struct {
+	registry *registry
+}
+
+type registry struct {
+	mu     sync.RWMutex
 	stacks Stacks
+	// folder the stacks are loaded from, empty if the stacks weren't loaded from a folder
+	folder string
 }
 
 func (s Service) Find(name string) (*model.Stack, error) {
-	stack, ok := s.stacks[name]
+	s.registry.mu.RLock()
+	defer s.registry.mu.RUnlock()
+
+	stack, ok := s.registry.stacks[name]
 	if !ok {
 		return nil, errdef.NewNotFound("stack not found: %s", name)
 	}
@@ -23,5 +48,27 @@ func (s Service) Find(name string) (*model.Stack, error) {
 }
 
 func (s Service) FindAll() ([]model.Stack, error) {
-	return maps.Values(s.stacks), nil
+	s.registry.mu.RLock()
+	defer s.registry.mu.RUnlock()
+
+	return maps.Values(s.registry.stacks), nil
+}
+
+// Reload loads the stacks from the folder again. The current stacks are kept if the stacks of the
+// folder aren't valid. Instances being deployed keep the stack they were deployed with.
+func (s Service) Reload() ([]model.Stack, error) {
+	if s.registry.folder == "" {
+		return nil, errors.New("stacks weren't loaded from a folder")
+	}
+
+	stacks, err := Load(s.registry.folder)
+	if err != nil {
+		return nil, errdef.NewBadRequest("failed to reload stacks: %v", err)
+	}
+
+	s.registry.mu.Lock()
+	s.registry.stacks = stacks
+	s.registry.mu.Unlock()
+
+	return maps.Values(stacks), nil
 }
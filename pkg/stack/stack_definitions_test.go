@@ -1,11 +1,8 @@
 package stack
 
 import (
-	"fmt"
 	"os"
-	"regexp"
-	"sort"
-	"strings"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,198 +11,149 @@ import (
 	"github.com/dhis2-sre/im-manager/pkg/model"
 )
 
-// assert every stack defined in Go has a helmfile
-// assert every stack helmfile has a stack definition in Go
-// assert that the parameters their default value and whether they are consumed are in sync
+// assert every stack folder has a valid manifest declaring the parameters its helmfile requires
 func TestStackDefinitionsAreInSyncWithHelmfile(t *testing.T) {
-	helmfileStacks, err := parseStacks("../../stacks")
+	stacks, err := Load("../../stacks")
 	require.NoError(t, err)
 
-	helmfileParameters := make(map[string]model.StackParameters, len(helmfileStacks))
-	for stackName, helmfileStack := range helmfileStacks {
-		consumedParameter := make(map[string]struct{})
-		for _, p := range helmfileStack.consumedParameters {
-			consumedParameter[p] = struct{}{}
-		}
-
-		parameters := make(model.StackParameters)
-		for name, value := range helmfileStack.parameters {
-			_, consumed := consumedParameter[name]
-			parameters[name] = model.StackParameter{DefaultValue: value, Consumed: consumed}
-		}
-		helmfileParameters[stackName] = parameters
-	}
-
-	// helmfileParameters will not contain Go Validator or Provider functions. We therefore need to
-	// create a map of stack name to parameters with parameters only containing. DefaultValue and
-	// Consumed as we cannot ignore fields in the assertions we use.
-	stacks := map[string]model.StackParameters{
-		"dhis2-db":      DHIS2DB.Parameters,
-		"dhis2-core":    DHIS2Core.Parameters,
-		"dhis2":         DHIS2.Parameters,
-		"minio":         MINIO.Parameters,
-		"pgadmin":       PgAdmin.Parameters,
-		"whoami-go":     WhoamiGo.Parameters,
-		"im-job-runner": IMJobRunner.Parameters,
-		"chap-db":       ChapDB.Parameters,
-		"chap-valkey":   ChapValkey.Parameters,
-		"chap-worker":   ChapWorker.Parameters,
-		"chap-core":     ChapCore.Parameters,
-	}
-	stackDefinitions := make(map[string]model.StackParameters)
-	for stackName, stackParameters := range stacks {
-		stackDefinitionParameters := make(map[string]model.StackParameter, len(stackParameters))
-		for parameterName, parameter := range stackParameters {
-			// DefaultValue is nil since it's no longer in the helmfile and therefor we don't have anything to compare it to
-			stackDefinitionParameters[parameterName] = model.StackParameter{DefaultValue: nil, Consumed: parameter.Consumed}
+	entries, err := os.ReadDir("../../stacks")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			assert.Containsf(t, stacks, entry.Name(), "stack %q has a helmfile but isn't loaded", entry.Name())
 		}
-		stackDefinitions[stackName] = stackDefinitionParameters
 	}
 
-	for name, parameters := range helmfileParameters {
-		stackDefinition, ok := stackDefinitions[name]
-		require.Truef(t, ok, "stack %q has a helmfile but no static stack definition in Go", name)
-		assert.Equalf(t, parameters, stackDefinition, "parameters defined in Go for stack %q don't match its helmfile", name)
-		delete(stackDefinitions, name)
-	}
+	core := stacks["dhis2-core"]
+	require.Len(t, core.Requires, 1)
+	assert.Equal(t, "dhis2-db", core.Requires[0].Name)
+	assert.NotNil(t, core.Requires[0].ParameterProviders["DATABASE_HOSTNAME"], "required stacks are embedded with their providers")
+	assert.Len(t, core.Companions, 2)
+
+	javaOpts := core.Parameters["JAVA_OPTS"].DefaultValue
+	require.NotNil(t, javaOpts)
+	assert.Equal(t, " ", *javaOpts)
+	assert.Nil(t, stacks["dhis2-db"].Parameters["DATABASE_ID"].DefaultValue, "parameters without a default have to be given")
+	assert.Equal(t, model.StatefulSetResource, stacks["dhis2-db"].KubernetesResource)
+	assert.Equal(t, []string{"analytics*", "_*"}, stacks["dhis2-db"].DumpOptions.ExcludeTableData)
+
+	assert.True(t, stacks["dhis2-db"].Parameters["DATABASE_PASSWORD"].Sensitive, "secret parameters are sensitive")
+	require.Error(t, core.Parameters["MIN_READY_SECONDS"].Validator("-1"))
+
+	storage := core.Parameters["STORAGE_TYPE"]
+	require.NoError(t, storage.Validator("s3"))
+	require.Error(t, storage.Validator("ftp"))
+	companion, err := storage.RequireCompanion.Require(model.DeploymentInstanceParameter{Value: "minio"})
+	require.NoError(t, err)
+	require.NotNil(t, companion)
+	assert.Equal(t, "minio", companion.Name)
+	companion, err = storage.RequireCompanion.Require(model.DeploymentInstanceParameter{Value: "s3"})
+	require.NoError(t, err)
+	assert.Nil(t, companion)
 
-	assert.Empty(t, stackDefinitions, "all stack definitions should have a helmfile, these don't")
+	hostname, err := stacks["dhis2-db"].ParameterProviders["DATABASE_HOSTNAME"].Provide(model.DeploymentInstance{
+		Name:  "sierra",
+		Group: &model.Group{ID: 2, Namespace: "whoami"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sierra-2-database-postgresql.whoami.svc", hostname)
 }
 
-func TestIsSystemParameterPositive(t *testing.T) {
-	const instanceId = "INSTANCE_ID"
+func TestLoad(t *testing.T) {
+	t.Run("FailGivenUndeclaredHelmfileParameter", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "parameters:\n  A_PARAM: {}\n", `{{ requiredEnv "A_PARAM" }} {{ requiredEnv "B_PARAM" }} {{ requiredEnv "INSTANCE_NAME" }}`)
 
-	parameter := isSystemParameter(instanceId)
+		_, err := Load(dir)
 
-	assert.True(t, parameter)
-}
+		require.ErrorContains(t, err, `parameter "B_PARAM" required by the helmfile isn't declared`)
+	})
 
-func TestIsSystemParameterNegative(t *testing.T) {
-	const instanceId = "some-random-parameter-name"
+	t.Run("FailGivenUnknownField", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "parameters:\n  A_PARAM:\n    defaultValue: a\n", "")
 
-	parameter := isSystemParameter(instanceId)
+		_, err := Load(dir)
 
-	assert.False(t, parameter)
-}
+		require.ErrorContains(t, err, "defaultValue")
+	})
 
-const (
-	stackParametersIdentifier    = "# stackParameters: "
-	consumedParametersIdentifier = "# consumedParameters: "
-)
+	t.Run("FailGivenUnknownStack", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "requires: [b]\n", "")
 
-type stack struct {
-	consumedParameters []string
-	parameters         map[string]*string
-}
+		_, err := Load(dir)
 
-func parseStacks(dir string) (map[string]*stack, error) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
+		require.ErrorContains(t, err, `stack "a" refers to unknown stack "b"`)
+	})
 
-	stacks := make(map[string]*stack)
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
+	t.Run("FailGivenCycle", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "requires: [b]\nparameters:\n  B_PARAM: {consumed: true}\n  A_PARAM: {}\n", "")
+		writeStack(t, dir, "b", "requires: [a]\nparameters:\n  A_PARAM: {consumed: true}\n  B_PARAM: {}\n", "")
 
-		stackName := entry.Name()
-		stack, err := parseStack(dir, stackName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse stack %q: %v", stackName, err)
-		}
+		_, err := Load(dir)
 
-		stacks[stackName] = stack
-	}
+		require.ErrorContains(t, err, "creates a cycle")
+	})
 
-	return stacks, nil
-}
+	t.Run("FailGivenConsumedParameterWithoutProvider", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "providers:\n  A_PROVIDED: a\n", "")
+		writeStack(t, dir, "b", "requires: [a]\nparameters:\n  A_PROVIDED: {consumed: true}\n  C_PARAM: {consumed: true}\n", "")
 
-func parseStack(dir, name string) (*stack, error) {
-	path := fmt.Sprintf("%s/%s/helmfile.yaml.gotmpl", dir, name)
-	file, err := os.ReadFile(path) // #nosec
-	if err != nil {
-		return nil, fmt.Errorf("error reading stack %q: %v", name, err)
-	}
+		_, err := Load(dir)
 
-	consumedParameters := extractMetadataParameters(file, consumedParametersIdentifier)
-	stackParameters := extractMetadataParameters(file, stackParametersIdentifier)
-	requiredParams := extractRequiredParameters(file, stackParameters)
+		require.ErrorContains(t, err, `no provider for stack "b" parameter "C_PARAM"`)
+	})
 
-	parameters := make(map[string]*string, len(stackParameters)+len(requiredParams))
-	for _, parameter := range stackParameters {
-		parameters[parameter] = nil
-	}
-	for _, parameter := range requiredParams {
-		parameters[parameter] = nil
-	}
+	t.Run("FailGivenInvalidPattern", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "parameters:\n  A_PARAM:\n    pattern: \"[\"\n", "")
 
-	return &stack{
-		consumedParameters: consumedParameters,
-		parameters:         parameters,
-	}, nil
-}
+		_, err := Load(dir)
 
-func extractMetadataParameters(file []byte, identifier string) []string {
-	lines := strings.Split(string(file), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, identifier) {
-			trimmed := strings.TrimPrefix(line, identifier)
-			trimmed = strings.ReplaceAll(trimmed, " ", "")
-			parameters := strings.Split(trimmed, ",")
-			sort.Strings(parameters)
-			return parameters
-		}
-	}
-	return []string{}
-}
+		require.ErrorContains(t, err, "invalid pattern")
+	})
 
-func extractRequiredParameters(file []byte, stackParameters []string) []string {
-	regexStr := `requiredEnv "([\w_]+)"`
-	return extractParameters(file, regexStr, stackParameters)
-}
+	t.Run("FailGivenInvalidDefault", func(t *testing.T) {
+		dir := t.TempDir()
+		writeStack(t, dir, "a", "parameters:\n  A_PARAM:\n    type: int\n    default: \"five\"\n", "")
 
-func extractParameters(file []byte, regexStr string, stackParameters []string) []string {
-	fileData := string(file)
-	parameterSet := make(map[string]bool)
-	re := regexp.MustCompile(regexStr)
-	matches := re.FindAllStringSubmatch(fileData, -1)
-	for _, match := range matches {
-		if !isSystemParameter(match[1]) && !isStackParameter(match[1], stackParameters) {
-			parameterSet[match[1]] = true
-		}
-	}
-	return getKeys(parameterSet)
-}
+		_, err := Load(dir)
 
-func getKeys(parameterSet map[string]bool) []string {
-	keys := make([]string, len(parameterSet))
-	i := 0
-	for k := range parameterSet {
-		keys[i] = k
-		i++
-	}
-	sort.Strings(keys)
-	return keys
+		require.ErrorContains(t, err, `stack "a" parameter "A_PARAM" has an invalid default: "five" is not an integer`)
+	})
 }
 
-func isStackParameter(parameter string, stackParameters []string) bool {
-	return inSlice(parameter, stackParameters)
+func TestPattern(t *testing.T) {
+	validator, err := Pattern(`\d+Gi`)
+	require.NoError(t, err)
+
+	require.NoError(t, validator("20Gi"))
+	require.ErrorContains(t, validator("20Gi "), `"20Gi " is not valid, it must match "\\d+Gi"`)
 }
 
-func isSystemParameter(parameter string) bool {
-	systemParameters := getSystemParameters()
-	return inSlice(parameter, systemParameters)
+func TestIsSystemParameterPositive(t *testing.T) {
+	const instanceId = "INSTANCE_ID"
+
+	parameter := isSystemParameter(instanceId)
+
+	assert.True(t, parameter)
 }
 
-func inSlice(str string, strings []string) bool {
-	index := sort.SearchStrings(strings, str)
-	return index < len(strings) && strings[index] == str
+func TestIsSystemParameterNegative(t *testing.T) {
+	const instanceId = "some-random-parameter-name"
+
+	parameter := isSystemParameter(instanceId)
+
+	assert.False(t, parameter)
 }
 
-func getSystemParameters() []string {
-	parameters := []string{"HOSTNAME", "DEPLOYMENT_ID", "INSTANCE_ID", "INSTANCE_TTL", "INSTANCE_NAME", "INSTANCE_PATH_NAME", "INSTANCE_HOSTNAME", "INSTANCE_NAMESPACE", "IM_ACCESS_TOKEN", "INSTANCE_CREATION_TIMESTAMP"}
-	sort.Strings(parameters)
-	return parameters
+func writeStack(t *testing.T, dir, name, manifest, helmfile string) {
+	t.Helper()
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name, manifestFile), []byte(manifest), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name, helmfileFile), []byte(helmfile), 0o600))
 }
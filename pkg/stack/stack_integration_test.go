@@ -1,6 +1,8 @@
 package stack_test
 
 import (
+	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -14,21 +16,12 @@ import (
 func TestStackHandler(t *testing.T) {
 	t.Parallel()
 
-	stacks, err := stack.New(
-		stack.DHIS2DB,
-		stack.DHIS2Core,
-		stack.DHIS2,
-		stack.PgAdmin,
-		stack.WhoamiGo,
-		stack.IMJobRunner,
-	)
+	stackService, err := stack.NewServiceFromFolder("../../stacks")
 	require.NoError(t, err)
 
-	stackService := stack.NewService(stacks)
-
 	client := inttest.SetupHTTPServer(t, func(engine *gin.Engine) {
 		stackHandler := stack.NewHandler(stackService)
-		stack.Routes(engine, func(ctx *gin.Context) {}, stackHandler)
+		stack.Routes(engine, TestAuthenticationMiddleware{}, TestAuthorizationMiddleware{}, stackHandler)
 	})
 
 	t.Run("GetStack", func(t *testing.T) {
@@ -55,4 +48,26 @@ func TestStackHandler(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("ReloadStacks", func(t *testing.T) {
+		t.Parallel()
+
+		body := client.Do(t, http.MethodPost, "/stacks/reload", nil, http.StatusOK)
+
+		var stacks []stack.Stack
+		err := json.Unmarshal(body, &stacks)
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, stacks)
+	})
+}
+
+type TestAuthenticationMiddleware struct{}
+
+func (t TestAuthenticationMiddleware) TokenAuthentication(c *gin.Context) {}
+
+type TestAuthorizationMiddleware struct{}
+
+func (t TestAuthorizationMiddleware) RequireAdministrator(c *gin.Context) {
+	c.Next()
 }
@@ -0,0 +1,211 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/pkg/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TypeValidator creates a function validating values against the type and the constraints of the
+// parameter. It returns an error if the constraints don't apply to the type.
+func TypeValidator(parameter model.StackParameter) (func(value string) error, error) {
+	typ := parameter.Type
+	if typ == "" {
+		typ = model.StringParameter
+	}
+
+	if len(parameter.Enum) > 0 && typ != model.EnumParameter {
+		return nil, fmt.Errorf("enum values only apply to enum parameters, not %s", typ)
+	}
+	if (parameter.Minimum != nil || parameter.Maximum != nil) && typ != model.IntParameter {
+		return nil, fmt.Errorf("minimum and maximum only apply to int parameters, not %s", typ)
+	}
+	if parameter.Minimum != nil && parameter.Maximum != nil && *parameter.Minimum > *parameter.Maximum {
+		return nil, fmt.Errorf("minimum %d is greater than maximum %d", *parameter.Minimum, *parameter.Maximum)
+	}
+
+	var validators []func(value string) error
+	if parameter.Pattern != "" {
+		if !hasPattern(typ) {
+			return nil, fmt.Errorf("pattern doesn't apply to %s parameters", typ)
+		}
+		pattern, err := Pattern(parameter.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, pattern)
+	}
+
+	switch typ {
+	case model.StringParameter, model.SecretParameter:
+		validators = append(validators, singleLine)
+	case model.TextParameter:
+	case model.IntParameter:
+		validators = append(validators, intRange(parameter.Minimum, parameter.Maximum))
+	case model.BoolParameter:
+		validators = append(validators, OneOf("true", "false"))
+	case model.EnumParameter:
+		if len(parameter.Enum) == 0 {
+			return nil, errors.New("enum parameters need enum values")
+		}
+		validators = append(validators, OneOf(parameter.Enum...))
+	case model.QuantityParameter:
+		validators = append(validators, quantity)
+	case model.HostnameParameter:
+		validators = append(validators, hostname)
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+
+	return func(value string) error {
+		for _, validator := range validators {
+			if err := validator(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// hasPattern reports whether values of the type can be constrained by a pattern
+func hasPattern(typ model.ParameterType) bool {
+	return slices.Contains([]model.ParameterType{model.StringParameter, model.SecretParameter, model.TextParameter, model.HostnameParameter}, typ)
+}
+
+func singleLine(value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%q is not valid, it must be a single line", value)
+	}
+	return nil
+}
+
+func intRange(minimum, maximum *int64) func(value string) error {
+	return func(value string) error {
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if minimum != nil && i < *minimum {
+			return fmt.Errorf("%d is less than the minimum of %d", i, *minimum)
+		}
+		if maximum != nil && i > *maximum {
+			return fmt.Errorf("%d is greater than the maximum of %d", i, *maximum)
+		}
+		return nil
+	}
+}
+
+func quantity(value string) error {
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return fmt.Errorf("%q is not a Kubernetes quantity like 8Gi or 250m", value)
+	}
+	return nil
+}
+
+func hostname(value string) error {
+	if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+		return fmt.Errorf("%q is not a valid hostname: %s", value, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// quantityPattern matches the serialization of Kubernetes quantities
+const quantityPattern = `^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)(([KMGTPE]i)|[numkMGTPE]|[eE][+-]?[0-9]+)?$`
+
+// Schema is a JSON Schema of the parameters of a stack. Parameters consumed from other stacks aren't
+// part of the schema. Values are described by their type although they're passed as strings.
+// swagger:model StackSchema
+type Schema struct {
+	Schema     string                    `json:"$schema"`
+	Title      string                    `json:"title"`
+	Type       string                    `json:"type"`
+	Properties map[string]SchemaProperty `json:"properties"`
+	// Required lists the parameters without a default value
+	Required []string `json:"required,omitempty"`
+}
+
+// SchemaProperty describes a stack parameter
+type SchemaProperty struct {
+	Title     string   `json:"title"`
+	Type      string   `json:"type"`
+	Format    string   `json:"format,omitempty"`
+	Default   any      `json:"default,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	Minimum   *int64   `json:"minimum,omitempty"`
+	Maximum   *int64   `json:"maximum,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	WriteOnly bool     `json:"writeOnly,omitempty"`
+	// Priority determines the order in which the parameter is shown
+	Priority uint `json:"x-priority"`
+}
+
+// NewSchema creates the JSON Schema of the parameters of the stack
+func NewSchema(stack model.Stack) Schema {
+	schema := Schema{
+		Schema:     "https://json-schema.org/draft/2020-12/schema",
+		Title:      stack.Name,
+		Type:       "object",
+		Properties: make(map[string]SchemaProperty),
+	}
+
+	for name, parameter := range stack.Parameters {
+		if parameter.Consumed {
+			continue
+		}
+
+		property := SchemaProperty{
+			Title:     parameter.DisplayName,
+			Type:      "string",
+			Minimum:   parameter.Minimum,
+			Maximum:   parameter.Maximum,
+			WriteOnly: parameter.Sensitive,
+			Priority:  parameter.Priority,
+		}
+		if parameter.Pattern != "" {
+			// patterns of JSON Schema aren't anchored
+			property.Pattern = "^(?:" + parameter.Pattern + ")$"
+		}
+		if parameter.DefaultValue != nil {
+			property.Default = *parameter.DefaultValue
+		}
+
+		switch parameter.Type {
+		case model.IntParameter:
+			property.Type = "integer"
+			if parameter.DefaultValue != nil {
+				property.Default, _ = strconv.ParseInt(*parameter.DefaultValue, 10, 64)
+			}
+		case model.BoolParameter:
+			property.Type = "boolean"
+			if parameter.DefaultValue != nil {
+				property.Default = *parameter.DefaultValue == "true"
+			}
+		case model.EnumParameter:
+			property.Enum = parameter.Enum
+		case model.QuantityParameter:
+			property.Format = "quantity"
+			property.Pattern = quantityPattern
+		case model.HostnameParameter:
+			property.Format = "hostname"
+		case model.SecretParameter:
+			property.Format = "password"
+			property.WriteOnly = true
+		case model.TextParameter:
+			property.Format = "multiline"
+		}
+		schema.Properties[name] = property
+
+		if parameter.DefaultValue == nil {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	slices.Sort(schema.Required)
+	return schema
+}
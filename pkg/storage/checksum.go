@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// NewChecksumReader returns a reader computing the SHA-256 checksum of everything read through it.
+func NewChecksumReader(r io.Reader) *ChecksumReader {
+	return &ChecksumReader{r: r, hash: sha256.New()}
+}
+
+// be aware that this reader is not safe for concurrent use
+type ChecksumReader struct {
+	r    io.Reader
+	hash hash.Hash
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the bytes read so far
+func (c *ChecksumReader) Checksum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of everything read from r
+func Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DigestHeader returns the value of a Digest header (RFC 3230) for the hex encoded SHA-256 checksum
+func DigestHeader(checksum string) (string, error) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return "", err
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum), nil
+}
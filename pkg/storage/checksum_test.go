@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sha256 of "hello world"
+const helloWorldChecksum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+func TestChecksumReader(t *testing.T) {
+	reader := NewChecksumReader(strings.NewReader("hello world"))
+
+	data, err := io.ReadAll(reader)
+
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, helloWorldChecksum, reader.Checksum())
+}
+
+func TestChecksum(t *testing.T) {
+	checksum, err := Checksum(strings.NewReader("hello world"))
+
+	require.NoError(t, err)
+	assert.Equal(t, helloWorldChecksum, checksum)
+}
+
+func TestDigestHeader(t *testing.T) {
+	digest, err := DigestHeader(helloWorldChecksum)
+
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", digest)
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+)
+
+const (
+	// multipartDir holds the parts of multipart uploads. Bucket names can't start with a dot so it
+	// never collides with a bucket.
+	multipartDir = ".multipart"
+	// tmpDir holds files while they are written so objects are only visible once complete
+	tmpDir = ".tmp"
+	// uploadKeyFile records the key of a multipart upload
+	uploadKeyFile = "key"
+)
+
+// NewFilesystemStore creates an object store keeping objects as files below root. Each bucket is a
+// directory in root and keys are paths relative to their bucket.
+func NewFilesystemStore(root string) (*FilesystemStore, error) {
+	for _, dir := range []string{root, filepath.Join(root, multipartDir), filepath.Join(root, tmpDir)} {
+		err := os.MkdirAll(dir, 0o750)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage directory %q: %v", dir, err)
+		}
+	}
+
+	return &FilesystemStore{root: root}, nil
+}
+
+// FilesystemStore is an object store backed by the local filesystem meant for air-gapped and
+// development setups
+type FilesystemStore struct {
+	root string
+}
+
+func (f FilesystemStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || !filepath.IsLocal(bucket) || strings.ContainsRune(bucket, '/') {
+		return "", errdef.NewBadRequest("invalid bucket %q", bucket)
+	}
+	return filepath.Join(f.root, bucket), nil
+}
+
+func (f FilesystemStore) objectPath(bucket, key string) (string, error) {
+	bucketPath, err := f.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	if key == "" || strings.HasSuffix(key, "/") || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", errdef.NewBadRequest("invalid key %q", key)
+	}
+	return filepath.Join(bucketPath, filepath.FromSlash(key)), nil
+}
+
+func (f FilesystemStore) uploadPath(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", errdef.NewNotFound("multipart upload %q not found", uploadID)
+	}
+	return filepath.Join(f.root, multipartDir, uploadID), nil
+}
+
+// writeFile atomically writes the content of the reader to path returning the number of bytes written
+func (f FilesystemStore) writeFile(path string, r io.Reader) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(f.root, tmpDir), "object-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		return 0, errors.Join(err, tmp.Close())
+	}
+	err = tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (f FilesystemStore) Upload(_ context.Context, bucket string, key string, body ReadAtSeeker, size int64) error {
+	path, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.writeFile(path, io.NewSectionReader(body, 0, size))
+	if err != nil {
+		return fmt.Errorf("error uploading object to bucket %q using key %q: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (f FilesystemStore) StreamUpload(_ context.Context, bucket, key, _ string, r io.Reader) (int64, error) {
+	path, err := f.objectPath(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.writeFile(path, r)
+	if err != nil {
+		return 0, fmt.Errorf("stream error, upload aborted: %w", err)
+	}
+	return n, nil
+}
+
+func (f FilesystemStore) open(bucket, key string) (*os.File, error) {
+	path, err := f.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path) // #nosec G304 -- path is validated to be within the bucket
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errdef.NewNotFound("key %q not found in bucket %q", key, bucket)
+		}
+		return nil, fmt.Errorf("error opening object from bucket %q using key %q: %v", bucket, key, err)
+	}
+	return file, nil
+}
+
+func (f FilesystemStore) Download(_ context.Context, bucket string, key string, dst io.Writer, cb func(contentLength int64)) error {
+	file, err := f.open(bucket, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	cb(info.Size())
+
+	_, err = io.Copy(dst, file)
+
+	return err
+}
+
+func (f FilesystemStore) DownloadRange(_ context.Context, bucket, key string, offset, length int64) ([]byte, error) {
+	file, err := f.open(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.NewSectionReader(file, offset, length))
+}
+
+func (f FilesystemStore) Copy(bucket string, source string, destination string) error {
+	file, err := f.open(bucket, source)
+	if err != nil {
+		return fmt.Errorf("error copying object from %q to %q: %w", source, destination, err)
+	}
+	defer file.Close()
+
+	path, err := f.objectPath(bucket, destination)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.writeFile(path, file)
+	if err != nil {
+		return fmt.Errorf("error copying object from %q to %q: %v", source, destination, err)
+	}
+	return nil
+}
+
+func (f FilesystemStore) Move(bucket string, source string, destination string) error {
+	sourcePath, err := f.objectPath(bucket, source)
+	if err != nil {
+		return err
+	}
+	destinationPath, err := f.objectPath(bucket, destination)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(destinationPath), 0o750)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(sourcePath, destinationPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errdef.NewNotFound("key %q not found in bucket %q", source, bucket)
+		}
+		return fmt.Errorf("error moving object from %q to %q: %v", source, destination, err)
+	}
+	return nil
+}
+
+func (f FilesystemStore) Delete(bucket string, key string) error {
+	path, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error deleting object from bucket %q using key %q: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (f FilesystemStore) List(_ context.Context, bucket, prefix string) ([]Object, error) {
+	bucketPath, err := f.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Object
+	err = filepath.WalkDir(bucketPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == bucketPath && errors.Is(err, fs.ErrNotExist) {
+				return errdef.NewNotFound("bucket %q does not exist", bucket)
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relative, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		if errdef.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error listing objects in bucket %q using prefix %q: %v", bucket, prefix, err)
+	}
+
+	// WalkDir orders by path which differs from ordering by key if a key segment contains characters
+	// sorting before "/"
+	slices.SortFunc(objects, func(a, b Object) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return objects, nil
+}
+
+func (f FilesystemStore) InitiateMultipartUpload(_ context.Context, bucket, key, _ string) (string, error) {
+	_, err := f.objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	uploadPath, err := f.uploadPath(uploadID)
+	if err != nil {
+		return "", err
+	}
+	err = os.Mkdir(uploadPath, 0o750)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(filepath.Join(uploadPath, uploadKeyFile), []byte(bucket+"/"+key), 0o600)
+	if err != nil {
+		return "", errors.Join(err, os.RemoveAll(uploadPath))
+	}
+
+	return uploadID, nil
+}
+
+// findUpload returns the path of the multipart upload after ensuring it belongs to the object
+func (f FilesystemStore) findUpload(bucket, key, uploadID string) (string, error) {
+	uploadPath, err := f.uploadPath(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	uploadKey, err := os.ReadFile(filepath.Join(uploadPath, uploadKeyFile)) // #nosec G304 -- upload id is validated to be hex
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", errdef.NewNotFound("multipart upload %q not found", uploadID)
+		}
+		return "", err
+	}
+	if string(uploadKey) != bucket+"/"+key {
+		return "", errdef.NewNotFound("multipart upload %q not found for key %q in bucket %q", uploadID, key, bucket)
+	}
+
+	return uploadPath, nil
+}
+
+func (f FilesystemStore) UploadPart(_ context.Context, bucket, key, uploadID string, partNumber int, body io.ReadSeeker, size int64) (*Part, error) {
+	uploadPath, err := f.findUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if partNumber < 1 || partNumber > 10000 {
+		return nil, errdef.NewBadRequest("part number must be between 1 and 10000")
+	}
+
+	hash := md5.New() // #nosec G401 -- used for change detection like S3 does, not for security
+	written, err := f.writeFile(filepath.Join(uploadPath, strconv.Itoa(partNumber)), io.TeeReader(io.LimitReader(body, size), hash))
+	if err != nil {
+		return nil, fmt.Errorf("error uploading part %d of %q: %v", partNumber, key, err)
+	}
+	if written != size {
+		return nil, errdef.NewBadRequest("part %d of %q has %d bytes but %d were expected", partNumber, key, written, size)
+	}
+
+	return &Part{Number: partNumber, ETag: `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, Size: written}, nil
+}
+
+// etag mimics the ETag S3 returns for parts which is the quoted MD5 hash of their content
+func etag(data []byte) string {
+	sum := md5.Sum(data) // #nosec G401 -- used for change detection like S3 does, not for security
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f FilesystemStore) CompleteMultipartUpload(_ context.Context, bucket, key, uploadID string, parts []Part) error {
+	uploadPath, err := f.findUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errdef.NewBadRequest("multipart upload %q requires at least one part", uploadID)
+	}
+
+	files := make([]io.Reader, len(parts))
+	for i, part := range parts {
+		data, err := os.ReadFile(filepath.Join(uploadPath, strconv.Itoa(part.Number))) // #nosec G304 -- upload id is validated to be hex
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return errdef.NewBadRequest("part %d of multipart upload %q not found", part.Number, uploadID)
+			}
+			return err
+		}
+		if etag(data) != part.ETag {
+			return errdef.NewBadRequest("ETag of part %d of multipart upload %q doesn't match", part.Number, uploadID)
+		}
+		files[i] = bytes.NewReader(data)
+	}
+
+	path, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = f.writeFile(path, io.MultiReader(files...))
+	if err != nil {
+		return fmt.Errorf("error completing multipart upload %q: %v", uploadID, err)
+	}
+
+	return os.RemoveAll(uploadPath)
+}
+
+func (f FilesystemStore) AbortMultipartUpload(_ context.Context, bucket, key, uploadID string) error {
+	uploadPath, err := f.findUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(uploadPath)
+}
+
+func (f FilesystemStore) ListParts(_ context.Context, bucket, key, uploadID string) ([]Part, error) {
+	uploadPath, err := f.findUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(uploadPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for _, entry := range entries {
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(uploadPath, entry.Name())) // #nosec G304 -- upload id is validated to be hex
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{Number: number, ETag: etag(data), Size: int64(len(data))})
+	}
+
+	slices.SortFunc(parts, func(a, b Part) int {
+		return a.Number - b.Number
+	})
+
+	return parts, nil
+}
@@ -0,0 +1,33 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/dhis2-sre/im-manager/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesystemStore(t *testing.T) {
+	root := t.TempDir()
+	bucket := "database-bucket"
+	require.NoError(t, os.Mkdir(filepath.Join(root, bucket), 0o750))
+	store, err := storage.NewFilesystemStore(root)
+	require.NoError(t, err)
+
+	storagetest.Run(t, store, bucket)
+
+	t.Run("RejectsKeysOutsideBucket", func(t *testing.T) {
+		for _, key := range []string{"../other-bucket/object.txt", "/etc/passwd", "a/../../object.txt"} {
+			err := store.Upload(context.Background(), bucket, key, strings.NewReader("content"), 7)
+
+			assert.True(t, errdef.IsBadRequest(err), "expected BadRequest for key %q but got %v", key, err)
+		}
+	})
+}
@@ -11,7 +11,6 @@ import (
 	"strings"
 
 	"github.com/dhis2-sre/im-manager/pkg/model"
-	"github.com/dhis2-sre/im-manager/pkg/stack"
 	"github.com/go-gormigrate/gormigrate/v2"
 	"gorm.io/gorm"
 )
@@ -19,29 +18,26 @@ import (
 func reencryptCFBToGCM() *gormigrate.Migration {
 	const gcmPrefix = "v2:"
 
-	allStacks := []model.Stack{
-		stack.DHIS2DB,
-		stack.MINIO,
-		stack.DHIS2Core,
-		stack.DHIS2,
-		stack.PgAdmin,
-		stack.WhoamiGo,
-		stack.IMJobRunner,
-		stack.ChapDB,
-		stack.ChapValkey,
-		stack.ChapWorker,
-		stack.ChapCore,
-	}
-
-	sensitive := map[string]map[string]bool{}
-	for _, s := range allStacks {
-		m := map[string]bool{}
-		for name, p := range s.Parameters {
-			if p.Sensitive {
-				m[name] = true
-			}
-		}
-		sensitive[s.Name] = m
+	// sensitive lists the sensitive parameters of each stack at the time of the migration. Stacks
+	// are loaded from their manifests at runtime so they're listed here to keep the migration stable.
+	sensitive := map[string]map[string]bool{
+		"dhis2-db": {"DATABASE_PASSWORD": true, "DATABASE_USERNAME": true},
+		"dhis2-core": {
+			"S3_REGION": true, "S3_IDENTITY": true, "S3_SECRET": true, "FILESYSTEM_VOLUME_SIZE": true,
+			"CUSTOM_DHIS2_CONFIG": true, "GOOGLE_AUTH_PROJECT_ID": true, "GOOGLE_AUTH_PRIVATE_KEY": true,
+			"GOOGLE_AUTH_PRIVATE_KEY_ID": true, "GOOGLE_AUTH_CLIENT_EMAIL": true, "GOOGLE_AUTH_CLIENT_ID": true,
+			"DATABASE_PASSWORD": true, "DATABASE_USERNAME": true,
+		},
+		"dhis2": {
+			"DATABASE_PASSWORD": true, "DATABASE_USERNAME": true, "GOOGLE_AUTH_PROJECT_ID": true,
+			"GOOGLE_AUTH_PRIVATE_KEY": true, "GOOGLE_AUTH_PRIVATE_KEY_ID": true,
+			"GOOGLE_AUTH_CLIENT_EMAIL": true, "GOOGLE_AUTH_CLIENT_ID": true,
+		},
+		"pgadmin":       {"PGADMIN_USERNAME": true, "PGADMIN_PASSWORD": true, "DATABASE_USERNAME": true},
+		"im-job-runner": {"DHIS2_DATABASE_PASSWORD": true, "DHIS2_DATABASE_USERNAME": true},
+		"chap-db":       {"DATABASE_PASSWORD": true},
+		"chap-valkey":   {"REDIS_PASSWORD": true},
+		"chap-core":     {"GOOGLE_SERVICE_ACCOUNT_EMAIL": true, "GOOGLE_SERVICE_ACCOUNT_PRIVATE_KEY": true, "DHIS2_PASSWORD": true},
 	}
 
 	encryptGCM := func(key, plaintext string) (string, error) {
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// lockExpiry backfills the expiry of the locks acquired before locks expired. The column defaults to
+// now() when it's added which would release them all right away. They're given the lock TTL at the
+// time of the migration instead so only the locks which aren't renewed are released.
+func lockExpiry() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: "20261019001",
+		Migrate: func(tx *gorm.DB) error {
+			expiresAt := time.Now().Add(15 * time.Minute)
+			return tx.Exec("UPDATE locks SET expires_at = ? WHERE expires_at < ?", expiresAt, expiresAt).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
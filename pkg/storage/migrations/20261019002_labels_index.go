@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// labelsIndex drops the index of the database labels created with the jsonb_path_ops operator
+// class. It only supports containment so selecting databases by label key couldn't use it. The index
+// is created again with the default operator class once the migrations have run.
+func labelsIndex() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: "20261019002",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Exec("DROP INDEX IF EXISTS idx_databases_labels").Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
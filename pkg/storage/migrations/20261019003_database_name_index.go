@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// databaseNameIndex limits the unique indexes of the database names and slugs to databases which
+// aren't trashed so the names of trashed databases can be used again. AutoMigrate doesn't change
+// existing indexes so they're created again here.
+func databaseNameIndex() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: "20261019003",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Transaction(func(tx *gorm.DB) error {
+				statements := []string{
+					"DROP INDEX IF EXISTS database_name_group_idx",
+					"CREATE UNIQUE INDEX database_name_group_idx ON databases (name, group_name) WHERE deleted_at IS NULL",
+					"DROP INDEX IF EXISTS idx_databases_slug",
+					"CREATE UNIQUE INDEX idx_databases_slug ON databases (slug) WHERE deleted_at IS NULL",
+				}
+				for _, statement := range statements {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
@@ -67,6 +67,8 @@ func NewDatabase(logger *slog.Logger, c PostgresqlConfig) (*gorm.DB, error) {
 		&model.Deployment{},
 		&model.DeploymentInstance{},
 		&model.DeploymentInstanceParameter{},
+		&model.Comparison{},
+		&model.BackupSchedule{},
 
 		&model.User{},
 		&model.Group{},
@@ -74,7 +76,15 @@ func NewDatabase(logger *slog.Logger, c PostgresqlConfig) (*gorm.DB, error) {
 
 		&model.Database{},
 		&model.Lock{},
+		&model.ForcedUnlock{},
 		&model.ExternalDownload{},
+		&model.ExternalDownloadAccess{},
+		&model.DatabaseVersion{},
+		&model.DatabaseMetadata{},
+		&model.DatabaseShare{},
+		&model.RetentionPolicy{},
+		&model.DatabaseUpload{},
+		&model.DatabaseUploadPart{},
 
 		&model.Notification{},
 	)
@@ -100,10 +110,16 @@ func NewDatabase(logger *slog.Logger, c PostgresqlConfig) (*gorm.DB, error) {
 	//dev-1           | [00] im-manager exited due to: failed to setup DB: failed to open Gorm session: ERROR: operator class "gin_trgm_ops" does not exist for access method "btree" (SQLSTATE 42704)exit status 1
 	//dev-1           | [00] (error exit: exit status 1)
 	//dev-1           | [00] Killing service
-	sql := "CREATE INDEX IF NOT EXISTS idx_databases_description ON databases USING gin (description gin_trgm_ops)"
-	err = db.Exec(sql).Error
-	if err != nil {
-		return nil, err
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_databases_description ON databases USING gin (description gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_databases_name ON databases USING gin (name gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_databases_labels ON databases USING gin (labels)",
+	}
+	for _, sql := range indexes {
+		err = db.Exec(sql).Error
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return db, nil
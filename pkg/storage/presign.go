@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// NewPresigner creates a presigner of URLs which are valid for the given time to live. The client
+// should use an endpoint which is reachable by the clients of the URLs, e.g. the public endpoint of
+// MinIO rather than its cluster internal service.
+func NewPresigner(client *s3.PresignClient, ttl time.Duration) *Presigner {
+	return &Presigner{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// Presigner creates short-lived URLs granting direct access to S3 objects so the content doesn't have
+// to pass through IM
+type Presigner struct {
+	client *s3.PresignClient
+	ttl    time.Duration
+}
+
+// TTL is how long the presigned URLs are valid
+func (p Presigner) TTL() time.Duration {
+	return p.ttl
+}
+
+// PresignGet presigns a URL downloading the object as an attachment with the given filename
+func (p Presigner) PresignGet(ctx context.Context, bucket, key, filename string) (string, error) {
+	request, err := p.client.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": filename})),
+	}, s3.WithPresignExpires(p.ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download of %q: %v", key, err)
+	}
+	return request.URL, nil
+}
+
+// PresignUploadPart presigns a URL uploading a part of a multipart upload using a PUT request. The
+// size is signed so S3 rejects parts of any other size.
+func (p Presigner) PresignUploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, size int64) (string, error) {
+	request, err := p.client.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(p.ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign upload of part %d of %q: %v", partNumber, key, err)
+	}
+	return request.URL, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of the progress of a transfer
+type Progress struct {
+	// Bytes transferred so far
+	Bytes int64 `json:"bytes"`
+	// Objects transferred so far, omitted if objects aren't counted
+	Objects int64 `json:"objects,omitempty"`
+	// Throughput in bytes per second since the transfer started
+	Throughput int64 `json:"throughput"`
+	// ETA is the estimated number of seconds until the transfer is done, omitted if the size of the
+	// transfer isn't known
+	ETA *int64 `json:"eta,omitempty"`
+}
+
+// NewProgressTracker returns a tracker reporting the progress of a transfer of the given total size
+// at most once per interval. The total size is 0 if it isn't known.
+func NewProgressTracker(total int64, interval time.Duration, report func(Progress)) *ProgressTracker {
+	now := time.Now()
+	return &ProgressTracker{
+		total:      total,
+		interval:   interval,
+		report:     report,
+		start:      now,
+		reportedAt: now,
+	}
+}
+
+// ProgressTracker counts the bytes and objects of a transfer. It's safe for concurrent use and a nil
+// tracker doesn't count anything.
+type ProgressTracker struct {
+	mu         sync.Mutex
+	total      int64
+	bytes      int64
+	objects    int64
+	interval   time.Duration
+	report     func(Progress)
+	start      time.Time
+	reportedAt time.Time
+}
+
+// AddBytes counts n transferred bytes
+func (p *ProgressTracker) AddBytes(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.bytes += n
+	p.mu.Unlock()
+	p.maybeReport()
+}
+
+// AddObject counts a transferred object
+func (p *ProgressTracker) AddObject() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.objects++
+	p.mu.Unlock()
+	p.maybeReport()
+}
+
+// Progress returns the progress of the transfer so far
+func (p *ProgressTracker) Progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progress(time.Now())
+}
+
+func (p *ProgressTracker) progress(now time.Time) Progress {
+	progress := Progress{Bytes: p.bytes, Objects: p.objects}
+
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return progress
+	}
+
+	throughput := float64(p.bytes) / elapsed
+	progress.Throughput = int64(throughput)
+
+	if p.total > 0 && throughput > 0 {
+		// The total is an estimate so the transfer can exceed it
+		eta := int64(float64(max(p.total-p.bytes, 0)) / throughput)
+		progress.ETA = &eta
+	}
+
+	return progress
+}
+
+func (p *ProgressTracker) maybeReport() {
+	now := time.Now()
+
+	p.mu.Lock()
+	if now.Sub(p.reportedAt) < p.interval {
+		p.mu.Unlock()
+		return
+	}
+	p.reportedAt = now
+	progress := p.progress(now)
+	p.mu.Unlock()
+
+	p.report(progress)
+}
+
+// Reader returns a reader counting the bytes read from r
+func (p *ProgressTracker) Reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return trackingReader{r: r, tracker: p}
+}
+
+type trackingReader struct {
+	r       io.Reader
+	tracker *ProgressTracker
+}
+
+func (r trackingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.tracker.AddBytes(int64(n))
+	return n, err
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTracker(t *testing.T) {
+	t.Run("CountsBytesAndObjects", func(t *testing.T) {
+		tracker := NewProgressTracker(0, time.Hour, func(Progress) {})
+
+		data, err := io.ReadAll(tracker.Reader(strings.NewReader("hello world")))
+		require.NoError(t, err)
+		tracker.AddObject()
+		tracker.AddObject()
+
+		assert.Equal(t, "hello world", string(data))
+		progress := tracker.Progress()
+		assert.Equal(t, int64(11), progress.Bytes)
+		assert.Equal(t, int64(2), progress.Objects)
+		assert.Nil(t, progress.ETA, "ETA is unknown without a total")
+	})
+
+	t.Run("EstimatesETA", func(t *testing.T) {
+		tracker := NewProgressTracker(100, time.Hour, func(Progress) {})
+		tracker.start = time.Now().Add(-10 * time.Second)
+
+		tracker.AddBytes(50)
+
+		progress := tracker.Progress()
+		require.NotNil(t, progress.ETA)
+		assert.InDelta(t, 5, progress.Throughput, 1)
+		assert.InDelta(t, 10, *progress.ETA, 1)
+	})
+
+	t.Run("ETAIsZeroWhenTotalIsExceeded", func(t *testing.T) {
+		tracker := NewProgressTracker(10, time.Hour, func(Progress) {})
+		tracker.start = time.Now().Add(-time.Second)
+
+		tracker.AddBytes(20)
+
+		progress := tracker.Progress()
+		require.NotNil(t, progress.ETA)
+		assert.Equal(t, int64(0), *progress.ETA)
+	})
+
+	t.Run("ReportsAtMostOncePerInterval", func(t *testing.T) {
+		var reports []Progress
+		tracker := NewProgressTracker(0, time.Hour, func(p Progress) { reports = append(reports, p) })
+
+		tracker.AddBytes(1)
+		assert.Empty(t, reports)
+
+		tracker.reportedAt = time.Now().Add(-time.Hour)
+		tracker.AddBytes(1)
+		tracker.AddBytes(1)
+
+		require.Len(t, reports, 1)
+		assert.Equal(t, int64(2), reports[0].Bytes)
+	})
+
+	t.Run("NilTracker", func(t *testing.T) {
+		var tracker *ProgressTracker
+		reader := strings.NewReader("hello world")
+
+		tracker.AddBytes(1)
+		tracker.AddObject()
+
+		assert.Same(t, reader, tracker.Reader(reader))
+	})
+}
@@ -31,6 +31,14 @@ type S3Client struct {
 	uploader AWSS3Uploader
 }
 
+// WithoutDefaultChecksums makes the client calculate and validate checksums only for operations
+// requiring them. By default the SDK sends CRC checksums of uploads in trailers of aws-chunked encoded
+// bodies which AWS S3 and MinIO support but many other services implementing the S3 API reject.
+func WithoutDefaultChecksums(o *s3.Options) {
+	o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+	o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+}
+
 type AWSS3Client interface {
 	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
@@ -39,6 +47,8 @@ type AWSS3Client interface {
 	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
 	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
 	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
 }
 
 type AWSS3Uploader interface {
@@ -59,6 +69,21 @@ func s3AuthErr(err error) error {
 	return nil
 }
 
+// s3NotFoundErr returns a NotFound error if err is caused by a missing bucket or key. Returns nil
+// otherwise.
+func s3NotFoundErr(err error, bucket, key string) error {
+	var noBucket *types.NoSuchBucket
+	var noKey *types.NoSuchKey
+	var apiErr smithy.APIError
+	if errors.As(err, &noBucket) {
+		return errdef.NewNotFound("bucket %q does not exist", bucket)
+	}
+	if errors.As(err, &noKey) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
+		return errdef.NewNotFound("key %q not found in bucket %q", key, bucket)
+	}
+	return nil
+}
+
 func (s S3Client) Copy(bucket string, source string, destination string) error {
 	_, err := s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
 		Bucket:     aws.String(bucket),
@@ -139,14 +164,8 @@ func (s S3Client) Download(ctx context.Context, bucket string, key string, dst i
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		var noBucket *types.NoSuchBucket
-		var noKey *types.NoSuchKey
-		var apiErr smithy.APIError
-		if errors.As(err, &noBucket) {
-			return errdef.NewNotFound("bucket %q does not exist", bucket)
-		}
-		if errors.As(err, &noKey) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
-			return errdef.NewNotFound("key %q not found in bucket %q", key, bucket)
+		if err := s3NotFoundErr(err, bucket, key); err != nil {
+			return err
 		}
 		if authErr := s3AuthErr(err); authErr != nil {
 			return authErr
@@ -215,26 +234,35 @@ func (s S3Client) InitiateMultipartUpload(ctx context.Context, bucket, key, cont
 	return *resp.UploadId, nil
 }
 
-func (s S3Client) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data []byte) (*types.CompletedPart, error) {
+func (s S3Client) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, body io.ReadSeeker, size int64) (*Part, error) {
 	resp, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
-		Bucket:     &bucket,
-		Key:        &key,
-		UploadId:   &uploadID,
-		PartNumber: aws.Int32(int32(partNumber)),
-		Body:       bytes.NewReader(data),
+		Bucket:        &bucket,
+		Key:           &key,
+		UploadId:      &uploadID,
+		PartNumber:    aws.Int32(int32(partNumber)),
+		Body:          body,
+		ContentLength: aws.Int64(size),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	completedPart := &types.CompletedPart{
-		ETag:       resp.ETag,
-		PartNumber: aws.Int32(int32(partNumber)),
-	}
-	return completedPart, nil
+	return &Part{
+		Number: partNumber,
+		ETag:   aws.ToString(resp.ETag),
+		Size:   size,
+	}, nil
 }
 
-func (s S3Client) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, completedParts []types.CompletedPart) error {
+func (s S3Client) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
+	completedParts := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completedParts[i] = types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(int32(part.Number)),
+		}
+	}
+
 	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
 		Bucket:   &bucket,
 		Key:      &key,
@@ -255,13 +283,100 @@ func (s S3Client) AbortMultipartUpload(ctx context.Context, bucket, key, uploadI
 	return err
 }
 
+func (s S3Client) ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	input := &s3.ListPartsInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	}
+	for {
+		resp, err := s.client.ListParts(ctx, input)
+		if err != nil {
+			if authErr := s3AuthErr(err); authErr != nil {
+				return nil, authErr
+			}
+			return nil, err
+		}
+		for _, part := range resp.Parts {
+			parts = append(parts, Part{
+				Number: int(aws.ToInt32(part.PartNumber)),
+				ETag:   aws.ToString(part.ETag),
+				Size:   aws.ToInt64(part.Size),
+			})
+		}
+
+		if !aws.ToBool(resp.IsTruncated) {
+			return parts, nil
+		}
+		input.PartNumberMarker = resp.NextPartNumberMarker
+	}
+}
+
+func (s S3Client) DownloadRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			return []byte{}, nil
+		}
+		if err := s3NotFoundErr(err, bucket, key); err != nil {
+			return nil, err
+		}
+		if authErr := s3AuthErr(err); authErr != nil {
+			return nil, authErr
+		}
+		return nil, fmt.Errorf("error downloading range of object from bucket %q using key %q: %s", bucket, key, err)
+	}
+	defer object.Body.Close()
+
+	return io.ReadAll(io.LimitReader(object.Body, length))
+}
+
+func (s S3Client) List(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var objects []Object
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		resp, err := s.client.ListObjectsV2(ctx, input)
+		if err != nil {
+			var noBucket *types.NoSuchBucket
+			if errors.As(err, &noBucket) {
+				return nil, errdef.NewNotFound("bucket %q does not exist", bucket)
+			}
+			if authErr := s3AuthErr(err); authErr != nil {
+				return nil, authErr
+			}
+			return nil, fmt.Errorf("error listing objects in bucket %q using prefix %q: %s", bucket, prefix, err)
+		}
+		for _, object := range resp.Contents {
+			objects = append(objects, Object{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+
+		if !aws.ToBool(resp.IsTruncated) {
+			return objects, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
 func (s S3Client) StreamUpload(ctx context.Context, bucket, key, contentType string, r io.Reader) (int64, error) {
 	uploadID, err := s.InitiateMultipartUpload(ctx, bucket, key, contentType)
 	if err != nil {
 		return 0, fmt.Errorf("failed to initiate multipart upload: %w", err)
 	}
 
-	var completedParts []types.CompletedPart
+	var completedParts []Part
 	var totalSize int64
 	partNumber := 1
 	const chunkSize = 10 * 1024 * 1024
@@ -281,7 +396,7 @@ func (s S3Client) StreamUpload(ctx context.Context, bucket, key, contentType str
 			break
 		}
 
-		part, partErr := s.UploadPart(ctx, bucket, key, uploadID, partNumber, buffer[:n])
+		part, partErr := s.UploadPart(ctx, bucket, key, uploadID, partNumber, bytes.NewReader(buffer[:n]), int64(n))
 		if partErr != nil {
 			_ = s.AbortMultipartUpload(ctx, bucket, key, uploadID)
 			return 0, fmt.Errorf("failed to upload part %d: %w", partNumber, partErr)
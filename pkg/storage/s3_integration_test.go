@@ -0,0 +1,34 @@
+package storage_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dhis2-sre/im-manager/pkg/inttest"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/dhis2-sre/im-manager/pkg/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Client(t *testing.T) {
+	t.Parallel()
+
+	s3Dir := t.TempDir()
+	s3Bucket := "database-bucket"
+	compatibleBucket := "compatible-bucket"
+	require.NoError(t, os.Mkdir(s3Dir+"/"+s3Bucket, 0o755))
+	require.NoError(t, os.Mkdir(s3Dir+"/"+compatibleBucket, 0o755))
+	s3 := inttest.SetupS3(t, s3Dir)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	storagetest.Run(t, storage.NewS3Client(logger, s3.Client, manager.NewUploader(s3.Client)), s3Bucket)
+
+	t.Run("WithoutDefaultChecksums", func(t *testing.T) {
+		client := awss3.New(s3.Client.Options(), storage.WithoutDefaultChecksums)
+
+		storagetest.Run(t, storage.NewS3Client(logger, client, manager.NewUploader(client)), compatibleBucket)
+	})
+}
@@ -1,9 +1,19 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	smithy "github.com/aws/smithy-go"
 	"github.com/dhis2-sre/im-manager/internal/errdef"
 	"github.com/stretchr/testify/assert"
@@ -47,3 +57,68 @@ func TestS3AuthErr(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestWithoutDefaultChecksums(t *testing.T) {
+	// newServer records the checksum related headers of every request
+	newServer := func(t *testing.T) (*httptest.Server, *[]http.Header) {
+		t.Helper()
+
+		var headers []http.Header
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			recorded := http.Header{}
+			for name, values := range r.Header {
+				if strings.Contains(strings.ToLower(name), "checksum") || name == "X-Amz-Trailer" || name == "Content-Encoding" {
+					recorded[name] = values
+				}
+			}
+			headers = append(headers, recorded)
+			w.Header().Set("ETag", `"etag"`)
+			if r.Method == http.MethodGet {
+				w.Header().Set("Content-Length", "7")
+				_, _ = w.Write([]byte("content"))
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server, &headers
+	}
+	newClient := func(server *httptest.Server, optFns ...func(*s3.Options)) *S3Client {
+		client := s3.New(s3.Options{
+			Region:       "eu-west-1",
+			BaseEndpoint: aws.String(server.URL),
+			HTTPClient:   server.Client(),
+			UsePathStyle: true,
+			Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			// the defaults of config.LoadDefaultConfig
+			RequestChecksumCalculation: aws.RequestChecksumCalculationWhenSupported,
+			ResponseChecksumValidation: aws.ResponseChecksumValidationWhenSupported,
+		}, optFns...)
+		return NewS3Client(slog.New(slog.NewTextHandler(io.Discard, nil)), client, manager.NewUploader(client))
+	}
+	use := func(t *testing.T, client *S3Client) {
+		t.Helper()
+
+		ctx := context.Background()
+		require.NoError(t, client.Upload(ctx, "bucket", "object", strings.NewReader("content"), 7))
+		_, err := client.UploadPart(ctx, "bucket", "object", "upload", 1, strings.NewReader("content"), 7)
+		require.NoError(t, err)
+		require.NoError(t, client.Download(ctx, "bucket", "object", io.Discard, func(int64) {}))
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		server, headers := newServer(t)
+
+		use(t, newClient(server))
+
+		trailer := http.Header{"Content-Encoding": {"aws-chunked"}, "X-Amz-Trailer": {"x-amz-checksum-crc32"}}
+		assert.Equal(t, []http.Header{trailer, trailer, {"X-Amz-Checksum-Mode": {"ENABLED"}}}, *headers, "the SDK sends checksums by default")
+	})
+
+	t.Run("WithoutDefaultChecksums", func(t *testing.T) {
+		server, headers := newServer(t)
+
+		use(t, newClient(server, WithoutDefaultChecksums))
+
+		assert.Equal(t, []http.Header{{}, {}, {}}, *headers)
+	})
+}
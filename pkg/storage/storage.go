@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ObjectStore stores objects by key in buckets. It's implemented by S3Client for AWS S3, MinIO and
+// other services implementing the S3 API and by FilesystemStore for setups without access to an
+// object storage service. Azure Blob Storage isn't supported.
+type ObjectStore interface {
+	// Upload uploads the body of the given size
+	Upload(ctx context.Context, bucket string, key string, body ReadAtSeeker, size int64) error
+	// StreamUpload uploads the content of the reader without knowing its size upfront and returns
+	// the number of bytes uploaded
+	StreamUpload(ctx context.Context, bucket, key, contentType string, r io.Reader) (int64, error)
+	// Download writes the object to dst calling cb with its size before writing any content
+	Download(ctx context.Context, bucket string, key string, dst io.Writer, cb func(contentLength int64)) error
+	// DownloadRange downloads up to length bytes starting at offset
+	DownloadRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, error)
+	Copy(bucket string, source string, destination string) error
+	Move(bucket string, source string, destination string) error
+	// Delete deletes the object. Deleting an object which doesn't exist isn't an error.
+	Delete(bucket string, key string) error
+	// List lists the objects whose key starts with prefix ordered by key
+	List(ctx context.Context, bucket, prefix string) ([]Object, error)
+
+	InitiateMultipartUpload(ctx context.Context, bucket, key, contentType string) (string, error)
+	// UploadPart uploads size bytes read from body as the given part of the multipart upload
+	UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, body io.ReadSeeker, size int64) (*Part, error)
+	// CompleteMultipartUpload assembles the object from the given parts in the given order
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
+	// ListParts lists the parts which have been uploaded to the multipart upload ordered by part number
+	ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error)
+}
+
+// Object describes a stored object
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int
+	ETag   string
+	Size   int64
+}
@@ -0,0 +1,191 @@
+// Package storagetest provides a conformance test suite every storage.ObjectStore implementation has
+// to pass.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dhis2-sre/im-manager/internal/errdef"
+	"github.com/dhis2-sre/im-manager/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// minPartSize is the minimum size of all but the last part of a multipart upload enforced by S3
+const minPartSize = 5 * 1024 * 1024
+
+// Run runs the conformance test suite against the store. The bucket has to exist and be empty.
+func Run(t *testing.T, store storage.ObjectStore, bucket string) {
+	ctx := context.Background()
+
+	t.Run("UploadAndDownload", func(t *testing.T) {
+		content := []byte("some content")
+
+		err := store.Upload(ctx, bucket, "upload/object.txt", bytes.NewReader(content), int64(len(content)))
+		require.NoError(t, err)
+
+		var contentLength int64
+		var buffer bytes.Buffer
+		err = store.Download(ctx, bucket, "upload/object.txt", &buffer, func(l int64) { contentLength = l })
+		require.NoError(t, err)
+		assert.Equal(t, content, buffer.Bytes())
+		assert.Equal(t, int64(len(content)), contentLength)
+	})
+
+	t.Run("DownloadMissingKey", func(t *testing.T) {
+		err := store.Download(ctx, bucket, "missing/object.txt", io.Discard, func(int64) {})
+
+		require.Error(t, err)
+		assert.True(t, errdef.IsNotFound(err), "expected NotFound error but got %v", err)
+	})
+
+	t.Run("StreamUpload", func(t *testing.T) {
+		content := strings.Repeat("a", 11*1024*1024)
+
+		size, err := store.StreamUpload(ctx, bucket, "stream/object.txt", "text/plain", strings.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), size)
+
+		assert.Equal(t, []byte(content), download(t, store, bucket, "stream/object.txt"))
+	})
+
+	t.Run("StreamUploadFailingReader", func(t *testing.T) {
+		_, err := store.StreamUpload(ctx, bucket, "stream/failed.txt", "text/plain", io.MultiReader(strings.NewReader("partial"), errReader{}))
+		require.Error(t, err)
+
+		err = store.Download(ctx, bucket, "stream/failed.txt", io.Discard, func(int64) {})
+		assert.True(t, errdef.IsNotFound(err), "expected failed upload to not be stored but got %v", err)
+	})
+
+	t.Run("DownloadRange", func(t *testing.T) {
+		upload(t, store, bucket, "range/object.txt", "0123456789")
+
+		data, err := store.DownloadRange(ctx, bucket, "range/object.txt", 2, 3)
+		require.NoError(t, err)
+		assert.Equal(t, "234", string(data))
+
+		data, err = store.DownloadRange(ctx, bucket, "range/object.txt", 8, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "89", string(data))
+	})
+
+	t.Run("CopyAndMove", func(t *testing.T) {
+		upload(t, store, bucket, "copy/source.txt", "content")
+
+		err := store.Copy(bucket, "copy/source.txt", "copy/copied.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(download(t, store, bucket, "copy/source.txt")))
+		assert.Equal(t, "content", string(download(t, store, bucket, "copy/copied.txt")))
+
+		err = store.Move(bucket, "copy/copied.txt", "copy/moved/object.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(download(t, store, bucket, "copy/moved/object.txt")))
+		err = store.Download(ctx, bucket, "copy/copied.txt", io.Discard, func(int64) {})
+		assert.True(t, errdef.IsNotFound(err), "expected moved object to be gone but got %v", err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		upload(t, store, bucket, "delete/object.txt", "content")
+
+		err := store.Delete(bucket, "delete/object.txt")
+		require.NoError(t, err)
+		err = store.Download(ctx, bucket, "delete/object.txt", io.Discard, func(int64) {})
+		assert.True(t, errdef.IsNotFound(err), "expected deleted object to be gone but got %v", err)
+
+		err = store.Delete(bucket, "delete/object.txt")
+		assert.NoError(t, err, "deleting a missing object should succeed")
+	})
+
+	t.Run("List", func(t *testing.T) {
+		upload(t, store, bucket, "list/b.txt", "bb")
+		upload(t, store, bucket, "list/a/c.txt", "c")
+		upload(t, store, bucket, "list/a.txt", "a")
+		upload(t, store, bucket, "listing.txt", "not listed")
+
+		objects, err := store.List(ctx, bucket, "list/")
+		require.NoError(t, err)
+
+		keys := make([]string, len(objects))
+		for i, object := range objects {
+			keys[i] = object.Key
+		}
+		assert.Equal(t, []string{"list/a.txt", "list/a/c.txt", "list/b.txt"}, keys)
+		assert.Equal(t, int64(2), objects[2].Size)
+		assert.False(t, objects[2].LastModified.IsZero())
+
+		objects, err = store.List(ctx, bucket, "empty/")
+		require.NoError(t, err)
+		assert.Empty(t, objects)
+	})
+
+	t.Run("MultipartUpload", func(t *testing.T) {
+		key := "multipart/object.txt"
+		uploadID, err := store.InitiateMultipartUpload(ctx, bucket, key, "text/plain")
+		require.NoError(t, err)
+
+		first := bytes.Repeat([]byte("a"), minPartSize)
+		second := []byte("last part")
+		// parts can be uploaded in any order
+		secondPart, err := store.UploadPart(ctx, bucket, key, uploadID, 2, bytes.NewReader(second), int64(len(second)))
+		require.NoError(t, err)
+		firstPart, err := store.UploadPart(ctx, bucket, key, uploadID, 1, bytes.NewReader(first), int64(len(first)))
+		require.NoError(t, err)
+
+		parts, err := store.ListParts(ctx, bucket, key, uploadID)
+		require.NoError(t, err)
+		require.Len(t, parts, 2)
+		assert.Equal(t, 1, parts[0].Number)
+		assert.Equal(t, firstPart.ETag, parts[0].ETag)
+		assert.Equal(t, int64(len(first)), parts[0].Size)
+		assert.Equal(t, 2, parts[1].Number)
+		assert.Equal(t, secondPart.ETag, parts[1].ETag)
+
+		err = store.CompleteMultipartUpload(ctx, bucket, key, uploadID, []storage.Part{*firstPart, *secondPart})
+		require.NoError(t, err)
+
+		assert.Equal(t, append(first, second...), download(t, store, bucket, key))
+	})
+
+	t.Run("AbortMultipartUpload", func(t *testing.T) {
+		key := "multipart/aborted.txt"
+		uploadID, err := store.InitiateMultipartUpload(ctx, bucket, key, "text/plain")
+		require.NoError(t, err)
+		_, err = store.UploadPart(ctx, bucket, key, uploadID, 1, strings.NewReader("content"), int64(len("content")))
+		require.NoError(t, err)
+
+		err = store.AbortMultipartUpload(ctx, bucket, key, uploadID)
+		require.NoError(t, err)
+
+		_, err = store.ListParts(ctx, bucket, key, uploadID)
+		assert.Error(t, err)
+		err = store.Download(ctx, bucket, key, io.Discard, func(int64) {})
+		assert.True(t, errdef.IsNotFound(err), "expected aborted upload to not be stored but got %v", err)
+	})
+}
+
+func upload(t *testing.T, store storage.ObjectStore, bucket, key, content string) {
+	t.Helper()
+
+	err := store.Upload(context.Background(), bucket, key, strings.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+}
+
+func download(t *testing.T, store storage.ObjectStore, bucket, key string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	err := store.Download(context.Background(), bucket, key, &buffer, func(int64) {})
+	require.NoError(t, err)
+	return buffer.Bytes()
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
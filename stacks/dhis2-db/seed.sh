@@ -25,11 +25,19 @@ curl --connect-timeout 10 --retry 5 --retry-delay 1 --fail -L "$DATABASE_DOWNLOA
   exit 1
 }
 
-# Try pg_restore... Or gzipped sql
-# pg_restore often returns a non zero return code due to benign errors resulting in executing of gunzip despite the restore being successful
-# gunzip will fail because the input isn't gzipped causing the whole seed script to fail... Which is why there's a "|| true" at the end
-(pg_restore --verbose -U postgres -d "$DATABASE_NAME" -j 4 "$tmp_file") ||
-  (gunzip -v -c "$tmp_file" | psql -U postgres -d "$DATABASE_NAME") || true
+# Detect the format by its magic bytes: custom format archives start with "PGDMP", directory format dumps are tars
+# and plain dumps are gzipped sql
+# pg_restore often returns a non zero return code due to benign errors despite the restore being successful
+if [[ "$(head -c 5 "$tmp_file")" == "PGDMP" ]]; then
+  pg_restore --verbose -U postgres -d "$DATABASE_NAME" -j 4 "$tmp_file" || true
+elif [[ "$(tail -c +258 "$tmp_file" | head -c 5)" == "ustar" ]]; then
+  dump_dir=$(mktemp -d)
+  tar -x -f "$tmp_file" -C "$dump_dir"
+  pg_restore --verbose -U postgres -d "$DATABASE_NAME" -j 4 "$dump_dir" || true
+  rm -r "$dump_dir"
+else
+  gunzip -v -c "$tmp_file" | psql -U postgres -d "$DATABASE_NAME"
+fi
 rm "$tmp_file"
 
 ## Change ownership to $DATABASE_USERNAME